@@ -0,0 +1,134 @@
+// Package action binds keys to things to do: run a command, send an HTTP
+// request, switch page, set the brightness, or a sequence of these.
+//
+// Actions are plain values implementing Action, and can be run on their
+// own, e.g. in tests. A Button displays an element and runs an action when
+// its key is pressed, optionally flashing the key to show the outcome.
+package action
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+)
+
+// Action is something which can be run when a key is pressed.
+type Action interface {
+	// Run performs the action. It returns when the action is done, the
+	// context is canceled or its deadline expires.
+	Run(ctx context.Context, env *Env) error
+}
+
+// Deck switches pages, typically a *page.Deck.
+type Deck interface {
+	SwitchToName(name string) error
+	Back() error
+}
+
+// Device is a device whose brightness can be set, typically a
+// *streamdeck.StreamDeck.
+type Device interface {
+	SetBrightness(pc uint8) error
+}
+
+// Env is the environment an action runs in. It is also the data available
+// to templates, e.g. {{.Key}} in the body of an HTTP request.
+type Env struct {
+	Deck   Deck   // may be nil if no page actions are used
+	Device Device // may be nil if no brightness actions are used
+	Key    int    // index of the key which triggered the action
+	State  sd.BtnState
+	// Vars are free-form values for templates, e.g. {{.Vars.room}}.
+	Vars map[string]string
+}
+
+// Func adapts a function to the Action interface.
+type Func func(ctx context.Context, env *Env) error
+
+// Run calls f.
+func (f Func) Run(ctx context.Context, env *Env) error {
+	return f(ctx, env)
+}
+
+// Page switches the deck to the page with the given name.
+type Page string
+
+// Run switches the page.
+func (p Page) Run(ctx context.Context, env *Env) error {
+	if env.Deck == nil {
+		return fmt.Errorf("no deck to switch to page %q", string(p))
+	}
+	return env.Deck.SwitchToName(string(p))
+}
+
+// Back switches the deck back to the previous page.
+type Back struct{}
+
+// Run switches the page.
+func (Back) Run(ctx context.Context, env *Env) error {
+	if env.Deck == nil {
+		return fmt.Errorf("no deck to switch pages on")
+	}
+	return env.Deck.Back()
+}
+
+// Brightness sets the brightness of the device, in percent.
+type Brightness uint8
+
+// Run sets the brightness.
+func (b Brightness) Run(ctx context.Context, env *Env) error {
+	if env.Device == nil {
+		return fmt.Errorf("no device to set the brightness of")
+	}
+	if b > 100 {
+		return fmt.Errorf("invalid brightness %d%%", b)
+	}
+	return env.Device.SetBrightness(uint8(b))
+}
+
+// Delay waits for the given duration. It is mostly useful in sequences.
+type Delay time.Duration
+
+// Run waits.
+func (d Delay) Run(ctx context.Context, env *Env) error {
+	t := time.NewTimer(time.Duration(d))
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Sequence runs actions one after the other, and stops at the first
+// failure.
+type Sequence []Action
+
+// Run runs the actions.
+func (s Sequence) Run(ctx context.Context, env *Env) error {
+	for i, a := range s {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := a.Run(ctx, env); err != nil {
+			if len(s) == 1 {
+				return err
+			}
+			return fmt.Errorf("step %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// WithTimeout limits the time an action may take.
+func WithTimeout(a Action, timeout time.Duration) Action {
+	return Func(func(ctx context.Context, env *Env) error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return a.Run(ctx, env)
+	})
+}
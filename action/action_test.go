@@ -0,0 +1,99 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeDeck records the pages switched to.
+type fakeDeck struct {
+	pages []string
+}
+
+func (d *fakeDeck) SwitchToName(name string) error {
+	if name == "nope" {
+		return errors.New("no such page")
+	}
+	d.pages = append(d.pages, name)
+	return nil
+}
+
+func (d *fakeDeck) Back() error {
+	d.pages = append(d.pages, "back")
+	return nil
+}
+
+type fakeDevice struct {
+	brightness uint8
+}
+
+func (d *fakeDevice) SetBrightness(pc uint8) error {
+	d.brightness = pc
+	return nil
+}
+
+func TestSequence(t *testing.T) {
+	deck := &fakeDeck{}
+	dev := &fakeDevice{}
+	env := &Env{Deck: deck, Device: dev}
+	var ran []int
+	step := func(i int) Action {
+		return Func(func(ctx context.Context, env *Env) error {
+			ran = append(ran, i)
+			return nil
+		})
+	}
+
+	s := Sequence{step(1), Page("sub"), Brightness(40), Back{}, step(2)}
+	if err := s.Run(context.Background(), env); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(deck.pages, ",") != "sub,back" || dev.brightness != 40 || len(ran) != 2 {
+		t.Errorf("pages %v, brightness %d, ran %v", deck.pages, dev.brightness, ran)
+	}
+
+	// A sequence stops at the first failure, which tells the step.
+	ran = nil
+	err := Sequence{step(1), Page("nope"), step(2)}.Run(context.Background(), env)
+	if err == nil || err.Error() != "step 2: no such page" || len(ran) != 1 {
+		t.Errorf("err %v, ran %v", err, ran)
+	}
+	// The error of a single action is returned as is.
+	if err := (Sequence{Page("nope")}).Run(context.Background(), env); err == nil || err.Error() != "no such page" {
+		t.Errorf("err %v", err)
+	}
+
+	// Canceling stops the sequence between steps, and during delays.
+	ctx, cancel := context.WithCancel(context.Background())
+	ran = nil
+	cancelStep := Func(func(context.Context, *Env) error {
+		cancel()
+		return nil
+	})
+	if err := (Sequence{cancelStep, step(1)}).Run(ctx, env); err != context.Canceled || len(ran) != 0 {
+		t.Errorf("err %v, ran %v", err, ran)
+	}
+	start := time.Now()
+	err = WithTimeout(Sequence{Delay(10 * time.Millisecond), Delay(time.Minute), step(1)}, 50*time.Millisecond).Run(context.Background(), env)
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 5*time.Second || len(ran) != 0 {
+		t.Errorf("err %v after %v, ran %v", err, time.Since(start), ran)
+	}
+	if err := Delay(time.Millisecond).Run(context.Background(), env); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEnvActions(t *testing.T) {
+	ctx := context.Background()
+	for _, a := range []Action{Page("main"), Back{}, Brightness(50)} {
+		if err := a.Run(ctx, &Env{}); err == nil {
+			t.Errorf("%T ran without deck or device", a)
+		}
+	}
+	if err := Brightness(101).Run(ctx, &Env{Device: &fakeDevice{}}); err == nil {
+		t.Error("brightness of 101% set")
+	}
+}
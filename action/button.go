@@ -0,0 +1,203 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+)
+
+// Colors used to flash a key after its action ran.
+var (
+	SuccessColor color.Color = color.NRGBA{0x2e, 0xcc, 0x40, 0xff}
+	FailureColor color.Color = color.NRGBA{0xff, 0x41, 0x36, 0xff}
+)
+
+// Button is an Element displaying another element and running an action
+// when its key is pressed. While the action runs, further presses are
+// ignored.
+type Button struct {
+	sd.Invalidator
+	mu       sync.Mutex
+	el       sd.Element
+	action   Action
+	env      Env
+	trigger  sd.BtnState
+	timeout  time.Duration
+	flash    time.Duration
+	onResult func(error)
+
+	running    bool
+	flashColor color.Color
+	flashUntil time.Time
+}
+
+var _ sd.Element = (*Button)(nil)
+
+// ErrRunning is returned by Trigger while the action of the Button runs.
+var ErrRunning = errors.New("action already running")
+
+// NewButton creates a Button displaying el and running a on key presses.
+// el may be nil for a black key. env is the environment passed to the
+// action, its Key and State are set on each run.
+func NewButton(el sd.Element, a Action, env Env, options ...func(*Button)) *Button {
+	b := &Button{
+		el:      el,
+		action:  a,
+		env:     env,
+		trigger: sd.BtnPressed,
+	}
+	for _, option := range options {
+		option(b)
+	}
+	return b
+}
+
+// Element returns the element displayed by the Button.
+func (b *Button) Element() sd.Element {
+	return b.el
+}
+
+// Change forwards key events to the element and runs the action when the
+// key is pressed (or released, see OnRelease).
+func (b *Button) Change(state sd.BtnState) {
+	if b.el != nil {
+		b.el.Change(state)
+	}
+	if state != b.trigger {
+		return
+	}
+
+	b.mu.Lock()
+	if b.running {
+		b.mu.Unlock()
+		return
+	}
+	b.running = true
+	env := b.env
+	b.mu.Unlock()
+
+	env.State = state
+	go b.run(&env)
+}
+
+// Trigger runs the action as if the key was pressed, and waits for it to
+// finish. It fails with ErrRunning if the action already runs.
+func (b *Button) Trigger() error {
+	b.mu.Lock()
+	if b.running {
+		b.mu.Unlock()
+		return ErrRunning
+	}
+	b.running = true
+	env := b.env
+	b.mu.Unlock()
+
+	env.State = b.trigger
+	return b.run(&env)
+}
+
+func (b *Button) run(env *Env) error {
+	ctx := context.Background()
+	if b.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.timeout)
+		defer cancel()
+	}
+
+	err := b.action.Run(ctx, env)
+
+	b.mu.Lock()
+	b.running = false
+	if b.flash > 0 {
+		b.flashColor = SuccessColor
+		if err != nil {
+			b.flashColor = FailureColor
+		}
+		b.flashUntil = time.Now().Add(b.flash)
+	}
+	b.mu.Unlock()
+
+	if b.flash > 0 {
+		b.Invalidate()
+		time.AfterFunc(b.flash, b.Invalidate)
+	}
+	if b.onResult != nil {
+		b.onResult(err)
+	}
+	return err
+}
+
+// Render draws the element, with a colored frame while the key flashes.
+func (b *Button) Render(img *image.RGBA) error {
+	if b.el != nil {
+		if err := b.el.Render(img); err != nil {
+			return err
+		}
+	} else {
+		draw.Draw(img, img.Bounds(), image.Black, image.Point{}, draw.Src)
+	}
+
+	b.mu.Lock()
+	c := b.flashColor
+	flashing := c != nil && time.Now().Before(b.flashUntil)
+	b.mu.Unlock()
+
+	if flashing {
+		drawFlash(img, c)
+	}
+	return nil
+}
+
+// drawFlash tints img with c and draws a frame around it.
+func drawFlash(img *image.RGBA, c color.Color) {
+	r := img.Bounds()
+	w := r.Dx() / 12
+	if w < 2 {
+		w = 2
+	}
+
+	cr, cg, cb, _ := c.RGBA()
+	tint := image.NewUniform(color.NRGBA{uint8(cr >> 8), uint8(cg >> 8), uint8(cb >> 8), 0x50})
+	draw.Draw(img, r, tint, image.Point{}, draw.Over)
+
+	frame := image.NewUniform(c)
+	for _, s := range []image.Rectangle{
+		image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+w),
+		image.Rect(r.Min.X, r.Max.Y-w, r.Max.X, r.Max.Y),
+		image.Rect(r.Min.X, r.Min.Y, r.Min.X+w, r.Max.Y),
+		image.Rect(r.Max.X-w, r.Min.Y, r.Max.X, r.Max.Y),
+	} {
+		draw.Draw(img, s, frame, image.Point{}, draw.Src)
+	}
+}
+
+// Dirty reports whether the Button or its element needs to be redrawn.
+func (b *Button) Dirty() bool {
+	dirty := b.Invalidator.Dirty()
+	if b.el != nil && b.el.Dirty() {
+		dirty = true
+	}
+	return dirty
+}
+
+// SetNotify sets the function called when the Button or its element needs
+// to be redrawn.
+func (b *Button) SetNotify(notify func()) {
+	b.Invalidator.SetNotify(notify)
+	if n, ok := b.el.(sd.Notifier); ok {
+		n.SetNotify(notify)
+	}
+}
+
+// Close closes the element, if it can be closed.
+func (b *Button) Close() {
+	if c, ok := b.el.(interface{ Close() }); ok {
+		c.Close()
+	}
+}
@@ -0,0 +1,145 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"sync"
+	"testing"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+)
+
+// frameColor renders b and returns the color of its top left corner, where
+// the frame of the flash is drawn.
+func frameColor(t *testing.T, b *Button) color.RGBA {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 72, 72))
+	if err := b.Render(img); err != nil {
+		t.Fatal(err)
+	}
+	return img.RGBAAt(0, 0)
+}
+
+func rgba(c color.Color) color.RGBA {
+	return color.RGBAModel.Convert(c).(color.RGBA)
+}
+
+func TestButtonFlash(t *testing.T) {
+	fail := errors.New("failed")
+	var result error
+	b := NewButton(nil, Func(func(ctx context.Context, env *Env) error { return result }), Env{}, Flash(100*time.Millisecond))
+	notified := make(chan struct{}, 8)
+	b.SetNotify(func() { notified <- struct{}{} })
+
+	if c := frameColor(t, b); c != rgba(color.Black) {
+		t.Errorf("frame %v before running", c)
+	}
+
+	if err := b.Trigger(); err != nil {
+		t.Fatal(err)
+	}
+	if c := frameColor(t, b); c != rgba(SuccessColor) {
+		t.Errorf("frame %v after success", c)
+	}
+
+	result = fail
+	if err := b.Trigger(); err != fail {
+		t.Fatalf("Trigger = %v", err)
+	}
+	if c := frameColor(t, b); c != rgba(FailureColor) {
+		t.Errorf("frame %v after failure", c)
+	}
+
+	// The key is redrawn when the flash ends.
+	<-notified
+	<-notified
+	select {
+	case <-notified:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not redrawn after the flash")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if c := frameColor(t, b); c != rgba(color.Black) {
+		t.Errorf("frame %v after the flash", c)
+	}
+}
+
+func TestButtonChange(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var runs []sd.BtnState
+	a := Func(func(ctx context.Context, env *Env) error {
+		mu.Lock()
+		runs = append(runs, env.State)
+		mu.Unlock()
+		<-release
+		if env.Key != 5 || env.Vars["a"] != "b" {
+			return errors.New("bad env")
+		}
+		return nil
+	})
+	results := make(chan error, 4)
+	b := NewButton(nil, a, Env{Key: 5, Vars: map[string]string{"a": "b"}}, OnResult(func(err error) { results <- err }))
+
+	// Presses while the action runs are ignored, releases don't run it.
+	b.Change(sd.BtnPressed)
+	b.Change(sd.BtnReleased)
+	b.Change(sd.BtnPressed)
+	close(release)
+	if err := <-results; err != nil {
+		t.Error(err)
+	}
+	select {
+	case err := <-results:
+		t.Errorf("ran twice: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	b = NewButton(nil, a, Env{Key: 5, Vars: map[string]string{"a": "b"}}, OnRelease(), OnResult(func(err error) { results <- err }))
+	b.Change(sd.BtnPressed)
+	b.Change(sd.BtnReleased)
+	<-results
+	mu.Lock()
+	if len(runs) != 2 || runs[0] != sd.BtnPressed || runs[1] != sd.BtnReleased {
+		t.Errorf("runs %v", runs)
+	}
+	mu.Unlock()
+
+	// Neither presses nor Trigger run the action while Trigger runs it.
+	release = make(chan struct{})
+	b = NewButton(nil, a, Env{Key: 5, Vars: map[string]string{"a": "b"}}, OnResult(func(err error) { results <- err }))
+	go b.Trigger()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		mu.Lock()
+		n := len(runs)
+		mu.Unlock()
+		if n == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Trigger didn't run the action")
+		}
+	}
+	b.Change(sd.BtnPressed)
+	if err := b.Trigger(); err != ErrRunning {
+		t.Errorf("Trigger while running = %v", err)
+	}
+	close(release)
+	if err := <-results; err != nil {
+		t.Error(err)
+	}
+	select {
+	case err := <-results:
+		t.Errorf("ran twice: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The timeout cancels the context of the action.
+	b = NewButton(nil, Delay(time.Minute), Env{}, Timeout(20*time.Millisecond))
+	if err := b.Trigger(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Trigger = %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package action
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Command runs an executable. The action fails if the command can't be
+// started or exits with a non-zero status.
+//
+// Besides Env, the command gets STREAMDECK_KEY and STREAMDECK_STATE in its
+// environment.
+type Command struct {
+	Path string   // executable, looked up in $PATH if it has no slash
+	Args []string // arguments, not including the executable
+	// Env holds extra "KEY=value" variables added to the environment of
+	// the current process.
+	Env     []string
+	Dir     string        // working directory, the current one if empty
+	Timeout time.Duration // zero for no timeout
+}
+
+// Run runs the command and waits for it to exit.
+func (c *Command) Run(ctx context.Context, env *Env) error {
+	_, err := c.Output(ctx, env)
+	return err
+}
+
+// Output runs the command and returns its standard output. Failures to
+// start the command and non-zero exit statuses wrap an *exec.ExitError or
+// the error of os/exec, so they can be told apart with errors.As.
+func (c *Command) Output(ctx context.Context, env *Env) ([]byte, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, c.Path, c.Args...)
+	cmd.Dir = c.Dir
+	cmd.Env = append(os.Environ(), c.Env...)
+	cmd.Env = append(cmd.Env,
+		fmt.Sprintf("STREAMDECK_KEY=%d", env.Key),
+		fmt.Sprintf("STREAMDECK_STATE=%s", env.State),
+	)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("%s: timed out", c.Path)
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return stdout.Bytes(), fmt.Errorf("%s: %w: %s", c.Path, err, msg)
+		}
+		return stdout.Bytes(), fmt.Errorf("%s: %w", c.Path, err)
+	}
+	return stdout.Bytes(), nil
+}
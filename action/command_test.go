@@ -0,0 +1,62 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+)
+
+func TestCommandEnv(t *testing.T) {
+	dir := t.TempDir()
+	c := &Command{
+		Path: "sh",
+		Args: []string{"-c", `echo "$STREAMDECK_KEY $STREAMDECK_STATE $FOO $(pwd)"`},
+		Env:  []string{"FOO=bar"},
+		Dir:  dir,
+	}
+	out, err := c.Output(context.Background(), &Env{Key: 4, State: sd.BtnReleased})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The temporary directory may be reached through a symbolic link.
+	real, _ := filepath.EvalSymlinks(dir)
+	if got := strings.TrimSpace(string(out)); got != "4 BtnReleased bar "+real {
+		t.Errorf("output %q", got)
+	}
+}
+
+func TestCommandFailure(t *testing.T) {
+	ctx := context.Background()
+	env := &Env{}
+
+	err := (&Command{Path: "sh", Args: []string{"-c", "echo oops >&2; exit 3"}}).Run(ctx, env)
+	var exit *exec.ExitError
+	if !errors.As(err, &exit) || exit.ExitCode() != 3 || !strings.HasSuffix(err.Error(), ": oops") {
+		t.Errorf("err %v", err)
+	}
+
+	err = (&Command{Path: "streamdeck-no-such-command"}).Run(ctx, env)
+	if err == nil || errors.As(err, &exit) {
+		t.Errorf("err %v", err)
+	}
+
+	start := time.Now()
+	err = (&Command{Path: "sleep", Args: []string{"10"}, Timeout: 50 * time.Millisecond}).Run(ctx, env)
+	if err == nil || !strings.Contains(err.Error(), "timed out") || time.Since(start) > 5*time.Second {
+		t.Errorf("err %v after %v", err, time.Since(start))
+	}
+
+	// Canceling the context kills the command as well.
+	ctx, cancel := context.WithCancel(ctx)
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start = time.Now()
+	if err := (&Command{Path: "sleep", Args: []string{"10"}}).Run(ctx, env); err == nil || time.Since(start) > 5*time.Second {
+		t.Errorf("err %v after %v", err, time.Since(start))
+	}
+}
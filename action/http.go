@@ -0,0 +1,99 @@
+package action
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"text/template"
+	"time"
+)
+
+// HTTP sends an HTTP request. The action fails if the server can't be
+// reached or doesn't answer with a 2xx status.
+type HTTP struct {
+	Method string // GET if empty
+	URL    string
+	Header http.Header
+	// Body is a text/template executed with the Env of the action, e.g.
+	// {"key": {{.Key}}, "state": "{{.State}}"}. Call Validate to parse it
+	// once up front rather than on every request.
+	Body    string
+	Timeout time.Duration // zero for no timeout
+	Client  *http.Client  // http.DefaultClient if nil
+	tmpl    *template.Template
+}
+
+// Validate checks the request and parses the body template. It must not be
+// called while the action runs.
+func (h *HTTP) Validate() error {
+	if h.URL == "" {
+		return fmt.Errorf("missing URL")
+	}
+	if h.Body == "" {
+		return nil
+	}
+	t, err := template.New("body").Option("missingkey=error").Parse(h.Body)
+	if err != nil {
+		return err
+	}
+	h.tmpl = t
+	return nil
+}
+
+// Run sends the request and waits for the response.
+func (h *HTTP) Run(ctx context.Context, env *Env) error {
+	tmpl := h.tmpl
+	if tmpl == nil && h.Body != "" {
+		var err error
+		if tmpl, err = template.New("body").Option("missingkey=error").Parse(h.Body); err != nil {
+			return err
+		}
+	}
+	if h.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+		defer cancel()
+	}
+
+	method := h.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	var body io.Reader
+	if tmpl != nil {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, env); err != nil {
+			return err
+		}
+		body = &buf
+	}
+
+	req, err := http.NewRequest(method, h.URL, body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range h.Header {
+		req.Header[k] = v
+	}
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<20))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s %s: %s", method, h.URL, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package action
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+)
+
+type request struct {
+	method, path, body, header string
+}
+
+func newServer(t *testing.T) (*httptest.Server, chan request) {
+	t.Helper()
+	reqs := make(chan request, 8)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		reqs <- request{r.Method, r.URL.Path, string(body), r.Header.Get("X-Test")}
+		switch r.URL.Path {
+		case "/fail":
+			http.Error(w, "nope", http.StatusInternalServerError)
+		case "/slow":
+			select {
+			case <-time.After(10 * time.Second):
+			case <-r.Context().Done():
+			}
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s, reqs
+}
+
+func TestHTTP(t *testing.T) {
+	s, reqs := newServer(t)
+	env := &Env{Key: 3, State: sd.BtnPressed, Vars: map[string]string{"room": "kitchen"}}
+
+	h := &HTTP{
+		Method: http.MethodPost,
+		URL:    s.URL + "/hook",
+		Header: http.Header{"X-Test": {"yes"}},
+		Body:   `{"key": {{.Key}}, "state": "{{.State}}", "room": "{{.Vars.room}}"}`,
+	}
+	if err := h.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := h.Run(context.Background(), env); err != nil {
+			t.Fatal(err)
+		}
+		want := request{"POST", "/hook", `{"key": 3, "state": "BtnPressed", "room": "kitchen"}`, "yes"}
+		if got := <-reqs; got != want {
+			t.Errorf("request %+v, want %+v", got, want)
+		}
+	}
+
+	// Without Validate, the body is parsed on each run.
+	h = &HTTP{URL: s.URL + "/get", Body: "{{.Key}}"}
+	if err := h.Run(context.Background(), env); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-reqs; got.method != "GET" || got.body != "3" {
+		t.Errorf("request %+v", got)
+	}
+
+	err := (&HTTP{URL: s.URL + "/fail"}).Run(context.Background(), env)
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("err %v", err)
+	}
+	<-reqs
+
+	start := time.Now()
+	err = (&HTTP{URL: s.URL + "/slow", Timeout: 50 * time.Millisecond}).Run(context.Background(), env)
+	if err == nil || time.Since(start) > 5*time.Second {
+		t.Errorf("err %v after %v", err, time.Since(start))
+	}
+}
+
+func TestHTTPTemplates(t *testing.T) {
+	s, reqs := newServer(t)
+	env := &Env{Vars: map[string]string{}}
+
+	if err := (&HTTP{}).Validate(); err == nil {
+		t.Error("missing URL accepted")
+	}
+	if err := (&HTTP{URL: s.URL, Body: "{{.Key"}).Validate(); err == nil {
+		t.Error("invalid template accepted")
+	}
+	if err := (&HTTP{URL: s.URL, Body: "{{.Key"}).Run(context.Background(), env); err == nil {
+		t.Error("invalid template run")
+	}
+
+	// Missing variables are errors rather than "<no value>", and nothing
+	// is sent.
+	h := &HTTP{URL: s.URL, Body: "{{.Vars.room}}"}
+	h.Validate()
+	if err := h.Run(context.Background(), env); err == nil {
+		t.Error("missing variable accepted")
+	}
+	select {
+	case r := <-reqs:
+		t.Errorf("request %+v sent", r)
+	default:
+	}
+}
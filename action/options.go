@@ -0,0 +1,36 @@
+package action
+
+import (
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+)
+
+// Flash makes the key flash green when the action succeeds, or red when
+// it fails, for the given duration.
+func Flash(d time.Duration) func(*Button) {
+	return func(b *Button) {
+		b.flash = d
+	}
+}
+
+// Timeout limits the time the action may take.
+func Timeout(d time.Duration) func(*Button) {
+	return func(b *Button) {
+		b.timeout = d
+	}
+}
+
+// OnRelease runs the action when the key is released rather than pressed.
+func OnRelease() func(*Button) {
+	return func(b *Button) {
+		b.trigger = sd.BtnReleased
+	}
+}
+
+// OnResult sets a function called with the outcome of each run.
+func OnResult(f func(error)) func(*Button) {
+	return func(b *Button) {
+		b.onResult = f
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/emulator"
+	"github.com/KarpelesLab/streamdeck/mock"
+	"github.com/KarpelesLab/streamdeck/record"
+)
+
+// backend provides the decks the commands work on.
+type backend interface {
+	list() ([]*entry, error)
+}
+
+// entry is a deck found by a backend.
+type entry struct {
+	path     string
+	model    *sd.StreamdeckDevice
+	identify func() (serial, firmware string, err error)
+	open     func() (sd.Transport, error)
+}
+
+// usbBackend uses the decks connected to the USB bus.
+type usbBackend struct{}
+
+func (usbBackend) list() ([]*entry, error) {
+	var res []*entry
+	for _, d := range sd.ListUSB() {
+		res = append(res, &entry{
+			path:     d.Path(),
+			model:    d.Model,
+			identify: d.Identify,
+			open:     d.OpenTransport,
+		})
+	}
+	return res, nil
+}
+
+// mockBackend uses an emulated deck.
+type mockBackend struct {
+	model *sd.StreamdeckDevice
+	dev   sd.Transport
+}
+
+func newMockBackend(model string) (*mockBackend, error) {
+	m := lookupModel(model)
+	if m == nil {
+		return nil, fmt.Errorf("unknown model %q", model)
+	}
+	return &mockBackend{model: m, dev: mock.New(m, "MOCK0001")}, nil
+}
+
+// newEmulatorBackend uses an emulated deck shown in a web browser at addr.
+func newEmulatorBackend(model, addr string, stderr io.Writer) (*mockBackend, error) {
+	m := lookupModel(model)
+	if m == nil {
+		return nil, fmt.Errorf("unknown model %q", model)
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	e := emulator.New(m, "EMUL0001")
+	go e.Serve(l)
+	fmt.Fprintf(stderr, "emulator running at http://%s/\n", l.Addr())
+	return &mockBackend{model: m, dev: e}, nil
+}
+
+func (b *mockBackend) list() ([]*entry, error) {
+	return []*entry{{
+		path:  "mock",
+		model: b.model,
+		identify: func() (string, string, error) {
+			return sd.Identify(b.dev, b.model)
+		},
+		open: func() (sd.Transport, error) {
+			return b.dev, nil
+		},
+	}}, nil
+}
+
+// recordBackend records the traffic of the decks opened from another
+// backend to a file.
+type recordBackend struct {
+	backend
+	path string
+}
+
+func (b *recordBackend) list() ([]*entry, error) {
+	entries, err := b.backend.list()
+	for _, e := range entries {
+		open, model := e.open, e.model
+		e.open = func() (sd.Transport, error) {
+			t, err := open()
+			if err != nil {
+				return nil, err
+			}
+			r, err := record.Create(t, b.path, record.Model(model))
+			if err != nil {
+				t.Close()
+				return nil, err
+			}
+			return r, nil
+		}
+	}
+	return entries, err
+}
+
+// lookupModel finds a model by product ID (e.g. 0x0063) or by a word of
+// its name (e.g. mini).
+func lookupModel(name string) *sd.StreamdeckDevice {
+	if id, err := strconv.ParseUint(name, 0, 16); err == nil {
+		return sd.LookupDevice(uint16(id))
+	}
+	for _, m := range sd.Devices() {
+		for _, word := range strings.Fields(strings.ToLower(m.Name)) {
+			if word == strings.ToLower(name) {
+				return m
+			}
+		}
+	}
+	return nil
+}
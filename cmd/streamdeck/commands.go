@@ -0,0 +1,359 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/profile"
+	"github.com/KarpelesLab/streamdeck/termview"
+	"github.com/KarpelesLab/streamdeck/tile"
+	"github.com/KarpelesLab/streamdeck/udev"
+)
+
+// app holds what the commands need.
+type app struct {
+	stdout  io.Writer
+	stderr  io.Writer
+	serial  string
+	backend backend
+	// doc locates the system files checked by the doctor command.
+	doc udev.Doctor
+	// interrupt is closed to stop long running commands; if nil, they stop
+	// on SIGINT or SIGTERM.
+	interrupt chan struct{}
+}
+
+// find returns the deck selected by the -serial flag, or the first one.
+func (a *app) find() (*entry, error) {
+	entries, err := a.backend.list()
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no stream deck found")
+	}
+	if a.serial == "" {
+		return entries[0], nil
+	}
+
+	for _, e := range entries {
+		if serial, _, err := e.identify(); err == nil && serial == a.serial {
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("no stream deck found with serial number %s", a.serial)
+}
+
+// open opens the selected deck, leaving its display as is.
+func (a *app) open() (*sd.StreamDeck, error) {
+	e, err := a.find()
+	if err != nil {
+		return nil, err
+	}
+	t, err := e.open()
+	if err != nil {
+		return nil, err
+	}
+	return sd.Attach(t, e.model), nil
+}
+
+// wait blocks until the command is interrupted.
+func (a *app) wait() {
+	if a.interrupt != nil {
+		<-a.interrupt
+		return
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	<-c
+	signal.Stop(c)
+}
+
+func (a *app) list(args []string) error {
+	if len(args) != 0 {
+		return usageError("too many arguments")
+	}
+	entries, err := a.backend.list()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(a.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tMODEL\tSERIAL\tFIRMWARE")
+	for _, e := range entries {
+		serial, firmware, err := e.identify()
+		if err != nil {
+			serial, firmware = "?", err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.path, e.model.Name, serial, firmware)
+	}
+	return w.Flush()
+}
+
+func (a *app) info(args []string) error {
+	if len(args) != 0 {
+		return usageError("too many arguments")
+	}
+	e, err := a.find()
+	if err != nil {
+		return err
+	}
+	serial, firmware, err := e.identify()
+	if err != nil {
+		return err
+	}
+
+	m := e.model
+	w := tabwriter.NewWriter(a.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Path:\t%s\n", e.path)
+	fmt.Fprintf(w, "Model:\t%s (%04x:%04x)\n", m.Name, sd.VendorID, m.ProductID)
+	fmt.Fprintf(w, "Serial:\t%s\n", serial)
+	fmt.Fprintf(w, "Firmware:\t%s\n", firmware)
+	fmt.Fprintf(w, "Keys:\t%d (%dx%d)\n", m.NumButtons, m.NumButtonColumns, m.NumButtonRows)
+	fmt.Fprintf(w, "Key size:\t%dx%d px\n", m.ButtonSize, m.ButtonSize)
+	fmt.Fprintf(w, "Panel size:\t%dx%d px\n", m.PanelWidth(), m.PanelHeight())
+	return w.Flush()
+}
+
+func (a *app) brightness(args []string) error {
+	if len(args) != 1 {
+		return usageError("expected a brightness")
+	}
+	pc, err := strconv.Atoi(args[0])
+	if err != nil || pc < 0 || pc > 100 {
+		return usageError("brightness must be between 0 and 100")
+	}
+
+	deck, err := a.open()
+	if err != nil {
+		return err
+	}
+	defer deck.Close()
+	return deck.SetBrightness(uint8(pc))
+}
+
+func (a *app) reset(args []string) error {
+	if len(args) != 0 {
+		return usageError("too many arguments")
+	}
+	deck, err := a.open()
+	if err != nil {
+		return err
+	}
+	defer deck.Close()
+	return deck.Reset()
+}
+
+func (a *app) clear(args []string) error {
+	if len(args) > 1 {
+		return usageError("too many arguments")
+	}
+	deck, err := a.open()
+	if err != nil {
+		return err
+	}
+	defer deck.Close()
+
+	if len(args) == 0 {
+		deck.ClearAllBtns()
+		return nil
+	}
+	key, err := parseKey(deck, args[0])
+	if err != nil {
+		return err
+	}
+	return deck.ClearBtn(key)
+}
+
+func (a *app) setImage(args []string) error {
+	if len(args) != 2 {
+		return usageError("expected a key and a file")
+	}
+	deck, err := a.open()
+	if err != nil {
+		return err
+	}
+	defer deck.Close()
+
+	key, err := parseKey(deck, args[0])
+	if err != nil {
+		return err
+	}
+	return deck.FillImageFromFile(key, args[1])
+}
+
+func (a *app) setPanel(args []string) error {
+	if len(args) != 1 {
+		return usageError("expected a file")
+	}
+	deck, err := a.open()
+	if err != nil {
+		return err
+	}
+	defer deck.Close()
+	return deck.FillPanelFromFile(args[0])
+}
+
+func (a *app) text(args []string) error {
+	fs := flag.NewFlagSet("text", flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	fg := fs.String("color", "white", "text color")
+	bg := fs.String("bg", "black", "background color")
+	if err := fs.Parse(args); err != nil {
+		return usageError(err.Error())
+	}
+	if fs.NArg() != 2 {
+		return usageError("expected a key and a text")
+	}
+
+	var colors [2]color.Color
+	for i, s := range []string{*fg, *bg} {
+		c, err := profile.ParseColor(s)
+		if err != nil {
+			return usageError(err.Error())
+		}
+		colors[i] = c
+	}
+
+	deck, err := a.open()
+	if err != nil {
+		return err
+	}
+	defer deck.Close()
+
+	key, err := parseKey(deck, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	t := tile.New(tile.Text(fs.Arg(1)), tile.TextColor(colors[0]), tile.BgColor(colors[1]))
+	img, err := sd.RenderElement(t, deck.ButtonSize())
+	if err != nil {
+		return err
+	}
+	return deck.FillImage(key, img)
+}
+
+func (a *app) watch(args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	count := fs.Int("n", 0, "exit after this many events (0 for no limit)")
+	if err := fs.Parse(args); err != nil {
+		return usageError(err.Error())
+	}
+	if fs.NArg() != 0 {
+		return usageError("too many arguments")
+	}
+
+	deck, err := a.open()
+	if err != nil {
+		return err
+	}
+	defer deck.Close()
+
+	// Once watch returns, events are dropped instead of blocking the
+	// dispatch of the deck.
+	events := make(chan string, 16)
+	stop := make(chan struct{})
+	defer close(stop)
+	deck.SetBtnEventCb(func(btnIndex int, state sd.BtnState) {
+		s := "released"
+		if state == sd.BtnPressed {
+			s = "pressed"
+		}
+		select {
+		case events <- fmt.Sprintf("%s key %d %s", time.Now().Format("15:04:05.000"), btnIndex, s):
+		case <-stop:
+		}
+	})
+
+	done := make(chan struct{})
+	go func() {
+		a.wait()
+		close(done)
+	}()
+
+	for n := 0; *count == 0 || n < *count; n++ {
+		select {
+		case ev := <-events:
+			fmt.Fprintln(a.stdout, ev)
+		case <-done:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (a *app) runProfile(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	noReload := fs.Bool("no-reload", false, "don't reload the profile when it changes")
+	view := fs.Bool("view", false, "show the deck in the terminal; with -mock, keyboard keys press its keys")
+	if err := fs.Parse(args); err != nil {
+		return usageError(err.Error())
+	}
+	if fs.NArg() != 1 {
+		return usageError("expected a profile")
+	}
+
+	e, err := a.find()
+	if err != nil {
+		return err
+	}
+	t, err := e.open()
+	if err != nil {
+		return err
+	}
+	deck, err := sd.Open(t, e.model)
+	if err != nil {
+		return err
+	}
+	defer deck.Close()
+
+	serial, err := deck.GetSerialNumber()
+	if err != nil {
+		return err
+	}
+	r, err := profile.Run(fs.Arg(0), deck, serial)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if !*noReload {
+		r.Watch(time.Second, func(err error) {
+			fmt.Fprintf(a.stderr, "reload: %s\n", err)
+		})
+	}
+	if *view {
+		var options []func(*termview.Viewer)
+		if p, ok := t.(termview.Presser); ok {
+			options = append(options, termview.Keyboard(p))
+		}
+		v := termview.New(deck, options...)
+		go func() {
+			a.wait()
+			v.Close()
+		}()
+		return v.Run(os.Stdin, a.stdout)
+	}
+	a.wait()
+	return nil
+}
+
+// parseKey parses a key index and checks it against the deck.
+func parseKey(deck *sd.StreamDeck, s string) (int, error) {
+	key, err := strconv.Atoi(s)
+	if err != nil || key < 0 || key >= deck.NumButtons() {
+		return 0, usageError(fmt.Sprintf("invalid key %q, the deck has keys 0 to %d", s, deck.NumButtons()-1))
+	}
+	return key, nil
+}
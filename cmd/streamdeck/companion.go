@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/KarpelesLab/streamdeck/companion"
+)
+
+func (a *app) companion(args []string) error {
+	fs := flag.NewFlagSet("companion", flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	id := fs.String("id", "", "identifier of the surface in Companion (default: serial number)")
+	if err := fs.Parse(args); err != nil {
+		return usageError(err.Error())
+	}
+	if fs.NArg() != 1 {
+		return usageError("expected the address of Companion")
+	}
+
+	deck, err := a.open()
+	if err != nil {
+		return err
+	}
+	defer deck.Close()
+	deck.ClearAllBtns()
+
+	options := []func(*companion.Satellite){
+		companion.OnError(func(err error) {
+			fmt.Fprintf(a.stderr, "companion: %s\n", err)
+		}),
+		companion.OnConnect(func() {
+			fmt.Fprintf(a.stdout, "connected to %s\n", fs.Arg(0))
+		}),
+	}
+	if *id != "" {
+		options = append(options, companion.DeviceID(*id))
+	}
+	c, err := companion.Dial(fs.Arg(0), deck, options...)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	a.wait()
+	return nil
+}
@@ -0,0 +1,156 @@
+// Command streamdeck manages Stream Decks from the command line.
+//
+// Usage:
+//
+//	streamdeck [-serial SERIAL] [-mock MODEL] [-emulate ADDR] [-remote ADDR] [-record FILE] <command> [arguments]
+//
+// Run "streamdeck help" for the list of commands. With -mock (or the
+// STREAMDECK_MOCK environment variable), commands run against an emulated
+// device of the given model instead of the USB devices, e.g. in CI. With
+// -emulate, the emulated device is also shown in a web browser, where its
+// keys can be clicked. With -remote, commands run against a deck exposed by
+// "streamdeck remote-serve" on another machine, authenticating with the
+// secret in the STREAMDECK_SECRET environment variable. With -record, the
+// traffic with the deck is recorded to a file, which the record package can
+// replay.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/KarpelesLab/streamdeck/emulator"
+)
+
+// command is a subcommand of the tool.
+type command struct {
+	args  string
+	short string
+	run   func(a *app, args []string) error
+}
+
+var commands = map[string]*command{
+	"list":         {"", "list the connected decks and their firmware", (*app).list},
+	"info":         {"", "show details about a deck", (*app).info},
+	"brightness":   {"<percent>", "set the brightness", (*app).brightness},
+	"reset":        {"", "reset a deck", (*app).reset},
+	"clear":        {"[key]", "clear a key, or all keys", (*app).clear},
+	"set-image":    {"<key> <file>", "display an image on a key", (*app).setImage},
+	"set-panel":    {"<file>", "display an image across all keys", (*app).setPanel},
+	"text":         {"[-color C] [-bg C] <key> <text>", "display text on a key", (*app).text},
+	"watch":        {"[-n count]", "print key events", (*app).watch},
+	"plugin":       {"[-settings F] <dir> [key=action...]", "run an Elgato Stream Deck plugin", (*app).plugin},
+	"run":          {"[-no-reload] [-view] <profile>", "display a profile until interrupted", (*app).runProfile},
+	"mqtt":         {"[-broker A] [-prefix P] [-discovery P] [-profile P]", "bridge a deck to an MQTT broker", (*app).mqtt},
+	"osc":          {"[-listen A] [-target A] [-prefix P] [-profile P] [key=/address...]", "send and receive OSC messages", (*app).osc},
+	"serve":        {"[-addr A] [-socket S] [-token T] [-profile P]", "serve the decks over a local HTTP API", (*app).serve},
+	"udev-rules":   {"[-group G] [-mode M] [-uaccess]", "print udev rules for all supported models", (*app).udevRules},
+	"companion":    {"[-id ID] <host[:port]>", "use a deck as a Bitfocus Companion satellite", (*app).companion},
+	"remote-serve": {"[-addr A] [-secret S] [-cert F -key F]", "expose a deck to remote clients over TCP", (*app).remoteServe},
+	"doctor":       {"[-group G]", "check device permissions and udev rules", (*app).doctor},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr, os.Getenv("STREAMDECK_MOCK")))
+}
+
+// run executes the command line and returns the exit status.
+func run(args []string, stdout, stderr io.Writer, mockModel string) int {
+	fs := flag.NewFlagSet("streamdeck", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	serial := fs.String("serial", "", "serial number of the deck to use")
+	fs.StringVar(&mockModel, "mock", mockModel, "use an emulated deck of the given model (e.g. mini, 0x0060)")
+	emulate := fs.String("emulate", "", "show the emulated deck in a web browser at this address (e.g. "+emulator.DefaultAddr+")")
+	remoteAddr := fs.String("remote", "", "use the deck served by \"streamdeck remote-serve\" at this address")
+	remoteCA := fs.String("remote-ca", "", "connect to the remote deck over TLS, trusting this certificate")
+	recordPath := fs.String("record", "", "record the traffic with the deck to this file")
+	fs.Usage = func() { usage(stderr, fs) }
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 || fs.Arg(0) == "help" {
+		usage(stdout, fs)
+		return 0
+	}
+
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "streamdeck: unknown command %q\n", fs.Arg(0))
+		usage(stderr, fs)
+		return 2
+	}
+
+	a := &app{stdout: stdout, stderr: stderr, serial: *serial}
+	if *emulate != "" {
+		if mockModel == "" {
+			mockModel = "mini"
+		}
+		b, err := newEmulatorBackend(mockModel, *emulate, stderr)
+		if err != nil {
+			fmt.Fprintf(stderr, "streamdeck: %s\n", err)
+			return 2
+		}
+		a.backend = b
+	} else if mockModel != "" {
+		b, err := newMockBackend(mockModel)
+		if err != nil {
+			fmt.Fprintf(stderr, "streamdeck: %s\n", err)
+			return 2
+		}
+		a.backend = b
+	} else if *remoteAddr != "" {
+		b, err := newRemoteBackend(*remoteAddr, os.Getenv("STREAMDECK_SECRET"), *remoteCA, func(err error) {
+			fmt.Fprintf(stderr, "remote: %s\n", err)
+		})
+		if err != nil {
+			fmt.Fprintf(stderr, "streamdeck: %s\n", err)
+			return 2
+		}
+		a.backend = b
+	} else {
+		a.backend = usbBackend{}
+	}
+	if *recordPath != "" {
+		a.backend = &recordBackend{backend: a.backend, path: *recordPath}
+	}
+
+	if err := cmd.run(a, fs.Args()[1:]); err != nil {
+		fmt.Fprintf(stderr, "streamdeck %s: %s\n", fs.Arg(0), err)
+		if _, ok := err.(usageError); ok {
+			fmt.Fprintf(stderr, "usage: streamdeck %s %s\n", fs.Arg(0), cmd.args)
+			return 2
+		}
+		return 1
+	}
+	return 0
+}
+
+func usage(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintln(w, "usage: streamdeck [flags] <command> [arguments]")
+	fmt.Fprintln(w, "\nCommands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cmd := commands[name]
+		fmt.Fprintf(w, "  %-45s %s\n", strings.TrimSpace(name+" "+cmd.args), cmd.short)
+	}
+
+	fmt.Fprintln(w, "\nFlags:")
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+}
+
+// usageError reports invalid arguments.
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
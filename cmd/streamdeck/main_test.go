@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/KarpelesLab/streamdeck/mock"
+)
+
+// newTestApp returns an app using an emulated Stream Deck Mini.
+func newTestApp(t *testing.T) (*app, *mock.Device, *bytes.Buffer) {
+	t.Helper()
+	b, err := newMockBackend("mini")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := new(bytes.Buffer)
+	a := &app{stdout: out, stderr: out, backend: b, interrupt: make(chan struct{})}
+	return a, b.dev.(*mock.Device), out
+}
+
+func keyColor(m *mock.Device, btnIndex, x, y int) color.RGBA {
+	return m.Key(btnIndex).(*image.RGBA).RGBAAt(x, y)
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		args   []string
+		status int
+		output string
+	}{
+		{[]string{"help"}, 0, "set-image <key> <file>"},
+		{nil, 0, "Commands:"},
+		{[]string{"nope"}, 2, `unknown command "nope"`},
+		{[]string{"-nope", "list"}, 2, "flag provided but not defined"},
+		{[]string{"-mock", "nope", "list"}, 2, `unknown model "nope"`},
+		{[]string{"-mock", "mini", "list"}, 0, "MOCK0001"},
+		{[]string{"-mock", "0x0060", "info"}, 0, "Keys:        15 (5x3)"},
+		{[]string{"-mock", "mini", "-serial", "OTHER", "info"}, 1, "no stream deck found with serial number OTHER"},
+		{[]string{"-mock", "mini", "brightness", "50"}, 0, ""},
+		{[]string{"-mock", "mini", "brightness", "101"}, 2, "usage: streamdeck brightness <percent>"},
+		{[]string{"-mock", "mini", "clear", "6"}, 2, "the deck has keys 0 to 5"},
+		{[]string{"-mock", "mini", "text", "-color", "nope", "0", "hi"}, 2, ""},
+		{[]string{"-mock", "mini", "set-image", "0", filepath.Join(dir, "missing.png")}, 1, ""},
+		{[]string{"-mock", "mini", "-record", filepath.Join(dir, "session.jsonl"), "clear"}, 0, ""},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		status := run(test.args, &out, &out, "")
+		if status != test.status || !strings.Contains(out.String(), test.output) {
+			t.Errorf("%q: status %d, output:\n%s\nwant status %d and %q", test.args, status, out.String(), test.status, test.output)
+		}
+	}
+
+	if fi, err := os.Stat(filepath.Join(dir, "session.jsonl")); err != nil || fi.Size() == 0 {
+		t.Errorf("no session recorded: %v", err)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	a, m, _ := newTestApp(t)
+	if err := a.brightness([]string{"30"}); err != nil {
+		t.Fatal(err)
+	}
+	if m.Brightness() != 30 {
+		t.Errorf("brightness = %d, want 30", m.Brightness())
+	}
+
+	a, m, _ = newTestApp(t)
+	if err := a.text([]string{"-bg", "#ff0000", "2", "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if c := keyColor(m, 2, 2, 2); c != (color.RGBA{255, 0, 0, 255}) {
+		t.Errorf("key 2 is %v, want a red background", c)
+	}
+
+	path := filepath.Join(t.TempDir(), "blue.png")
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for i := 0; i < len(img.Pix); i += 4 {
+		copy(img.Pix[i:], []byte{0, 0, 255, 255})
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	png.Encode(f, img)
+	f.Close()
+
+	a, m, _ = newTestApp(t)
+	if err := a.setImage([]string{"5", path}); err != nil {
+		t.Fatal(err)
+	}
+	if c := keyColor(m, 5, 40, 40); c != (color.RGBA{0, 0, 255, 255}) {
+		t.Errorf("key 5 is %v, want blue", c)
+	}
+
+	a, m, _ = newTestApp(t)
+	if err := a.setPanel([]string{path}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		if c := keyColor(m, i, 40, 40); c != (color.RGBA{0, 0, 255, 255}) {
+			t.Errorf("key %d is %v, want blue", i, c)
+		}
+	}
+
+	a, m, _ = newTestApp(t)
+	if err := a.clear(nil); err != nil {
+		t.Fatal(err)
+	}
+	if c := keyColor(m, 0, 40, 40); c != (color.RGBA{0, 0, 0, 255}) {
+		t.Errorf("cleared key 0 is %v, want black", c)
+	}
+
+	a, m, _ = newTestApp(t)
+	if err := a.reset(nil); err != nil {
+		t.Fatal(err)
+	}
+	if m.Resets() != 1 {
+		t.Errorf("%d resets, want 1", m.Resets())
+	}
+}
+
+func TestWatch(t *testing.T) {
+	a, m, out := newTestApp(t)
+	done := make(chan error, 1)
+	go func() {
+		done <- a.watch([]string{"-n", "2"})
+	}()
+
+	// The callback may not be set yet: press until events come through.
+	deadline := time.After(5 * time.Second)
+	for {
+		m.Click(3)
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(out.String(), "key 3 pressed") || !strings.Contains(out.String(), "key 3 released") {
+				t.Errorf("output:\n%s", out.String())
+			}
+			return
+		case <-deadline:
+			t.Fatal("timeout")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestWatchInterrupt(t *testing.T) {
+	a, _, _ := newTestApp(t)
+	done := make(chan error, 1)
+	go func() {
+		done <- a.watch(nil)
+	}()
+	close(a.interrupt)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch not interrupted")
+	}
+}
+
+func TestLookupModel(t *testing.T) {
+	for name, want := range map[string]uint16{"mini": 0x0063, "0x0090": 0x0090, "MINI": 0x0063, "legacy": 0x0060, "96": 0x0060} {
+		if m := lookupModel(name); m == nil || m.ProductID != want {
+			t.Errorf("lookupModel(%q) = %v, want %04x", name, m, want)
+		}
+	}
+	if m := lookupModel("nope"); m != nil {
+		t.Errorf("lookupModel(\"nope\") = %v", m.Name)
+	}
+}
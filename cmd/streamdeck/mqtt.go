@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/mqtt"
+)
+
+func (a *app) mqtt(args []string) error {
+	fs := flag.NewFlagSet("mqtt", flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	broker := fs.String("broker", "localhost:1883", "address of the MQTT broker")
+	prefix := fs.String("prefix", mqtt.DefaultPrefix, "prefix of the topics")
+	discovery := fs.String("discovery", "", "publish Home Assistant discovery messages under this prefix (e.g. homeassistant)")
+	user := fs.String("user", "", "user name on the broker")
+	password := fs.String("password", os.Getenv("MQTT_PASSWORD"), "password on the broker")
+	longPress := fs.Duration("long-press", 500*time.Millisecond, "how long a key must be held for a long press")
+	prof := fs.String("profile", "", "display this profile on the deck")
+	if err := fs.Parse(args); err != nil {
+		return usageError(err.Error())
+	}
+	if fs.NArg() != 0 {
+		return usageError("too many arguments")
+	}
+
+	e, err := a.find()
+	if err != nil {
+		return err
+	}
+	t, err := e.open()
+	if err != nil {
+		return err
+	}
+	deck, err := sd.Open(t, e.model)
+	if err != nil {
+		return err
+	}
+	defer deck.Close()
+
+	serial, err := deck.GetSerialNumber()
+	if err != nil {
+		return err
+	}
+	options := []func(*mqtt.Client){
+		mqtt.Will(mqtt.StatusTopic(*prefix, serial), []byte("offline"), true),
+		mqtt.OnError(func(err error) {
+			fmt.Fprintf(a.stderr, "mqtt: %s\n", err)
+		}),
+	}
+	if *user != "" {
+		options = append(options, mqtt.Auth(*user, *password))
+	}
+	c, err := mqtt.Dial(*broker, options...)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	bridgeOptions := []func(*mqtt.Bridge){
+		mqtt.Prefix(*prefix),
+		mqtt.LongPressAfter(*longPress),
+		mqtt.OnBridgeError(func(err error) {
+			fmt.Fprintf(a.stderr, "%s\n", err)
+		}),
+	}
+	if *discovery != "" {
+		bridgeOptions = append(bridgeOptions, mqtt.Discovery(*discovery))
+	}
+	if *prof != "" {
+		bridgeOptions = append(bridgeOptions, mqtt.Profile(*prof))
+	}
+	b, err := mqtt.NewBridge(c, deck, bridgeOptions...)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	fmt.Fprintf(a.stdout, "bridging %s to %s under %s/%s\n", serial, *broker, *prefix, serial)
+	a.wait()
+	return nil
+}
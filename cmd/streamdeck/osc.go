@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/KarpelesLab/streamdeck/osc"
+)
+
+func (a *app) osc(args []string) error {
+	fs := flag.NewFlagSet("osc", flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	listen := fs.String("listen", ":9000", "UDP address to listen on")
+	target := fs.String("target", "", "UDP address key events are sent to")
+	prefix := fs.String("prefix", osc.DefaultPrefix, "prefix of the addresses")
+	prof := fs.String("profile", "", "display this profile on the deck")
+	if err := fs.Parse(args); err != nil {
+		return usageError(err.Error())
+	}
+
+	options := []func(*osc.Bridge){
+		osc.Prefix(*prefix),
+		osc.OnBridgeError(func(err error) {
+			fmt.Fprintf(a.stderr, "%s\n", err)
+		}),
+	}
+	// key=address arguments send 1 and 0 to address instead of the
+	// default address of the key.
+	for _, arg := range fs.Args() {
+		i := strings.IndexByte(arg, '=')
+		if i < 0 || !strings.HasPrefix(arg[i+1:], "/") {
+			return usageError(fmt.Sprintf("invalid assignment %q, expected key=/address", arg))
+		}
+		key, err := strconv.Atoi(arg[:i])
+		if err != nil {
+			return usageError(fmt.Sprintf("invalid key %q", arg[:i]))
+		}
+		address := arg[i+1:]
+		options = append(options, osc.KeyMessages(key, osc.NewMessage(address, 1), osc.NewMessage(address, 0)))
+	}
+	if *target != "" {
+		options = append(options, osc.Target(*target))
+	}
+	if *prof != "" {
+		options = append(options, osc.Profile(*prof))
+	}
+
+	deck, err := a.open()
+	if err != nil {
+		return err
+	}
+	defer deck.Close()
+
+	c, err := osc.Listen(*listen, osc.OnError(func(err error) {
+		fmt.Fprintf(a.stderr, "%s\n", err)
+	}))
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	b, err := osc.NewBridge(c, deck, options...)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	fmt.Fprintf(a.stdout, "listening for OSC messages on %s under %s\n", c.LocalAddr(), *prefix)
+	a.wait()
+	return nil
+}
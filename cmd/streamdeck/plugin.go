@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/KarpelesLab/streamdeck/plugin"
+)
+
+// pluginSettings is the file the settings of a plugin are saved to.
+type pluginSettings struct {
+	mu     sync.Mutex
+	path   string
+	Global json.RawMessage         `json:"global,omitempty"`
+	Keys   map[int]json.RawMessage `json:"keys"`
+}
+
+func (s *pluginSettings) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path, data, 0644)
+}
+
+func (a *app) plugin(args []string) error {
+	fs := flag.NewFlagSet("plugin", flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	settingsFile := fs.String("settings", "", "load and save the settings of the actions in this file")
+	verbose := fs.Bool("v", false, "show the output of the plugin")
+	if err := fs.Parse(args); err != nil {
+		return usageError(err.Error())
+	}
+	if fs.NArg() < 1 {
+		return usageError("expected a plugin directory")
+	}
+
+	p, err := plugin.Load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	// key=action arguments; by default, the actions on the first keys
+	assign := make(map[int]string)
+	for _, arg := range fs.Args()[1:] {
+		i := strings.IndexByte(arg, '=')
+		if i < 0 {
+			return usageError(fmt.Sprintf("invalid assignment %q, expected key=action", arg))
+		}
+		key, err := strconv.Atoi(arg[:i])
+		if err != nil {
+			return usageError(fmt.Sprintf("invalid key %q", arg[:i]))
+		}
+		assign[key] = arg[i+1:]
+	}
+	if len(assign) == 0 {
+		for i, info := range p.Manifest.Actions {
+			assign[i] = info.UUID
+		}
+	}
+
+	settings := &pluginSettings{path: *settingsFile, Keys: make(map[int]json.RawMessage)}
+	if *settingsFile != "" {
+		data, err := ioutil.ReadFile(*settingsFile)
+		if err == nil {
+			err = json.Unmarshal(data, settings)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	deck, err := a.open()
+	if err != nil {
+		return err
+	}
+	defer deck.Close()
+	deck.ClearAllBtns()
+
+	save := func() {
+		if *settingsFile == "" {
+			return
+		}
+		if err := settings.save(); err != nil {
+			fmt.Fprintf(a.stderr, "%s\n", err)
+		}
+	}
+	options := []func(*plugin.Host){
+		plugin.GlobalSettings(settings.Global),
+		plugin.OnSettings(func(key int, s json.RawMessage) {
+			settings.mu.Lock()
+			settings.Keys[key] = s
+			settings.mu.Unlock()
+			save()
+		}),
+		plugin.OnGlobalSettings(func(s json.RawMessage) {
+			settings.mu.Lock()
+			settings.Global = s
+			settings.mu.Unlock()
+			save()
+		}),
+	}
+	if *verbose {
+		options = append(options, plugin.Log(a.stderr))
+	}
+	h := plugin.New(p, deck, options...)
+	defer h.Close()
+
+	for key, uuid := range assign {
+		if key >= deck.NumButtons() {
+			if len(fs.Args()) > 1 {
+				return usageError(fmt.Sprintf("invalid key %d", key))
+			}
+			continue
+		}
+		if err := h.Assign(key, uuid, settings.Keys[key]); err != nil {
+			return err
+		}
+	}
+	if err := h.Start(); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.stdout, "running %s\n", p.Manifest.Name)
+
+	exited := make(chan error, 1)
+	go func() { exited <- h.Wait() }()
+	interrupted := make(chan struct{})
+	go func() {
+		a.wait()
+		close(interrupted)
+	}()
+	select {
+	case err := <-exited:
+		if err != nil {
+			return fmt.Errorf("plugin exited: %w", err)
+		}
+		return fmt.Errorf("plugin exited")
+	case <-interrupted:
+		return nil
+	}
+}
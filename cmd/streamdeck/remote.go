@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/remote"
+)
+
+func (a *app) remoteServe(args []string) error {
+	fs := flag.NewFlagSet("remote-serve", flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	addr := fs.String("addr", ":"+strconv.Itoa(remote.DefaultPort), "address to listen on")
+	secret := fs.String("secret", os.Getenv("STREAMDECK_SECRET"), "secret shared with the clients")
+	cert := fs.String("cert", "", "serve over TLS with this certificate file")
+	key := fs.String("key", "", "private key of the certificate")
+	if err := fs.Parse(args); err != nil {
+		return usageError(err.Error())
+	}
+	if fs.NArg() != 0 {
+		return usageError("too many arguments")
+	}
+	if *secret == "" {
+		return usageError("a secret is required (-secret or STREAMDECK_SECRET)")
+	}
+	if (*cert == "") != (*key == "") {
+		return usageError("-cert and -key go together")
+	}
+
+	options := []func(*remote.Server){
+		remote.OnServerError(func(err error) {
+			fmt.Fprintf(a.stderr, "remote: %s\n", err)
+		}),
+	}
+	if *cert != "" {
+		c, err := tls.LoadX509KeyPair(*cert, *key)
+		if err != nil {
+			return err
+		}
+		options = append(options, remote.ServerTLS(&tls.Config{Certificates: []tls.Certificate{c}}))
+	}
+
+	e, err := a.find()
+	if err != nil {
+		return err
+	}
+	t, err := e.open()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	s := remote.NewServer(t, e.model, *secret, options...)
+	defer s.Close()
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(l) }()
+	fmt.Fprintf(a.stdout, "serving %s on %s\n", e.model.Name, l.Addr())
+
+	done := make(chan struct{})
+	go func() {
+		a.wait()
+		close(done)
+	}()
+	select {
+	case err := <-served:
+		return err
+	case <-done:
+		return nil
+	}
+}
+
+// remoteBackend uses a deck exposed by "streamdeck remote-serve" on
+// another machine.
+type remoteBackend struct {
+	addr    string
+	secret  string
+	tls     *tls.Config
+	onError func(error)
+	client  *remote.Client
+}
+
+// newRemoteBackend connects to addr over TLS if ca, the certificate of the
+// server or of its authority, is set.
+func newRemoteBackend(addr, secret, ca string, onError func(error)) (*remoteBackend, error) {
+	if secret == "" {
+		return nil, errors.New("the STREAMDECK_SECRET environment variable must be set to use -remote")
+	}
+	b := &remoteBackend{addr: addr, secret: secret, onError: onError}
+	if ca != "" {
+		pem, err := ioutil.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", ca)
+		}
+		b.tls = &tls.Config{RootCAs: pool}
+	}
+	return b, nil
+}
+
+func (b *remoteBackend) list() ([]*entry, error) {
+	if b.client == nil {
+		options := []func(*remote.Client){remote.OnError(b.onError)}
+		if b.tls != nil {
+			options = append(options, remote.TLS(b.tls))
+		}
+		c, err := remote.Dial(b.addr, b.secret, options...)
+		if err != nil {
+			return nil, err
+		}
+		b.client = c
+	}
+	c := b.client
+	return []*entry{{
+		path:  "remote:" + b.addr,
+		model: c.Model(),
+		identify: func() (string, string, error) {
+			return sd.Identify(c, c.Model())
+		},
+		open: func() (sd.Transport, error) {
+			return c, nil
+		},
+	}}, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/daemon"
+)
+
+func (a *app) serve(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	addr := fs.String("addr", daemon.DefaultAddr, "TCP address to listen on")
+	socket := fs.String("socket", "", "listen on this Unix socket instead of TCP")
+	token := fs.String("token", os.Getenv("STREAMDECK_TOKEN"), "token required from clients")
+	prof := fs.String("profile", "", "display this profile on the decks")
+	if err := fs.Parse(args); err != nil {
+		return usageError(err.Error())
+	}
+	if fs.NArg() != 0 {
+		return usageError("too many arguments")
+	}
+
+	entries, err := a.backend.list()
+	if err != nil {
+		return err
+	}
+	if a.serial != "" {
+		e, err := a.find()
+		if err != nil {
+			return err
+		}
+		entries = []*entry{e}
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("no stream deck found")
+	}
+
+	var options []func(*daemon.Server)
+	if *token != "" {
+		options = append(options, daemon.Token(*token))
+	}
+	s := daemon.New(options...)
+	defer s.Close()
+
+	for _, e := range entries {
+		t, err := e.open()
+		if err != nil {
+			fmt.Fprintf(a.stderr, "%s: %s\n", e.path, err)
+			continue
+		}
+		deck, err := sd.Open(t, e.model)
+		if err != nil {
+			fmt.Fprintf(a.stderr, "%s: %s\n", e.path, err)
+			continue
+		}
+		var deckOptions []func(*daemon.Deck)
+		if *prof != "" {
+			deckOptions = append(deckOptions, daemon.Profile(*prof))
+		}
+		d, err := s.Add(deck, deckOptions...)
+		if err != nil {
+			deck.Close()
+			return err
+		}
+		fmt.Fprintf(a.stdout, "serving %s (%s)\n", d.Serial(), deck.Info.Name)
+	}
+	if len(s.Decks()) == 0 {
+		return fmt.Errorf("no stream deck could be opened")
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		if *socket != "" {
+			fmt.Fprintf(a.stdout, "listening on %s\n", *socket)
+			errc <- s.ListenAndServeUnix(*socket)
+		} else {
+			fmt.Fprintf(a.stdout, "listening on %s\n", *addr)
+			errc <- s.ListenAndServe(*addr)
+		}
+	}()
+
+	interrupted := make(chan struct{})
+	go func() {
+		a.wait()
+		close(interrupted)
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-interrupted:
+		return nil
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/KarpelesLab/streamdeck/udev"
+)
+
+func (a *app) udevRules(args []string) error {
+	fs := flag.NewFlagSet("udev-rules", flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	var opts udev.RuleOptions
+	fs.StringVar(&opts.Group, "group", udev.DefaultGroup, "group given access to the devices")
+	fs.StringVar(&opts.Mode, "mode", "0660", "permissions of the device nodes")
+	fs.BoolVar(&opts.Uaccess, "uaccess", false, "also grant access to the user logged in at the seat")
+	if err := fs.Parse(args); err != nil {
+		return usageError(err.Error())
+	}
+	if fs.NArg() != 0 {
+		return usageError("too many arguments")
+	}
+	return udev.WriteRules(a.stdout, opts)
+}
+
+func (a *app) doctor(args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	doc := a.doc
+	fs.StringVar(&doc.Group, "group", udev.DefaultGroup, "group expected to have access to the devices")
+	if err := fs.Parse(args); err != nil {
+		return usageError(err.Error())
+	}
+	if fs.NArg() != 0 {
+		return usageError("too many arguments")
+	}
+
+	r, err := doc.Check()
+	if err != nil {
+		return err
+	}
+	if err := r.Write(a.stdout); err != nil {
+		return err
+	}
+	if !r.OK() {
+		return fmt.Errorf("%d problem(s) found", len(r.Problems))
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package companion
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// parseLine splits a line of the Satellite protocol into its command and
+// parameters. Parameters are KEY=VALUE pairs, where values can be quoted;
+// a lone KEY means "true".
+func parseLine(line string) (string, map[string]string) {
+	line = strings.TrimSpace(line)
+	cmd := line
+	rest := ""
+	if i := strings.IndexByte(line, ' '); i >= 0 {
+		cmd, rest = line[:i], line[i+1:]
+	}
+
+	params := make(map[string]string)
+	for {
+		rest = strings.TrimLeft(rest, " ")
+		if rest == "" {
+			return cmd, params
+		}
+		end := strings.IndexAny(rest, "= ")
+		if end < 0 {
+			params[rest] = "true"
+			return cmd, params
+		}
+		key := rest[:end]
+		if rest[end] == ' ' {
+			params[key] = "true"
+			rest = rest[end:]
+			continue
+		}
+		rest = rest[end+1:]
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				b.WriteByte(rest[i])
+			}
+			value = b.String()
+			if i < len(rest) {
+				i++ // closing quote
+			}
+			rest = rest[i:]
+		} else {
+			end := strings.IndexByte(rest, ' ')
+			if end < 0 {
+				end = len(rest)
+			}
+			value, rest = rest[:end], rest[end:]
+		}
+		params[key] = value
+	}
+}
+
+// formatLine formats a command and its parameters, quoting values as
+// needed. Parameters are sorted, so lines are deterministic.
+func formatLine(cmd string, params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(cmd)
+	for _, k := range keys {
+		v := params[k]
+		if v == "" || strings.ContainsAny(v, " \"\\") {
+			v = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+		}
+		fmt.Fprintf(&b, " %s=%s", k, v)
+	}
+	return b.String()
+}
@@ -0,0 +1,403 @@
+// Package companion connects Stream Decks to Bitfocus Companion as
+// satellite surfaces, using the Companion Satellite TCP protocol: the deck
+// shows the buttons Companion renders, and key presses are sent back to
+// Companion.
+package companion
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/draw"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/internal/reconnect"
+	"github.com/KarpelesLab/streamdeck/profile"
+	"github.com/KarpelesLab/streamdeck/tile"
+)
+
+// DefaultPort is the port Companion listens on for satellites.
+const DefaultPort = 16622
+
+// Satellite is a deck registered as a surface of Companion. It reconnects
+// when the connection is lost.
+type Satellite struct {
+	addr        string
+	surface     sd.Surface
+	model       *sd.StreamdeckDevice
+	deviceID    string
+	productName string
+	keepAlive   time.Duration
+	onError     func(error)
+	onConnect   func()
+
+	mu     sync.Mutex
+	conn   net.Conn
+	done   chan struct{}
+	closed chan struct{}
+}
+
+// DeviceID sets the identifier of the surface in Companion, by default the
+// serial number of the deck.
+func DeviceID(id string) func(*Satellite) {
+	return func(c *Satellite) {
+		c.deviceID = id
+	}
+}
+
+// ProductName sets the product name shown in Companion.
+func ProductName(name string) func(*Satellite) {
+	return func(c *Satellite) {
+		c.productName = name
+	}
+}
+
+// Model sets the model of the device, needed to map the keys when the
+// surface isn't a StreamDeck.
+func Model(m *sd.StreamdeckDevice) func(*Satellite) {
+	return func(c *Satellite) {
+		c.model = m
+	}
+}
+
+// KeepAlive sets the interval of keep alive pings, 2 seconds by default.
+// The connection is considered lost after three intervals without data.
+func KeepAlive(d time.Duration) func(*Satellite) {
+	return func(c *Satellite) {
+		c.keepAlive = d
+	}
+}
+
+// OnError sets a function called when the connection fails.
+func OnError(f func(error)) func(*Satellite) {
+	return func(c *Satellite) {
+		c.onError = f
+	}
+}
+
+// OnConnect sets a function called whenever the surface is registered in
+// Companion.
+func OnConnect(f func()) func(*Satellite) {
+	return func(c *Satellite) {
+		c.onConnect = f
+	}
+}
+
+// Dial connects to Companion at addr (host, or host:port) and registers
+// the surface. The first connection must succeed; later ones are retried
+// until Close.
+func Dial(addr string, s sd.Surface, options ...func(*Satellite)) (*Satellite, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, strconv.Itoa(DefaultPort))
+	}
+	c := &Satellite{
+		addr:      addr,
+		surface:   s,
+		keepAlive: 2 * time.Second,
+		done:      make(chan struct{}),
+		closed:    make(chan struct{}),
+	}
+	if dev, ok := s.(*sd.StreamDeck); ok {
+		c.model = dev.Info
+		c.deviceID, _ = dev.GetSerialNumber()
+	}
+	for _, option := range options {
+		option(c)
+	}
+	if c.model == nil {
+		return nil, fmt.Errorf("the model of the surface is required")
+	}
+	if c.deviceID == "" {
+		return nil, fmt.Errorf("a device ID is required")
+	}
+	if c.productName == "" {
+		c.productName = c.model.Name
+	}
+
+	conn, br, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+	s.SetBtnEventCb(c.event)
+	go c.run(conn, br)
+	return c, nil
+}
+
+// connect opens a connection and registers the surface.
+func (c *Satellite) connect() (net.Conn, *bufio.Reader, error) {
+	conn, err := net.DialTimeout("tcp", c.addr, 10*time.Second)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	br := bufio.NewReader(conn)
+
+	fail := func(err error) (net.Conn, *bufio.Reader, error) {
+		conn.Close()
+		return nil, nil, err
+	}
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return fail(err)
+	}
+	if cmd, _ := parseLine(line); cmd != "BEGIN" {
+		return fail(fmt.Errorf("companion: unexpected greeting %q", strings.TrimSpace(line)))
+	}
+
+	add := formatLine("ADD-DEVICE", map[string]string{
+		"DEVICEID":     c.deviceID,
+		"PRODUCT_NAME": c.productName,
+		"KEYS_TOTAL":   strconv.Itoa(c.model.NumButtons),
+		"KEYS_PER_ROW": strconv.Itoa(c.model.NumButtonColumns),
+		"BITMAPS":      strconv.Itoa(c.model.ButtonSize),
+		"COLORS":       "hex",
+		"TEXT":         "true",
+	})
+	if _, err := conn.Write([]byte(add + "\n")); err != nil {
+		return fail(err)
+	}
+
+	// Companion may send other messages before the answer.
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return fail(err)
+		}
+		cmd, params := parseLine(line)
+		if cmd != "ADD-DEVICE" {
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "ADD-DEVICE ERROR") {
+			return fail(fmt.Errorf("companion: device rejected: %s", params["MESSAGE"]))
+		}
+		break
+	}
+
+	conn.SetDeadline(time.Time{})
+	c.mu.Lock()
+	c.conn = conn
+	c.mu.Unlock()
+	if c.onConnect != nil {
+		go c.onConnect()
+	}
+	return conn, br, nil
+}
+
+// run serves connections until Close, reconnecting when they are lost.
+func (c *Satellite) run(conn net.Conn, br *bufio.Reader) {
+	defer close(c.closed)
+	serve := func() error {
+		return c.serve(conn, br)
+	}
+	lost := func() {
+		c.mu.Lock()
+		c.conn = nil
+		c.mu.Unlock()
+		conn.Close()
+
+		select {
+		case <-c.done:
+		default:
+			c.clear()
+		}
+	}
+	connect := func() error {
+		var err error
+		conn, br, err = c.connect()
+		return err
+	}
+	reconnect.Loop(c.done, serve, lost, connect, c.onError)
+}
+
+// serve handles the messages of Companion until the connection fails, and
+// pings it.
+func (c *Satellite) serve(conn net.Conn, br *bufio.Reader) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		t := time.NewTicker(c.keepAlive)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				c.send("PING keepalive")
+			}
+		}
+	}()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(3 * c.keepAlive))
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		cmd, params := parseLine(line)
+		if id, ok := params["DEVICEID"]; ok && id != c.deviceID {
+			continue
+		}
+
+		switch cmd {
+		case "PING":
+			c.send("PONG " + strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "PING")))
+		case "KEY-STATE":
+			if err := c.keyState(params); err != nil && c.onError != nil {
+				c.onError(err)
+			}
+		case "KEYS-CLEAR":
+			c.clear()
+		case "BRIGHTNESS":
+			if dev, ok := c.surface.(interface{ SetBrightness(uint8) error }); ok {
+				if v, err := strconv.Atoi(params["VALUE"]); err == nil && v >= 0 && v <= 100 {
+					dev.SetBrightness(uint8(v))
+				}
+			}
+		case "REMOVE-DEVICE", "QUIT":
+			return fmt.Errorf("companion: removed the device")
+		}
+	}
+}
+
+// send writes a line on the current connection.
+func (c *Satellite) send(line string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return fmt.Errorf("companion: not connected")
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := c.conn.Write([]byte(line + "\n"))
+	return err
+}
+
+// companionKey returns the index of a key for Companion, which numbers them
+// row by row from the top left corner.
+func (c *Satellite) companionKey(btnIndex int) int {
+	r := c.model.KeyRect(btnIndex)
+	step := c.model.ButtonSize + c.model.Spacer
+	return r.Min.Y/step*c.model.NumButtonColumns + r.Min.X/step
+}
+
+// deckKey is the reverse of companionKey.
+func (c *Satellite) deckKey(key int) int {
+	for i := 0; i < c.model.NumButtons; i++ {
+		if c.companionKey(i) == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func (c *Satellite) event(btnIndex int, state sd.BtnState) {
+	pressed := "false"
+	if state == sd.BtnPressed {
+		pressed = "true"
+	}
+	c.send(formatLine("KEY-PRESS", map[string]string{
+		"DEVICEID": c.deviceID,
+		"KEY":      strconv.Itoa(c.companionKey(btnIndex)),
+		"PRESSED":  pressed,
+	}))
+}
+
+// keyState displays a key rendered by Companion: its bitmap if there is
+// one, its color and text otherwise.
+func (c *Satellite) keyState(params map[string]string) error {
+	key, err := strconv.Atoi(params["KEY"])
+	if err != nil {
+		return fmt.Errorf("companion: invalid key %q", params["KEY"])
+	}
+	btnIndex := c.deckKey(key)
+	if btnIndex < 0 {
+		return fmt.Errorf("companion: invalid key %d", key)
+	}
+
+	if bitmap, ok := params["BITMAP"]; ok && bitmap != "" {
+		img, err := decodeBitmap(bitmap)
+		if err != nil {
+			return err
+		}
+		return c.surface.FillImage(btnIndex, img)
+	}
+
+	t := tile.New()
+	if s, ok := params["COLOR"]; ok {
+		if col, err := profile.ParseColor(s); err == nil {
+			t.SetBgColor(col)
+		}
+	}
+	if s, ok := params["TEXT"]; ok {
+		text, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return fmt.Errorf("companion: invalid text: %w", err)
+		}
+		t.SetText(string(text))
+	}
+	img, err := sd.RenderElement(t, c.surface.ButtonSize())
+	if err != nil {
+		return err
+	}
+	return c.surface.FillImage(btnIndex, img)
+}
+
+// decodeBitmap decodes a bitmap of Companion: square, with 3 bytes (RGB)
+// per pixel, in base64.
+func decodeBitmap(s string) (image.Image, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("companion: invalid bitmap: %w", err)
+	}
+	size := 0
+	for size*size*3 < len(data) {
+		size++
+	}
+	if size*size*3 != len(data) {
+		return nil, fmt.Errorf("companion: bitmap of %d bytes isn't square", len(data))
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for i := 0; i < size*size; i++ {
+		copy(img.Pix[i*4:], data[i*3:i*3+3])
+		img.Pix[i*4+3] = 0xff
+	}
+	return img, nil
+}
+
+// clear blanks all the keys.
+func (c *Satellite) clear() {
+	size := c.surface.ButtonSize()
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Bounds(), image.Black, image.Point{}, draw.Src)
+	for i := 0; i < c.surface.NumButtons(); i++ {
+		c.surface.FillImage(i, img)
+	}
+}
+
+// Close removes the surface from Companion and disconnects.
+func (c *Satellite) Close() error {
+	c.mu.Lock()
+	select {
+	case <-c.done:
+		c.mu.Unlock()
+		return nil
+	default:
+	}
+	close(c.done)
+	conn := c.conn
+	c.mu.Unlock()
+
+	c.surface.SetBtnEventCb(nil)
+	if conn != nil {
+		conn.Write([]byte(formatLine("REMOVE-DEVICE", map[string]string{"DEVICEID": c.deviceID}) + "\n"))
+		conn.Close()
+	}
+	<-c.closed
+	return nil
+}
@@ -0,0 +1,252 @@
+package companion
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/mock"
+)
+
+// fakeCompanion is a Companion server accepting satellites.
+type fakeCompanion struct {
+	l     net.Listener
+	conns chan *fakeConn
+	// reject makes the server refuse devices with this message.
+	reject string
+}
+
+// fakeConn is a satellite connected to the fake server.
+type fakeConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	add  map[string]string // parameters of ADD-DEVICE
+}
+
+func newFakeCompanion(t *testing.T, reject string) *fakeCompanion {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeCompanion{l: l, conns: make(chan *fakeConn, 4), reject: reject}
+	t.Cleanup(func() { l.Close() })
+	go f.serve()
+	return f
+}
+
+func (f *fakeCompanion) serve() {
+	for {
+		conn, err := f.l.Accept()
+		if err != nil {
+			return
+		}
+		c := &fakeConn{conn: conn, br: bufio.NewReader(conn)}
+		conn.Write([]byte("BEGIN CompanionVersion=3.0.0 ApiVersion=1.5.0\n"))
+		line, err := c.br.ReadString('\n')
+		if err != nil {
+			conn.Close()
+			continue
+		}
+		var cmd string
+		cmd, c.add = parseLine(line)
+		if cmd != "ADD-DEVICE" {
+			conn.Close()
+			continue
+		}
+		if f.reject != "" {
+			conn.Write([]byte(formatLine("ADD-DEVICE ERROR", map[string]string{"MESSAGE": f.reject}) + "\n"))
+			conn.Close()
+			continue
+		}
+		// Companion may send other messages before the answer.
+		conn.Write([]byte("PING first\n"))
+		conn.Write([]byte(formatLine("ADD-DEVICE OK", map[string]string{"DEVICEID": c.add["DEVICEID"]}) + "\n"))
+		f.conns <- c
+	}
+}
+
+func (f *fakeCompanion) accept(t *testing.T) *fakeConn {
+	t.Helper()
+	select {
+	case c := <-f.conns:
+		t.Cleanup(func() { c.conn.Close() })
+		return c
+	case <-time.After(5 * time.Second):
+		t.Fatal("no satellite connected")
+		return nil
+	}
+}
+
+func (c *fakeConn) send(cmd string, params map[string]string) {
+	c.conn.Write([]byte(formatLine(cmd, params) + "\n"))
+}
+
+// expect reads lines until one with the given command, and returns its
+// parameters.
+func (c *fakeConn) expect(t *testing.T, cmd string) map[string]string {
+	t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		line, err := c.br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("waiting for %s: %s", cmd, err)
+		}
+		if got, params := parseLine(line); got == cmd {
+			return params
+		}
+	}
+}
+
+func TestSatellite(t *testing.T) {
+	f := newFakeCompanion(t, "")
+	dev, m := mock.Open(t, sd.LookupDevice(0x0063), "TEST0001")
+
+	connects := make(chan struct{}, 4)
+	s, err := Dial(f.l.Addr().String(), dev,
+		KeepAlive(100*time.Millisecond),
+		OnConnect(func() { connects <- struct{}{} }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	c := f.accept(t)
+	want := map[string]string{
+		"DEVICEID":     "TEST0001",
+		"PRODUCT_NAME": "Stream Deck Mini",
+		"KEYS_TOTAL":   "6",
+		"KEYS_PER_ROW": "3",
+		"BITMAPS":      "80",
+		"COLORS":       "hex",
+		"TEXT":         "true",
+	}
+	if !reflect.DeepEqual(c.add, want) {
+		t.Errorf("ADD-DEVICE %v, want %v", c.add, want)
+	}
+	<-connects
+
+	// Companion numbers keys from the top left corner.
+	topLeft := -1
+	for i := 0; i < dev.NumButtons(); i++ {
+		if dev.Info.KeyRect(i).Min == (image.Point{}) {
+			topLeft = i
+		}
+	}
+
+	bitmap := bytes.Repeat([]byte{255, 0, 0}, 80*80)
+	c.send("KEY-STATE", map[string]string{"DEVICEID": "TEST0001", "KEY": "0", "BITMAP": base64.StdEncoding.EncodeToString(bitmap)})
+	m.WaitColor(t, topLeft, color.RGBA{255, 0, 0, 255})
+
+	c.send("KEY-STATE", map[string]string{"DEVICEID": "TEST0001", "KEY": "5", "COLOR": "#0000ff", "TEXT": base64.StdEncoding.EncodeToString([]byte("hi"))})
+	m.WaitColor(t, s.deckKey(5), color.RGBA{0, 0, 255, 255})
+
+	// Messages of other devices are ignored.
+	c.send("KEY-STATE", map[string]string{"DEVICEID": "OTHER", "KEY": "5", "COLOR": "#00ff00"})
+	c.send("BRIGHTNESS", map[string]string{"DEVICEID": "TEST0001", "VALUE": "40"})
+	deadline := time.Now().Add(5 * time.Second)
+	for m.Brightness() != 40 {
+		if time.Now().After(deadline) {
+			t.Fatalf("brightness = %d, want 40", m.Brightness())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	m.WaitColor(t, s.deckKey(5), color.RGBA{0, 0, 255, 255})
+
+	m.Press(topLeft)
+	if p := c.expect(t, "KEY-PRESS"); p["KEY"] != "0" || p["PRESSED"] != "true" || p["DEVICEID"] != "TEST0001" {
+		t.Errorf("KEY-PRESS %v", p)
+	}
+	m.Release(topLeft)
+	if p := c.expect(t, "KEY-PRESS"); p["KEY"] != "0" || p["PRESSED"] != "false" {
+		t.Errorf("KEY-PRESS %v", p)
+	}
+
+	c.send("PING", map[string]string{"abc": "true"})
+	c.expect(t, "PONG")
+	c.expect(t, "PING") // keep alive
+
+	c.send("KEYS-CLEAR", map[string]string{"DEVICEID": "TEST0001"})
+	m.WaitColor(t, topLeft, color.RGBA{0, 0, 0, 255})
+
+	// The satellite reconnects when the connection is lost, and clears the
+	// keys meanwhile.
+	c.send("KEY-STATE", map[string]string{"DEVICEID": "TEST0001", "KEY": "1", "COLOR": "#ff0000"})
+	m.WaitColor(t, s.deckKey(1), color.RGBA{255, 0, 0, 255})
+	c.conn.Close()
+	m.WaitColor(t, s.deckKey(1), color.RGBA{0, 0, 0, 255})
+	c = f.accept(t)
+	<-connects
+	c.send("KEY-STATE", map[string]string{"DEVICEID": "TEST0001", "KEY": "1", "COLOR": "#00ff00"})
+	m.WaitColor(t, s.deckKey(1), color.RGBA{0, 255, 0, 255})
+
+	s.Close()
+	if p := c.expect(t, "REMOVE-DEVICE"); p["DEVICEID"] != "TEST0001" {
+		t.Errorf("REMOVE-DEVICE %v", p)
+	}
+}
+
+func TestSatelliteRejected(t *testing.T) {
+	f := newFakeCompanion(t, "too many devices")
+	dev, _ := mock.Open(t, sd.LookupDevice(0x0063), "TEST0001")
+
+	_, err := Dial(f.l.Addr().String(), dev)
+	if err == nil || !strings.Contains(err.Error(), "too many devices") {
+		t.Errorf("Dial = %v", err)
+	}
+}
+
+func TestKeyMapping(t *testing.T) {
+	for _, id := range []uint16{0x0060, 0x0063} {
+		s := &Satellite{model: sd.LookupDevice(id)}
+		seen := make(map[int]bool)
+		for i := 0; i < s.model.NumButtons; i++ {
+			k := s.companionKey(i)
+			if seen[k] || k < 0 || k >= s.model.NumButtons {
+				t.Errorf("%s: key %d maps to %d", s.model.Name, i, k)
+			}
+			seen[k] = true
+			if s.deckKey(k) != i {
+				t.Errorf("%s: deckKey(%d) = %d, want %d", s.model.Name, k, s.deckKey(k), i)
+			}
+		}
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		line   string
+		cmd    string
+		params map[string]string
+	}{
+		{"PING\n", "PING", map[string]string{}},
+		{"KEY-STATE DEVICEID=abc KEY=3 PRESSED", "KEY-STATE", map[string]string{"DEVICEID": "abc", "KEY": "3", "PRESSED": "true"}},
+		{`ADD-DEVICE ERROR MESSAGE="a \"b\" c\\"`, "ADD-DEVICE", map[string]string{"ERROR": "true", "MESSAGE": `a "b" c\`}},
+		{`X A="" B=1`, "X", map[string]string{"A": "", "B": "1"}},
+		{`X A="unterminated`, "X", map[string]string{"A": "unterminated"}},
+	}
+	for _, test := range tests {
+		cmd, params := parseLine(test.line)
+		if cmd != test.cmd || !reflect.DeepEqual(params, test.params) {
+			t.Errorf("parseLine(%q) = %q, %v", test.line, cmd, params)
+		}
+	}
+
+	params := map[string]string{"B": `say "hi"`, "A": "", "C": "plain"}
+	line := formatLine("CMD", params)
+	if line != `CMD A="" B="say \"hi\"" C=plain` {
+		t.Errorf("formatLine = %s", line)
+	}
+	if cmd, got := parseLine(line); cmd != "CMD" || !reflect.DeepEqual(got, params) {
+		t.Errorf("round trip: %q %v", cmd, got)
+	}
+}
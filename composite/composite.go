@@ -0,0 +1,533 @@
+// Package composite combines several Stream Decks into one surface, e.g.
+// two decks mounted side by side used as a single grid. Keys get unified
+// indices across the decks, events are reported with these indices, and a
+// panel image spans all decks, skipping the bezels between them.
+//
+// The Surface keeps the images of all keys, so a deck which disconnects
+// doesn't stop the others: drawing on its keys goes on in memory, and the
+// deck is redrawn once it is back.
+package composite
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	xdraw "golang.org/x/image/draw"
+)
+
+// checkInterval is how often the decks are checked and the disconnected
+// ones reopened.
+const checkInterval = 2 * time.Second
+
+// Surface spans several decks. Like on a single deck, keys are numbered
+// row by row, starting from the top right corner of the whole surface.
+type Surface struct {
+	members    []*member
+	cols, rows int // size of the grid of keys
+	width      int
+	height     int
+	buttonSize int
+	gap        int
+	reopen     func(deck int) (*sd.StreamDeck, error)
+	onError    func(error)
+
+	mu         sync.Mutex
+	cb         sd.BtnEvent
+	brightness int // -1 until set
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+// member is a deck of the surface.
+type member struct {
+	index    int
+	model    *sd.StreamdeckDevice
+	col, row int         // position of its top left key in the grid
+	origin   image.Point // position of its panel on the surface
+
+	mu      sync.Mutex // serializes drawing, so a redraw can't go back in time
+	dev     *sd.StreamDeck
+	online  bool
+	images  []*image.RGBA // nil if black
+	pressed []bool
+}
+
+// Gap sets the distance in pixels between the panels of neighboring decks,
+// accounting for their bezels, so that a panel image lines up across them.
+// By default, it is the spacing between the keys of a deck.
+func Gap(px int) func(*Surface) {
+	return func(s *Surface) {
+		s.gap = px
+	}
+}
+
+// Reopen sets a function opening a deck again once it is disconnected,
+// e.g. by its serial number. Decks are numbered in the order given to New,
+// row by row. It is retried every few seconds until it succeeds.
+func Reopen(f func(deck int) (*sd.StreamDeck, error)) func(*Surface) {
+	return func(s *Surface) {
+		s.reopen = f
+	}
+}
+
+// OnError sets a function called when a deck fails or can't be reopened.
+func OnError(f func(error)) func(*Surface) {
+	return func(s *Surface) {
+		s.onError = f
+	}
+}
+
+// New creates a Surface of decks laid out in rows: rows[0] holds the top
+// decks, from left to right. Decks of different models may be mixed; the
+// keys missing where a deck is smaller than its neighbors are holes, which
+// are ignored.
+func New(rows [][]*sd.StreamDeck, options ...func(*Surface)) (*Surface, error) {
+	s := &Surface{
+		gap:        -1,
+		brightness: -1,
+		done:       make(chan struct{}),
+	}
+	for _, option := range options {
+		option(s)
+	}
+
+	for _, row := range rows {
+		for _, dev := range row {
+			if dev == nil {
+				return nil, errors.New("composite: nil deck")
+			}
+			if s.gap < 0 {
+				s.gap = dev.Info.Spacer
+			}
+		}
+	}
+	if s.gap < 0 {
+		return nil, errors.New("composite: no deck")
+	}
+
+	y := 0
+	for _, row := range rows {
+		col, x, keyRows, height := 0, 0, 0, 0
+		for _, dev := range row {
+			m := dev.Info
+			s.members = append(s.members, &member{
+				index:   len(s.members),
+				model:   m,
+				col:     col,
+				row:     s.rows,
+				origin:  image.Pt(x, y),
+				dev:     dev,
+				online:  true,
+				images:  make([]*image.RGBA, m.NumButtons),
+				pressed: make([]bool, m.NumButtons),
+			})
+			col += m.NumButtonColumns
+			x += m.PanelWidth() + s.gap
+			if m.NumButtonRows > keyRows {
+				keyRows = m.NumButtonRows
+			}
+			if m.PanelHeight() > height {
+				height = m.PanelHeight()
+			}
+			if m.ButtonSize > s.buttonSize {
+				s.buttonSize = m.ButtonSize
+			}
+		}
+		if len(row) == 0 {
+			continue
+		}
+		if col > s.cols {
+			s.cols = col
+		}
+		if w := x - s.gap; w > s.width {
+			s.width = w
+		}
+		s.rows += keyRows
+		y += height + s.gap
+	}
+	s.height = y - s.gap
+
+	for _, m := range s.members {
+		s.attach(m, m.dev)
+	}
+	go s.monitor()
+	return s, nil
+}
+
+// attach receives the events of dev as those of m.
+func (s *Surface) attach(m *member, dev *sd.StreamDeck) {
+	dev.SetBtnEventCb(func(btnIndex int, state sd.BtnState) {
+		m.mu.Lock()
+		if m.dev != dev || !m.online || btnIndex >= len(m.pressed) {
+			m.mu.Unlock()
+			return
+		}
+		m.pressed[btnIndex] = state == sd.BtnPressed
+		m.mu.Unlock()
+		s.event(s.index(m, btnIndex), state)
+	})
+}
+
+func (s *Surface) event(btnIndex int, state sd.BtnState) {
+	s.mu.Lock()
+	cb := s.cb
+	s.mu.Unlock()
+	if cb != nil {
+		cb(btnIndex, state)
+	}
+}
+
+func (s *Surface) error(err error) {
+	if s.onError != nil {
+		s.onError(err)
+	}
+}
+
+// index returns the index on the surface of a key of a deck.
+func (s *Surface) index(m *member, btnIndex int) int {
+	// KeyRect counts columns from the right
+	col := m.col + m.model.NumButtonColumns - 1 - btnIndex%m.model.NumButtonColumns
+	row := m.row + btnIndex/m.model.NumButtonColumns
+	return row*s.cols + s.cols - 1 - col
+}
+
+// key returns the deck and the index on that deck of a key of the surface,
+// or a nil member for a hole.
+func (s *Surface) key(btnIndex int) (*member, int, error) {
+	if btnIndex < 0 || btnIndex >= s.NumButtons() {
+		return nil, 0, fmt.Errorf("composite: invalid key index %d", btnIndex)
+	}
+	col, row := s.cols-1-btnIndex%s.cols, btnIndex/s.cols
+	for _, m := range s.members {
+		c, r := col-m.col, row-m.row
+		if c >= 0 && c < m.model.NumButtonColumns && r >= 0 && r < m.model.NumButtonRows {
+			return m, r*m.model.NumButtonColumns + m.model.NumButtonColumns - 1 - c, nil
+		}
+	}
+	return nil, 0, nil
+}
+
+// ButtonSize returns the largest key size of the decks; images are resized
+// for the others.
+func (s *Surface) ButtonSize() int {
+	return s.buttonSize
+}
+
+// NumButtons returns the number of keys of the grid, holes included.
+func (s *Surface) NumButtons() int {
+	return s.cols * s.rows
+}
+
+// Size returns the number of columns and rows of keys.
+func (s *Surface) Size() (cols, rows int) {
+	return s.cols, s.rows
+}
+
+// NumDecks returns the number of decks.
+func (s *Surface) NumDecks() int {
+	return len(s.members)
+}
+
+// Online tells whether a deck is connected.
+func (s *Surface) Online(deck int) bool {
+	m := s.members[deck]
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.online
+}
+
+// SetBtnEventCb sets the callback for the events of all keys.
+func (s *Surface) SetBtnEventCb(ev sd.BtnEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cb = ev
+}
+
+// FillImage displays an image on a key. If its deck is disconnected, the
+// image is displayed once the deck is back, and no error is returned.
+func (s *Surface) FillImage(btnIndex int, img image.Image) error {
+	m, i, err := s.key(btnIndex)
+	if err != nil || m == nil {
+		return err
+	}
+
+	size := m.model.ButtonSize
+	key := image.NewRGBA(image.Rect(0, 0, size, size))
+	if b := img.Bounds(); b.Dx() == size && b.Dy() == size {
+		draw.Draw(key, key.Bounds(), img, b.Min, draw.Src)
+	} else {
+		xdraw.CatmullRom.Scale(key, key.Bounds(), img, b, draw.Src, nil)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.images[i] = key
+	if m.online {
+		if err := m.dev.FillImage(i, key); err != nil {
+			s.disconnected(m, err)
+		}
+	}
+	return nil
+}
+
+// FillColor fills a key with a solid color.
+func (s *Surface) FillColor(btnIndex, r, g, b int) error {
+	for _, v := range []int{r, g, b} {
+		if v < 0 || v > 255 {
+			return fmt.Errorf("composite: invalid color value %d", v)
+		}
+	}
+	c := image.NewUniform(color.RGBA{uint8(r), uint8(g), uint8(b), 0xff})
+	img := image.NewRGBA(image.Rect(0, 0, s.buttonSize, s.buttonSize))
+	draw.Draw(img, img.Bounds(), c, image.Point{}, draw.Src)
+	return s.FillImage(btnIndex, img)
+}
+
+// ClearAllBtns fills all keys with black.
+func (s *Surface) ClearAllBtns() {
+	for i := 0; i < s.NumButtons(); i++ {
+		s.FillColor(i, 0, 0, 0)
+	}
+}
+
+// PanelWidth returns the width in pixels of the surface, gaps included.
+func (s *Surface) PanelWidth() int {
+	return s.width
+}
+
+// PanelHeight returns the height in pixels of the surface, gaps included.
+func (s *Surface) PanelHeight() int {
+	return s.height
+}
+
+// KeyRect returns the area of a key on the surface, as used by FillPanel,
+// or an empty rectangle for a hole.
+func (s *Surface) KeyRect(btnIndex int) image.Rectangle {
+	m, i, err := s.key(btnIndex)
+	if err != nil || m == nil {
+		return image.Rectangle{}
+	}
+	return m.model.KeyRect(i).Add(m.origin)
+}
+
+// FillPanel displays an image across all decks. The image is scaled to the
+// width of the surface and center-cropped; the parts behind the gaps
+// between the decks aren't shown, so lines continue straight across them.
+func (s *Surface) FillPanel(img image.Image) error {
+	b := img.Bounds()
+	h := b.Dy() * s.width / b.Dx()
+	scaled := image.NewRGBA(image.Rect(0, 0, s.width, h))
+	xdraw.CatmullRom.Scale(scaled, scaled.Bounds(), img, b, draw.Src, nil)
+
+	offset := image.Pt(0, (h-s.height)/2)
+	for i := 0; i < s.NumButtons(); i++ {
+		r := s.KeyRect(i)
+		if r.Empty() {
+			continue
+		}
+		key := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+		draw.Draw(key, key.Bounds(), scaled, r.Min.Add(offset), draw.Src)
+		if err := s.FillImage(i, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// KeyImage returns the image displayed on a key, or which will be once its
+// deck is back.
+func (s *Surface) KeyImage(btnIndex int) (image.Image, error) {
+	m, i, err := s.key(btnIndex)
+	if err != nil {
+		return nil, err
+	}
+	size := s.buttonSize
+	if m != nil {
+		size = m.model.ButtonSize
+	}
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Bounds(), image.Black, image.Point{}, draw.Src)
+	if m != nil {
+		m.mu.Lock()
+		if src := m.images[i]; src != nil {
+			draw.Draw(img, img.Bounds(), src, image.Point{}, draw.Src)
+		}
+		m.mu.Unlock()
+	}
+	return img, nil
+}
+
+// Screenshot returns an image of the whole surface, with the keys laid
+// out as by FillPanel.
+func (s *Surface) Screenshot() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, s.width, s.height))
+	draw.Draw(img, img.Bounds(), image.Black, image.Point{}, draw.Src)
+	for _, m := range s.members {
+		m.mu.Lock()
+		for i, key := range m.images {
+			if key != nil {
+				draw.Draw(img, m.model.KeyRect(i).Add(m.origin), key, image.Point{}, draw.Src)
+			}
+		}
+		m.mu.Unlock()
+	}
+	return img
+}
+
+// SetBrightness sets the brightness of all decks, and of the decks coming
+// back later.
+func (s *Surface) SetBrightness(pc uint8) error {
+	s.mu.Lock()
+	s.brightness = int(pc)
+	s.mu.Unlock()
+
+	for _, m := range s.members {
+		m.mu.Lock()
+		if m.online {
+			if err := m.dev.SetBrightness(pc); err != nil {
+				s.disconnected(m, err)
+			}
+		}
+		m.mu.Unlock()
+	}
+	return nil
+}
+
+// disconnected marks a deck as gone, and releases its keys held. The
+// caller holds m.mu.
+func (s *Surface) disconnected(m *member, err error) {
+	if !m.online {
+		return
+	}
+	m.online = false
+	m.dev.SetBtnEventCb(nil)
+	m.dev.Close()
+	s.error(fmt.Errorf("composite: deck %d: %w", m.index, err))
+
+	for i, pressed := range m.pressed {
+		if pressed {
+			m.pressed[i] = false
+			go s.event(s.index(m, i), sd.BtnReleased)
+		}
+	}
+}
+
+// Replace puts dev in place of a deck, e.g. once it was reconnected, and
+// draws the keys on it. It must be of the same model.
+func (s *Surface) Replace(deck int, dev *sd.StreamDeck) error {
+	if deck < 0 || deck >= len(s.members) {
+		return fmt.Errorf("composite: invalid deck index %d", deck)
+	}
+	m := s.members[deck]
+	if dev.Info.NumButtonColumns != m.model.NumButtonColumns || dev.Info.NumButtonRows != m.model.NumButtonRows {
+		return fmt.Errorf("composite: deck %d: expected a %s, got a %s", deck, m.model.Name, dev.Info.Name)
+	}
+
+	s.mu.Lock()
+	brightness := s.brightness
+	s.mu.Unlock()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.online && m.dev != dev {
+		m.dev.SetBtnEventCb(nil)
+		m.dev.Close()
+	}
+	m.dev, m.online = dev, true
+	for i := range m.pressed {
+		m.pressed[i] = false
+	}
+	s.attach(m, dev)
+
+	if brightness >= 0 {
+		if err := dev.SetBrightness(uint8(brightness)); err != nil {
+			s.disconnected(m, err)
+			return err
+		}
+	}
+	for i, key := range m.images {
+		var err error
+		if key != nil {
+			err = dev.FillImage(i, key)
+		} else {
+			err = dev.ClearBtn(i)
+		}
+		if err != nil {
+			s.disconnected(m, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// monitor checks that the decks are still there, and reopens the others.
+func (s *Surface) monitor() {
+	t := time.NewTicker(checkInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-t.C:
+		}
+
+		for _, m := range s.members {
+			m.mu.Lock()
+			online, dev := m.online, m.dev
+			m.mu.Unlock()
+
+			if online {
+				if _, err := dev.GetFirmwareVersion(); err != nil {
+					m.mu.Lock()
+					if m.dev == dev {
+						s.disconnected(m, err)
+					}
+					m.mu.Unlock()
+				}
+				continue
+			}
+			if s.reopen == nil {
+				continue
+			}
+			dev, err := s.reopen(m.index)
+			if err != nil {
+				s.error(fmt.Errorf("composite: deck %d: %w", m.index, err))
+				continue
+			}
+			select {
+			case <-s.done:
+				dev.Close()
+				return
+			default:
+			}
+			if err := s.Replace(m.index, dev); err != nil {
+				dev.Close()
+			}
+		}
+	}
+}
+
+// Close closes all decks.
+func (s *Surface) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+
+	var firstErr error
+	for _, m := range s.members {
+		m.mu.Lock()
+		if m.online {
+			m.online = false
+			m.dev.SetBtnEventCb(nil)
+			if err := m.dev.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		m.mu.Unlock()
+	}
+	return firstErr
+}
@@ -0,0 +1,191 @@
+package composite
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/mock"
+)
+
+func TestTwoXL(t *testing.T) {
+	left, ml := mock.Open(t, sd.LookupDevice(0x006c), "XL000001")
+	right, mr := mock.Open(t, sd.LookupDevice(0x006c), "XL000002")
+	s, err := New([][]*sd.StreamDeck{{left, right}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if cols, rows := s.Size(); cols != 16 || rows != 4 || s.NumButtons() != 64 {
+		t.Fatalf("size %dx%d, %d keys", cols, rows, s.NumButtons())
+	}
+
+	// Keys are numbered from the top right corner of the surface: key 0 is
+	// the top right key of the right deck, key 8 the top right key of the
+	// left deck.
+	events := make(chan int, 4)
+	s.SetBtnEventCb(func(btnIndex int, state sd.BtnState) {
+		if state == sd.BtnPressed {
+			events <- btnIndex
+		}
+	})
+	for _, c := range []struct {
+		m         *mock.Device
+		key, want int
+	}{{mr, 0, 0}, {ml, 0, 8}, {ml, 31, 63}, {mr, 8, 16}} {
+		c.m.Click(c.key)
+		select {
+		case got := <-events:
+			if got != c.want {
+				t.Errorf("pressed %s key %d, got %d, want %d", c.m.Model().Name, c.key, got, c.want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %d", c.want)
+		}
+	}
+
+	if err := s.FillColor(8, 255, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	red := func(m *mock.Device) uint8 {
+		return color.RGBAModel.Convert(m.Key(0).At(48, 48)).(color.RGBA).R
+	}
+	if red(ml) < 240 || red(mr) > 16 {
+		t.Errorf("key 0 of the decks is %d and %d red, want the left one only", red(ml), red(mr))
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	mini := sd.LookupDevice(0x0063)
+	left, ml := mock.Open(t, mini, "MINI0001")
+	right, _ := mock.Open(t, mini, "MINI0002")
+	errs := make(chan error, 4)
+	s, err := New([][]*sd.StreamDeck{{left, right}}, OnError(func(err error) { errs <- err }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	events := make(chan sd.BtnState, 4)
+	s.SetBtnEventCb(func(btnIndex int, state sd.BtnState) {
+		if btnIndex == 4 {
+			events <- state
+		}
+	})
+	wait := func(want sd.BtnState) {
+		t.Helper()
+		select {
+		case got := <-events:
+			if got != want {
+				t.Fatalf("key 4 is %v, want %v", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %v", want)
+		}
+	}
+
+	// Keys 3 and 4 are the top right keys of the left deck.
+	if err := s.FillColor(3, 255, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	ml.Press(1)
+	wait(sd.BtnPressed)
+
+	// The key held is released once drawing on the unplugged deck fails,
+	// and the key drawn meanwhile is kept for later.
+	ml.Close()
+	if err := s.FillColor(4, 0, 0, 255); err != nil {
+		t.Fatalf("FillColor on a disconnected deck: %v", err)
+	}
+	wait(sd.BtnReleased)
+	if s.Online(0) || !s.Online(1) {
+		t.Errorf("decks online: %v, %v", s.Online(0), s.Online(1))
+	}
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "deck 0") {
+			t.Errorf("error %q doesn't name the deck", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("no error reported")
+	}
+
+	back, mb := mock.Open(t, mini, "MINI0001")
+	if err := s.Replace(0, back); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Online(0) {
+		t.Error("deck 0 offline after Replace")
+	}
+	mb.WaitColor(t, 0, color.RGBA{255, 0, 0, 255})
+	mb.WaitColor(t, 1, color.RGBA{0, 0, 255, 255})
+	mb.Click(1)
+	wait(sd.BtnPressed)
+	wait(sd.BtnReleased)
+
+	xl, _ := mock.Open(t, sd.LookupDevice(0x006c), "XL000001")
+	if err := s.Replace(0, xl); err == nil {
+		t.Error("Replace accepted a deck of another model")
+	}
+	if err := s.Replace(2, back); err == nil {
+		t.Error("Replace accepted an invalid deck index")
+	}
+}
+
+func TestFillPanelGap(t *testing.T) {
+	mini := sd.LookupDevice(0x0063)
+	left, ml := mock.Open(t, mini, "MINI0001")
+	right, mr := mock.Open(t, mini, "MINI0002")
+	const gap = 50
+	s, err := New([][]*sd.StreamDeck{{left, right}}, Gap(gap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if w := s.PanelWidth(); w != 2*mini.PanelWidth()+gap {
+		t.Fatalf("panel width %d", w)
+	}
+	// Key 3 is the top right key of the left deck, key 2 the top left key
+	// of the right one.
+	if d := s.KeyRect(2).Min.X - s.KeyRect(3).Max.X; d != gap {
+		t.Errorf("keys %d pixels apart across the gap, want %d", d, gap)
+	}
+
+	// A horizontal gradient: the red of each key column tells where it was
+	// taken from the panel.
+	w, h := s.PanelWidth(), s.PanelHeight()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			img.Set(x, y, color.RGBA{uint8(x * 255 / (w - 1)), 0, 0, 255})
+		}
+	}
+	if err := s.FillPanel(img); err != nil {
+		t.Fatal(err)
+	}
+
+	size := mini.ButtonSize
+	for _, c := range []struct {
+		name string
+		m    *mock.Device
+		key  int // of the deck
+		surf int // of the surface
+		x    int // in the key
+	}{
+		{"left", ml, 0, 3, size - 1},
+		{"right", mr, 2, 2, 0},
+		{"right", mr, 0, 0, size - 1},
+		{"left", ml, 5, 11, 0},
+	} {
+		want := img.RGBAAt(s.KeyRect(c.surf).Min.X+c.x, 0).R
+		got := color.RGBAModel.Convert(c.m.Key(c.key).At(c.x, size/2)).(color.RGBA).R
+		if diff := int(got) - int(want); diff < -2 || diff > 2 {
+			t.Errorf("key %d of the %s deck, column %d: red %d, want %d", c.key, c.name, c.x, got, want)
+		}
+	}
+}
@@ -0,0 +1,390 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	_ "image/gif"  // image formats accepted for keys
+	_ "image/jpeg" // image formats accepted for keys
+	"image/png"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/KarpelesLab/streamdeck/internal/websocket"
+	"github.com/KarpelesLab/streamdeck/profile"
+	"github.com/KarpelesLab/streamdeck/tile"
+)
+
+// maxBody is the size limit of request bodies, large enough for any key
+// image.
+const maxBody = 8 << 20
+
+// DeviceInfo describes a deck in API responses.
+type DeviceInfo struct {
+	Serial   string `json:"serial"`
+	Model    string `json:"model"`
+	Firmware string `json:"firmware,omitempty"`
+	Keys     int    `json:"keys"`
+	Columns  int    `json:"columns"`
+	Rows     int    `json:"rows"`
+	KeySize  int    `json:"key_size"`
+	Page     string `json:"page,omitempty"`
+}
+
+// KeyRequest is the JSON body setting a key. Text is drawn over the image,
+// itself drawn over the background color.
+type KeyRequest struct {
+	Text      string `json:"text"`
+	TextColor string `json:"text_color"`
+	Color     string `json:"color"`
+	Image     string `json:"image"` // base64 encoded PNG, JPEG or GIF
+}
+
+// Info describes the deck.
+func (d *Deck) Info() DeviceInfo {
+	info := DeviceInfo{
+		Serial:   d.serial,
+		Model:    d.dev.Info.Name,
+		Firmware: d.firmware,
+		Keys:     d.dev.Info.NumButtons,
+		Columns:  d.dev.Info.NumButtonColumns,
+		Rows:     d.dev.Info.NumButtonRows,
+		KeySize:  d.dev.Info.ButtonSize,
+	}
+	if d.runtime != nil {
+		info.Page = d.runtime.Deck().Current().Name()
+	}
+	return info
+}
+
+func (s *Server) route(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "api" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	switch {
+	case len(parts) == 2 && parts[1] == "events":
+		s.events(w, r)
+	case len(parts) == 2 && parts[1] == "devices":
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		res := []DeviceInfo{}
+		for _, d := range s.Decks() {
+			res = append(res, d.Info())
+		}
+		writeJSON(w, http.StatusOK, res)
+	case len(parts) >= 3 && parts[1] == "devices":
+		d := s.Deck(parts[2])
+		if d == nil {
+			writeError(w, http.StatusNotFound, "unknown device %s", parts[2])
+			return
+		}
+		d.route(w, r, parts[3:])
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (d *Deck) route(w http.ResponseWriter, r *http.Request, parts []string) {
+	type endpoint struct {
+		method string
+		path   string
+		fn     func(w http.ResponseWriter, r *http.Request, arg string)
+	}
+	endpoints := []endpoint{
+		{http.MethodGet, "", d.getInfo},
+		{http.MethodGet, "keys/*", d.getKey},
+		{http.MethodPost, "keys/*", d.setKey},
+		{http.MethodDelete, "keys/*", d.clearKey},
+		{http.MethodPut, "brightness", d.setBrightness},
+		{http.MethodGet, "page", d.getPage},
+		{http.MethodPut, "page", d.setPage},
+		{http.MethodGet, "screenshot", d.screenshot},
+		{http.MethodGet, "lease", d.serveLease},
+	}
+
+	path, arg := strings.Join(parts, "/"), ""
+	if len(parts) == 2 {
+		path, arg = parts[0]+"/*", parts[1]
+	}
+	found := false
+	for _, e := range endpoints {
+		if e.path != path {
+			continue
+		}
+		found = true
+		if e.method == r.Method {
+			e.fn(w, r, arg)
+			return
+		}
+	}
+	if found {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeError(w, http.StatusNotFound, "not found")
+}
+
+func (d *Deck) getInfo(w http.ResponseWriter, r *http.Request, _ string) {
+	writeJSON(w, http.StatusOK, d.Info())
+}
+
+// key parses a key index from the URL, reporting errors to the client.
+func (d *Deck) key(w http.ResponseWriter, arg string) (int, bool) {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 0 || n >= d.dev.NumButtons() {
+		writeError(w, http.StatusNotFound, "invalid key %q", arg)
+		return 0, false
+	}
+	return n, true
+}
+
+func (d *Deck) getKey(w http.ResponseWriter, r *http.Request, arg string) {
+	n, ok := d.key(w, arg)
+	if !ok {
+		return
+	}
+	img, err := d.dev.KeyImage(n)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+	writePNG(w, img)
+}
+
+func (d *Deck) setKey(w http.ResponseWriter, r *http.Request, arg string) {
+	n, ok := d.key(w, arg)
+	if !ok {
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBody))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	var t *tile.Tile
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "image/") {
+		img, _, err := image.Decode(bytes.NewReader(body))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid image: %s", err)
+			return
+		}
+		t = tile.New(tile.Image(img))
+	} else {
+		if !isJSON(w, r) {
+			return
+		}
+		var req KeyRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request: %s", err)
+			return
+		}
+		if t, err = req.tile(); err != nil {
+			writeError(w, http.StatusBadRequest, "%s", err)
+			return
+		}
+	}
+
+	if err := d.SetKey(n, t); err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tile builds the tile described by the request.
+func (req *KeyRequest) tile() (*tile.Tile, error) {
+	options := []func(*tile.Tile){tile.Text(req.Text)}
+	if req.TextColor != "" {
+		c, err := profile.ParseColor(req.TextColor)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, tile.TextColor(c))
+	}
+	if req.Color != "" {
+		c, err := profile.ParseColor(req.Color)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, tile.BgColor(c))
+	}
+	if req.Image != "" {
+		data, err := base64.StdEncoding.DecodeString(req.Image)
+		if err != nil {
+			return nil, err
+		}
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, tile.Image(img))
+	}
+	return tile.New(options...), nil
+}
+
+func (d *Deck) clearKey(w http.ResponseWriter, r *http.Request, arg string) {
+	n, ok := d.key(w, arg)
+	if !ok {
+		return
+	}
+	if err := d.ClearKey(n); err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (d *Deck) setBrightness(w http.ResponseWriter, r *http.Request, _ string) {
+	var req struct {
+		Brightness *int `json:"brightness"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Brightness == nil || *req.Brightness < 0 || *req.Brightness > 100 {
+		writeError(w, http.StatusBadRequest, "brightness must be between 0 and 100")
+		return
+	}
+	if err := d.dev.SetBrightness(uint8(*req.Brightness)); err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (d *Deck) getPage(w http.ResponseWriter, r *http.Request, _ string) {
+	if d.runtime == nil {
+		writeError(w, http.StatusConflict, "no profile loaded")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"page": d.runtime.Deck().Current().Name()})
+}
+
+func (d *Deck) setPage(w http.ResponseWriter, r *http.Request, _ string) {
+	var req struct {
+		Page string `json:"page"`
+		Back bool   `json:"back"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if d.runtime == nil {
+		writeError(w, http.StatusConflict, "no profile loaded")
+		return
+	}
+
+	deck := d.runtime.Deck()
+	var err error
+	switch {
+	case req.Back:
+		err = deck.Back()
+	case req.Page != "":
+		if deck.Page(req.Page) == nil {
+			writeError(w, http.StatusNotFound, "unknown page %q", req.Page)
+			return
+		}
+		err = deck.SwitchToName(req.Page)
+	default:
+		writeError(w, http.StatusBadRequest, "page or back required")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusConflict, "%s", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"page": deck.Current().Name()})
+}
+
+func (d *Deck) screenshot(w http.ResponseWriter, r *http.Request, _ string) {
+	writePNG(w, d.dev.Screenshot())
+}
+
+// events streams key events over a WebSocket until the client disconnects.
+func (s *Server) events(w http.ResponseWriter, r *http.Request) {
+	if !websocket.IsWebSocket(r) {
+		writeError(w, http.StatusBadRequest, "websocket required")
+		return
+	}
+	serial := r.URL.Query().Get("serial")
+	if serial != "" && s.Deck(serial) == nil {
+		writeError(w, http.StatusNotFound, "unknown device %s", serial)
+		return
+	}
+
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	sub := s.subscribe(serial)
+	defer s.unsubscribe(sub)
+
+	// The client isn't expected to send anything, but reading detects
+	// when it goes away.
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-gone:
+			return
+		case <-s.done:
+			return
+		case ev := <-sub.events:
+			if err := conn.WriteJSON(ev); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// isJSON checks that a request body is JSON. Web pages can't send this
+// content type to another origin without asking first, which guards
+// against forms posted from other sites.
+func isJSON(w http.ResponseWriter, r *http.Request) bool {
+	if t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || t != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, "content type must be application/json")
+		return false
+	}
+	return true
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if !isJSON(w, r) {
+		return false
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxBody)).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request: %s", err)
+		return false
+	}
+	return true
+}
+
+func writePNG(w http.ResponseWriter, img image.Image) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(buf.Bytes())
+}
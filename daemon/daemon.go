@@ -0,0 +1,335 @@
+// Package daemon exposes Stream Decks to other programs through a local
+// HTTP API and a WebSocket stream of key events, so tools which can't link
+// Go (shell scripts, Python...) can drive them.
+//
+// All requests and responses are JSON, except images:
+//
+//	GET    /api/devices                          list the decks
+//	GET    /api/devices/{serial}                 describe a deck
+//	POST   /api/devices/{serial}/keys/{key}      set a key: {"text", "text_color", "color", "image"}
+//	                                             (image in base64), or an image as the body
+//	DELETE /api/devices/{serial}/keys/{key}      clear a key
+//	GET    /api/devices/{serial}/keys/{key}      PNG of a key
+//	PUT    /api/devices/{serial}/brightness      {"brightness": 0-100}
+//	GET    /api/devices/{serial}/page            {"page": name}
+//	PUT    /api/devices/{serial}/page            {"page": name} or {"back": true}
+//	GET    /api/devices/{serial}/screenshot      PNG of the whole panel
+//	GET    /api/devices/{serial}/lease           WebSocket leasing keys, see below
+//	GET    /api/events[?serial=...]              WebSocket of key events, except of leased keys
+//
+// Several clients can share a deck by leasing keys: a lease displays the
+// client's keys over the rest and gives it their events, which aren't sent
+// to the event stream, until the client disconnects. The lease endpoint
+// takes the keys (e.g. "0-2,5", all by default) and a priority in its query
+// string; leases of a higher priority temporarily take over the keys of
+// lower ones, e.g. for alerts.
+//
+// JSON bodies must be sent with the "application/json" content type.
+//
+// The server listens on localhost or on a Unix socket. If a token is set,
+// requests must carry it as "Authorization: Bearer <token>" or, for
+// WebSockets opened from a browser, as the token query parameter. Without a
+// token, requests from web pages of other origins are rejected.
+package daemon
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+)
+
+// DefaultAddr is the address the daemon listens on by default.
+const DefaultAddr = "127.0.0.1:9180"
+
+// Event is a key event, as sent on the WebSocket stream.
+type Event struct {
+	Serial string    `json:"serial"`
+	Key    int       `json:"key"`
+	State  string    `json:"state"` // "pressed" or "released"
+	Time   time.Time `json:"time"`
+}
+
+// Server owns decks and serves the API.
+type Server struct {
+	mu    sync.Mutex
+	token string
+	decks map[string]*Deck
+	subs  map[*subscriber]bool
+	srv   []*http.Server
+	done  chan struct{} // closed by Close
+}
+
+type subscriber struct {
+	serial string // only events of this deck, if not empty
+	events chan Event
+}
+
+// New creates a Server.
+func New(options ...func(*Server)) *Server {
+	s := &Server{
+		decks: make(map[string]*Deck),
+		subs:  make(map[*subscriber]bool),
+		done:  make(chan struct{}),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+// Token requires clients to authenticate with the given token.
+func Token(token string) func(*Server) {
+	return func(s *Server) {
+		s.token = token
+	}
+}
+
+// Decks returns the decks owned by the Server, sorted by serial number.
+func (s *Server) Decks() []*Deck {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := make([]*Deck, 0, len(s.decks))
+	for _, d := range s.decks {
+		res = append(res, d)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].serial < res[j].serial })
+	return res
+}
+
+// Deck returns the deck with the given serial number, or nil.
+func (s *Server) Deck(serial string) *Deck {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.decks[serial]
+}
+
+// publish sends an event to the subscribers. Slow subscribers miss events
+// rather than blocking the deck.
+func (s *Server) publish(ev Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for sub := range s.subs {
+		if sub.serial != "" && sub.serial != ev.Serial {
+			continue
+		}
+		select {
+		case sub.events <- ev:
+		default:
+		}
+	}
+}
+
+func (s *Server) subscribe(serial string) *subscriber {
+	sub := &subscriber{serial: serial, events: make(chan Event, 64)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subs[sub] = true
+	return sub
+}
+
+func (s *Server) unsubscribe(sub *subscriber) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subs, sub)
+}
+
+// ListenAndServe serves the API on a TCP address, DefaultAddr if empty.
+// Without a token, only loopback addresses are accepted.
+func (s *Server) ListenAndServe(addr string) error {
+	if addr == "" {
+		addr = DefaultAddr
+	}
+	if s.token == "" && !isLoopback(addr) {
+		return fmt.Errorf("refusing to listen on %s without a token", addr)
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// ListenAndServeUnix serves the API on a Unix socket, only accessible to
+// the current user. A socket left over at path by a daemon which didn't
+// exit cleanly is replaced, but not one still in use nor another file.
+func (s *Server) ListenAndServeUnix(path string) error {
+	if err := removeStale(path); err != nil {
+		return err
+	}
+	// The socket is created with the right permissions rather than changed
+	// afterwards, which would let others connect meanwhile.
+	restore := umask(0177)
+	l, err := net.Listen("unix", path)
+	restore()
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// removeStale removes the socket at path if nothing listens on it anymore.
+func removeStale(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if c, err := net.Dial("unix", path); err == nil {
+		c.Close()
+		return fmt.Errorf("%s is in use", path)
+	}
+	return os.Remove(path)
+}
+
+// Serve serves the API on a listener until the Server is closed.
+func (s *Server) Serve(l net.Listener) error {
+	srv := &http.Server{Handler: s.Handler()}
+
+	s.mu.Lock()
+	if s.isClosed() {
+		s.mu.Unlock()
+		l.Close()
+		return http.ErrServerClosed
+	}
+	s.srv = append(s.srv, srv)
+	s.mu.Unlock()
+
+	return srv.Serve(l)
+}
+
+// Close stops serving and closes the decks.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.isClosed() {
+		s.mu.Unlock()
+		return nil
+	}
+	close(s.done)
+	servers := s.srv
+	decks := s.decks
+	s.srv = nil
+	s.decks = make(map[string]*Deck)
+	s.mu.Unlock()
+
+	for _, srv := range servers {
+		srv.Close()
+	}
+	for _, d := range decks {
+		d.close()
+	}
+	return nil
+}
+
+func (s *Server) isClosed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Handler returns the HTTP handler of the API, e.g. for tests.
+func (s *Server) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token == "" && !localHost(r.Host) {
+			writeError(w, http.StatusForbidden, "host %s not allowed", r.Host)
+			return
+		}
+		if s.token == "" && !sameOrigin(r) {
+			writeError(w, http.StatusForbidden, "origin %s not allowed", r.Header.Get("Origin"))
+			return
+		}
+		if !s.authorized(r) {
+			writeError(w, http.StatusUnauthorized, "invalid or missing token")
+			return
+		}
+		s.route(w, r)
+	})
+}
+
+// authorized checks the token of a request, if one is required.
+func (s *Server) authorized(r *http.Request) bool {
+	if s.token == "" {
+		return true
+	}
+	token := r.URL.Query().Get("token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+// localHost reports whether the Host header of a request names the local
+// machine. Without a token, other names are rejected to defeat DNS
+// rebinding from web pages.
+func localHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return host == "" || isLoopback(net.JoinHostPort(strings.Trim(host, "[]"), "0"))
+}
+
+// sameOrigin reports whether a request was made by a page of the API
+// itself, or not by a browser at all. Without a token, requests of other
+// web pages are rejected, as they could otherwise read events, lease keys
+// or post forms to set keys.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// isLoopback reports whether addr (host:port) is a loopback address.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	writeJSON(w, status, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
+
+// stateName returns the name of a key state in events.
+func stateName(state sd.BtnState) string {
+	if state == sd.BtnPressed {
+		return "pressed"
+	}
+	return "released"
+}
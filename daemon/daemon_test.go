@@ -0,0 +1,516 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/internal/websocket"
+	"github.com/KarpelesLab/streamdeck/mock"
+)
+
+const serial = "TEST0001"
+
+// newTestServer serves a mock Stream Deck Mini.
+func newTestServer(t *testing.T, options ...func(*Server)) (*Server, *mock.Device, *httptest.Server) {
+	return newTestServerDeck(t, options)
+}
+
+func newTestServerDeck(t *testing.T, options []func(*Server), deckOptions ...func(*Deck)) (*Server, *mock.Device, *httptest.Server) {
+	t.Helper()
+	dev, m := mock.Open(t, sd.LookupDevice(0x0063), serial)
+	s := New(options...)
+	if _, err := s.Add(dev, deckOptions...); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(func() {
+		ts.Close()
+		s.Close()
+	})
+	return s, m, ts
+}
+
+func do(t *testing.T, method, url, contentType string, body []byte, header ...string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	return res
+}
+
+func checkStatus(t *testing.T, res *http.Response, want int) {
+	t.Helper()
+	if res.StatusCode != want {
+		body, _ := ioutil.ReadAll(res.Body)
+		t.Fatalf("%s %s: status %d, want %d: %s", res.Request.Method, res.Request.URL.Path, res.StatusCode, want, body)
+	}
+}
+
+// waitFor polls cond until it holds, failing the test after a while.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// keyColor returns the color at the center of a key of the mock.
+func keyColor(m *mock.Device, btnIndex int) color.RGBA {
+	img := m.Key(btnIndex).(*image.RGBA)
+	c := img.Bounds().Size().Div(2)
+	return img.RGBAAt(c.X, c.Y)
+}
+
+var (
+	red  = color.RGBA{255, 0, 0, 255}
+	blue = color.RGBA{0, 0, 255, 255}
+)
+
+func wsURL(ts *httptest.Server, path string) string {
+	return "ws" + strings.TrimPrefix(ts.URL, "http") + path
+}
+
+func TestDevices(t *testing.T) {
+	_, _, ts := newTestServer(t)
+
+	res := do(t, http.MethodGet, ts.URL+"/api/devices", "", nil)
+	checkStatus(t, res, http.StatusOK)
+	var devices []DeviceInfo
+	if err := json.NewDecoder(res.Body).Decode(&devices); err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 1 || devices[0].Serial != serial || devices[0].Keys != 6 || devices[0].Columns != 3 {
+		t.Errorf("devices = %+v", devices)
+	}
+
+	checkStatus(t, do(t, http.MethodGet, ts.URL+"/api/devices/"+serial, "", nil), http.StatusOK)
+	checkStatus(t, do(t, http.MethodGet, ts.URL+"/api/devices/NOPE", "", nil), http.StatusNotFound)
+	checkStatus(t, do(t, http.MethodPost, ts.URL+"/api/devices", "", nil), http.StatusMethodNotAllowed)
+	checkStatus(t, do(t, http.MethodGet, ts.URL+"/api/devices/"+serial+"/keys/6", "", nil), http.StatusNotFound)
+}
+
+func TestSetKey(t *testing.T) {
+	_, m, ts := newTestServer(t)
+	key := ts.URL + "/api/devices/" + serial + "/keys/"
+
+	res := do(t, http.MethodPost, key+"1", "application/json", []byte(`{"color":"#ff0000"}`))
+	checkStatus(t, res, http.StatusNoContent)
+	if c := keyColor(m, 1); c != red {
+		t.Errorf("key 1 is %v, want red", c)
+	}
+
+	var buf bytes.Buffer
+	img := image.NewRGBA(image.Rect(0, 0, 80, 80))
+	for i := 0; i < len(img.Pix); i += 4 {
+		copy(img.Pix[i:], []byte{0, 0, 255, 255})
+	}
+	png.Encode(&buf, img)
+	checkStatus(t, do(t, http.MethodPost, key+"2", "image/png", buf.Bytes()), http.StatusNoContent)
+	if c := keyColor(m, 2); c != blue {
+		t.Errorf("key 2 is %v, want blue", c)
+	}
+
+	res = do(t, http.MethodGet, key+"2", "", nil)
+	checkStatus(t, res, http.StatusOK)
+	got, err := png.Decode(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := color.RGBAModel.Convert(got.At(40, 40)); c != blue {
+		t.Errorf("PNG of key 2 is %v, want blue", c)
+	}
+
+	res = do(t, http.MethodGet, ts.URL+"/api/devices/"+serial+"/screenshot", "", nil)
+	checkStatus(t, res, http.StatusOK)
+	if _, err := png.Decode(res.Body); err != nil {
+		t.Errorf("screenshot: %s", err)
+	}
+
+	checkStatus(t, do(t, http.MethodDelete, key+"1", "", nil), http.StatusNoContent)
+	if c := keyColor(m, 1); c != (color.RGBA{0, 0, 0, 255}) {
+		t.Errorf("cleared key 1 is %v, want black", c)
+	}
+
+	checkStatus(t, do(t, http.MethodPost, key+"1", "application/json", []byte(`{"color":"nope"}`)), http.StatusBadRequest)
+	checkStatus(t, do(t, http.MethodPost, key+"1", "application/json", []byte(`{`)), http.StatusBadRequest)
+	checkStatus(t, do(t, http.MethodPost, key+"1", "image/png", []byte("not a PNG")), http.StatusBadRequest)
+}
+
+func TestBrightness(t *testing.T) {
+	_, m, ts := newTestServer(t)
+	url := ts.URL + "/api/devices/" + serial + "/brightness"
+
+	checkStatus(t, do(t, http.MethodPut, url, "application/json", []byte(`{"brightness":42}`)), http.StatusNoContent)
+	if b := m.Brightness(); b != 42 {
+		t.Errorf("brightness = %d, want 42", b)
+	}
+	checkStatus(t, do(t, http.MethodPut, url, "application/json", []byte(`{"brightness":101}`)), http.StatusBadRequest)
+	checkStatus(t, do(t, http.MethodPut, url, "application/json", []byte(`{}`)), http.StatusBadRequest)
+}
+
+func TestRequestChecks(t *testing.T) {
+	_, m, ts := newTestServer(t)
+	key := ts.URL + "/api/devices/" + serial + "/keys/0"
+	body := []byte(`{"color":"#ff0000"}`)
+
+	tests := []struct {
+		name        string
+		contentType string
+		header      []string
+		want        int
+	}{
+		{"form", "application/x-www-form-urlencoded", nil, http.StatusUnsupportedMediaType},
+		{"plain text", "text/plain", nil, http.StatusUnsupportedMediaType},
+		{"no content type", "", nil, http.StatusUnsupportedMediaType},
+		{"other origin", "application/json", []string{"Origin", "http://evil.example"}, http.StatusForbidden},
+		{"other host", "application/json", []string{"Host", "evil.example"}, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodPost, key, bytes.NewReader(body))
+			if test.contentType != "" {
+				req.Header.Set("Content-Type", test.contentType)
+			}
+			if test.header != nil {
+				req.Header.Set(test.header[0], test.header[1])
+				if test.header[0] == "Host" {
+					req.Host = test.header[1]
+				}
+			}
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != test.want {
+				t.Errorf("status %d, want %d", res.StatusCode, test.want)
+			}
+		})
+	}
+	if c := keyColor(m, 0); c == red {
+		t.Error("a rejected request set the key")
+	}
+
+	origin := "http://" + strings.TrimPrefix(ts.URL, "http://")
+	checkStatus(t, do(t, http.MethodPost, key, "application/json", body, "Origin", origin), http.StatusNoContent)
+	if c := keyColor(m, 0); c != red {
+		t.Errorf("key 0 is %v, want red", c)
+	}
+
+	if _, err := websocket.Dial(wsURL(ts, "/api/events"), http.Header{"Origin": {"http://evil.example"}}); err == nil {
+		t.Error("WebSocket of another origin accepted")
+	}
+}
+
+func TestToken(t *testing.T) {
+	_, _, ts := newTestServer(t, Token("secret"))
+	url := ts.URL + "/api/devices"
+
+	checkStatus(t, do(t, http.MethodGet, url, "", nil), http.StatusUnauthorized)
+	checkStatus(t, do(t, http.MethodGet, url, "", nil, "Authorization", "Bearer wrong"), http.StatusUnauthorized)
+	checkStatus(t, do(t, http.MethodGet, url, "", nil, "Authorization", "Bearer secret"), http.StatusOK)
+	checkStatus(t, do(t, http.MethodGet, url+"?token=secret", "", nil), http.StatusOK)
+}
+
+// subscribers returns the number of clients of the event stream.
+func (s *Server) subscribers() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.subs)
+}
+
+func readEvent(t *testing.T, conn *websocket.Conn) Event {
+	t.Helper()
+	var ev Event
+	if err := conn.ReadJSON(&ev); err != nil {
+		t.Fatal(err)
+	}
+	return ev
+}
+
+func TestEvents(t *testing.T) {
+	s, m, ts := newTestServer(t)
+
+	conn, err := websocket.Dial(wsURL(ts, "/api/events?serial="+serial), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitFor(t, "subscription", func() bool { return s.subscribers() == 1 })
+
+	m.Click(4)
+	for _, state := range []string{"pressed", "released"} {
+		ev := readEvent(t, conn)
+		if ev.Serial != serial || ev.Key != 4 || ev.State != state {
+			t.Errorf("event = %+v, want key 4 %s", ev, state)
+		}
+	}
+
+	conn.Close()
+	waitFor(t, "unsubscription", func() bool { return s.subscribers() == 0 })
+
+	res := do(t, http.MethodGet, ts.URL+"/api/events", "", nil)
+	checkStatus(t, res, http.StatusBadRequest)
+}
+
+func readLease(t *testing.T, conn *websocket.Conn, typ string) leaseMessage {
+	t.Helper()
+	var m struct {
+		leaseMessage
+		Event
+	}
+	if err := conn.ReadJSON(&m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Type != typ {
+		t.Fatalf("got %q message %+v, want %q", m.Type, m, typ)
+	}
+	m.leaseMessage.Event = &m.Event
+	return m.leaseMessage
+}
+
+func TestLease(t *testing.T) {
+	s, m, ts := newTestServer(t)
+	base := "/api/devices/" + serial
+
+	events, err := websocket.Dial(wsURL(ts, "/api/events"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer events.Close()
+	waitFor(t, "subscription", func() bool { return s.subscribers() == 1 })
+
+	checkStatus(t, do(t, http.MethodPost, ts.URL+base+"/keys/1", "application/json", []byte(`{"color":"#ff0000"}`)), http.StatusNoContent)
+
+	low, err := websocket.Dial(wsURL(ts, base+"/lease?keys=0-1"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer low.Close()
+	if msg := readLease(t, low, "leased"); !reflect.DeepEqual(msg.Keys, []int{0, 1}) {
+		t.Errorf("leased keys %v", msg.Keys)
+	}
+	if c := keyColor(m, 1); c != (color.RGBA{0, 0, 0, 255}) {
+		t.Errorf("leased key 1 is %v, want black", c)
+	}
+
+	// Events of leased keys go to the lease only.
+	m.Press(0)
+	m.Press(2)
+	if msg := readLease(t, low, "key"); msg.Key != 0 || msg.State != "pressed" {
+		t.Errorf("lease event = %+v", msg.Event)
+	}
+	if ev := readEvent(t, events); ev.Key != 2 {
+		t.Errorf("event stream got key %d, want 2", ev.Key)
+	}
+
+	low.WriteJSON(map[string]interface{}{"type": "set", "key": 1, "color": "#0000ff"})
+	waitFor(t, "key 1 blue", func() bool { return keyColor(m, 1) == blue })
+	low.WriteJSON(map[string]interface{}{"type": "set", "key": 3, "color": "#0000ff"})
+	readLease(t, low, "error")
+
+	if _, err := websocket.Dial(wsURL(ts, base+"/lease?keys=1"), nil); err == nil {
+		t.Error("lease of an equal priority on a leased key accepted")
+	}
+
+	high, err := websocket.Dial(wsURL(ts, base+"/lease?keys=1&priority=5"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readLease(t, high, "leased")
+	if msg := readLease(t, low, "preempted"); !reflect.DeepEqual(msg.Keys, []int{1}) {
+		t.Errorf("preempted keys %v", msg.Keys)
+	}
+	if c := keyColor(m, 1); c != (color.RGBA{0, 0, 0, 255}) {
+		t.Errorf("preempted key 1 is %v, want black", c)
+	}
+
+	high.Close()
+	if msg := readLease(t, low, "restored"); !reflect.DeepEqual(msg.Keys, []int{1}) {
+		t.Errorf("restored keys %v", msg.Keys)
+	}
+	waitFor(t, "key 1 blue again", func() bool { return keyColor(m, 1) == blue })
+
+	low.Close()
+	waitFor(t, "key 1 red again", func() bool { return keyColor(m, 1) == red })
+}
+
+func TestLeaseRequests(t *testing.T) {
+	_, _, ts := newTestServer(t)
+	base := "/api/devices/" + serial + "/lease"
+
+	for _, query := range []string{"?keys=0-100000000", "?keys=6", "?keys=-1", "?keys=1,1", "?priority=high"} {
+		if _, err := websocket.Dial(wsURL(ts, base+query), nil); err == nil {
+			t.Errorf("lease %s accepted", query)
+		}
+	}
+	checkStatus(t, do(t, http.MethodGet, ts.URL+base, "", nil), http.StatusBadRequest)
+}
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []int
+		ok   bool
+	}{
+		{"", nil, true},
+		{"3", []int{3}, true},
+		{"0,1,4-5", []int{0, 1, 4, 5}, true},
+		{" 1 - 2 ", []int{1, 2}, true},
+		{"5-6", nil, false},
+		{"0-1000000000", nil, false},
+		{"-1", nil, false},
+		{"2-1", nil, false},
+		{"a", nil, false},
+	}
+	for _, test := range tests {
+		got, err := parseKeys(test.in, 6)
+		if (err == nil) != test.ok || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseKeys(%q) = %v, %v", test.in, got, err)
+		}
+	}
+}
+
+func TestProfile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "profile.yaml")
+	conf := `devices:
+  - brightness: 60
+    pages:
+      - name: main
+        keys:
+          - key: 0
+            color: "#ff0000"
+      - name: other
+`
+	if err := os.WriteFile(path, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, m, ts := newTestServerDeck(t, nil, Profile(path))
+	url := ts.URL + "/api/devices/" + serial + "/page"
+
+	if b := m.Brightness(); b != 60 {
+		t.Errorf("brightness = %d, want 60", b)
+	}
+	if c := keyColor(m, 0); c != red {
+		t.Errorf("key 0 is %v, want red", c)
+	}
+
+	checkStatus(t, do(t, http.MethodPut, url, "application/json", []byte(`{"page":"other"}`)), http.StatusOK)
+	res := do(t, http.MethodGet, url, "", nil)
+	checkStatus(t, res, http.StatusOK)
+	var page map[string]string
+	json.NewDecoder(res.Body).Decode(&page)
+	if page["page"] != "other" {
+		t.Errorf("page = %v", page)
+	}
+	checkStatus(t, do(t, http.MethodPut, url, "application/json", []byte(`{"page":"nope"}`)), http.StatusNotFound)
+	checkStatus(t, do(t, http.MethodPut, url, "application/json", []byte(`{"back":true}`)), http.StatusOK)
+}
+
+func TestAdd(t *testing.T) {
+	dev, _ := mock.Open(t, sd.LookupDevice(0x0063), serial)
+	s := New()
+	if _, err := s.Add(dev); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Add(dev); err == nil || !strings.Contains(err.Error(), "already added") {
+		t.Errorf("adding the deck twice: %v", err)
+	}
+	s.Close()
+
+	other, _ := mock.Open(t, sd.LookupDevice(0x0063), "TEST0002")
+	if _, err := s.Add(other); err == nil {
+		t.Error("deck added to a closed server")
+	}
+	if len(s.Decks()) != 0 {
+		t.Errorf("decks of a closed server: %v", s.Decks())
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	dir := t.TempDir()
+
+	// Other files are left alone.
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := New().ListenAndServeUnix(file); err == nil || !strings.Contains(err.Error(), "not a socket") {
+		t.Errorf("listening on a file: %v", err)
+	}
+
+	// A socket nobody listens on anymore is replaced.
+	path := filepath.Join(dir, "daemon.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	s := New()
+	errc := make(chan error, 1)
+	go func() { errc <- s.ListenAndServeUnix(path) }()
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	waitFor(t, "the socket", func() bool {
+		res, err := client.Get("http://localhost/api/devices")
+		if err != nil {
+			return false
+		}
+		res.Body.Close()
+		return res.StatusCode == http.StatusOK
+	})
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Errorf("socket mode %v, want 0600", perm)
+	}
+
+	// The socket of a running daemon isn't.
+	if err := New().ListenAndServeUnix(path); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("listening on a socket in use: %v", err)
+	}
+
+	s.Close()
+	if err := <-errc; err != http.ErrServerClosed {
+		t.Errorf("ListenAndServeUnix returned %v", err)
+	}
+}
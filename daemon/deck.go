@@ -0,0 +1,261 @@
+package daemon
+
+import (
+	"fmt"
+	"image"
+	"sync"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/page"
+	"github.com/KarpelesLab/streamdeck/profile"
+)
+
+// Deck is a Stream Deck served by the daemon.
+type Deck struct {
+	mu       sync.Mutex
+	server   *Server
+	dev      *sd.StreamDeck
+	serial   string
+	firmware string
+	profile  string
+	base     *layer // what the API and the profile display
+	host     *sd.Host
+	runtime  *profile.Runtime
+	leases   []*Lease
+	owners   []*Lease // lease displayed on each key, nil for base
+}
+
+// Profile displays the profile file at path on the deck. Pages can then be
+// switched through the API.
+func Profile(path string) func(*Deck) {
+	return func(d *Deck) {
+		d.profile = path
+	}
+}
+
+// Add serves dev, which is closed with the Server.
+func (s *Server) Add(dev *sd.StreamDeck, options ...func(*Deck)) (*Deck, error) {
+	serial, err := dev.GetSerialNumber()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read serial number: %w", err)
+	}
+	firmware, _ := dev.GetFirmwareVersion()
+
+	d := &Deck{
+		server:   s,
+		dev:      dev,
+		serial:   serial,
+		firmware: firmware,
+		owners:   make([]*Lease, dev.NumButtons()),
+	}
+	d.base = &layer{deck: d, images: make([]image.Image, dev.NumButtons())}
+	for _, option := range options {
+		option(d)
+	}
+
+	s.mu.Lock()
+	_, dup := s.decks[serial]
+	s.mu.Unlock()
+	if dup {
+		return nil, fmt.Errorf("deck %s already added", serial)
+	}
+
+	// The profile is loaded and drawn without holding the lock, which
+	// would block the API meanwhile.
+	if d.profile != "" {
+		d.runtime, err = profile.Run(d.profile, d.base, serial)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		d.host = sd.NewHost(d.base)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, dup := s.decks[serial]; dup || s.isClosed() {
+		if d.runtime != nil {
+			d.runtime.Close()
+		} else {
+			d.host.Close()
+		}
+		if dup {
+			return nil, fmt.Errorf("deck %s already added", serial)
+		}
+		return nil, fmt.Errorf("server closed")
+	}
+	dev.SetBtnEventCb(d.event)
+	s.decks[serial] = d
+	return d, nil
+}
+
+// Serial returns the serial number of the deck.
+func (d *Deck) Serial() string {
+	return d.serial
+}
+
+// Device returns the underlying StreamDeck.
+func (d *Deck) Device() *sd.StreamDeck {
+	return d.dev
+}
+
+// Runtime returns the profile displayed on the deck, or nil.
+func (d *Deck) Runtime() *profile.Runtime {
+	return d.runtime
+}
+
+// event hands a key event over to the lease holding the key, or publishes
+// it and hands it over to whatever displays the deck. Events of leased keys
+// only go to the holder of the lease.
+func (d *Deck) event(btnIndex int, state sd.BtnState) {
+	ev := Event{
+		Serial: d.serial,
+		Key:    btnIndex,
+		State:  stateName(state),
+		Time:   time.Now(),
+	}
+
+	d.mu.Lock()
+	l := d.base
+	if btnIndex < len(d.owners) && d.owners[btnIndex] != nil {
+		l = d.owners[btnIndex].layer
+	}
+	d.mu.Unlock()
+	if l == d.base {
+		d.server.publish(ev)
+	}
+	l.event(ev, state)
+}
+
+// SetKey displays el on a key. With a profile, the key of the current page
+// is replaced.
+func (d *Deck) SetKey(btnIndex int, el sd.Element) error {
+	if btnIndex < 0 || btnIndex >= d.dev.NumButtons() {
+		return fmt.Errorf("invalid key index %d", btnIndex)
+	}
+	if d.runtime != nil {
+		d.runtime.Deck().Current().SetKey(btnIndex, &page.Key{Element: el})
+		return nil
+	}
+	return d.host.Bind(btnIndex, el)
+}
+
+// ClearKey removes what SetKey displayed and blanks the key.
+func (d *Deck) ClearKey(btnIndex int) error {
+	if btnIndex < 0 || btnIndex >= d.dev.NumButtons() {
+		return fmt.Errorf("invalid key index %d", btnIndex)
+	}
+	if d.runtime != nil {
+		d.runtime.Deck().Current().SetKey(btnIndex, nil)
+		return nil
+	}
+	d.host.Unbind(btnIndex)
+	return d.base.clear(btnIndex)
+}
+
+func (d *Deck) close() {
+	d.mu.Lock()
+	leases := append([]*Lease(nil), d.leases...)
+	d.mu.Unlock()
+	for _, l := range leases {
+		l.Release()
+	}
+
+	if d.runtime != nil {
+		d.runtime.Close()
+	}
+	if d.host != nil {
+		d.host.Close()
+	}
+	d.dev.Close()
+}
+
+// layer is the surface of the base display or of a lease. It remembers
+// what is drawn on each key, and only draws on the device the keys the
+// layer is displayed on.
+type layer struct {
+	deck   *Deck
+	lease  *Lease // nil for the base layer
+	mu     sync.Mutex
+	cb     sd.BtnEvent
+	images []image.Image
+}
+
+func (l *layer) FillImage(btnIndex int, img image.Image) error {
+	if btnIndex < 0 || btnIndex >= len(l.images) {
+		return fmt.Errorf("invalid key index %d", btnIndex)
+	}
+	l.mu.Lock()
+	l.images[btnIndex] = img
+	l.mu.Unlock()
+
+	d := l.deck
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.owners[btnIndex] != l.lease {
+		return nil
+	}
+	return d.dev.FillImage(btnIndex, img)
+}
+
+// clear blanks a key.
+func (l *layer) clear(btnIndex int) error {
+	l.mu.Lock()
+	l.images[btnIndex] = nil
+	l.mu.Unlock()
+
+	d := l.deck
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.owners[btnIndex] != l.lease {
+		return nil
+	}
+	return d.dev.ClearBtn(btnIndex)
+}
+
+// redraw draws the remembered image of a key, or clears it. The caller
+// holds the lock of the deck.
+func (l *layer) redraw(btnIndex int) error {
+	l.mu.Lock()
+	img := l.images[btnIndex]
+	l.mu.Unlock()
+
+	if img == nil {
+		return l.deck.dev.ClearBtn(btnIndex)
+	}
+	return l.deck.dev.FillImage(btnIndex, img)
+}
+
+// SetBrightness sets the brightness of the deck, e.g. from a profile.
+func (l *layer) SetBrightness(pc uint8) error {
+	return l.deck.dev.SetBrightness(pc)
+}
+
+func (l *layer) SetBtnEventCb(ev sd.BtnEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cb = ev
+}
+
+func (l *layer) ButtonSize() int {
+	return l.deck.dev.ButtonSize()
+}
+
+func (l *layer) NumButtons() int {
+	return l.deck.dev.NumButtons()
+}
+
+func (l *layer) event(ev Event, state sd.BtnState) {
+	if l.lease != nil && l.lease.onEvent != nil {
+		l.lease.onEvent(ev)
+	}
+
+	l.mu.Lock()
+	cb := l.cb
+	l.mu.Unlock()
+
+	if cb != nil {
+		cb(ev.Key, state)
+	}
+}
@@ -0,0 +1,398 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/internal/websocket"
+)
+
+// ErrConflict is returned when keys can't be leased because a lease of the
+// same or a higher priority holds them.
+var ErrConflict = errors.New("keys already leased")
+
+// Lease gives a client exclusive use of some keys of a deck: the client
+// draws on them and receives their events, over whatever the deck displays
+// otherwise.
+//
+// A lease of a higher priority preempts the keys of lower priority leases
+// for as long as it is held, after which they are handed back.
+type Lease struct {
+	deck      *Deck
+	keys      []int
+	priority  int
+	layer     *layer
+	host      *sd.Host
+	onEvent   func(Event)
+	onPreempt func(keys []int)
+	onRestore func(keys []int)
+	// released and preempted are protected by the lock of the deck.
+	released  bool
+	preempted map[int]bool
+}
+
+// Priority sets the priority of a lease, 0 by default.
+func Priority(p int) func(*Lease) {
+	return func(l *Lease) {
+		l.priority = p
+	}
+}
+
+// OnEvent sets a function called with the events of the keys the lease is
+// displayed on.
+func OnEvent(f func(Event)) func(*Lease) {
+	return func(l *Lease) {
+		l.onEvent = f
+	}
+}
+
+// OnPreempt sets a function called when keys are taken over by a lease of
+// a higher priority.
+func OnPreempt(f func(keys []int)) func(*Lease) {
+	return func(l *Lease) {
+		l.onPreempt = f
+	}
+}
+
+// OnRestore sets a function called when preempted keys are handed back.
+func OnRestore(f func(keys []int)) func(*Lease) {
+	return func(l *Lease) {
+		l.onRestore = f
+	}
+}
+
+// Lease claims keys of the deck, all of them if keys is empty.
+func (d *Deck) Lease(keys []int, options ...func(*Lease)) (*Lease, error) {
+	if len(keys) == 0 {
+		for i := 0; i < d.dev.NumButtons(); i++ {
+			keys = append(keys, i)
+		}
+	}
+	seen := make(map[int]bool)
+	for _, k := range keys {
+		if k < 0 || k >= d.dev.NumButtons() {
+			return nil, fmt.Errorf("invalid key index %d", k)
+		}
+		if seen[k] {
+			return nil, fmt.Errorf("key %d listed twice", k)
+		}
+		seen[k] = true
+	}
+
+	l := &Lease{deck: d, keys: append([]int(nil), keys...), preempted: make(map[int]bool)}
+	sort.Ints(l.keys)
+	for _, option := range options {
+		option(l)
+	}
+	l.layer = &layer{deck: d, lease: l, images: make([]image.Image, d.dev.NumButtons())}
+
+	d.mu.Lock()
+	for _, other := range d.leases {
+		if other.priority >= l.priority && other.holds(seen) {
+			d.mu.Unlock()
+			return nil, ErrConflict
+		}
+	}
+	d.leases = append(d.leases, l)
+	changes := d.assign()
+	d.mu.Unlock()
+
+	l.host = sd.NewHost(l.layer)
+	notify(changes)
+	return l, nil
+}
+
+// holds reports whether the lease includes any of the keys.
+func (l *Lease) holds(keys map[int]bool) bool {
+	for _, k := range l.keys {
+		if keys[k] {
+			return true
+		}
+	}
+	return false
+}
+
+// Keys returns the keys of the lease.
+func (l *Lease) Keys() []int {
+	return append([]int(nil), l.keys...)
+}
+
+// Active returns the keys the lease is displayed on, i.e. which aren't
+// preempted.
+func (l *Lease) Active() []int {
+	d := l.deck
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var res []int
+	for _, k := range l.keys {
+		if d.owners[k] == l {
+			res = append(res, k)
+		}
+	}
+	return res
+}
+
+// SetKey displays el on a key of the lease. It becomes visible when the key
+// isn't preempted.
+func (l *Lease) SetKey(btnIndex int, el sd.Element) error {
+	if !l.has(btnIndex) {
+		return fmt.Errorf("key %d isn't leased", btnIndex)
+	}
+	return l.host.Bind(btnIndex, el)
+}
+
+// ClearKey blanks a key of the lease.
+func (l *Lease) ClearKey(btnIndex int) error {
+	if !l.has(btnIndex) {
+		return fmt.Errorf("key %d isn't leased", btnIndex)
+	}
+	l.host.Unbind(btnIndex)
+	return l.layer.clear(btnIndex)
+}
+
+func (l *Lease) has(btnIndex int) bool {
+	i := sort.SearchInts(l.keys, btnIndex)
+	return i < len(l.keys) && l.keys[i] == btnIndex
+}
+
+// Release gives the keys back to the leases they were preempted from, or to
+// the base display.
+func (l *Lease) Release() {
+	d := l.deck
+	d.mu.Lock()
+	if l.released {
+		d.mu.Unlock()
+		return
+	}
+	l.released = true
+	for i, other := range d.leases {
+		if other == l {
+			d.leases = append(d.leases[:i], d.leases[i+1:]...)
+			break
+		}
+	}
+	changes := d.assign()
+	d.mu.Unlock()
+
+	l.host.Close()
+	notify(changes)
+}
+
+// change records that a lease lost or regained keys.
+type change struct {
+	lease    *Lease
+	keys     []int
+	restored bool
+}
+
+// assign gives each key to the lease of the highest priority holding it,
+// redraws the keys changing hands, and returns the changes to notify. The
+// caller holds the lock of the deck.
+func (d *Deck) assign() []change {
+	lost := make(map[*Lease][]int)
+	gained := make(map[*Lease][]int)
+
+	for k := range d.owners {
+		var owner *Lease
+		for _, l := range d.leases {
+			if l.has(k) && (owner == nil || l.priority > owner.priority) {
+				owner = l
+			}
+		}
+		prev := d.owners[k]
+		if owner == prev {
+			continue
+		}
+		d.owners[k] = owner
+
+		if owner != nil {
+			owner.layer.redraw(k)
+		} else {
+			d.base.redraw(k)
+		}
+		if prev != nil && !prev.released {
+			prev.preempted[k] = true
+			lost[prev] = append(lost[prev], k)
+		}
+		if owner != nil && owner.preempted[k] {
+			delete(owner.preempted, k)
+			gained[owner] = append(gained[owner], k)
+		}
+	}
+
+	var res []change
+	for l, keys := range lost {
+		res = append(res, change{lease: l, keys: keys})
+	}
+	for l, keys := range gained {
+		res = append(res, change{lease: l, keys: keys, restored: true})
+	}
+	return res
+}
+
+// notify reports changes to the leases, without holding the deck's lock.
+func notify(changes []change) {
+	for _, c := range changes {
+		f := c.lease.onPreempt
+		if c.restored {
+			f = c.lease.onRestore
+		}
+		if f != nil {
+			f(c.keys)
+		}
+	}
+}
+
+// leaseMessage is a message sent to lease clients.
+type leaseMessage struct {
+	Type string `json:"type"`
+	Keys []int  `json:"keys,omitempty"`
+	*Event
+	Error string `json:"error,omitempty"`
+}
+
+// leaseCommand is a message sent by lease clients.
+type leaseCommand struct {
+	Type string `json:"type"`
+	Key  *int   `json:"key"`
+	KeyRequest
+}
+
+// serveLease serves a lease over a WebSocket, released when the client
+// disconnects. The keys ("0,1,4-6", all if empty) and the priority are
+// taken from the query string.
+//
+// The server sends {"type": "leased", "keys": [...]}, then "key" messages
+// with the fields of an Event, and "preempted" and "restored" messages with
+// the keys concerned. The client sends {"type": "set", "key": n, ...} with
+// the fields of a KeyRequest, and {"type": "clear", "key": n}.
+func (d *Deck) serveLease(w http.ResponseWriter, r *http.Request, _ string) {
+	if !websocket.IsWebSocket(r) {
+		writeError(w, http.StatusBadRequest, "websocket required")
+		return
+	}
+	keys, err := parseKeys(r.URL.Query().Get("keys"), d.dev.NumButtons())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	priority := 0
+	if p := r.URL.Query().Get("priority"); p != "" {
+		if priority, err = strconv.Atoi(p); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid priority %q", p)
+			return
+		}
+	}
+
+	// Messages are queued, so a slow client doesn't block the deck.
+	out := make(chan leaseMessage, 64)
+	send := func(m leaseMessage) {
+		select {
+		case out <- m:
+		default:
+		}
+	}
+	l, err := d.Lease(keys,
+		Priority(priority),
+		OnEvent(func(ev Event) { send(leaseMessage{Type: "key", Event: &ev}) }),
+		OnPreempt(func(keys []int) { send(leaseMessage{Type: "preempted", Keys: keys}) }),
+		OnRestore(func(keys []int) { send(leaseMessage{Type: "restored", Keys: keys}) }),
+	)
+	if err == ErrConflict {
+		writeError(w, http.StatusConflict, "%s", err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	defer l.Release()
+
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			var m leaseCommand
+			if err := conn.ReadJSON(&m); err != nil {
+				return
+			}
+			if err := l.handle(&m); err != nil {
+				send(leaseMessage{Type: "error", Error: err.Error()})
+			}
+		}
+	}()
+
+	if err := conn.WriteJSON(leaseMessage{Type: "leased", Keys: l.Keys()}); err != nil {
+		return
+	}
+	for {
+		select {
+		case <-gone:
+			return
+		case <-d.server.done:
+			return
+		case m := <-out:
+			if err := conn.WriteJSON(m); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handle executes a message of the client.
+func (l *Lease) handle(m *leaseCommand) error {
+	if m.Key == nil {
+		return fmt.Errorf("%s: key required", m.Type)
+	}
+	switch m.Type {
+	case "set":
+		t, err := m.KeyRequest.tile()
+		if err != nil {
+			return err
+		}
+		return l.SetKey(*m.Key, t)
+	case "clear":
+		return l.ClearKey(*m.Key)
+	default:
+		return fmt.Errorf("unknown message type %q", m.Type)
+	}
+}
+
+// parseKeys parses a list of keys and ranges of keys, e.g. "0,1,4-6", of a
+// deck of n keys.
+func parseKeys(s string, n int) ([]int, error) {
+	var keys []int
+	if s == "" {
+		return keys, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		from, to := part, part
+		if i := strings.IndexByte(part, '-'); i > 0 {
+			from, to = part[:i], part[i+1:]
+		}
+		a, err1 := strconv.Atoi(strings.TrimSpace(from))
+		b, err2 := strconv.Atoi(strings.TrimSpace(to))
+		if err1 != nil || err2 != nil || a > b {
+			return nil, fmt.Errorf("invalid keys %q", s)
+		}
+		if a < 0 || b >= n {
+			return nil, fmt.Errorf("invalid keys %q: the deck has %d keys", s, n)
+		}
+		for k := a; k <= b; k++ {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,9 @@
+//go:build windows || plan9 || js || wasip1
+// +build windows plan9 js wasip1
+
+package daemon
+
+// umask does nothing on systems without a file mode creation mask.
+func umask(mask int) func() {
+	return func() {}
+}
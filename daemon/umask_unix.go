@@ -0,0 +1,14 @@
+//go:build !windows && !plan9 && !js && !wasip1
+// +build !windows,!plan9,!js,!wasip1
+
+package daemon
+
+import "syscall"
+
+// umask sets the file mode creation mask of the process, and returns a
+// function restoring the previous one. As the mask is shared by all
+// goroutines, it should only be changed briefly.
+func umask(mask int) func() {
+	old := syscall.Umask(mask)
+	return func() { syscall.Umask(old) }
+}
@@ -1,5 +1,20 @@
 package streamdeck
 
+import "image"
+
+// Protocol is the generation of the USB protocol spoken by a model.
+type Protocol int
+
+const (
+	// ProtocolV1 is spoken by the original Stream Deck and the Mini: key
+	// images are BMP files sent in pages with a 16 byte header.
+	ProtocolV1 Protocol = iota
+	// ProtocolV2 is spoken by the XL: key images are JPEG files sent in
+	// pages with an 8 byte header, the feature reports differ and the keys
+	// are numbered from the top left corner by the device.
+	ProtocolV2
+)
+
 type StreamdeckDevice struct {
 	ProductID        uint16
 	Name             string
@@ -9,6 +24,7 @@ type StreamdeckDevice struct {
 	Spacer           int
 	NumButtonColumns int
 	NumButtonRows    int
+	Protocol         Protocol
 }
 
 var streamdeckDevices = []*StreamdeckDevice{
@@ -42,6 +58,17 @@ var streamdeckDevices = []*StreamdeckDevice{
 		NumButtonColumns: 3,
 		NumButtonRows:    2,
 	},
+	&StreamdeckDevice{
+		ProductID:        0x006c, // xl
+		Name:             "Stream Deck XL",
+		NumButtons:       32, // 8x4
+		ButtonSize:       96,
+		StreamBuffer:     1024,
+		Spacer:           24, // ?? measured on photos
+		NumButtonColumns: 8,
+		NumButtonRows:    4,
+		Protocol:         ProtocolV2,
+	},
 }
 
 func (dev *StreamdeckDevice) PanelWidth() int {
@@ -51,3 +78,39 @@ func (dev *StreamdeckDevice) PanelWidth() int {
 func (dev *StreamdeckDevice) PanelHeight() int {
 	return dev.NumButtonRows*dev.ButtonSize + dev.Spacer*(dev.NumButtonRows-1)
 }
+
+// KeyRect returns the area of a key on the panel, as used by FillPanel:
+// keys are numbered row by row, starting from the top right corner.
+func (dev *StreamdeckDevice) KeyRect(btnIndex int) image.Rectangle {
+	col, row := btnIndex%dev.NumButtonColumns, btnIndex/dev.NumButtonColumns
+	x := dev.PanelWidth() - dev.ButtonSize - col*(dev.ButtonSize+dev.Spacer)
+	y := row * (dev.ButtonSize + dev.Spacer)
+	return image.Rect(x, y, x+dev.ButtonSize, y+dev.ButtonSize)
+}
+
+// DeviceKey converts between the key indices of the library and those used
+// on the wire by the device: ProtocolV2 devices count columns from the left.
+// The conversion is its own inverse.
+func (dev *StreamdeckDevice) DeviceKey(btnIndex int) int {
+	if dev.Protocol != ProtocolV2 {
+		return btnIndex
+	}
+	col, row := btnIndex%dev.NumButtonColumns, btnIndex/dev.NumButtonColumns
+	return row*dev.NumButtonColumns + dev.NumButtonColumns - 1 - col
+}
+
+// Devices returns the models supported by this library.
+func Devices() []*StreamdeckDevice {
+	return append([]*StreamdeckDevice(nil), streamdeckDevices...)
+}
+
+// LookupDevice returns the model with the given USB product ID, or nil if
+// it isn't supported.
+func LookupDevice(productID uint16) *StreamdeckDevice {
+	for _, dev := range streamdeckDevices {
+		if dev.ProductID == productID {
+			return dev
+		}
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+package streamdeck
+
+import (
+	"fmt"
+	"image"
+	"sync"
+)
+
+// Element is a widget which can be displayed on a key. Elements render
+// into an image of any size and don't need to know about the device, so they
+// can also be rendered off-screen (e.g. into PNG files).
+type Element interface {
+	// Render draws the element into img, which has the size of a key.
+	Render(img *image.RGBA) error
+	// Change is called when the key the element is bound to is pressed or
+	// released.
+	Change(state BtnState)
+	// Dirty reports whether the element changed since the last call to
+	// Dirty, and thus needs to be rendered again.
+	Dirty() bool
+}
+
+// Notifier is implemented by elements which can signal by themselves that
+// they need to be redrawn, for example when they are updated from another
+// goroutine or animated.
+type Notifier interface {
+	SetNotify(notify func())
+}
+
+// Invalidator can be embedded in elements to implement Dirty and Notifier.
+type Invalidator struct {
+	mu     sync.Mutex
+	dirty  bool
+	notify func()
+}
+
+// Invalidate marks the element as dirty and notifies its host, if any.
+func (inv *Invalidator) Invalidate() {
+	inv.mu.Lock()
+	inv.dirty = true
+	notify := inv.notify
+	inv.mu.Unlock()
+
+	if notify != nil {
+		notify()
+	}
+}
+
+// Dirty reports whether Invalidate was called since the last call to Dirty.
+func (inv *Invalidator) Dirty() bool {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	dirty := inv.dirty
+	inv.dirty = false
+	return dirty
+}
+
+// SetNotify sets the function called by Invalidate.
+func (inv *Invalidator) SetNotify(notify func()) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	inv.notify = notify
+}
+
+// Surface is anything elements can be displayed on, typically a StreamDeck.
+type Surface interface {
+	FillImage(btnIndex int, img image.Image) error
+	SetBtnEventCb(ev BtnEvent)
+	ButtonSize() int
+	NumButtons() int
+}
+
+// RenderElement renders el into a new image of size x size pixels.
+func RenderElement(el Element, size int) (*image.RGBA, error) {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	if err := el.Render(img); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// Host binds elements to the keys of a Surface. It forwards button events
+// to the elements and redraws them whenever they report to be dirty.
+type Host struct {
+	sync.Mutex
+	drawMu   sync.Mutex // serializes drawing, so a key can't go back in time
+	surface  Surface
+	elements map[int]Element
+	cb       BtnEvent
+	update   chan struct{}
+	done     chan struct{}
+}
+
+// NewHost creates a Host for the given surface. It takes over the button
+// event callback of the surface; use the SetBtnEventCb method of the Host to
+// receive events of keys which have no element bound.
+func NewHost(s Surface) *Host {
+	h := &Host{
+		surface:  s,
+		elements: make(map[int]Element),
+		update:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	s.SetBtnEventCb(h.event)
+
+	go h.loop()
+
+	return h
+}
+
+// Close stops the Host and detaches it from the surface.
+func (h *Host) Close() {
+	h.Lock()
+	defer h.Unlock()
+
+	select {
+	case <-h.done:
+		return
+	default:
+	}
+	close(h.done)
+	h.surface.SetBtnEventCb(nil)
+	for _, el := range h.elements {
+		if n, ok := el.(Notifier); ok {
+			n.SetNotify(nil)
+		}
+	}
+}
+
+// SetBtnEventCb sets the callback for events of keys without an element.
+func (h *Host) SetBtnEventCb(ev BtnEvent) {
+	h.Lock()
+	defer h.Unlock()
+	h.cb = ev
+}
+
+// Bind displays el on the given key, replacing any element previously bound
+// to it.
+func (h *Host) Bind(btnIndex int, el Element) error {
+	if btnIndex < 0 || btnIndex >= h.surface.NumButtons() {
+		return fmt.Errorf("invalid key index")
+	}
+
+	h.drawMu.Lock()
+	defer h.drawMu.Unlock()
+
+	h.Lock()
+	if old, ok := h.elements[btnIndex]; ok {
+		if n, ok := old.(Notifier); ok {
+			n.SetNotify(nil)
+		}
+	}
+	h.elements[btnIndex] = el
+	h.Unlock()
+
+	if n, ok := el.(Notifier); ok {
+		n.SetNotify(h.notify)
+	}
+
+	el.Dirty()
+	return h.draw(btnIndex, el)
+}
+
+// Unbind removes the element bound to the given key. The key keeps its
+// current content.
+func (h *Host) Unbind(btnIndex int) {
+	h.Lock()
+	defer h.Unlock()
+
+	if el, ok := h.elements[btnIndex]; ok {
+		if n, ok := el.(Notifier); ok {
+			n.SetNotify(nil)
+		}
+		delete(h.elements, btnIndex)
+	}
+}
+
+// Element returns the element bound to the given key, or nil.
+func (h *Host) Element(btnIndex int) Element {
+	h.Lock()
+	defer h.Unlock()
+	return h.elements[btnIndex]
+}
+
+// Update redraws all elements which are dirty.
+func (h *Host) Update() error {
+	h.drawMu.Lock()
+	defer h.drawMu.Unlock()
+
+	var firstErr error
+	for i, el := range h.snapshot() {
+		if !el.Dirty() {
+			continue
+		}
+		if err := h.draw(i, el); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Redraw draws all elements, whether they are dirty or not.
+func (h *Host) Redraw() error {
+	h.drawMu.Lock()
+	defer h.drawMu.Unlock()
+
+	var firstErr error
+	for i, el := range h.snapshot() {
+		el.Dirty()
+		if err := h.draw(i, el); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (h *Host) snapshot() map[int]Element {
+	h.Lock()
+	defer h.Unlock()
+
+	res := make(map[int]Element, len(h.elements))
+	for i, el := range h.elements {
+		res[i] = el
+	}
+	return res
+}
+
+// draw renders el on a key. The caller holds h.drawMu.
+func (h *Host) draw(btnIndex int, el Element) error {
+	img, err := RenderElement(el, h.surface.ButtonSize())
+	if err != nil {
+		return err
+	}
+	return h.surface.FillImage(btnIndex, img)
+}
+
+// notify schedules an update. It never blocks, so elements may call it from
+// anywhere, including their own Render or Change methods.
+func (h *Host) notify() {
+	select {
+	case h.update <- struct{}{}:
+	default:
+	}
+}
+
+func (h *Host) loop() {
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-h.update:
+			h.Update()
+		}
+	}
+}
+
+func (h *Host) event(btnIndex int, state BtnState) {
+	h.Lock()
+	el := h.elements[btnIndex]
+	cb := h.cb
+	h.Unlock()
+
+	if el == nil {
+		if cb != nil {
+			cb(btnIndex, state)
+		}
+		return
+	}
+
+	// The element is redrawn by the loop rather than here, where it could
+	// race with a redraw it triggered itself through notify.
+	el.Change(state)
+	h.notify()
+}
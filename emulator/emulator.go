@@ -0,0 +1,306 @@
+// Package emulator provides a virtual Stream Deck displayed in a web
+// browser. The Emulator is a streamdeck.Transport decoding the reports the
+// library sends, exactly like the mock package, and serves a page showing
+// the keys live, laid out like the real model. Clicking a key on the page
+// presses it.
+package emulator
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/base64"
+	"image/png"
+	"net"
+	"net/http"
+	"sync"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/internal/websocket"
+	"github.com/KarpelesLab/streamdeck/mock"
+)
+
+//go:embed index.html
+var indexHTML []byte
+
+// DefaultAddr is the address the command line tool serves the emulator on.
+const DefaultAddr = "127.0.0.1:9190"
+
+// Emulator is a virtual Stream Deck. It embeds a mock.Device, so keys can
+// also be pressed and images checked from Go.
+type Emulator struct {
+	*mock.Device
+
+	mu      sync.Mutex
+	clients map[*client]struct{}
+	srv     *http.Server
+}
+
+var _ sd.Transport = (*Emulator)(nil)
+
+// New creates an Emulator of the given model.
+func New(model *sd.StreamdeckDevice, serial string) *Emulator {
+	e := &Emulator{
+		Device:  mock.New(model, serial),
+		clients: make(map[*client]struct{}),
+	}
+	e.Device.OnDraw(func(btnIndex int) {
+		e.update(func(c *client) { c.keys[btnIndex] = true })
+	})
+	return e
+}
+
+// SetFeatureReport handles the reset and brightness reports, and updates
+// the pages.
+func (e *Emulator) SetFeatureReport(report int, data []byte) error {
+	if err := e.Device.SetFeatureReport(report, data); err != nil {
+		return err
+	}
+	// A reset blanks all keys.
+	e.update(func(c *client) {
+		c.brightness = true
+		for i := range c.keys {
+			c.keys[i] = true
+		}
+	})
+	return nil
+}
+
+// Close closes the device and the server, if any.
+func (e *Emulator) Close() error {
+	e.mu.Lock()
+	srv := e.srv
+	e.mu.Unlock()
+	if srv != nil {
+		srv.Close()
+	}
+	// WebSocket connections are hijacked, and not closed by the server.
+	e.mu.Lock()
+	for c := range e.clients {
+		c.conn.Close()
+	}
+	e.mu.Unlock()
+	return e.Device.Close()
+}
+
+// ListenAndServe serves the page on the TCP address addr, e.g.
+// DefaultAddr, until the Emulator is closed.
+func (e *Emulator) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return e.Serve(l)
+}
+
+// Serve serves the page on l until the Emulator is closed.
+func (e *Emulator) Serve(l net.Listener) error {
+	srv := &http.Server{Handler: e.Handler()}
+	e.mu.Lock()
+	e.srv = srv
+	e.mu.Unlock()
+
+	err := srv.Serve(l)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Handler returns the handler serving the page at "/" and its WebSocket
+// at "/ws".
+func (e *Emulator) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(indexHTML)
+	})
+	mux.HandleFunc("/ws", e.serveWS)
+	return mux
+}
+
+// update marks changes for all connected pages.
+func (e *Emulator) update(f func(c *client)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for c := range e.clients {
+		c.mu.Lock()
+		f(c)
+		c.mu.Unlock()
+		c.signal()
+	}
+}
+
+// client is a connected page. Changes are coalesced, so a slow page skips
+// intermediate images rather than falling behind.
+type client struct {
+	conn       *websocket.Conn
+	mu         sync.Mutex
+	keys       []bool // keys to send
+	brightness bool
+	notify     chan struct{}
+}
+
+func (c *client) signal() {
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Messages sent to the page.
+type layout struct {
+	Type    string   `json:"type"`
+	Name    string   `json:"name"`
+	Serial  string   `json:"serial"`
+	Width   int      `json:"width"`
+	Height  int      `json:"height"`
+	KeySize int      `json:"key_size"`
+	Keys    [][2]int `json:"keys"` // position of each key on the panel
+}
+
+type keyMessage struct {
+	Type  string `json:"type"`
+	Key   int    `json:"key"`
+	Image string `json:"image"` // data URL
+}
+
+type brightnessMessage struct {
+	Type  string `json:"type"`
+	Value uint8  `json:"value"`
+}
+
+// input is a message received from the page.
+type input struct {
+	Type string `json:"type"` // "press" or "release"
+	Key  int    `json:"key"`
+}
+
+func (e *Emulator) serveWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	m := e.Model()
+	l := &layout{
+		Type:    "layout",
+		Name:    m.Name,
+		Width:   m.PanelWidth(),
+		Height:  m.PanelHeight(),
+		KeySize: m.ButtonSize,
+	}
+	l.Serial, _, _ = sd.Identify(e.Device, m)
+	for i := 0; i < m.NumButtons; i++ {
+		p := m.KeyRect(i).Min
+		l.Keys = append(l.Keys, [2]int{p.X, p.Y})
+	}
+	if err := conn.WriteJSON(l); err != nil {
+		return
+	}
+
+	c := &client{
+		conn:       conn,
+		keys:       make([]bool, m.NumButtons),
+		brightness: true,
+		notify:     make(chan struct{}, 1),
+	}
+	for i := range c.keys {
+		c.keys[i] = true
+	}
+	c.signal()
+	e.mu.Lock()
+	e.clients[c] = struct{}{}
+	e.mu.Unlock()
+	defer func() {
+		e.mu.Lock()
+		delete(e.clients, c)
+		e.mu.Unlock()
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.readInput(conn)
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-c.notify:
+		}
+		if err := e.send(conn, c); err != nil {
+			return
+		}
+	}
+}
+
+// send sends the changes marked for a page.
+func (e *Emulator) send(conn *websocket.Conn, c *client) error {
+	c.mu.Lock()
+	var keys []int
+	for i, dirty := range c.keys {
+		if dirty {
+			keys = append(keys, i)
+			c.keys[i] = false
+		}
+	}
+	brightness := c.brightness
+	c.brightness = false
+	c.mu.Unlock()
+
+	if brightness {
+		if err := conn.WriteJSON(&brightnessMessage{Type: "brightness", Value: e.Brightness()}); err != nil {
+			return err
+		}
+	}
+	for _, i := range keys {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, e.Key(i)); err != nil {
+			return err
+		}
+		msg := &keyMessage{
+			Type:  "key",
+			Key:   i,
+			Image: "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()),
+		}
+		if err := conn.WriteJSON(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readInput turns the clicks of a page into key presses. Keys still held
+// when the page goes away are released.
+func (e *Emulator) readInput(conn *websocket.Conn) {
+	held := make(map[int]bool)
+	defer func() {
+		for i := range held {
+			e.Release(i)
+		}
+	}()
+
+	for {
+		var in input
+		if err := conn.ReadJSON(&in); err != nil {
+			return
+		}
+		switch in.Type {
+		case "press":
+			if e.Press(in.Key) == nil {
+				held[in.Key] = true
+			}
+		case "release":
+			if held[in.Key] {
+				e.Release(in.Key)
+				delete(held, in.Key)
+			}
+		}
+	}
+}
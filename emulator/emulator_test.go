@@ -0,0 +1,198 @@
+package emulator
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/internal/websocket"
+)
+
+func newEmulator(t *testing.T) (*Emulator, *sd.StreamDeck, *websocket.Conn) {
+	t.Helper()
+	e := New(sd.LookupDevice(0x0063), "EMUL0001")
+	dev, err := sd.Open(e, e.Model())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dev.Close() })
+
+	ts := httptest.NewServer(e.Handler())
+	t.Cleanup(ts.Close)
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return e, dev, conn
+}
+
+// read reads a message sent to the page, decodes it into the value v maps
+// its type to, if any, and returns its type.
+func read(t *testing.T, conn *websocket.Conn, v map[string]interface{}) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	if dst, ok := v[m.Type]; ok {
+		if err := json.Unmarshal(data, dst); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return m.Type
+}
+
+// keyImage decodes the image of a key message.
+func keyImage(t *testing.T, m *keyMessage) image.Image {
+	t.Helper()
+	const prefix = "data:image/png;base64,"
+	if !strings.HasPrefix(m.Image, prefix) {
+		t.Fatalf("key %d: image %.40q", m.Key, m.Image)
+	}
+	data, err := base64.StdEncoding.DecodeString(m.Image[len(prefix):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestPage(t *testing.T) {
+	e, dev, conn := newEmulator(t)
+	model := e.Model()
+
+	var (
+		l   layout
+		key keyMessage
+		br  brightnessMessage
+		v   = map[string]interface{}{"layout": &l, "key": &key, "brightness": &br}
+	)
+	if typ := read(t, conn, v); typ != "layout" {
+		t.Fatalf("got a %s message, want the layout", typ)
+	}
+	if l.Name != model.Name || l.Serial != "EMUL0001" ||
+		l.Width != model.PanelWidth() || l.Height != model.PanelHeight() || l.KeySize != model.ButtonSize {
+		t.Errorf("layout %+v", l)
+	}
+	if len(l.Keys) != dev.NumButtons() {
+		t.Fatalf("%d keys in the layout", len(l.Keys))
+	}
+	for i, p := range l.Keys {
+		if r := model.KeyRect(i); p != [2]int{r.Min.X, r.Min.Y} {
+			t.Errorf("key %d at %v, want %v", i, p, r.Min)
+		}
+	}
+
+	// The current state is sent first, then the changes.
+	if typ := read(t, conn, v); typ != "brightness" || br.Value != 100 {
+		t.Errorf("got %s %+v, want the brightness", typ, br)
+	}
+	if err := dev.FillColor(4, 255, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	red := color.RGBA{255, 0, 0, 255}
+	for {
+		if typ := read(t, conn, v); typ != "key" {
+			t.Fatalf("got a %s message, want keys", typ)
+		}
+		img := keyImage(t, &key)
+		if b := img.Bounds(); b.Dx() != model.ButtonSize || b.Dy() != model.ButtonSize {
+			t.Fatalf("key %d is %v", key.Key, b)
+		}
+		if key.Key == 4 && color.RGBAModel.Convert(img.At(10, 10)) == red {
+			break
+		}
+	}
+
+	if err := dev.SetBrightness(40); err != nil {
+		t.Fatal(err)
+	}
+	for read(t, conn, v) != "brightness" {
+	}
+	if br.Value != 40 {
+		t.Errorf("brightness %d, want 40", br.Value)
+	}
+}
+
+func TestInput(t *testing.T) {
+	_, dev, conn := newEmulator(t)
+	type event struct {
+		key   int
+		state sd.BtnState
+	}
+	events := make(chan event, 8)
+	dev.SetBtnEventCb(func(btnIndex int, state sd.BtnState) {
+		events <- event{btnIndex, state}
+	})
+	expect := func(want event) {
+		t.Helper()
+		select {
+		case got := <-events:
+			if got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %v", want)
+		}
+	}
+
+	for _, in := range []input{{"press", 2}, {"release", 2}, {"release", 5}, {"press", 7}, {"press", 3}} {
+		if err := conn.WriteJSON(&in); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect(event{2, sd.BtnPressed})
+	expect(event{2, sd.BtnReleased})
+	// Releasing a key which isn't held and pressing a key which doesn't
+	// exist do nothing.
+	expect(event{3, sd.BtnPressed})
+
+	// Keys held are released when the page goes away.
+	conn.Close()
+	expect(event{3, sd.BtnReleased})
+}
+
+func TestIndex(t *testing.T) {
+	ts := httptest.NewServer(New(sd.LookupDevice(0x0063), "EMUL0001").Handler())
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || !strings.HasPrefix(res.Header.Get("Content-Type"), "text/html") || !bytes.Equal(body, indexHTML) {
+		t.Errorf("index: status %d, %s", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+
+	res, err = http.Get(ts.URL + "/nope")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("status %d for an unknown page", res.StatusCode)
+	}
+}
@@ -6,5 +6,6 @@ require (
 	github.com/KarpelesLab/hid v0.1.0
 	github.com/disintegration/gift v1.2.1
 	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0
-	golang.org/x/image v0.0.0-20200618115811-c13761719519 // indirect
+	golang.org/x/image v0.0.0-20200618115811-c13761719519
+	gopkg.in/yaml.v3 v3.0.1
 )
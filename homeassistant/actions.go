@@ -0,0 +1,36 @@
+package homeassistant
+
+import (
+	"context"
+
+	"github.com/KarpelesLab/streamdeck/action"
+)
+
+// Call is an action calling a service of Home Assistant, e.g.
+// {Domain: "light", Service: "turn_on", Data: {"entity_id": "light.desk"}}.
+type Call struct {
+	Client  *Client
+	Domain  string
+	Service string
+	Data    map[string]interface{} // service data, may be nil
+}
+
+// Run calls the service.
+func (c *Call) Run(ctx context.Context, env *action.Env) error {
+	return c.Client.CallService(ctx, c.Domain, c.Service, c.Data)
+}
+
+// Toggle returns an action toggling an entity.
+func Toggle(c *Client, entityID string) *Call {
+	return &Call{Client: c, Domain: "homeassistant", Service: "toggle", Data: map[string]interface{}{"entity_id": entityID}}
+}
+
+// TurnOn returns an action turning an entity on.
+func TurnOn(c *Client, entityID string) *Call {
+	return &Call{Client: c, Domain: "homeassistant", Service: "turn_on", Data: map[string]interface{}{"entity_id": entityID}}
+}
+
+// TurnOff returns an action turning an entity off.
+func TurnOff(c *Client, entityID string) *Call {
+	return &Call{Client: c, Domain: "homeassistant", Service: "turn_off", Data: map[string]interface{}{"entity_id": entityID}}
+}
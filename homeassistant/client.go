@@ -0,0 +1,419 @@
+// Package homeassistant controls Home Assistant through its WebSocket API:
+// it provides a client calling services and following entity states, and
+// an element binding a key to an entity, which toggles it when pressed and
+// shows its live state.
+package homeassistant
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KarpelesLab/streamdeck/internal/websocket"
+)
+
+// ErrClosed is returned by commands once the connection is closed.
+var ErrClosed = errors.New("homeassistant: connection closed")
+
+// Error is returned when Home Assistant fails a command.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("homeassistant: %s (%s)", e.Message, e.Code)
+}
+
+// Event is an event fired on the bus of Home Assistant.
+type Event struct {
+	Type      string          `json:"event_type"`
+	Data      json.RawMessage `json:"data"`
+	Origin    string          `json:"origin"`
+	TimeFired time.Time       `json:"time_fired"`
+}
+
+// State is the state of an entity.
+type State struct {
+	EntityID    string                 `json:"entity_id"`
+	State       string                 `json:"state"`
+	Attributes  map[string]interface{} `json:"attributes"`
+	LastChanged time.Time              `json:"last_changed"`
+	LastUpdated time.Time              `json:"last_updated"`
+}
+
+// Domain returns the domain of the entity, e.g. "light".
+func (s *State) Domain() string {
+	return Domain(s.EntityID)
+}
+
+// Name returns the friendly name of the entity, or its ID.
+func (s *State) Name() string {
+	if name, ok := s.Attributes["friendly_name"].(string); ok && name != "" {
+		return name
+	}
+	return s.EntityID
+}
+
+// Domain returns the domain of an entity ID, e.g. "light" for
+// "light.kitchen".
+func Domain(entityID string) string {
+	if i := strings.IndexByte(entityID, '.'); i >= 0 {
+		return entityID[:i]
+	}
+	return entityID
+}
+
+// Client is a connection to Home Assistant.
+type Client struct {
+	conn    *websocket.Conn
+	token   string
+	timeout time.Duration
+
+	mu       sync.Mutex
+	nextID   int
+	pending  map[int]chan *result
+	handlers map[int]func(*Event)
+	watchers map[string][]*watcher
+	watching bool
+	done     chan struct{}
+	err      error
+}
+
+type message struct {
+	ID      int             `json:"id"`
+	Type    string          `json:"type"`
+	Success bool            `json:"success"`
+	Result  json.RawMessage `json:"result"`
+	Error   *Error          `json:"error"`
+	Event   *Event          `json:"event"`
+	Message string          `json:"message"`
+}
+
+type result struct {
+	data json.RawMessage
+	err  *Error
+}
+
+// Timeout sets how long commands wait for their result, 10 seconds by
+// default.
+func Timeout(d time.Duration) func(*Client) {
+	return func(c *Client) {
+		c.timeout = d
+	}
+}
+
+// Dial connects to the WebSocket API of Home Assistant, e.g.
+// "ws://homeassistant.local:8123/api/websocket", and authenticates with a
+// long-lived access token.
+func Dial(url, token string, options ...func(*Client)) (*Client, error) {
+	c := &Client{
+		token:    token,
+		timeout:  10 * time.Second,
+		pending:  make(map[int]chan *result),
+		handlers: make(map[int]func(*Event)),
+		watchers: make(map[string][]*watcher),
+		done:     make(chan struct{}),
+	}
+	for _, option := range options {
+		option(c)
+	}
+
+	conn, err := websocket.Dial(url, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	if err := c.auth(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go c.read()
+	return c, nil
+}
+
+// auth runs the authentication phase.
+func (c *Client) auth() error {
+	c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+	defer c.conn.SetReadDeadline(time.Time{})
+
+	var m message
+	if err := c.conn.ReadJSON(&m); err != nil {
+		return err
+	}
+	if m.Type != "auth_required" {
+		return fmt.Errorf("homeassistant: expected auth_required, got %q", m.Type)
+	}
+	if err := c.conn.WriteJSON(map[string]string{"type": "auth", "access_token": c.token}); err != nil {
+		return err
+	}
+	if err := c.conn.ReadJSON(&m); err != nil {
+		return err
+	}
+	switch m.Type {
+	case "auth_ok":
+		return nil
+	case "auth_invalid":
+		return fmt.Errorf("homeassistant: authentication failed: %s", m.Message)
+	}
+	return fmt.Errorf("homeassistant: expected auth_ok, got %q", m.Type)
+}
+
+// read dispatches the messages of Home Assistant until the connection is
+// closed.
+func (c *Client) read() {
+	var err error
+	defer func() {
+		c.mu.Lock()
+		c.err = err
+		for id, ch := range c.pending {
+			close(ch)
+			delete(c.pending, id)
+		}
+		c.mu.Unlock()
+		close(c.done)
+	}()
+
+	for {
+		var m message
+		if err = c.conn.ReadJSON(&m); err != nil {
+			return
+		}
+
+		switch m.Type {
+		case "event":
+			c.mu.Lock()
+			handler := c.handlers[m.ID]
+			c.mu.Unlock()
+			if handler != nil && m.Event != nil {
+				handler(m.Event)
+			}
+		case "result":
+			c.mu.Lock()
+			ch, ok := c.pending[m.ID]
+			delete(c.pending, m.ID)
+			c.mu.Unlock()
+			if !ok {
+				continue
+			}
+			r := &result{data: m.Result}
+			if !m.Success {
+				r.err = m.Error
+				if r.err == nil {
+					r.err = &Error{Code: "unknown_error", Message: "command failed"}
+				}
+			}
+			ch <- r
+		}
+	}
+}
+
+// Command sends a command of the given type, with the fields of params
+// (may be nil), and decodes its result into res (may be nil).
+func (c *Client) Command(ctx context.Context, commandType string, params map[string]interface{}, res interface{}) error {
+	_, err := c.command(ctx, commandType, params, res, nil)
+	return err
+}
+
+// command sends a command. If handler isn't nil, it receives the events
+// sent with the ID of the command, which is returned.
+func (c *Client) command(ctx context.Context, commandType string, params map[string]interface{}, res interface{}, handler func(*Event)) (int, error) {
+	c.mu.Lock()
+	select {
+	case <-c.done:
+		c.mu.Unlock()
+		return 0, ErrClosed
+	default:
+	}
+	c.nextID++
+	id := c.nextID
+	ch := make(chan *result, 1)
+	c.pending[id] = ch
+	if handler != nil {
+		c.handlers[id] = handler
+	}
+
+	m := map[string]interface{}{}
+	for k, v := range params {
+		m[k] = v
+	}
+	m["id"] = id
+	m["type"] = commandType
+	// Writes are serialized by the lock, which also keeps the IDs in
+	// increasing order as Home Assistant requires.
+	err := c.conn.WriteJSON(m)
+	c.mu.Unlock()
+	if err != nil {
+		c.forget(id)
+		return 0, err
+	}
+
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+	var r *result
+	select {
+	case r = <-ch:
+	case <-ctx.Done():
+		c.forget(id)
+		return 0, ctx.Err()
+	case <-timer.C:
+		c.forget(id)
+		return 0, fmt.Errorf("homeassistant: %s timed out", commandType)
+	}
+	if r == nil {
+		return 0, ErrClosed
+	}
+	if r.err != nil {
+		c.forget(id)
+		return 0, r.err
+	}
+	if res != nil && len(r.data) > 0 && string(r.data) != "null" {
+		return id, json.Unmarshal(r.data, res)
+	}
+	return id, nil
+}
+
+func (c *Client) forget(id int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, id)
+	delete(c.handlers, id)
+}
+
+// SubscribeEvents calls handler with the events of the given type, or all
+// events if eventType is empty, from the goroutine reading the connection.
+// It returns the ID of the subscription, for Unsubscribe.
+func (c *Client) SubscribeEvents(ctx context.Context, eventType string, handler func(*Event)) (int, error) {
+	var params map[string]interface{}
+	if eventType != "" {
+		params = map[string]interface{}{"event_type": eventType}
+	}
+	return c.command(ctx, "subscribe_events", params, nil, handler)
+}
+
+// Unsubscribe cancels a subscription.
+func (c *Client) Unsubscribe(ctx context.Context, id int) error {
+	c.mu.Lock()
+	delete(c.handlers, id)
+	c.mu.Unlock()
+	return c.Command(ctx, "unsubscribe_events", map[string]interface{}{"subscription": id}, nil)
+}
+
+// CallService calls a service, e.g. "light", "turn_on", with data as its
+// service data (may be nil). Entities are usually given as "entity_id" in
+// data.
+func (c *Client) CallService(ctx context.Context, domain, service string, data map[string]interface{}) error {
+	params := map[string]interface{}{"domain": domain, "service": service}
+	if data != nil {
+		params["service_data"] = data
+	}
+	return c.Command(ctx, "call_service", params, nil)
+}
+
+// GetStates returns the states of all entities.
+func (c *Client) GetStates(ctx context.Context) ([]*State, error) {
+	var states []*State
+	err := c.Command(ctx, "get_states", nil, &states)
+	return states, err
+}
+
+// GetState returns the state of an entity.
+func (c *Client) GetState(ctx context.Context, entityID string) (*State, error) {
+	states, err := c.GetStates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range states {
+		if s.EntityID == entityID {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("homeassistant: unknown entity %s", entityID)
+}
+
+// watcher is a function following the state of an entity.
+type watcher struct {
+	f func(*State)
+}
+
+// Watch calls f with the current state of an entity, then whenever it
+// changes. f is called with nil when the entity is removed. The returned
+// function stops watching.
+func (c *Client) Watch(ctx context.Context, entityID string, f func(*State)) (func(), error) {
+	w := &watcher{f: f}
+	c.mu.Lock()
+	subscribe := !c.watching
+	c.watching = true
+	c.watchers[entityID] = append(c.watchers[entityID], w)
+	c.mu.Unlock()
+
+	stop := func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		ws := c.watchers[entityID]
+		for i := range ws {
+			if ws[i] == w {
+				c.watchers[entityID] = append(ws[:i:i], ws[i+1:]...)
+				break
+			}
+		}
+	}
+
+	// All watchers share a single subscription to state changes.
+	if subscribe {
+		if _, err := c.SubscribeEvents(ctx, "state_changed", c.stateChanged); err != nil {
+			c.mu.Lock()
+			c.watching = false
+			c.mu.Unlock()
+			stop()
+			return nil, err
+		}
+	}
+	s, err := c.GetState(ctx, entityID)
+	if err != nil {
+		stop()
+		return nil, err
+	}
+	f(s)
+	return stop, nil
+}
+
+func (c *Client) stateChanged(ev *Event) {
+	var data struct {
+		EntityID string `json:"entity_id"`
+		NewState *State `json:"new_state"`
+	}
+	if json.Unmarshal(ev.Data, &data) != nil {
+		return
+	}
+	c.mu.Lock()
+	ws := c.watchers[data.EntityID]
+	c.mu.Unlock()
+	for _, w := range ws {
+		w.f(data.NewState)
+	}
+}
+
+// Done returns a channel closed when the connection is lost or closed.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the connection was lost, once Done is closed.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	err := c.conn.Close()
+	<-c.done
+	return err
+}
@@ -0,0 +1,521 @@
+package homeassistant
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/internal/testutil"
+	"github.com/KarpelesLab/streamdeck/internal/websocket"
+)
+
+const testToken = "secret"
+
+// call is a service call received by the fake server.
+type call struct {
+	Domain  string                 `json:"domain"`
+	Service string                 `json:"service"`
+	Data    map[string]interface{} `json:"service_data"`
+}
+
+// fakeHA is a WebSocket server speaking the API of Home Assistant. Calls
+// to homeassistant.toggle toggle the entity, calls to a "fail" service
+// fail.
+type fakeHA struct {
+	*httptest.Server
+	t *testing.T
+
+	mu            sync.Mutex
+	conn          *websocket.Conn
+	states        map[string]*State
+	subscriptions map[int]string // event type by ID
+	calls         chan call
+}
+
+func newFakeHA(t *testing.T) *fakeHA {
+	t.Helper()
+	f := &fakeHA{
+		t:             t,
+		states:        make(map[string]*State),
+		subscriptions: make(map[int]string),
+		calls:         make(chan call, 16),
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeHA) url() string {
+	return "ws" + strings.TrimPrefix(f.URL, "http") + "/api/websocket"
+}
+
+func (f *fakeHA) serve(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	conn.WriteJSON(map[string]string{"type": "auth_required", "ha_version": "2023.1.0"})
+	var auth struct {
+		Type  string `json:"type"`
+		Token string `json:"access_token"`
+	}
+	if conn.ReadJSON(&auth) != nil || auth.Type != "auth" {
+		return
+	}
+	if auth.Token != testToken {
+		conn.WriteJSON(map[string]string{"type": "auth_invalid", "message": "Invalid access token"})
+		return
+	}
+	f.mu.Lock()
+	f.conn = conn
+	f.mu.Unlock()
+	conn.WriteJSON(map[string]string{"type": "auth_ok"})
+
+	lastID := 0
+	for {
+		var cmd struct {
+			ID           int    `json:"id"`
+			Type         string `json:"type"`
+			EventType    string `json:"event_type"`
+			Subscription int    `json:"subscription"`
+			call
+		}
+		if conn.ReadJSON(&cmd) != nil {
+			return
+		}
+		// Home Assistant closes connections sending IDs out of order.
+		if cmd.ID <= lastID {
+			f.t.Errorf("command ID %d after %d", cmd.ID, lastID)
+			return
+		}
+		lastID = cmd.ID
+
+		res := map[string]interface{}{"id": cmd.ID, "type": "result", "success": true, "result": nil}
+		f.mu.Lock()
+		switch cmd.Type {
+		case "subscribe_events":
+			f.subscriptions[cmd.ID] = cmd.EventType
+		case "unsubscribe_events":
+			if _, ok := f.subscriptions[cmd.Subscription]; !ok {
+				res["success"] = false
+				res["error"] = map[string]string{"code": "not_found", "message": "Subscription not found."}
+			}
+			delete(f.subscriptions, cmd.Subscription)
+		case "get_states":
+			states := make([]*State, 0, len(f.states))
+			for _, s := range f.states {
+				states = append(states, s)
+			}
+			res["result"] = states
+		case "call_service":
+			if cmd.Service == "fail" {
+				res["success"] = false
+				res["error"] = map[string]string{"code": "not_found", "message": "Service not found."}
+			}
+		default:
+			res["success"] = false
+			res["error"] = map[string]string{"code": "unknown_command", "message": "Unknown command."}
+		}
+		f.mu.Unlock()
+		conn.WriteJSON(res)
+
+		if cmd.Type == "call_service" {
+			f.calls <- cmd.call
+			if cmd.Domain == "homeassistant" && cmd.Service == "toggle" {
+				id, _ := cmd.Data["entity_id"].(string)
+				f.mu.Lock()
+				s := *f.states[id]
+				f.mu.Unlock()
+				if s.State == "on" {
+					s.State = "off"
+				} else {
+					s.State = "on"
+				}
+				f.setState(&s)
+			}
+		}
+	}
+}
+
+// setState changes the state of an entity, or removes it if s.State is
+// empty, and sends state_changed events to the subscribers.
+func (f *fakeHA) setState(s *State) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var newState *State
+	if s.State == "" {
+		delete(f.states, s.EntityID)
+	} else {
+		f.states[s.EntityID] = s
+		newState = s
+	}
+	if f.conn == nil {
+		return
+	}
+	for id, eventType := range f.subscriptions {
+		if eventType != "" && eventType != "state_changed" {
+			continue
+		}
+		f.conn.WriteJSON(map[string]interface{}{
+			"id":   id,
+			"type": "event",
+			"event": map[string]interface{}{
+				"event_type": "state_changed",
+				"data":       map[string]interface{}{"entity_id": s.EntityID, "new_state": newState},
+			},
+		})
+	}
+}
+
+func (f *fakeHA) subscriptionCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.subscriptions)
+}
+
+func (f *fakeHA) expectCall(t *testing.T) call {
+	t.Helper()
+	select {
+	case c := <-f.calls:
+		return c
+	case <-time.After(5 * time.Second):
+		t.Fatal("no service called")
+		return call{}
+	}
+}
+
+func dial(t *testing.T, f *fakeHA) *Client {
+	t.Helper()
+	c, err := Dial(f.url(), testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestDial(t *testing.T) {
+	f := newFakeHA(t)
+	if _, err := Dial(f.url(), "wrong"); err == nil || !strings.Contains(err.Error(), "Invalid access token") {
+		t.Errorf("Dial with a wrong token = %v", err)
+	}
+
+	c := dial(t, f)
+	c.Close()
+	<-c.Done()
+	if err := c.Command(context.Background(), "ping", nil, nil); err != ErrClosed {
+		t.Errorf("Command after Close = %v", err)
+	}
+}
+
+func TestCallService(t *testing.T) {
+	f := newFakeHA(t)
+	c := dial(t, f)
+	ctx := context.Background()
+
+	if err := c.CallService(ctx, "light", "turn_on", map[string]interface{}{"entity_id": "light.desk", "brightness": 10}); err != nil {
+		t.Fatal(err)
+	}
+	got := f.expectCall(t)
+	if got.Domain != "light" || got.Service != "turn_on" || got.Data["entity_id"] != "light.desk" || got.Data["brightness"] != 10.0 {
+		t.Errorf("call %+v", got)
+	}
+
+	// The actions call services as well.
+	if err := TurnOff(c, "switch.fan").Run(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.expectCall(t); got.Domain != "homeassistant" || got.Service != "turn_off" || got.Data["entity_id"] != "switch.fan" {
+		t.Errorf("call %+v", got)
+	}
+
+	var haErr *Error
+	err := c.CallService(ctx, "light", "fail", nil)
+	if !errors.As(err, &haErr) || haErr.Code != "not_found" || haErr.Message != "Service not found." {
+		t.Errorf("failed call = %v", err)
+	}
+	f.expectCall(t)
+
+	// Concurrent commands are sent in order of their IDs, and each gets
+	// its own result.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			service := "turn_on"
+			if i%2 == 1 {
+				service = "fail"
+			}
+			err := c.CallService(ctx, "light", service, nil)
+			if (err != nil) != (i%2 == 1) {
+				t.Errorf("call %d: %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestWatch(t *testing.T) {
+	f := newFakeHA(t)
+	f.setState(&State{EntityID: "sensor.temp", State: "20.5", Attributes: map[string]interface{}{"unit_of_measurement": "°C"}})
+	f.setState(&State{EntityID: "light.desk", State: "off"})
+	c := dial(t, f)
+	ctx := context.Background()
+
+	s, err := c.GetState(ctx, "sensor.temp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.State != "20.5" || s.Attributes["unit_of_measurement"] != "°C" || s.Domain() != "sensor" || s.Name() != "sensor.temp" {
+		t.Errorf("state %+v", s)
+	}
+	if _, err := c.GetState(ctx, "sensor.nope"); err == nil {
+		t.Error("unknown entity found")
+	}
+
+	temps := make(chan *State, 8)
+	lights := make(chan *State, 8)
+	stopTemp, err := c.Watch(ctx, "sensor.temp", func(s *State) { temps <- s })
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopLight, err := c.Watch(ctx, "light.desk", func(s *State) { lights <- s })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stopLight()
+	expectState(t, temps, "20.5")
+	expectState(t, lights, "off")
+
+	// All watchers share a single subscription.
+	if n := f.subscriptionCount(); n != 1 {
+		t.Errorf("%d subscriptions", n)
+	}
+
+	f.setState(&State{EntityID: "sensor.temp", State: "21"})
+	expectState(t, temps, "21")
+	f.setState(&State{EntityID: "light.desk", State: "on"})
+	expectState(t, lights, "on")
+	f.setState(&State{EntityID: "sensor.temp"})
+	expectState(t, temps, "")
+
+	stopTemp()
+	f.setState(&State{EntityID: "sensor.temp", State: "22"})
+	f.setState(&State{EntityID: "light.desk", State: "off"})
+	expectState(t, lights, "off")
+	select {
+	case s := <-temps:
+		t.Errorf("state %v after stop", s)
+	default:
+	}
+}
+
+// expectState waits for a state, "" for a removed entity.
+func expectState(t *testing.T, ch chan *State, want string) {
+	t.Helper()
+	select {
+	case s := <-ch:
+		got := ""
+		if s != nil {
+			got = s.State
+		}
+		if got != want {
+			t.Errorf("state %q, want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no state %q", want)
+	}
+}
+
+func TestSubscribeEvents(t *testing.T) {
+	f := newFakeHA(t)
+	f.setState(&State{EntityID: "light.desk", State: "off"})
+	c := dial(t, f)
+	ctx := context.Background()
+
+	events := make(chan *Event, 8)
+	id, err := c.SubscribeEvents(ctx, "", func(ev *Event) { events <- ev })
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.setState(&State{EntityID: "light.desk", State: "on"})
+	select {
+	case ev := <-events:
+		var data struct {
+			EntityID string `json:"entity_id"`
+		}
+		json.Unmarshal(ev.Data, &data)
+		if ev.Type != "state_changed" || data.EntityID != "light.desk" {
+			t.Errorf("event %s %s", ev.Type, ev.Data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+
+	if err := c.Unsubscribe(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.subscriptionCount(); n != 0 {
+		t.Errorf("%d subscriptions after Unsubscribe", n)
+	}
+	if err := c.Unsubscribe(ctx, id); err == nil {
+		t.Error("unsubscribed twice")
+	}
+}
+
+func TestEntity(t *testing.T) {
+	f := newFakeHA(t)
+	f.setState(&State{EntityID: "light.desk", State: "on", Attributes: map[string]interface{}{
+		"friendly_name": "Desk",
+		"rgb_color":     []int{0, 0, 255},
+	}})
+	c := dial(t, f)
+
+	errs := make(chan error, 8)
+	e, err := NewEntity(c, "light.desk", OnError(func(err error) { errs <- err }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	// The light is shown in its color.
+	waitColor(t, e, color.RGBA{0, 0, 255, 255})
+	if s := e.State(); s.Name() != "Desk" {
+		t.Errorf("name %q", s.Name())
+	}
+
+	// Pressing the key toggles the light.
+	e.Change(sd.BtnPressed)
+	if got := f.expectCall(t); got.Domain != "homeassistant" || got.Service != "toggle" || got.Data["entity_id"] != "light.desk" {
+		t.Errorf("call %+v", got)
+	}
+	waitColor(t, e, color.RGBA{0, 0, 0, 255})
+
+	// Once removed, the entity is unavailable.
+	f.setState(&State{EntityID: "light.desk"})
+	waitColor(t, e, color.RGBA{0x60, 0x10, 0x10, 0xff})
+
+	e.Close()
+	f.setState(&State{EntityID: "light.desk", State: "on"})
+	time.Sleep(50 * time.Millisecond)
+	if s := e.State(); s.State != "unavailable" {
+		t.Errorf("state %q after Close", s.State)
+	}
+	select {
+	case err := <-errs:
+		t.Errorf("error %v", err)
+	default:
+	}
+}
+
+func TestEntityTemplates(t *testing.T) {
+	f := newFakeHA(t)
+	f.setState(&State{EntityID: "sensor.temp", State: "20", Attributes: map[string]interface{}{"max": 30}})
+	c := dial(t, f)
+
+	e, err := NewEntity(c, "sensor.temp",
+		Text("{{round 1 .State}}"),
+		Color(`{{if gt (len .State) 3}}#ff0000{{else}}#00ff00{{end}}`),
+		Service("script", "fail", nil),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	waitColor(t, e, color.RGBA{0, 255, 0, 255})
+	f.setState(&State{EntityID: "sensor.temp", State: "20.25"})
+	waitColor(t, e, color.RGBA{255, 0, 0, 255})
+
+	if _, err := NewEntity(c, "sensor.temp", Text("{{")); err == nil {
+		t.Error("invalid template accepted")
+	}
+
+	// Errors of templates and services are reported.
+	errs := make(chan error, 8)
+	e2, err := NewEntity(c, "sensor.temp", Color("{{round 1 .Attributes.nope}}"), Service("script", "fail", nil),
+		OnError(func(err error) { errs <- err }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e2.Close()
+	testutil.ExpectError(t, errs, "not a number")
+	e2.Change(sd.BtnPressed)
+	f.expectCall(t)
+	testutil.ExpectError(t, errs, "Service not found")
+}
+
+// waitColor waits for the background of the entity to have the color c.
+func waitColor(t *testing.T, e *Entity, c color.RGBA) {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 72, 72))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if err := e.Render(img); err != nil {
+			t.Fatal(err)
+		}
+		got := img.RGBAAt(2, 2)
+		if got == c {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("background %v, want %v", got, c)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	tests := []struct {
+		got  func() (string, error)
+		want string
+	}{
+		{func() (string, error) { return rgbFunc([]interface{}{255.0, 128.0, 0.0}) }, "#ff8000"},
+		{func() (string, error) { return rgbFunc([]interface{}{255.0, 128.0}) }, ""},
+		{func() (string, error) { return rgbFunc("red") }, ""},
+		{func() (string, error) { return roundFunc(1, "20.25") }, "20.2"},
+		{func() (string, error) { return roundFunc(0, 3.6) }, "4"},
+		{func() (string, error) { return roundFunc(2, 3) }, "3.00"},
+		{func() (string, error) { return roundFunc(1, "warm") }, ""},
+		{func() (string, error) { return percentFunc(255.0) }, "100%"},
+		{func() (string, error) { return percentFunc(128.0) }, "50%"},
+		{func() (string, error) { return percentFunc(nil) }, "0%"},
+		{func() (string, error) { return percentFunc(true) }, ""},
+	}
+	for i, test := range tests {
+		got, err := test.got()
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("%d: got %q, want an error", i, got)
+			}
+		} else if got != test.want || err != nil {
+			t.Errorf("%d: got %q, %v, want %q", i, got, err, test.want)
+		}
+	}
+}
+
+func TestDefaultService(t *testing.T) {
+	for _, test := range []struct{ entity, want string }{
+		{"light.desk", "homeassistant.toggle"},
+		{"scene.movie", "scene.turn_on"},
+		{"input_button.bell", "input_button.press"},
+		{"sensor.temp", "."},
+	} {
+		domain, service := defaultService(Domain(test.entity))
+		if got := fmt.Sprintf("%s.%s", domain, service); got != test.want {
+			t.Errorf("%s: %s, want %s", test.entity, got, test.want)
+		}
+	}
+}
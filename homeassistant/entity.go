@@ -0,0 +1,387 @@
+package homeassistant
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/profile"
+	"github.com/KarpelesLab/streamdeck/tile"
+)
+
+// Colors of entities, when no Color template is set.
+var (
+	// OnColor fills the keys of entities which are on, unless they have a
+	// color of their own, like most lights.
+	OnColor color.Color = color.NRGBA{0xff, 0xb0, 0x00, 0xff}
+	// UnavailableColor fills the keys of entities which are unavailable.
+	UnavailableColor color.Color = color.NRGBA{0x60, 0x10, 0x10, 0xff}
+)
+
+// DefaultText is the text template of entities by default: their name and
+// state, with its unit if any.
+const DefaultText = `{{.Name}}
+{{.State}}{{with .Attributes.unit_of_measurement}} {{.}}{{end}}`
+
+// Entity is an Element showing the live state of a Home Assistant entity,
+// and calling a service when pressed: by default, entities which can be
+// toggled (lights, switches, ...) are toggled, scenes and scripts are
+// turned on and buttons are pressed.
+//
+// Its appearance is set by text/template templates executed with the
+// *State of the entity, e.g. "{{.Attributes.temperature}}°". Besides the
+// standard functions, templates can use:
+//
+//	rgb     formats an [r, g, b] attribute as "#rrggbb", e.g. {{rgb .Attributes.rgb_color}}
+//	round   formats a number with the given decimals, e.g. {{round 1 .State}}
+//	percent converts a 0 to 255 attribute to a percentage, e.g. {{percent .Attributes.brightness}}
+type Entity struct {
+	sd.Invalidator
+	client   *Client
+	entityID string
+	texts    [4]string // text, color, text color and image templates
+	domain   string
+	service  string
+	data     map[string]interface{}
+	onError  func(error)
+
+	tmpls [4]*template.Template
+	tile  *tile.Tile
+
+	mu     sync.Mutex
+	state  *State
+	image  string
+	stop   func()
+	closed bool
+}
+
+var _ sd.Element = (*Entity)(nil)
+
+// Indexes of the templates of an Entity.
+const (
+	textTemplate = iota
+	colorTemplate
+	textColorTemplate
+	imageTemplate
+)
+
+// Text sets the template of the text of the key, DefaultText by default.
+func Text(tmpl string) func(*Entity) {
+	return func(e *Entity) {
+		e.texts[textTemplate] = tmpl
+	}
+}
+
+// Color sets the template of the background color, which must give a
+// color name or "#rrggbb". An empty result keeps the default color.
+func Color(tmpl string) func(*Entity) {
+	return func(e *Entity) {
+		e.texts[colorTemplate] = tmpl
+	}
+}
+
+// TextColor sets the template of the text color. By default, the text is
+// white, or black on light backgrounds.
+func TextColor(tmpl string) func(*Entity) {
+	return func(e *Entity) {
+		e.texts[textColorTemplate] = tmpl
+	}
+}
+
+// Image sets the template of the path of an image shown on the key, e.g.
+// "icons/{{.State}}.png". An empty result shows no image.
+func Image(tmpl string) func(*Entity) {
+	return func(e *Entity) {
+		e.texts[imageTemplate] = tmpl
+	}
+}
+
+// Service sets the service called when the key is pressed, with data as
+// its service data (may be nil). The entity_id of the entity is added to
+// data. An empty service disables the key.
+func Service(domain, service string, data map[string]interface{}) func(*Entity) {
+	return func(e *Entity) {
+		e.domain = domain
+		e.service = service
+		e.data = data
+	}
+}
+
+// OnError sets a function called when following the entity or calling the
+// service fails.
+func OnError(f func(error)) func(*Entity) {
+	return func(e *Entity) {
+		e.onError = f
+	}
+}
+
+var funcs = template.FuncMap{
+	"rgb":     rgbFunc,
+	"round":   roundFunc,
+	"percent": percentFunc,
+}
+
+// NewEntity creates an Entity for entityID, e.g. "light.kitchen", which
+// follows its state until closed.
+func NewEntity(c *Client, entityID string, options ...func(*Entity)) (*Entity, error) {
+	e := &Entity{
+		client:   c,
+		entityID: entityID,
+		tile:     tile.New(),
+	}
+	e.texts[textTemplate] = DefaultText
+	e.domain, e.service = defaultService(Domain(entityID))
+	for _, option := range options {
+		option(e)
+	}
+
+	for i, text := range e.texts {
+		if text == "" {
+			continue
+		}
+		t, err := template.New(entityID).Funcs(funcs).Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		e.tmpls[i] = t
+	}
+
+	e.update(&State{EntityID: entityID, State: "unavailable"})
+	go e.watch()
+	return e, nil
+}
+
+// defaultService returns the service called by default on the entities of
+// a domain.
+func defaultService(domain string) (string, string) {
+	switch domain {
+	case "light", "switch", "fan", "input_boolean", "automation", "cover",
+		"media_player", "siren", "humidifier", "group":
+		return "homeassistant", "toggle"
+	case "scene", "script":
+		return domain, "turn_on"
+	case "button", "input_button":
+		return domain, "press"
+	}
+	return "", ""
+}
+
+func (e *Entity) watch() {
+	stop, err := e.client.Watch(context.Background(), e.entityID, e.update)
+	if err != nil {
+		e.error(err)
+		return
+	}
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		stop()
+		return
+	}
+	e.stop = stop
+	e.mu.Unlock()
+}
+
+// State returns the last known state of the entity.
+func (e *Entity) State() *State {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.state
+}
+
+// update applies a new state to the tile.
+func (e *Entity) update(s *State) {
+	if s == nil {
+		s = &State{EntityID: e.entityID, State: "unavailable"}
+	}
+
+	bg := defaultColor(s)
+	if c, ok := e.color(colorTemplate, s); ok {
+		bg = c
+	}
+	fg := color.Color(color.White)
+	if isLight(bg) {
+		fg = color.Black
+	}
+	if c, ok := e.color(textColorTemplate, s); ok {
+		fg = c
+	}
+	text, _ := e.execute(textTemplate, s)
+	path, _ := e.execute(imageTemplate, s)
+	path = strings.TrimSpace(path)
+
+	e.mu.Lock()
+	e.state = s
+	imageChanged := path != e.image
+	e.image = path
+	e.mu.Unlock()
+
+	e.tile.SetBgColor(bg)
+	e.tile.SetTextColor(fg)
+	e.tile.SetText(text)
+	if imageChanged {
+		if path == "" {
+			e.tile.SetImage(nil)
+		} else if err := e.tile.SetImageFile(path); err != nil {
+			e.tile.SetImage(nil)
+			e.error(err)
+		}
+	}
+	e.Invalidate()
+}
+
+// execute runs a template, reporting errors.
+func (e *Entity) execute(i int, s *State) (string, bool) {
+	t := e.tmpls[i]
+	if t == nil {
+		return "", false
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, s); err != nil {
+		e.error(err)
+		return "", false
+	}
+	return buf.String(), true
+}
+
+// color runs a color template.
+func (e *Entity) color(i int, s *State) (color.Color, bool) {
+	text, ok := e.execute(i, s)
+	text = strings.TrimSpace(text)
+	if !ok || text == "" {
+		return nil, false
+	}
+	c, err := profile.ParseColor(text)
+	if err != nil {
+		e.error(fmt.Errorf("%s: %w", e.entityID, err))
+		return nil, false
+	}
+	return c, true
+}
+
+// defaultColor returns the background color of an entity without Color
+// template.
+func defaultColor(s *State) color.Color {
+	switch s.State {
+	case "on", "open", "playing", "home", "unlocked", "heat", "cool":
+		if c, ok := rgbAttribute(s.Attributes["rgb_color"]); ok {
+			return c
+		}
+		return OnColor
+	case "unavailable":
+		return UnavailableColor
+	}
+	return color.Black
+}
+
+// isLight reports whether c is light enough to need dark text.
+func isLight(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return 299*r+587*g+114*b > 1000*0xffff*6/10
+}
+
+// rgbAttribute converts an [r, g, b] attribute to a color.
+func rgbAttribute(v interface{}) (color.Color, bool) {
+	l, ok := v.([]interface{})
+	if !ok || len(l) != 3 {
+		return nil, false
+	}
+	var c [3]uint8
+	for i := range c {
+		f, ok := l[i].(float64)
+		if !ok || f < 0 || f > 255 {
+			return nil, false
+		}
+		c[i] = uint8(f)
+	}
+	return color.NRGBA{c[0], c[1], c[2], 0xff}, true
+}
+
+func rgbFunc(v interface{}) (string, error) {
+	c, ok := rgbAttribute(v)
+	if !ok {
+		return "", fmt.Errorf("rgb: invalid color %v", v)
+	}
+	n := c.(color.NRGBA)
+	return fmt.Sprintf("#%02x%02x%02x", n.R, n.G, n.B), nil
+}
+
+// number converts a template argument to a float64.
+func number(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case int:
+		return float64(v), nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+	}
+	return 0, fmt.Errorf("%v is not a number", v)
+}
+
+func roundFunc(decimals int, v interface{}) (string, error) {
+	f, err := number(v)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatFloat(f, 'f', decimals, 64), nil
+}
+
+func percentFunc(v interface{}) (string, error) {
+	if v == nil {
+		return "0%", nil
+	}
+	f, err := number(v)
+	if err != nil {
+		return "", err
+	}
+	return strconv.Itoa(int(math.Round(f*100/255))) + "%", nil
+}
+
+// Change calls the service of the entity when the key is pressed.
+func (e *Entity) Change(state sd.BtnState) {
+	if state != sd.BtnPressed || e.service == "" {
+		return
+	}
+	data := map[string]interface{}{"entity_id": e.entityID}
+	for k, v := range e.data {
+		data[k] = v
+	}
+	go func() {
+		if err := e.client.CallService(context.Background(), e.domain, e.service, data); err != nil {
+			e.error(err)
+		}
+	}()
+}
+
+// Render draws the entity.
+func (e *Entity) Render(img *image.RGBA) error {
+	return e.tile.Render(img)
+}
+
+// Close stops following the entity.
+func (e *Entity) Close() {
+	e.mu.Lock()
+	stop := e.stop
+	e.stop = nil
+	e.closed = true
+	e.mu.Unlock()
+
+	if stop != nil {
+		stop()
+	}
+}
+
+func (e *Entity) error(err error) {
+	if e.onError != nil {
+		e.onError(err)
+	}
+}
@@ -0,0 +1,67 @@
+package icon
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Codepoints maps glyph names to their codepoint in an icon font.
+type Codepoints map[string]rune
+
+// LoadCodepoints parses a codepoints file as shipped with Material Symbols
+// and Material Icons: one "name hexcodepoint" pair per line. Empty lines and
+// lines starting with '#' are ignored.
+func LoadCodepoints(r io.Reader) (Codepoints, error) {
+	cp := make(Codepoints)
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("codepoints line %d: expected \"name codepoint\"", lineNo)
+		}
+
+		hex := strings.TrimPrefix(strings.TrimPrefix(fields[1], "0x"), "U+")
+		v, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("codepoints line %d: invalid codepoint %q", lineNo, fields[1])
+		}
+		cp[fields[0]] = rune(v)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return cp, nil
+}
+
+// LoadCodepointsFile loads a codepoints file from disk.
+func LoadCodepointsFile(path string) (Codepoints, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return LoadCodepoints(f)
+}
+
+// Lookup returns the codepoint of the glyph with the given name.
+func (cp Codepoints) Lookup(name string) (rune, error) {
+	r, ok := cp[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown icon name %q", name)
+	}
+	return r, nil
+}
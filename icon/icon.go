@@ -0,0 +1,203 @@
+// Package icon renders glyphs of an icon font (Material Symbols, Font Awesome
+// or any other TrueType font) on the keys of a StreamDeck.
+package icon
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io/ioutil"
+	"sync"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/label"
+	"github.com/golang/freetype"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// Icon is an Element displaying a single glyph, centered and scaled to fit
+// the key, with an optional caption underneath. All methods are safe for
+// concurrent use.
+type Icon struct {
+	sd.Invalidator
+	streamDeck   *sd.StreamDeck
+	id           int
+	font         *truetype.Font
+	name         string
+	codepoints   Codepoints
+	fgColor      color.Color
+	bgColor      color.Color
+	scale        float64
+	captionColor color.Color
+	cb           func(int, sd.BtnState)
+
+	mu      sync.Mutex
+	glyph   rune
+	caption string
+}
+
+var _ sd.Element = (*Icon)(nil)
+
+// NewIcon is the constructor method for an Icon. The glyph is selected with
+// either the Glyph or the Name option. The StreamDeck may be nil if the Icon
+// is only rendered through a Host or RenderElement.
+func NewIcon(sd *sd.StreamDeck, btnIndex int, f *truetype.Font, options ...func(*Icon)) (*Icon, error) {
+	if f == nil {
+		return nil, fmt.Errorf("font must not be nil")
+	}
+
+	ic := &Icon{
+		streamDeck: sd,
+		id:         btnIndex,
+		font:       f,
+		fgColor:    image.White,
+		bgColor:    image.Black,
+		scale:      0.7,
+	}
+
+	for _, option := range options {
+		option(ic)
+	}
+
+	if ic.name != "" {
+		r, err := ic.codepoints.Lookup(ic.name)
+		if err != nil {
+			return nil, err
+		}
+		ic.glyph = r
+	}
+	if ic.font.Index(ic.glyph) == 0 {
+		return nil, fmt.Errorf("glyph %U not found in font", ic.glyph)
+	}
+	if ic.scale <= 0 || ic.scale > 1 {
+		return nil, fmt.Errorf("invalid scale %v", ic.scale)
+	}
+
+	return ic, nil
+}
+
+// LoadFont parses a TrueType icon font.
+func LoadFont(data []byte) (*truetype.Font, error) {
+	return freetype.ParseFont(data)
+}
+
+// LoadFontFile reads and parses a TrueType icon font from disk.
+func LoadFontFile(path string) (*truetype.Font, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return LoadFont(data)
+}
+
+// Change is called when the state of the key changes.
+func (ic *Icon) Change(state sd.BtnState) {
+	if ic.cb != nil {
+		ic.cb(ic.id, state)
+	}
+}
+
+// SetGlyph changes the displayed glyph and renders the Icon.
+func (ic *Icon) SetGlyph(r rune) error {
+	if ic.font.Index(r) == 0 {
+		return fmt.Errorf("glyph %U not found in font", r)
+	}
+	ic.mu.Lock()
+	ic.glyph = r
+	ic.mu.Unlock()
+	return ic.changed()
+}
+
+// SetCaption changes the caption and renders the Icon.
+func (ic *Icon) SetCaption(text string) error {
+	ic.mu.Lock()
+	ic.caption = text
+	ic.mu.Unlock()
+	return ic.changed()
+}
+
+// Draw renders the Icon on the designated Button.
+func (ic *Icon) Draw() error {
+	if ic.streamDeck == nil {
+		return fmt.Errorf("icon is not attached to a stream deck")
+	}
+	img, err := sd.RenderElement(ic, ic.streamDeck.Info.ButtonSize)
+	if err != nil {
+		return err
+	}
+	return ic.streamDeck.FillImage(ic.id, img)
+}
+
+// changed marks the Icon as dirty and, if it is attached to a stream deck,
+// redraws it right away.
+func (ic *Icon) changed() error {
+	ic.Invalidate()
+	if ic.streamDeck == nil {
+		return nil
+	}
+	return ic.Draw()
+}
+
+// Render draws the Icon into img.
+func (ic *Icon) Render(img *image.RGBA) error {
+	ic.mu.Lock()
+	glyph, caption := ic.glyph, ic.caption
+	ic.mu.Unlock()
+
+	draw.Draw(img, img.Bounds(), image.NewUniform(ic.bgColor), image.Point{}, draw.Src)
+
+	area := img.Bounds()
+	if caption != "" {
+		captionColor := ic.captionColor
+		if captionColor == nil {
+			captionColor = ic.fgColor
+		}
+		area.Max.Y -= label.DrawCaption(img, caption, captionColor)
+	}
+
+	return drawGlyph(img, area, ic.font, glyph, ic.scale, ic.fgColor)
+}
+
+// refSize is the font size used to measure glyphs before scaling them.
+const refSize = 100
+
+// drawGlyph draws r centered in area, scaled so its bounding box covers the
+// given fraction of the area.
+func drawGlyph(img *image.RGBA, area image.Rectangle, f *truetype.Font, r rune, scale float64, c color.Color) error {
+	face := truetype.NewFace(f, &truetype.Options{Size: refSize, DPI: 72})
+	b, _, ok := face.GlyphBounds(r)
+	if !ok {
+		return fmt.Errorf("glyph %U not found in font", r)
+	}
+	w, h := float64(b.Max.X-b.Min.X)/64, float64(b.Max.Y-b.Min.Y)/64
+	if w <= 0 || h <= 0 {
+		// blank glyph (e.g. space), nothing to draw
+		return nil
+	}
+
+	ratio := scale * float64(area.Dx()) / w
+	if rh := scale * float64(area.Dy()) / h; rh < ratio {
+		ratio = rh
+	}
+
+	face = truetype.NewFace(f, &truetype.Options{Size: refSize * ratio, DPI: 72})
+	b, _, _ = face.GlyphBounds(r)
+
+	// position the dot so that the center of the glyph bounds matches the
+	// center of the area
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(c),
+		Face: face,
+		Dot: fixed.Point26_6{
+			X: fixed.I(area.Min.X+area.Max.X)/2 - (b.Min.X+b.Max.X)/2,
+			Y: fixed.I(area.Min.Y+area.Max.Y)/2 - (b.Min.Y+b.Max.Y)/2,
+		},
+	}
+	d.DrawString(string(r))
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package icon
+
+import (
+	"fmt"
+	"image"
+	"sync"
+	"testing"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/label"
+	"github.com/KarpelesLab/streamdeck/mock"
+)
+
+func TestIconConcurrent(t *testing.T) {
+	dev, _ := mock.Open(t, sd.LookupDevice(0x0063), "TEST0001")
+	h := sd.NewHost(dev)
+	defer h.Close()
+
+	f := label.MPlus1mMediumFont
+	direct, err := NewIcon(dev, 0, f, Glyph('A'))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosted, _ := NewIcon(dev, 1, f, Glyph('A'))
+	free, _ := NewIcon(nil, 2, f, Glyph('A'))
+	if err := h.Bind(1, hosted); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for _, ic := range []*Icon{direct, hosted, free} {
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func(ic *Icon) {
+				defer wg.Done()
+				img := image.NewRGBA(image.Rect(0, 0, 80, 80))
+				for i := 0; i < 10; i++ {
+					ic.SetGlyph(rune('A' + i))
+					ic.SetCaption(fmt.Sprint(i))
+					ic.Render(img)
+				}
+			}(ic)
+		}
+	}
+	wg.Wait()
+
+	if err := free.SetGlyph(0x10ffff); err == nil {
+		t.Error("missing glyph accepted")
+	}
+}
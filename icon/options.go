@@ -0,0 +1,70 @@
+package icon
+
+import (
+	"image/color"
+
+	sd "github.com/KarpelesLab/streamdeck"
+)
+
+// Glyph is a functional option selecting the glyph to render by codepoint.
+func Glyph(r rune) func(*Icon) {
+	return func(ic *Icon) {
+		ic.glyph = r
+		ic.name = ""
+	}
+}
+
+// Name is a functional option selecting the glyph to render by its name in
+// the supplied codepoints map.
+func Name(cp Codepoints, name string) func(*Icon) {
+	return func(ic *Icon) {
+		ic.codepoints = cp
+		ic.name = name
+	}
+}
+
+// FgColor is a functional option which sets the color of the glyph.
+func FgColor(c color.Color) func(*Icon) {
+	return func(ic *Icon) {
+		ic.fgColor = c
+	}
+}
+
+// BgColor is a functional option which sets the background color of the key.
+func BgColor(c color.Color) func(*Icon) {
+	return func(ic *Icon) {
+		ic.bgColor = c
+	}
+}
+
+// Scale is a functional option setting the fraction (0 < s <= 1) of the
+// available area the glyph is scaled to. Default is 0.7.
+func Scale(s float64) func(*Icon) {
+	return func(ic *Icon) {
+		ic.scale = s
+	}
+}
+
+// Caption is a functional option which adds a line of text underneath the
+// glyph.
+func Caption(text string) func(*Icon) {
+	return func(ic *Icon) {
+		ic.caption = text
+	}
+}
+
+// CaptionColor is a functional option which sets the color of the caption.
+// It defaults to the glyph color.
+func CaptionColor(c color.Color) func(*Icon) {
+	return func(ic *Icon) {
+		ic.captionColor = c
+	}
+}
+
+// Callback is a functional option which sets the function called when the
+// key is pressed or released.
+func Callback(cb func(int, sd.BtnState)) func(*Icon) {
+	return func(ic *Icon) {
+		ic.cb = cb
+	}
+}
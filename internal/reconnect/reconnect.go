@@ -0,0 +1,52 @@
+// Package reconnect keeps a client connected to a server, reconnecting
+// with a backoff whenever the connection is lost.
+package reconnect
+
+import "time"
+
+// Backoff bounds the delay between two connection attempts, which doubles
+// after each failure.
+const (
+	MinDelay = time.Second
+	MaxDelay = 30 * time.Second
+)
+
+// Loop serves connections until done is closed. serve handles the current
+// connection until it fails, and lost cleans up after it; then connect is
+// retried until it opens the next connection. The errors of serve and
+// connect are passed to onError, which may be nil; nothing is reported
+// once done is closed.
+func Loop(done <-chan struct{}, serve func() error, lost func(), connect func() error, onError func(error)) {
+	delay := MinDelay
+	for {
+		err := serve()
+		lost()
+
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if onError != nil {
+			onError(err)
+		}
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(delay):
+			}
+			if err = connect(); err == nil {
+				delay = MinDelay
+				break
+			}
+			if onError != nil {
+				onError(err)
+			}
+			if delay *= 2; delay > MaxDelay {
+				delay = MaxDelay
+			}
+		}
+	}
+}
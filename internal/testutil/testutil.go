@@ -0,0 +1,25 @@
+// Package testutil holds helpers shared by the tests of several packages.
+package testutil
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// ExpectError waits up to 5 seconds for an error containing substr on
+// errs, skipping the others, and fails the test otherwise.
+func ExpectError(t testing.TB, errs <-chan error, substr string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case err := <-errs:
+			if strings.Contains(err.Error(), substr) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("no error %q", substr)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+// Package websocket is a minimal implementation of the WebSocket protocol
+// (RFC 6455), server and client side, sufficient for the JSON and binary
+// messages exchanged by this library's integrations.
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message types.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+)
+
+// MaxMessageSize is the largest message accepted.
+const MaxMessageSize = 16 << 20
+
+// ErrClosed is returned once the connection is closed.
+var ErrClosed = errors.New("websocket: connection closed")
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// acceptKey computes the Sec-WebSocket-Accept header for a key.
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// Conn is a WebSocket connection. Reads must be done from a single
+// goroutine; writes may be done concurrently.
+type Conn struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	client bool // client connections mask their frames
+
+	wmu    sync.Mutex
+	closed bool
+}
+
+func newConn(c net.Conn, br *bufio.Reader, client bool) *Conn {
+	if br == nil {
+		br = bufio.NewReader(c)
+	}
+	return &Conn{conn: c, br: br, client: client}
+}
+
+// RemoteAddr returns the address of the peer.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetReadDeadline sets the deadline of the pending and future reads.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// ReadMessage returns the next text or binary message. Pings are answered
+// and fragmented messages reassembled. A close frame from the peer is
+// answered and reported as io.EOF.
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	var (
+		msgType int
+		msg     []byte
+	)
+	for {
+		fin, op, data, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch op {
+		case PingMessage:
+			if err := c.writeFrame(PongMessage, data); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			continue
+		case CloseMessage:
+			c.Close()
+			return 0, nil, io.EOF
+		case 0: // continuation
+			if msgType == 0 {
+				return 0, nil, fmt.Errorf("websocket: unexpected continuation frame")
+			}
+		case TextMessage, BinaryMessage:
+			if msgType != 0 {
+				return 0, nil, fmt.Errorf("websocket: expected continuation frame")
+			}
+			msgType = op
+		default:
+			return 0, nil, fmt.Errorf("websocket: unknown opcode %d", op)
+		}
+
+		if len(msg)+len(data) > MaxMessageSize {
+			return 0, nil, fmt.Errorf("websocket: message too large")
+		}
+		msg = append(msg, data...)
+		if fin {
+			return msgType, msg, nil
+		}
+	}
+}
+
+// ReadJSON reads the next message and decodes it as JSON into v.
+func (c *Conn) ReadJSON(v interface{}) error {
+	_, data, err := c.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (c *Conn) readFrame() (fin bool, op int, data []byte, err error) {
+	var h [2]byte
+	if _, err = io.ReadFull(c.br, h[:]); err != nil {
+		return
+	}
+	fin = h[0]&0x80 != 0
+	op = int(h[0] & 0x0f)
+	masked := h[1]&0x80 != 0
+	n := uint64(h[1] & 0x7f)
+
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err = io.ReadFull(c.br, b[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err = io.ReadFull(c.br, b[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	if n > MaxMessageSize {
+		err = fmt.Errorf("websocket: frame too large")
+		return
+	}
+	if op >= CloseMessage && (n > 125 || !fin) {
+		err = fmt.Errorf("websocket: invalid control frame")
+		return
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+			return
+		}
+	}
+	data = make([]byte, n)
+	if _, err = io.ReadFull(c.br, data); err != nil {
+		return
+	}
+	if masked {
+		for i := range data {
+			data[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+// WriteMessage sends a message.
+func (c *Conn) WriteMessage(msgType int, data []byte) error {
+	return c.writeFrame(msgType, data)
+}
+
+// WriteJSON sends v encoded as JSON in a text message.
+func (c *Conn) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(TextMessage, data)
+}
+
+// Ping sends a ping; the peer answers with a pong.
+func (c *Conn) Ping() error {
+	return c.writeFrame(PingMessage, nil)
+}
+
+func (c *Conn) writeFrame(op int, data []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+
+	buf := make([]byte, 0, len(data)+14)
+	buf = append(buf, 0x80|byte(op))
+
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(data); {
+	case n < 126:
+		buf = append(buf, maskBit|byte(n))
+	case n <= 0xffff:
+		buf = append(buf, maskBit|126, byte(n>>8), byte(n))
+	default:
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], uint64(n))
+		buf = append(buf, maskBit|127)
+		buf = append(buf, b[:]...)
+	}
+
+	if c.client {
+		var mask [4]byte
+		rand.Read(mask[:])
+		buf = append(buf, mask[:]...)
+		start := len(buf)
+		buf = append(buf, data...)
+		for i := range buf[start:] {
+			buf[start+i] ^= mask[i%4]
+		}
+	} else {
+		buf = append(buf, data...)
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// Close sends a close frame, if possible, and closes the connection.
+func (c *Conn) Close() error {
+	c.writeFrame(CloseMessage, []byte{0x03, 0xe8}) // 1000, normal closure
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.conn.Close()
+}
+
+// headerContains reports whether a comma separated header contains token,
+// case insensitively.
+func headerContains(header, token string) bool {
+	for _, t := range strings.Split(header, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
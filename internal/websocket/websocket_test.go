@@ -0,0 +1,200 @@
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// example of RFC 6455, section 1.3
+	if got := acceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("acceptKey = %q", got)
+	}
+}
+
+// pipe returns a server side connection, and the raw other end.
+func pipe(t *testing.T) (*Conn, net.Conn) {
+	a, b := net.Pipe()
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return newConn(a, nil, false), b
+}
+
+func TestReadFrames(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+		want  string
+	}{
+		// examples of RFC 6455, section 5.7
+		{"unmasked", []byte{0x81, 0x05, 0x48, 0x65, 0x6c, 0x6c, 0x6f}, "Hello"},
+		{"masked", []byte{0x81, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58}, "Hello"},
+		{"fragmented", []byte{0x01, 0x03, 0x48, 0x65, 0x6c, 0x80, 0x02, 0x6c, 0x6f}, "Hello"},
+		{"16-bit length", append([]byte{0x82, 0x7e, 0x01, 0x00}, bytes.Repeat([]byte{'a'}, 256)...), strings.Repeat("a", 256)},
+		{"64-bit length", append([]byte{0x82, 0x7f, 0, 0, 0, 0, 0, 1, 0, 0}, bytes.Repeat([]byte{'b'}, 65536)...), strings.Repeat("b", 65536)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, peer := pipe(t)
+			go peer.Write(test.input)
+			_, msg, err := c.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(msg) != test.want {
+				t.Errorf("got %d bytes %.20q, want %d bytes %.20q", len(msg), msg, len(test.want), test.want)
+			}
+		})
+	}
+}
+
+func TestPingBetweenFragments(t *testing.T) {
+	c, peer := pipe(t)
+	go peer.Write([]byte{0x01, 0x03, 0x48, 0x65, 0x6c, 0x89, 0x02, 'h', 'i', 0x80, 0x02, 0x6c, 0x6f})
+
+	pong := make(chan []byte, 1)
+	go func() {
+		buf := make([]byte, 4)
+		io.ReadFull(peer, buf)
+		pong <- buf
+	}()
+
+	_, msg, err := c.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg) != "Hello" {
+		t.Errorf("got %q", msg)
+	}
+	if got := <-pong; !bytes.Equal(got, []byte{0x8a, 0x02, 'h', 'i'}) {
+		t.Errorf("pong = %x", got)
+	}
+}
+
+func TestInvalidFrames(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{"long control frame", append([]byte{0x89, 0x7e, 0x00, 0x7e}, make([]byte, 126)...)},
+		{"fragmented control frame", []byte{0x09, 0x00}},
+		{"continuation first", []byte{0x80, 0x01, 'a'}},
+		{"unknown opcode", []byte{0x83, 0x00}},
+		{"too large", []byte{0x82, 0x7f, 0, 0, 0, 1, 0, 0, 0, 0}},
+		{"truncated", []byte{0x81, 0x05, 'H', 'e'}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, peer := pipe(t)
+			go func() {
+				peer.Write(test.input)
+				peer.Close()
+			}()
+			if _, _, err := c.ReadMessage(); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
+
+func TestCloseFrame(t *testing.T) {
+	c, peer := pipe(t)
+	go func() {
+		peer.Write([]byte{0x88, 0x02, 0x03, 0xe8})
+		io.Copy(io.Discard, peer)
+	}()
+	if _, _, err := c.ReadMessage(); err != io.EOF {
+		t.Errorf("err = %v, want io.EOF", err)
+	}
+	if err := c.WriteMessage(TextMessage, []byte("late")); err != ErrClosed {
+		t.Errorf("write after close: %v", err)
+	}
+}
+
+func TestClientMasksFrames(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	c := newConn(a, nil, true)
+	go c.WriteMessage(TextMessage, []byte("Hello"))
+
+	buf := make([]byte, 11)
+	if _, err := io.ReadFull(b, buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf[0] != 0x81 || buf[1] != 0x85 {
+		t.Fatalf("header = %x", buf[:2])
+	}
+	mask := buf[2:6]
+	for i := range buf[6:] {
+		buf[6+i] ^= mask[i%4]
+	}
+	if string(buf[6:]) != "Hello" {
+		t.Errorf("payload = %q", buf[6:])
+	}
+}
+
+func TestDialUpgrade(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !IsWebSocket(r) {
+			http.Error(w, "websocket required", http.StatusBadRequest)
+			return
+		}
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			typ, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(typ, msg)
+		}
+	}))
+	defer srv.Close()
+
+	conn, err := Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, n := range []int{0, 125, 126, 65535, 65536, 200000} {
+		msg := bytes.Repeat([]byte{byte(n)}, n)
+		if err := conn.WriteMessage(BinaryMessage, msg); err != nil {
+			t.Fatal(err)
+		}
+		typ, got, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ != BinaryMessage || !bytes.Equal(got, msg) {
+			t.Errorf("echo of %d bytes: got type %d, %d bytes", n, typ, len(got))
+		}
+	}
+
+	var v struct{ A int }
+	if err := conn.WriteJSON(map[string]int{"A": 42}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.ReadJSON(&v); err != nil || v.A != 42 {
+		t.Errorf("ReadJSON = %v, %v", v, err)
+	}
+}
+
+func TestDialRejected(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	if _, err := Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil); err == nil {
+		t.Error("no error")
+	}
+}
@@ -0,0 +1,50 @@
+package label
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// DrawCaption renders a single line of text horizontally centered at the
+// bottom of img. The font size is derived from the image size and reduced
+// until the text fits the width. It returns the height in pixels taken by the
+// caption, so callers can lay out the remaining area above it.
+func DrawCaption(img *image.RGBA, text string, c color.Color) int {
+	if text == "" {
+		return 0
+	}
+
+	b := img.Bounds()
+	size := float64(b.Dy()) / 5
+	margin := b.Dx() / 24
+
+	var face font.Face
+	var width fixed.Int26_6
+	for ; size >= 6; size-- {
+		face = truetype.NewFace(MPlus1mMediumFont, &truetype.Options{Size: size, DPI: 72})
+		width = font.MeasureString(face, text)
+		if width.Ceil() <= b.Dx()-2*margin {
+			break
+		}
+	}
+
+	m := face.Metrics()
+	height := (m.Ascent + m.Descent).Ceil() + margin
+
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(c),
+		Face: face,
+		Dot: fixed.Point26_6{
+			X: fixed.I(b.Min.X) + (fixed.I(b.Dx())-width)/2,
+			Y: fixed.I(b.Max.Y-margin) - m.Descent,
+		},
+	}
+	d.DrawString(text)
+
+	return height
+}
@@ -4,32 +4,42 @@ import (
 	"fmt"
 	"image"
 	"image/color"
-	"image/draw"
+	"sync"
 
 	sd "github.com/KarpelesLab/streamdeck"
 	"github.com/golang/freetype"
 )
 
-// Label is a basic Element for the StreamDeck.
+// Label is a basic Element for the StreamDeck. Its appearance depends on
+// its state (normal, pressed, disabled, active), each with its own Style.
+// All methods are safe for concurrent use.
 type Label struct {
+	sd.Invalidator
+	mu         sync.Mutex
+	drawMu     sync.Mutex // serializes drawing, so the key can't go back in time
 	streamDeck *sd.StreamDeck
 	text       string
 	id         int
-	textColor  color.Color
-	bgColor    color.Color
+	styles     [numStates]Style
 	state      sd.BtnState
+	disabled   bool
+	active     bool
+	hosted     bool // bound to a Host, which draws the Label
 	cb         func(int, sd.BtnState)
 }
 
-// NewLabel is the constructor method for a Label.
-func NewLabel(sd *sd.StreamDeck, btnIndex int, options ...func(*Label)) (*Label, error) {
+var _ sd.Element = (*Label)(nil)
+
+// NewLabel is the constructor method for a Label. The StreamDeck may be nil
+// if the Label is only rendered through a Host or RenderElement.
+func NewLabel(streamDeck *sd.StreamDeck, btnIndex int, options ...func(*Label)) (*Label, error) {
 
 	l := &Label{
-		streamDeck: sd,
+		streamDeck: streamDeck,
 		id:         btnIndex,
 		text:       "",
-		textColor:  image.White,
-		bgColor:    image.Black,
+		styles:     defaultStyles,
+		state:      sd.BtnReleased,
 	}
 
 	for _, option := range options {
@@ -39,43 +49,166 @@ func NewLabel(sd *sd.StreamDeck, btnIndex int, options ...func(*Label)) (*Label,
 	return l, nil
 }
 
+// Change updates the Label when its key is pressed or released, redraws it
+// and calls the callback. Disabled Labels ignore key presses.
 func (l *Label) Change(state sd.BtnState) {
-	if state == sd.BtnPressed {
-		col := color.RGBA{0, 0, 153, 0}
-		l.SetBgColor(image.NewUniform(col))
-	} else { // must be BtnReleased
-		col := color.RGBA{0, 0, 0, 255}
-		l.SetBgColor(image.NewUniform(col))
+	l.mu.Lock()
+	if l.disabled || l.state == state {
+		l.mu.Unlock()
+		return
 	}
-	if l.cb != nil {
-		l.cb(l.id, state)
+	l.state = state
+	cb := l.cb
+	l.mu.Unlock()
+
+	l.changed()
+	if cb != nil {
+		cb(l.id, state)
 	}
 }
 
+// State returns the current visual state of the Label.
+func (l *Label) State() State {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.visualState()
+}
+
+// visualState must be called with the lock held.
+func (l *Label) visualState() State {
+	switch {
+	case l.disabled:
+		return Disabled
+	case l.state == sd.BtnPressed:
+		return Pressed
+	case l.active:
+		return Active
+	}
+	return Normal
+}
+
+// style returns the effective style of the Label. It must be called with
+// the lock held.
+func (l *Label) style() Style {
+	s := l.styles[Normal]
+	if l.active {
+		s = s.merge(l.styles[Active])
+	}
+	switch l.visualState() {
+	case Pressed:
+		s = s.merge(l.styles[Pressed])
+	case Disabled:
+		s = s.merge(l.styles[Disabled])
+	}
+	return s
+}
+
+// Render draws the Label into img.
+func (l *Label) Render(img *image.RGBA) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s := l.style()
+	s.drawBackground(img)
+	return l.addText(l.text, s.TextColor, img)
+}
+
 // Draw renders the Label on the designated Button.
 func (l *Label) Draw() error {
-	img := image.NewRGBA(image.Rect(0, 0, l.streamDeck.Info.ButtonSize, l.streamDeck.Info.ButtonSize))
-	l.addBgColor(l.bgColor, img)
-	if err := l.addText(l.text, img); err != nil {
+	if l.streamDeck == nil {
+		return fmt.Errorf("label is not attached to a stream deck")
+	}
+	// Rendering and sending the image as one step makes the last Draw show
+	// the current state, even if an earlier one is still sending.
+	l.drawMu.Lock()
+	defer l.drawMu.Unlock()
+	img, err := sd.RenderElement(l, l.streamDeck.Info.ButtonSize)
+	if err != nil {
 		return err
 	}
 	return l.streamDeck.FillImage(l.id, img)
 }
 
-// SetText sets the text of the Label.
+// SetNotify sets the function called when the Label needs to be redrawn.
+// While it is set, the Label is bound to a Host and leaves the drawing to
+// it, even if it is attached to a stream deck.
+func (l *Label) SetNotify(notify func()) {
+	l.mu.Lock()
+	l.hosted = notify != nil
+	l.mu.Unlock()
+	l.Invalidator.SetNotify(notify)
+}
+
+// changed marks the Label as dirty, which redraws it if it is bound to a
+// Host. Otherwise, if it is attached to a stream deck, it is redrawn right
+// away.
+func (l *Label) changed() error {
+	l.mu.Lock()
+	draw := l.streamDeck != nil && !l.hosted
+	l.mu.Unlock()
+
+	l.Invalidate()
+	if !draw {
+		return nil
+	}
+	return l.Draw()
+}
+
+// SetText sets the text of the Label and renders it.
 func (l *Label) SetText(text string) error {
+	l.mu.Lock()
 	l.text = text
-	return nil
+	l.mu.Unlock()
+	return l.changed()
+}
+
+// SetTextColor sets the text color of the normal style and renders the
+// Label.
+func (l *Label) SetTextColor(c color.Color) error {
+	l.mu.Lock()
+	l.styles[Normal].TextColor = c
+	l.mu.Unlock()
+	return l.changed()
 }
 
-// SetBgColor sets the background color of the Label.
+// SetBgColor sets the background color of the normal style and renders the
+// Label.
 func (l *Label) SetBgColor(color *image.Uniform) error {
-	l.bgColor = color
-	return nil
+	l.mu.Lock()
+	l.styles[Normal].BgColor = color
+	l.mu.Unlock()
+	return l.changed()
 }
 
-func (l *Label) addBgColor(col color.Color, img *image.RGBA) {
-	draw.Draw(img, img.Bounds(), image.NewUniform(col), image.ZP, draw.Src)
+// SetStyle sets the style used in the given state and renders the Label.
+func (l *Label) SetStyle(state State, style Style) error {
+	if state < 0 || state >= numStates {
+		return fmt.Errorf("invalid label state %d", state)
+	}
+
+	l.mu.Lock()
+	l.styles[state] = style
+	l.mu.Unlock()
+	return l.changed()
+}
+
+// SetDisabled enables or disables the Label and renders it.
+func (l *Label) SetDisabled(disabled bool) error {
+	l.mu.Lock()
+	l.disabled = disabled
+	if disabled {
+		l.state = sd.BtnReleased
+	}
+	l.mu.Unlock()
+	return l.changed()
+}
+
+// SetActive marks the Label as active or not and renders it.
+func (l *Label) SetActive(active bool) error {
+	l.mu.Lock()
+	l.active = active
+	l.mu.Unlock()
+	return l.changed()
 }
 
 type textParams struct {
@@ -120,11 +253,13 @@ var oneLine = textParams{
 	posY:     20,
 }
 
-func (l *Label) addText(text string, img *image.RGBA) error {
+func (l *Label) addText(text string, textColor color.Color, img *image.RGBA) error {
 
 	var p textParams
 
 	switch len(text) {
+	case 0:
+		return nil
 	case 1:
 		p = singleChar
 	case 2:
@@ -139,15 +274,19 @@ func (l *Label) addText(text string, img *image.RGBA) error {
 		return fmt.Errorf("text line contains more than 5 characters")
 	}
 
+	// the text parameters are designed for 72px keys, scale them to the
+	// actual size
+	scale := float64(img.Bounds().Dx()) / 72
+
 	// create Context
 	c := freetype.NewContext()
 	c.SetDPI(72)
 	c.SetFont(MPlus1mMediumFont)
-	c.SetFontSize(p.fontSize)
+	c.SetFontSize(p.fontSize * scale)
 	c.SetClip(img.Bounds())
 	c.SetDst(img)
-	c.SetSrc(image.NewUniform(l.textColor))
-	pt := freetype.Pt(p.posX, p.posY+int(c.PointToFixed(24)>>6))
+	c.SetSrc(image.NewUniform(textColor))
+	pt := freetype.Pt(int(float64(p.posX)*scale), int(float64(p.posY+24)*scale))
 
 	if _, err := c.DrawString(text, pt); err != nil {
 		return err
@@ -0,0 +1,157 @@
+package label
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"sync"
+	"testing"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/mock"
+)
+
+// drawCounter counts the images sent to each key of a mock.
+type drawCounter struct {
+	mu    sync.Mutex
+	draws map[int]int
+}
+
+func countDraws(m *mock.Device) *drawCounter {
+	c := &drawCounter{draws: make(map[int]int)}
+	m.OnDraw(func(btnIndex int) {
+		c.mu.Lock()
+		c.draws[btnIndex]++
+		c.mu.Unlock()
+	})
+	return c
+}
+
+func (c *drawCounter) get(btnIndex int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.draws[btnIndex]
+}
+
+func TestLabelDrawsOnce(t *testing.T) {
+	dev, m := mock.Open(t, sd.LookupDevice(0x0063), "TEST0001")
+	draws := countDraws(m)
+
+	// Without Host, the Label draws itself.
+	l, err := NewLabel(dev, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.SetText("a"); err != nil {
+		t.Fatal(err)
+	}
+	if n := draws.get(1); n != 1 {
+		t.Errorf("drawn %d times", n)
+	}
+
+	// Bound to a Host, only the Host draws it.
+	h := sd.NewHost(dev)
+	defer h.Close()
+	if err := h.Bind(1, l); err != nil {
+		t.Fatal(err)
+	}
+	before := draws.get(1)
+	if err := l.SetBgColor(image.NewUniform(color.RGBA{255, 0, 0, 255})); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for m.Key(1).(*image.RGBA).RGBAAt(2, 2) != (color.RGBA{255, 0, 0, 255}) {
+		if time.Now().After(deadline) {
+			t.Fatal("the Host didn't redraw the Label")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := draws.get(1) - before; n != 1 {
+		t.Errorf("drawn %d times for one change", n)
+	}
+
+	// Once unbound, it draws itself again.
+	h.Unbind(1)
+	before = draws.get(1)
+	l.SetText("b")
+	if n := draws.get(1) - before; n != 1 {
+		t.Errorf("drawn %d times after Unbind", n)
+	}
+}
+
+func TestLabelConcurrent(t *testing.T) {
+	dev, m := mock.Open(t, sd.LookupDevice(0x0063), "TEST0001")
+	h := sd.NewHost(dev)
+	defer h.Close()
+
+	direct, _ := NewLabel(dev, 0)
+	hosted, _ := NewLabel(dev, 1)
+	free, _ := NewLabel(nil, 2)
+	if err := h.Bind(1, hosted); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for _, l := range []*Label{direct, hosted, free} {
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func(l *Label) {
+				defer wg.Done()
+				img := image.NewRGBA(image.Rect(0, 0, 80, 80))
+				for i := 0; i < 10; i++ {
+					l.SetText(fmt.Sprint(i))
+					l.SetTextColor(color.White)
+					l.SetBgColor(image.NewUniform(color.RGBA{uint8(i), 0, 0, 255}))
+					l.SetActive(i%2 == 0)
+					l.SetDisabled(i%3 == 0)
+					l.SetStyle(Pressed, Style{TextColor: color.Black})
+					l.Change(sd.BtnState(i % 2))
+					l.State()
+					l.Render(img)
+				}
+			}(l)
+		}
+	}
+	wg.Wait()
+
+	// The last drawing shows the current state.
+	want, err := sd.RenderElement(direct, dev.ButtonSize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Key(0).(*image.RGBA); !bytes.Equal(got.Pix, want.Pix) {
+		t.Error("the key doesn't show the current state")
+	}
+}
+
+func TestBorderWidth(t *testing.T) {
+	orange := color.RGBA{255, 165, 0, 255}
+	black := color.RGBA{0, 0, 0, 255}
+	// edge and inner are the colors at 1 and 4 pixels from the edge.
+	for _, tt := range []struct {
+		name        string
+		style       Style
+		edge, inner color.RGBA
+	}{
+		{"inherited", Style{}, orange, black},
+		{"wider", Style{BorderWidth: Width(6)}, orange, orange},
+		{"none", Style{BorderWidth: Width(0)}, black, black},
+	} {
+		l, _ := NewLabel(nil, 0, WithStyle(Pressed, tt.style))
+		l.SetActive(true)
+		l.Change(sd.BtnPressed)
+		img, err := sd.RenderElement(l, 72)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := img.RGBAAt(1, 36); got != tt.edge {
+			t.Errorf("%s: %v at the edge, want %v", tt.name, got, tt.edge)
+		}
+		if got := img.RGBAAt(4, 36); got != tt.inner {
+			t.Errorf("%s: %v inside, want %v", tt.name, got, tt.inner)
+		}
+	}
+}
@@ -17,20 +17,39 @@ func Text(text string) func(*Label) {
 // TextColor is a functional option which sets the text color.
 func TextColor(c color.Color) func(*Label) {
 	return func(l *Label) {
-		l.textColor = c
+		l.styles[Normal].TextColor = c
 	}
 }
 
 // BgColor is a functional option which sets the background color of the label.
 func BgColor(c color.Color) func(*Label) {
 	return func(l *Label) {
-		l.bgColor = c
+		l.styles[Normal].BgColor = c
 	}
 }
 
-//
+// Callback is a functional option which sets the function called when the
+// key is pressed or released.
 func Callback(cb func(int, sd.BtnState)) func(*Label) {
 	return func(l *Label) {
 		l.cb = cb
 	}
 }
+
+// WithStyle is a functional option which sets the style used in the given
+// state.
+func WithStyle(state State, style Style) func(*Label) {
+	return func(l *Label) {
+		if state >= 0 && state < numStates {
+			l.styles[state] = style
+		}
+	}
+}
+
+// Enabled is a functional option which sets whether the Label is enabled.
+// Labels are enabled by default.
+func Enabled(enabled bool) func(*Label) {
+	return func(l *Label) {
+		l.disabled = !enabled
+	}
+}
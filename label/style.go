@@ -0,0 +1,94 @@
+package label
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// State is the visual state of a Label.
+type State int
+
+const (
+	// Normal is the style used when no other state applies.
+	Normal State = iota
+	// Pressed is used while the key is held down.
+	Pressed
+	// Disabled is used when the Label is disabled; presses are ignored.
+	Disabled
+	// Active is used when the Label is marked active, e.g. to highlight
+	// the current selection.
+	Active
+
+	numStates
+)
+
+// Style describes the appearance of a Label in a given state. Fields left
+// nil are inherited from the Normal style (or, for a pressed Label which is
+// also active, from the Active style).
+type Style struct {
+	TextColor   color.Color
+	BgColor     color.Color
+	BgImage     image.Image // scaled to the key size, drawn over BgColor
+	BorderColor color.Color
+	BorderWidth *int // see Width; 0 removes an inherited border
+}
+
+// Width returns a pointer to w, for Style.BorderWidth.
+func Width(w int) *int {
+	return &w
+}
+
+// defaultStyles are the styles of a new Label.
+var defaultStyles = [numStates]Style{
+	Normal:   {TextColor: color.White, BgColor: color.Black},
+	Pressed:  {BgColor: color.RGBA{0, 0, 153, 255}},
+	Disabled: {TextColor: color.RGBA{96, 96, 96, 255}},
+	Active:   {BorderColor: color.RGBA{255, 165, 0, 255}, BorderWidth: Width(3)},
+}
+
+// merge returns s with the fields set in o overridden.
+func (s Style) merge(o Style) Style {
+	if o.TextColor != nil {
+		s.TextColor = o.TextColor
+	}
+	if o.BgColor != nil {
+		s.BgColor = o.BgColor
+	}
+	if o.BgImage != nil {
+		s.BgImage = o.BgImage
+	}
+	if o.BorderColor != nil {
+		s.BorderColor = o.BorderColor
+	}
+	if o.BorderWidth != nil {
+		s.BorderWidth = o.BorderWidth
+	}
+	return s
+}
+
+// drawBackground fills img with the background color and image of the
+// style, then draws its border.
+func (s Style) drawBackground(img *image.RGBA) {
+	b := img.Bounds()
+	draw.Draw(img, b, image.NewUniform(s.BgColor), image.Point{}, draw.Src)
+
+	if s.BgImage != nil {
+		xdraw.CatmullRom.Scale(img, b, s.BgImage, s.BgImage.Bounds(), draw.Over, nil)
+	}
+
+	if s.BorderColor != nil && s.BorderWidth != nil && *s.BorderWidth > 0 {
+		w := *s.BorderWidth
+		src := image.NewUniform(s.BorderColor)
+		for _, r := range []image.Rectangle{
+			image.Rect(b.Min.X, b.Min.Y, b.Max.X, b.Min.Y+w),
+			image.Rect(b.Min.X, b.Max.Y-w, b.Max.X, b.Max.Y),
+			image.Rect(b.Min.X, b.Min.Y, b.Min.X+w, b.Max.Y),
+			image.Rect(b.Max.X-w, b.Min.Y, b.Max.X, b.Max.Y),
+		} {
+			draw.Draw(img, r.Intersect(b), src, image.Point{}, draw.Over)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package ledbutton
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock drives the animations of LED buttons. Buttons sharing a clock blink
+// and pulse in sync, since their phase is derived from the clock time. The
+// clock only ticks while at least one animated button is subscribed.
+type Clock struct {
+	mu       sync.Mutex
+	interval time.Duration
+	subs     map[int]func(time.Time)
+	nextID   int
+	stop     chan struct{}
+}
+
+// DefaultClock is the clock used by buttons unless another one is given
+// with the WithClock option. It ticks 30 times per second.
+var DefaultClock = NewClock(time.Second / 30)
+
+// NewClock returns a clock ticking at the given interval.
+func NewClock(interval time.Duration) *Clock {
+	return &Clock{
+		interval: interval,
+		subs:     make(map[int]func(time.Time)),
+	}
+}
+
+// subscribe registers f to be called on every tick and returns the function
+// to unsubscribe it.
+func (c *Clock) subscribe(f func(time.Time)) func() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id := c.nextID
+	c.nextID++
+	c.subs[id] = f
+
+	if c.stop == nil {
+		c.stop = make(chan struct{})
+		go c.run(c.stop)
+	}
+
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		delete(c.subs, id)
+		if len(c.subs) == 0 && c.stop != nil {
+			close(c.stop)
+			c.stop = nil
+		}
+	}
+}
+
+func (c *Clock) run(stop chan struct{}) {
+	t := time.NewTicker(c.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-t.C:
+			c.mu.Lock()
+			subs := make([]func(time.Time), 0, len(c.subs))
+			for _, f := range c.subs {
+				subs = append(subs, f)
+			}
+			c.mu.Unlock()
+
+			for _, f := range subs {
+				f(now)
+			}
+		}
+	}
+}
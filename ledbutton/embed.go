@@ -1,15 +0,0 @@
-package ledbutton
-
-import _ "embed"
-
-//go:embed images/led_green_on.png
-var ledGreenBin []byte
-
-//go:embed images/led_off.png
-var ledOffBin []byte
-
-//go:embed images/led_red_on.png
-var ledRedBin []byte
-
-//go:embed images/led_yellow_on.png
-var ledYellowBin []byte
@@ -0,0 +1,139 @@
+package ledbutton
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"time"
+)
+
+// Mode defines how a LED which is switched on behaves.
+type Mode int
+
+const (
+	// Steady keeps the LED lit.
+	Steady Mode = iota
+	// Blink switches the LED on and off, once per period.
+	Blink
+	// Pulse smoothly fades the LED in and out, once per period.
+	Pulse
+	// FlashOnce lights the LED for one period each time it is switched on,
+	// after which it switches itself off.
+	FlashOnce
+)
+
+// LEDColor is the type which defines the preset colors of the LED. It
+// implements color.Color, so presets can be used wherever any color is
+// accepted.
+type LEDColor int
+
+const (
+	//LEDRed is a red LED
+	LEDRed LEDColor = iota
+	// LEDGreen is a green LED
+	LEDGreen
+	// LEDYellow is a yellow LED
+	LEDYellow
+	// LEDOff turns the LED off
+	LEDOff
+)
+
+// RGBA implements color.Color.
+func (c LEDColor) RGBA() (r, g, b, a uint32) {
+	switch c {
+	case LEDRed:
+		return color.RGBA{255, 60, 50, 255}.RGBA()
+	case LEDGreen:
+		return color.RGBA{170, 255, 147, 255}.RGBA()
+	case LEDYellow:
+		return color.RGBA{255, 230, 90, 255}.RGBA()
+	}
+	return offColor.RGBA()
+}
+
+// offColor is the color of a LED which is switched off.
+var offColor = color.RGBA{56, 56, 56, 255}
+
+// intensity returns how bright the LED is at the given time, between 0
+// and 1.
+func (btn *LedButton) intensity(now time.Time) float64 {
+	if !btn.state {
+		return 0
+	}
+
+	period := btn.period
+	if period <= 0 {
+		period = time.Second
+	}
+	phase := float64(now.UnixNano()%int64(period)) / float64(period)
+
+	switch btn.mode {
+	case Blink:
+		if phase < 0.5 {
+			return 1
+		}
+		return 0
+	case Pulse:
+		return 0.1 + 0.9*(0.5-0.5*math.Cos(2*math.Pi*phase))
+	case FlashOnce:
+		if now.Before(btn.flashUntil) {
+			return 1
+		}
+		return 0
+	}
+	return 1
+}
+
+// drawLED draws the LED as a horizontal bar at the top of img, with a glow
+// around it when lit. The geometry is relative to the image size.
+func drawLED(img *image.RGBA, c color.Color, intensity float64) {
+	b := img.Bounds()
+	size := float64(b.Dx())
+
+	// segment of the bar center line and its radius
+	x0, x1 := size*0.22, size*0.78
+	y := float64(b.Dy()) * 0.21
+	radius := size * 0.03
+	glow := size * 0.08
+
+	cr, cg, cb, _ := c.RGBA()
+	on := [3]float64{float64(cr >> 8), float64(cg >> 8), float64(cb >> 8)}
+	off := [3]float64{float64(offColor.R), float64(offColor.G), float64(offColor.B)}
+
+	var core [3]float64
+	for i := range core {
+		core[i] = off[i] + (on[i]-off[i])*intensity
+	}
+
+	for py := b.Min.Y; py < b.Max.Y; py++ {
+		for px := b.Min.X; px < b.Max.X; px++ {
+			// distance to the bar center line
+			fx, fy := float64(px-b.Min.X)+0.5, float64(py-b.Min.Y)+0.5
+			cx := math.Max(x0, math.Min(x1, fx))
+			d := math.Hypot(fx-cx, fy-y)
+
+			// coverage of the bar itself, anti-aliased over one pixel
+			cov := math.Max(0, math.Min(1, radius-d+0.5))
+			// glow around the bar, only when lit
+			g := 0.0
+			if d > radius {
+				g = 0.6 * intensity * math.Exp(-(d-radius)/glow*3)
+			}
+
+			a := cov + (1-cov)*g
+			if a <= 0 {
+				continue
+			}
+
+			var px3 [3]float64
+			for i := range px3 {
+				px3[i] = core[i]*cov + on[i]*(1-cov)*g
+			}
+			i := img.PixOffset(px, py)
+			for j := 0; j < 3; j++ {
+				img.Pix[i+j] = uint8(math.Min(255, px3[j]+float64(img.Pix[i+j])*(1-a)))
+			}
+			img.Pix[i+3] = 255
+		}
+	}
+}
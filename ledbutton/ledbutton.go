@@ -1,79 +1,50 @@
-//go:generate stringer -type=LEDColor
 package ledbutton
 
 import (
-	"bytes"
 	"fmt"
 	"image"
+	"image/color"
 	"image/draw"
-	"log"
+	"sync"
+	"time"
 
 	sd "github.com/KarpelesLab/streamdeck"
 	"github.com/KarpelesLab/streamdeck/label"
 	"github.com/golang/freetype"
 )
 
-// LedButton simulates a Button with a status LED.
+// LedButton simulates a Button with a status LED. The LED is drawn
+// procedurally, so it can have any color and the button any size.
 type LedButton struct {
-	streamDeck *sd.StreamDeck
-	ledColor   LEDColor
-	text       string
-	textColor  *image.Uniform
-	id         int
-	state      bool
-}
-
-// LEDColor is the type which defines the colors of the LED
-type LEDColor int
-
-const (
-	//LEDRed is a red LED
-	LEDRed LEDColor = iota
-	// LEDGreen is a green LED
-	LEDGreen
-	// LEDYellow is a yellow LED
-	LEDYellow
-	// LEDOff turns the LED off
-	LEDOff
-)
-
-var ledOff image.Image
-var ledGreen image.Image
-var ledYellow image.Image
-var ledRed image.Image
+	sd.Invalidator
+	mu          sync.Mutex
+	streamDeck  *sd.StreamDeck
+	ledColor    color.Color
+	text        string
+	textColor   *image.Uniform
+	id          int
+	state       bool
+	mode        Mode
+	period      time.Duration
+	flashUntil  time.Time
+	clock       *Clock
+	unsubscribe func()
+	hosted      bool // bound to a Host, which draws the button
+	level       int
+	onToggle    func(int, bool)
+}
 
-// in order to avoid the repetitive loading of the font and the LED pictures,
-// we load them during initalization into memory
-func init() {
-	var err error
+var _ sd.Element = (*LedButton)(nil)
 
-	ledOff, _, err = image.Decode(bytes.NewReader(ledOffBin))
-	if err != nil {
-		log.Panic(err)
-	}
-
-	ledGreen, _, err = image.Decode(bytes.NewReader(ledGreenBin))
-	if err != nil {
-		log.Panic(err)
-	}
-	ledYellow, _, err = image.Decode(bytes.NewReader(ledYellowBin))
-	if err != nil {
-		log.Panic(err)
-	}
-	ledRed, _, err = image.Decode(bytes.NewReader(ledRedBin))
-	if err != nil {
-		log.Panic(err)
-	}
-}
+// levels is the number of distinct LED intensities, used to avoid redrawing
+// an animated button when its appearance didn't change.
+const levels = 32
 
 // NewLedButton is the constructor for a new Led Button. Functional
-// arguments can be supplied to modify it's default characteristics
+// arguments can be supplied to modify it's default characteristics. The
+// StreamDeck may be nil if the button is only rendered through a Host or
+// RenderElement.
 func NewLedButton(sd *sd.StreamDeck, id int, options ...func(*LedButton)) (*LedButton, error) {
-
-	if sd == nil {
-		return nil, fmt.Errorf("stream deck must not be nil")
-	}
-
 	btn := &LedButton{
 		streamDeck: sd,
 		id:         id,
@@ -81,39 +52,176 @@ func NewLedButton(sd *sd.StreamDeck, id int, options ...func(*LedButton)) (*LedB
 		text:       "",
 		textColor:  image.White,
 		state:      false,
+		period:     time.Second,
+		clock:      DefaultClock,
 	}
 
 	for _, option := range options {
 		option(btn)
 	}
 
+	if btn.period <= 0 {
+		return nil, fmt.Errorf("invalid period %s", btn.period)
+	}
+	if btn.mode == FlashOnce && btn.state {
+		btn.flashUntil = time.Now().Add(btn.period)
+	}
+	btn.mu.Lock()
+	btn.updateSubscription()
+	btn.mu.Unlock()
+
 	return btn, nil
 }
 
+// Close stops the animation of the button, if any.
+func (btn *LedButton) Close() {
+	btn.mu.Lock()
+	defer btn.mu.Unlock()
+
+	if btn.unsubscribe != nil {
+		btn.unsubscribe()
+		btn.unsubscribe = nil
+	}
+}
+
 // State returns the state of the LED
 func (btn *LedButton) State() bool {
+	btn.mu.Lock()
+	defer btn.mu.Unlock()
 	return btn.state
 }
 
 // SetState sets the state of the LED and renders the Button.
 func (btn *LedButton) SetState(state bool) error {
+	btn.mu.Lock()
+	btn.setState(state)
+	btn.updateSubscription()
+	btn.mu.Unlock()
+	return btn.changed()
+}
+
+// setState must be called with the lock held.
+func (btn *LedButton) setState(state bool) {
+	if state && !btn.state && btn.mode == FlashOnce {
+		btn.flashUntil = time.Now().Add(btn.period)
+	}
 	btn.state = state
-	return btn.Draw()
 }
 
-// Change button state
+// SetColor sets the color of the LED and renders the Button.
+func (btn *LedButton) SetColor(c color.Color) error {
+	btn.mu.Lock()
+	btn.ledColor = c
+	btn.mu.Unlock()
+	return btn.changed()
+}
+
+// SetMode sets the behaviour of the LED when switched on, and the period of
+// the Blink, Pulse and FlashOnce modes.
+func (btn *LedButton) SetMode(mode Mode, period time.Duration) error {
+	if period <= 0 {
+		return fmt.Errorf("invalid period %s", period)
+	}
+
+	btn.mu.Lock()
+	btn.mode = mode
+	btn.period = period
+	if mode == FlashOnce && btn.state {
+		btn.flashUntil = time.Now().Add(period)
+	}
+	btn.updateSubscription()
+	btn.mu.Unlock()
+
+	return btn.changed()
+}
+
+// SetNotify sets the function called when the Button needs to be redrawn.
+// While it is set, the Button is bound to a Host and leaves the drawing to
+// it, even if it is attached to a stream deck.
+func (btn *LedButton) SetNotify(notify func()) {
+	btn.Invalidator.SetNotify(notify)
+	btn.mu.Lock()
+	btn.hosted = notify != nil
+	btn.updateSubscription()
+	btn.mu.Unlock()
+}
+
+// updateSubscription subscribes the button to its clock while the LED is
+// on, animated and displayed on a stream deck or by a Host, or unsubscribes
+// it otherwise. It must be called with the lock held.
+func (btn *LedButton) updateSubscription() {
+	animated := btn.mode != Steady && btn.state && (btn.streamDeck != nil || btn.hosted)
+	if animated && btn.unsubscribe == nil {
+		btn.unsubscribe = btn.clock.subscribe(btn.tick)
+	} else if !animated && btn.unsubscribe != nil {
+		btn.unsubscribe()
+		btn.unsubscribe = nil
+	}
+}
+
+// tick is called by the clock and redraws the button whenever the
+// brightness of the LED changed.
+func (btn *LedButton) tick(now time.Time) {
+	var cb func(int, bool)
+	btn.mu.Lock()
+	if btn.mode == FlashOnce && btn.state && !now.Before(btn.flashUntil) {
+		btn.state = false
+		btn.updateSubscription()
+		cb = btn.onToggle
+	}
+	level := int(btn.intensity(now) * levels)
+	changed := level != btn.level
+	btn.mu.Unlock()
+
+	if changed {
+		btn.changed()
+	}
+	if cb != nil {
+		// the flash is over, the LED switched itself off
+		cb(btn.id, false)
+	}
+}
+
+// Change toggles the LED when the button is pressed, redraws it and calls
+// the toggle callback.
 func (btn *LedButton) Change(state sd.BtnState) {
-	if state == sd.BtnPressed {
-		btn.state = !btn.state
+	if state != sd.BtnPressed {
+		return
+	}
+
+	btn.mu.Lock()
+	btn.setState(!btn.state)
+	btn.updateSubscription()
+	on := btn.state
+	cb := btn.onToggle
+	btn.mu.Unlock()
+
+	btn.changed()
+	if cb != nil {
+		cb(btn.id, on)
 	}
 }
 
+// Render draws the Button into img.
+func (btn *LedButton) Render(img *image.RGBA) error {
+	btn.mu.Lock()
+	defer btn.mu.Unlock()
+
+	intensity := btn.intensity(time.Now())
+	btn.level = int(intensity * levels)
+
+	draw.Draw(img, img.Bounds(), image.Black, image.Point{}, draw.Src)
+	drawLED(img, btn.ledColor, intensity)
+	return btn.addText(btn.text, img)
+}
+
 // Draw renders the Button
 func (btn *LedButton) Draw() error {
-
-	img := image.NewRGBA(image.Rect(0, 0, btn.streamDeck.Info.ButtonSize, btn.streamDeck.Info.ButtonSize))
-	btn.addLED(btn.ledColor, img)
-	if err := btn.addText(btn.text, img); err != nil {
+	if btn.streamDeck == nil {
+		return fmt.Errorf("led button is not attached to a stream deck")
+	}
+	img, err := sd.RenderElement(btn, btn.streamDeck.Info.ButtonSize)
+	if err != nil {
 		return err
 	}
 	return btn.streamDeck.FillImage(btn.id, img)
@@ -122,26 +230,25 @@ func (btn *LedButton) Draw() error {
 // SetText sets the text (max 5 Chars) on the LedButton. The result will be
 // rendered immediately.
 func (btn *LedButton) SetText(text string) error {
+	btn.mu.Lock()
 	btn.text = text
-	return btn.Draw()
+	btn.mu.Unlock()
+	return btn.changed()
 }
 
-func (btn *LedButton) addLED(color LEDColor, img *image.RGBA) {
-
-	if !btn.state {
-		draw.Draw(img, img.Bounds(), ledOff, image.ZP, draw.Src)
-		return
-	}
-
-	switch color {
-	case LEDRed:
-		draw.Draw(img, img.Bounds(), ledRed, image.ZP, draw.Src)
-	case LEDGreen:
-		draw.Draw(img, img.Bounds(), ledGreen, image.ZP, draw.Src)
-	case LEDYellow:
-		draw.Draw(img, img.Bounds(), ledYellow, image.ZP, draw.Src)
+// changed marks the button as dirty, which redraws it if it is bound to a
+// Host. Otherwise, if it is attached to a stream deck, it is redrawn right
+// away.
+func (btn *LedButton) changed() error {
+	btn.mu.Lock()
+	draw := btn.streamDeck != nil && !btn.hosted
+	btn.mu.Unlock()
+
+	btn.Invalidate()
+	if !draw {
+		return nil
 	}
-
+	return btn.Draw()
 }
 
 type textParams struct {
@@ -191,6 +298,8 @@ func (btn *LedButton) addText(text string, img *image.RGBA) error {
 	var p textParams
 
 	switch len(text) {
+	case 0:
+		return nil
 	case 1:
 		p = singleChar
 	case 2:
@@ -205,15 +314,19 @@ func (btn *LedButton) addText(text string, img *image.RGBA) error {
 		return fmt.Errorf("text line contains more than 5 characters")
 	}
 
+	// the text parameters are designed for 72px keys, scale them to the
+	// actual size
+	scale := float64(img.Bounds().Dx()) / 72
+
 	// create Context
 	c := freetype.NewContext()
 	c.SetDPI(72)
 	c.SetFont(label.MPlus1mMediumFont)
-	c.SetFontSize(p.fontSize)
+	c.SetFontSize(p.fontSize * scale)
 	c.SetClip(img.Bounds())
 	c.SetDst(img)
 	c.SetSrc(btn.textColor)
-	pt := freetype.Pt(p.posX, p.posY+int(c.PointToFixed(24)>>6))
+	pt := freetype.Pt(int(float64(p.posX)*scale), int(float64(p.posY+24)*scale))
 
 	if _, err := c.DrawString(text, pt); err != nil {
 		return err
@@ -0,0 +1,89 @@
+package ledbutton
+
+import (
+	"testing"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/mock"
+)
+
+// ticking tells whether the clock runs.
+func (c *Clock) ticking() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stop != nil
+}
+
+func TestTickOnlyWhenDisplayed(t *testing.T) {
+	clock := NewClock(time.Millisecond)
+	btn, err := NewLedButton(nil, 1, WithClock(clock), Blinking(100*time.Millisecond), State(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btn.Close()
+	if clock.ticking() {
+		t.Error("ticking for a button displayed nowhere")
+	}
+
+	dev, _ := mock.Open(t, sd.LookupDevice(0x0063), "TEST0001")
+	h := sd.NewHost(dev)
+	defer h.Close()
+
+	if err := h.Bind(1, btn); err != nil {
+		t.Fatal(err)
+	}
+	if !clock.ticking() {
+		t.Error("not ticking for a bound button")
+	}
+	btn.SetState(false)
+	if clock.ticking() {
+		t.Error("ticking for a button switched off")
+	}
+	btn.SetState(true)
+	if !clock.ticking() {
+		t.Error("not ticking once switched on again")
+	}
+	h.Unbind(1)
+	if clock.ticking() {
+		t.Error("ticking after Unbind")
+	}
+}
+
+func TestFlashOnceToggle(t *testing.T) {
+	dev, _ := mock.Open(t, sd.LookupDevice(0x0063), "TEST0001")
+
+	clock := NewClock(time.Millisecond)
+	toggles := make(chan bool, 4)
+	btn, err := NewLedButton(dev, 2, WithClock(clock), Flashing(20*time.Millisecond),
+		OnToggle(func(id int, on bool) {
+			if id != 2 {
+				t.Errorf("toggled %d", id)
+			}
+			toggles <- on
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btn.Close()
+
+	btn.Change(sd.BtnPressed)
+	for _, want := range []bool{true, false} {
+		select {
+		case on := <-toggles:
+			if on != want {
+				t.Errorf("toggled to %v, want %v", on, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("not toggled to %v", want)
+		}
+	}
+	if btn.State() || clock.ticking() {
+		t.Errorf("after the flash: state %v, ticking %v", btn.State(), clock.ticking())
+	}
+	select {
+	case on := <-toggles:
+		t.Errorf("toggled again to %v", on)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -1,6 +1,10 @@
 package ledbutton
 
-import "image"
+import (
+	"image"
+	"image/color"
+	"time"
+)
 
 // TextColor is a functional option which sets the text color.
 func TextColor(c image.Uniform) func(*LedButton) {
@@ -9,13 +13,21 @@ func TextColor(c image.Uniform) func(*LedButton) {
 	}
 }
 
-// LedColor is a functional option to set the color of the LED.
+// LedColor is a functional option to set the color of the LED to one of
+// the presets.
 func LedColor(color LEDColor) func(*LedButton) {
 	return func(btn *LedButton) {
 		btn.ledColor = color
 	}
 }
 
+// Color is a functional option to set the color of the LED to any color.
+func Color(c color.Color) func(*LedButton) {
+	return func(btn *LedButton) {
+		btn.ledColor = c
+	}
+}
+
 // Text is a functional option for providing the initial text on the LED Button.
 // Max 5 characters.
 func Text(text string) func(*LedButton) {
@@ -23,3 +35,53 @@ func Text(text string) func(*LedButton) {
 		btn.text = text
 	}
 }
+
+// State is a functional option setting the initial state of the LED.
+func State(on bool) func(*LedButton) {
+	return func(btn *LedButton) {
+		btn.setState(on)
+	}
+}
+
+// Blinking is a functional option which makes the LED blink with the given
+// period when it is on.
+func Blinking(period time.Duration) func(*LedButton) {
+	return func(btn *LedButton) {
+		btn.mode = Blink
+		btn.period = period
+	}
+}
+
+// Pulsing is a functional option which makes the LED pulse with the given
+// period when it is on.
+func Pulsing(period time.Duration) func(*LedButton) {
+	return func(btn *LedButton) {
+		btn.mode = Pulse
+		btn.period = period
+	}
+}
+
+// Flashing is a functional option which makes the LED light up for the
+// given duration each time it is switched on.
+func Flashing(duration time.Duration) func(*LedButton) {
+	return func(btn *LedButton) {
+		btn.mode = FlashOnce
+		btn.period = duration
+	}
+}
+
+// WithClock is a functional option to drive the animations of the button
+// with another clock than DefaultClock.
+func WithClock(c *Clock) func(*LedButton) {
+	return func(btn *LedButton) {
+		btn.clock = c
+	}
+}
+
+// OnToggle is a functional option which sets the function called when the
+// LED is toggled by pressing the button.
+func OnToggle(cb func(id int, on bool)) func(*LedButton) {
+	return func(btn *LedButton) {
+		btn.onToggle = cb
+	}
+}
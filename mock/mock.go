@@ -0,0 +1,417 @@
+// Package mock provides a Stream Deck which exists only in memory. It
+// implements streamdeck.Transport and decodes what the library sends, so
+// programs and tests can run without hardware and check what would be
+// displayed.
+package mock
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"sync"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"golang.org/x/image/bmp"
+)
+
+// ErrClosed is returned once the Device is closed.
+var ErrClosed = errors.New("mock: device closed")
+
+// Device is an emulated Stream Deck.
+type Device struct {
+	mu         sync.Mutex
+	model      *sd.StreamdeckDevice
+	serial     string
+	firmware   string
+	brightness uint8
+	resets     int
+	keys       []*image.RGBA
+	pressed    []bool
+	// image being received for each key
+	pending map[int]*upload
+	input   chan []byte
+	closed  chan struct{}
+	onDraw  func(btnIndex int)
+}
+
+var _ sd.Transport = (*Device)(nil)
+
+// upload is an image being received, page by page.
+type upload struct {
+	buf  bytes.Buffer
+	next int // number of the next page expected
+}
+
+// New creates a Device of the given model, with all keys black.
+func New(model *sd.StreamdeckDevice, serial string) *Device {
+	d := &Device{
+		model:      model,
+		serial:     serial,
+		firmware:   "1.00.000",
+		brightness: 100,
+		keys:       make([]*image.RGBA, model.NumButtons),
+		pressed:    make([]bool, model.NumButtons),
+		pending:    make(map[int]*upload),
+		input:      make(chan []byte, 64),
+		closed:     make(chan struct{}),
+	}
+	for i := range d.keys {
+		d.keys[i] = image.NewRGBA(image.Rect(0, 0, model.ButtonSize, model.ButtonSize))
+		draw.Draw(d.keys[i], d.keys[i].Bounds(), image.Black, image.Point{}, draw.Src)
+	}
+	return d
+}
+
+// Model returns the model emulated by the Device.
+func (d *Device) Model() *sd.StreamdeckDevice {
+	return d.model
+}
+
+// SetFirmware sets the firmware version reported by the Device.
+func (d *Device) SetFirmware(version string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.firmware = version
+}
+
+// OnDraw sets a function called after a key image was received.
+func (d *Device) OnDraw(f func(btnIndex int)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.onDraw = f
+}
+
+// Write receives an output report. Key images are sent as a series of
+// 1024 byte pages. With ProtocolV1, a page is a 16 byte header (0x02, 0x01,
+// page number, 0, last page flag, key + 1) followed by a chunk of a BMP
+// file. With ProtocolV2, it is an 8 byte header (0x02, 0x07, key, last page
+// flag, chunk length and page number as little endian 16 bit integers)
+// followed by a chunk of a JPEG file.
+func (d *Device) Write(data []byte, timeout time.Duration) (int, error) {
+	select {
+	case <-d.closed:
+		return 0, ErrClosed
+	default:
+	}
+
+	var page, key int
+	var last bool
+	var chunk []byte
+	switch {
+	case d.model.Protocol == sd.ProtocolV2 && len(data) >= 8 && data[0] == 0x02 && data[1] == 0x07:
+		page, last, key = int(binary.LittleEndian.Uint16(data[6:])), data[3] != 0, int(data[2])
+		n := int(binary.LittleEndian.Uint16(data[4:]))
+		if n > len(data)-8 {
+			return 0, fmt.Errorf("mock: chunk of %d bytes in a %d byte report", n, len(data))
+		}
+		chunk = data[8 : 8+n]
+	case d.model.Protocol == sd.ProtocolV1 && len(data) >= 16 && data[0] == 0x02 && data[1] == 0x01:
+		page, last, key = int(data[2]), data[4] != 0, int(data[5])-1
+		chunk = data[16:]
+	default:
+		return 0, fmt.Errorf("mock: unexpected output report % x", head(data))
+	}
+	if key < 0 || key >= d.model.NumButtons {
+		return 0, fmt.Errorf("mock: invalid key %d", key)
+	}
+	key = d.model.DeviceKey(key)
+
+	d.mu.Lock()
+	up := d.pending[key]
+	switch {
+	case page == 0:
+		up = &upload{}
+		d.pending[key] = up
+	case up == nil:
+		d.mu.Unlock()
+		return 0, fmt.Errorf("mock: key %d: page %d without page 0", key, page)
+	case page != up.next:
+		delete(d.pending, key)
+		d.mu.Unlock()
+		return 0, fmt.Errorf("mock: key %d: page %d instead of page %d", key, page, up.next)
+	}
+	up.buf.Write(chunk)
+	up.next++
+	if !last {
+		d.mu.Unlock()
+		return len(data), nil
+	}
+	delete(d.pending, key)
+	d.mu.Unlock()
+	buf := &up.buf
+
+	decode := decodeKey
+	if d.model.Protocol == sd.ProtocolV2 {
+		decode = decodeJPEGKey
+	}
+	img, err := decode(buf.Bytes(), d.model.ButtonSize)
+	if err != nil {
+		return 0, fmt.Errorf("mock: key %d: %w", key, err)
+	}
+
+	d.mu.Lock()
+	d.keys[key] = img
+	onDraw := d.onDraw
+	d.mu.Unlock()
+
+	if onDraw != nil {
+		onDraw(key)
+	}
+	return len(data), nil
+}
+
+// decodeKey decodes the bitmap of a key. Pages are zero padded, and the
+// bitmap is rotated by the library, which is undone here.
+func decodeKey(data []byte, size int) (*image.RGBA, error) {
+	src, err := bmp.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	b := src.Bounds()
+	if b.Dx() != size || b.Dy() != size {
+		return nil, fmt.Errorf("bitmap is %dx%d, expected %dx%d", b.Dx(), b.Dy(), size, size)
+	}
+
+	// the library rotates the image by 270°, which together with the
+	// bottom-up rows of the bitmap amounts to a transposition
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			img.Set(x, y, src.At(b.Min.X+y, b.Min.Y+x))
+		}
+	}
+	return img, nil
+}
+
+// decodeJPEGKey decodes the JPEG image of a key, which the library flips
+// for the device.
+func decodeJPEGKey(data []byte, size int) (*image.RGBA, error) {
+	src, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	b := src.Bounds()
+	if b.Dx() != size || b.Dy() != size {
+		return nil, fmt.Errorf("image is %dx%d, expected %dx%d", b.Dx(), b.Dy(), size, size)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			img.Set(x, y, src.At(b.Max.X-1-x, b.Max.Y-1-y))
+		}
+	}
+	return img, nil
+}
+
+// ReadInputPacket returns the next key state report, once a key is pressed
+// or released.
+func (d *Device) ReadInputPacket(timeout time.Duration) ([]byte, error) {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	select {
+	case data := <-d.input:
+		return data, nil
+	case <-d.closed:
+		return nil, ErrClosed
+	case <-t.C:
+		return nil, fmt.Errorf("mock: timeout")
+	}
+}
+
+// SetFeatureReport handles the reset and brightness reports.
+func (d *Device) SetFeatureReport(report int, data []byte) error {
+	select {
+	case <-d.closed:
+		return ErrClosed
+	default:
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	v2 := d.model.Protocol == sd.ProtocolV2
+	switch {
+	case !v2 && len(data) >= 2 && data[0] == 0x0b && data[1] == 0x63,
+		v2 && len(data) >= 2 && data[0] == 0x03 && data[1] == 0x02:
+		d.resets++
+		for _, img := range d.keys {
+			draw.Draw(img, img.Bounds(), image.Black, image.Point{}, draw.Src)
+		}
+		d.pending = make(map[int]*upload)
+	case !v2 && len(data) >= 6 && data[0] == 0x05 && data[1] == 0x55 && data[2] == 0xaa && data[3] == 0xd1 && data[4] == 0x01:
+		d.brightness = data[5]
+	case v2 && len(data) >= 3 && data[0] == 0x03 && data[1] == 0x08:
+		d.brightness = data[2]
+	default:
+		return fmt.Errorf("mock: unexpected feature report % x", head(data))
+	}
+	return nil
+}
+
+// GetFeatureReport returns the serial number (report 3, or 6 with
+// ProtocolV2) or the firmware version (report 4, or 5 with ProtocolV2).
+func (d *Device) GetFeatureReport(report int) ([]byte, error) {
+	select {
+	case <-d.closed:
+		return nil, ErrClosed
+	default:
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.model.Protocol == sd.ProtocolV2 {
+		res := make([]byte, 32)
+		res[0] = byte(report)
+		switch report {
+		case 6:
+			res[1] = byte(len(d.serial))
+			copy(res[2:], d.serial)
+		case 5:
+			res[1] = byte(len(d.firmware))
+			copy(res[6:], d.firmware)
+		default:
+			return nil, fmt.Errorf("mock: unexpected feature report %d", report)
+		}
+		return res, nil
+	}
+
+	var s string
+	switch report {
+	case 3:
+		s = d.serial
+	case 4:
+		s = d.firmware
+	default:
+		return nil, fmt.Errorf("mock: unexpected feature report %d", report)
+	}
+	res := make([]byte, 17)
+	res[0] = byte(report)
+	copy(res[5:], s)
+	return res, nil
+}
+
+// Close closes the Device. Pending and later reads fail.
+func (d *Device) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	select {
+	case <-d.closed:
+	default:
+		close(d.closed)
+	}
+	return nil
+}
+
+// Press presses a key.
+func (d *Device) Press(btnIndex int) error {
+	return d.set(btnIndex, true)
+}
+
+// Release releases a key.
+func (d *Device) Release(btnIndex int) error {
+	return d.set(btnIndex, false)
+}
+
+// Click presses and releases a key.
+func (d *Device) Click(btnIndex int) error {
+	if err := d.Press(btnIndex); err != nil {
+		return err
+	}
+	return d.Release(btnIndex)
+}
+
+func (d *Device) set(btnIndex int, pressed bool) error {
+	if btnIndex < 0 || btnIndex >= d.model.NumButtons {
+		return fmt.Errorf("mock: invalid key %d", btnIndex)
+	}
+
+	d.mu.Lock()
+	d.pressed[btnIndex] = pressed
+	header := 1
+	if d.model.Protocol == sd.ProtocolV2 {
+		header = 4
+	}
+	report := make([]byte, header+d.model.NumButtons)
+	report[0] = 0x01
+	if header == 4 {
+		report[2] = byte(d.model.NumButtons)
+	}
+	for i, p := range d.pressed {
+		if p {
+			report[header+d.model.DeviceKey(i)] = 1
+		}
+	}
+	d.mu.Unlock()
+
+	// Checked first so that a buffered input channel cannot win the
+	// select below on a closed device.
+	select {
+	case <-d.closed:
+		return ErrClosed
+	default:
+	}
+	select {
+	case d.input <- report:
+		return nil
+	case <-d.closed:
+		return ErrClosed
+	}
+}
+
+// Key returns a copy of the image displayed on a key.
+func (d *Device) Key(btnIndex int) image.Image {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	src := d.keys[btnIndex]
+	img := image.NewRGBA(src.Bounds())
+	draw.Draw(img, img.Bounds(), src, image.Point{}, draw.Src)
+	return img
+}
+
+// Panel returns an image of the whole panel, with the keys laid out as by
+// StreamDeck.FillPanel and the space between them black.
+func (d *Device) Panel() *image.RGBA {
+	m := d.model
+	img := image.NewRGBA(image.Rect(0, 0, m.PanelWidth(), m.PanelHeight()))
+	draw.Draw(img, img.Bounds(), image.Black, image.Point{}, draw.Src)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i, key := range d.keys {
+		draw.Draw(img, m.KeyRect(i), key, image.Point{}, draw.Src)
+	}
+	return img
+}
+
+// Brightness returns the brightness set by the library, in percent.
+func (d *Device) Brightness() uint8 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.brightness
+}
+
+// Resets returns the number of times the device was reset.
+func (d *Device) Resets() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.resets
+}
+
+// head returns the beginning of a report, for error messages.
+func head(data []byte) []byte {
+	if len(data) > 8 {
+		return data[:8]
+	}
+	return data
+}
@@ -0,0 +1,84 @@
+package mock
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+)
+
+// recorder keeps a copy of the reports written to a Device.
+type recorder struct {
+	*Device
+	reports [][]byte
+}
+
+func (r *recorder) Write(data []byte, timeout time.Duration) (int, error) {
+	r.reports = append(r.reports, append([]byte(nil), data...))
+	return r.Device.Write(data, timeout)
+}
+
+// pages returns the reports sent by the library to draw a key. The image
+// is noise, so that it takes several pages even as a JPEG, except for its
+// red top left corner.
+func pages(t *testing.T, model *sd.StreamdeckDevice) [][]byte {
+	t.Helper()
+	size := model.ButtonSize
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+	draw.Draw(img, image.Rect(0, 0, 16, 16), image.NewUniform(color.RGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+
+	r := &recorder{Device: New(model, "TEST0001")}
+	dev := sd.Attach(r, model)
+	defer dev.Close()
+	if err := dev.FillImage(1, img); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.reports) < 3 {
+		t.Fatalf("image sent in %d pages", len(r.reports))
+	}
+	return r.reports
+}
+
+func TestPageOrder(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	for _, id := range []uint16{0x0063, 0x006c} {
+		model := sd.LookupDevice(id)
+		p := pages(t, model)
+		n := len(p)
+
+		for _, c := range []struct {
+			name  string
+			order []int
+			err   string
+		}{
+			{"skipped", []int{0, 2}, "page 2 instead of page 1"},
+			{"repeated", []int{0, 1, 1}, "page 1 instead of page 2"},
+			{"no first page", []int{1}, "page 1 without page 0"},
+		} {
+			d := New(model, "TEST0001")
+			var err error
+			for _, i := range c.order {
+				if _, err = d.Write(p[i], time.Second); err != nil {
+					break
+				}
+			}
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s, %s: got error %v, want %q", model.Name, c.name, err, c.err)
+			}
+
+			// The next image is received correctly.
+			for i := range p {
+				if _, err := d.Write(p[i], time.Second); err != nil {
+					t.Fatalf("%s, %s: page %d of %d: %v", model.Name, c.name, i, n, err)
+				}
+			}
+			d.WaitColor(t, 1, red)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package mock
+
+import (
+	"image/color"
+	"testing"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+)
+
+// Open creates a Device of the given model and opens it with sd.Open, for
+// use in tests. The StreamDeck is closed when the test ends.
+func Open(t testing.TB, model *sd.StreamdeckDevice, serial string) (*sd.StreamDeck, *Device) {
+	t.Helper()
+	m := New(model, serial)
+	dev, err := sd.Open(m, model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dev.Close() })
+	return dev, m
+}
+
+// WaitColor waits up to 5 seconds for the top left corner of a key to have
+// the color c, and fails the test otherwise. With ProtocolV2 models, which
+// receive JPEG images, small differences are accepted.
+func (d *Device) WaitColor(t testing.TB, btnIndex int, c color.Color) {
+	t.Helper()
+	want := color.RGBAModel.Convert(c).(color.RGBA)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := color.RGBAModel.Convert(d.Key(btnIndex).At(4, 4)).(color.RGBA)
+		if d.sameColor(got, want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("key %d is %v, want %v", btnIndex, got, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// sameColor compares a color read back from a key to the expected one.
+func (d *Device) sameColor(a, b color.RGBA) bool {
+	if d.model.Protocol != sd.ProtocolV2 {
+		return a == b
+	}
+	near := func(x, y uint8) bool {
+		diff := int(x) - int(y)
+		return diff > -8 && diff < 8
+	}
+	return near(a.R, b.R) && near(a.G, b.G) && near(a.B, b.B) && a.A == b.A
+}
@@ -0,0 +1,418 @@
+// Package mqtt bridges Stream Decks to MQTT brokers, for home automation
+// and industrial setups.
+//
+// For a deck with serial number SERIAL and the default "streamdeck" prefix,
+// the Bridge publishes:
+//
+//	streamdeck/SERIAL/status       "online", or "offline" (retained)
+//	streamdeck/SERIAL/key/N        "pressed", "released" and "long_press"
+//	streamdeck/SERIAL/brightness   the brightness (retained)
+//	streamdeck/SERIAL/page         the current page, with a profile (retained)
+//
+// and subscribes to:
+//
+//	streamdeck/SERIAL/key/N/set/text         text of key N
+//	streamdeck/SERIAL/key/N/set/color        background color, e.g. "red", "#ff8000"
+//	streamdeck/SERIAL/key/N/set/text_color   text color
+//	streamdeck/SERIAL/key/N/set/image        image in base64, or the path of a file
+//	streamdeck/SERIAL/key/N/set/clear        any payload blanks the key
+//	streamdeck/SERIAL/brightness/set         brightness, 0 to 100
+//	streamdeck/SERIAL/page/set               page name, or "back"
+//
+// With Discovery, each key also shows up in Home Assistant as device
+// triggers.
+//
+// The package includes a minimal MQTT 3.1.1 client, and a broker for tests
+// and setups without one.
+package mqtt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	_ "image/gif"  // image formats accepted for keys
+	_ "image/jpeg" // image formats accepted for keys
+	_ "image/png"  // image formats accepted for keys
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/page"
+	"github.com/KarpelesLab/streamdeck/profile"
+	"github.com/KarpelesLab/streamdeck/tile"
+)
+
+// DefaultPrefix is the topic prefix used by default.
+const DefaultPrefix = "streamdeck"
+
+// Payloads of the key topics.
+const (
+	Pressed   = "pressed"
+	Released  = "released"
+	LongPress = "long_press"
+)
+
+// Bridge publishes the events of a deck and displays what it receives.
+type Bridge struct {
+	client    *Client
+	dev       *sd.StreamDeck
+	serial    string
+	firmware  string
+	prefix    string
+	discovery string
+	longPress time.Duration
+	profile   string
+	onError   func(error)
+
+	surface *tap
+	host    *sd.Host
+	runtime *profile.Runtime
+	filters []string // subscribed to
+	unhook  func()   // unregisters from OnConnect
+
+	mu     sync.Mutex
+	tiles  map[int]*tile.Tile
+	timers map[int]*time.Timer
+}
+
+// Prefix sets the prefix of the topics, DefaultPrefix by default.
+func Prefix(prefix string) func(*Bridge) {
+	return func(b *Bridge) {
+		b.prefix = prefix
+	}
+}
+
+// Discovery publishes Home Assistant discovery messages under the given
+// prefix, usually "homeassistant".
+func Discovery(prefix string) func(*Bridge) {
+	return func(b *Bridge) {
+		b.discovery = prefix
+	}
+}
+
+// LongPressAfter sets how long a key must be held to publish a long press,
+// 500ms by default.
+func LongPressAfter(d time.Duration) func(*Bridge) {
+	return func(b *Bridge) {
+		b.longPress = d
+	}
+}
+
+// Profile displays the profile file at path on the deck. Keys set over
+// MQTT replace those of the current page.
+func Profile(path string) func(*Bridge) {
+	return func(b *Bridge) {
+		b.profile = path
+	}
+}
+
+// OnBridgeError sets a function called with the errors of the commands
+// received.
+func OnBridgeError(f func(error)) func(*Bridge) {
+	return func(b *Bridge) {
+		b.onError = f
+	}
+}
+
+// StatusTopic returns the topic of the status of a deck, for the Will of
+// the Client.
+func StatusTopic(prefix, serial string) string {
+	return prefix + "/" + serial + "/status"
+}
+
+// NewBridge bridges dev to the broker c is connected to.
+func NewBridge(c *Client, dev *sd.StreamDeck, options ...func(*Bridge)) (*Bridge, error) {
+	serial, err := dev.GetSerialNumber()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read serial number: %w", err)
+	}
+	firmware, _ := dev.GetFirmwareVersion()
+
+	b := &Bridge{
+		client:    c,
+		dev:       dev,
+		serial:    serial,
+		firmware:  firmware,
+		prefix:    DefaultPrefix,
+		longPress: 500 * time.Millisecond,
+		surface:   &tap{StreamDeck: dev},
+		tiles:     make(map[int]*tile.Tile),
+		timers:    make(map[int]*time.Timer),
+	}
+	for _, option := range options {
+		option(b)
+	}
+
+	if b.profile != "" {
+		b.runtime, err = profile.Run(b.profile, b.surface, serial)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		b.host = sd.NewHost(b.surface)
+	}
+	dev.SetBtnEventCb(b.event)
+
+	base := b.topic("")
+	subs := map[string]func(*Message){
+		base + "key/+/set/+":    b.setKey,
+		base + "brightness/set": b.setBrightness,
+		base + "page/set":       b.setPage,
+	}
+	for filter, handler := range subs {
+		b.filters = append(b.filters, filter)
+		if err := c.Subscribe(filter, handler); err != nil {
+			b.Close()
+			return nil, err
+		}
+	}
+
+	b.unhook = c.OnConnect(func() { b.announce() })
+	if err := b.announce(); err != nil {
+		b.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// Serial returns the serial number of the deck.
+func (b *Bridge) Serial() string {
+	return b.serial
+}
+
+// topic returns the topic of the deck with the given suffix.
+func (b *Bridge) topic(suffix string) string {
+	return b.prefix + "/" + b.serial + "/" + suffix
+}
+
+// announce publishes the retained state of the deck and the discovery
+// messages.
+func (b *Bridge) announce() error {
+	if err := b.client.Publish(StatusTopic(b.prefix, b.serial), []byte("online"), true); err != nil {
+		return err
+	}
+	b.publishPage()
+	if b.discovery != "" {
+		return b.publishDiscovery()
+	}
+	return nil
+}
+
+func (b *Bridge) publishPage() {
+	if b.runtime != nil {
+		b.client.Publish(b.topic("page"), []byte(b.runtime.Deck().Current().Name()), true)
+	}
+}
+
+func (b *Bridge) event(btnIndex int, state sd.BtnState) {
+	topic := b.topic("key/" + strconv.Itoa(btnIndex))
+
+	b.mu.Lock()
+	if t, ok := b.timers[btnIndex]; ok {
+		t.Stop()
+		delete(b.timers, btnIndex)
+	}
+	if state == sd.BtnPressed && b.longPress > 0 {
+		b.timers[btnIndex] = time.AfterFunc(b.longPress, func() {
+			b.client.Publish(topic, []byte(LongPress), false)
+		})
+	}
+	b.mu.Unlock()
+
+	payload := Released
+	if state == sd.BtnPressed {
+		payload = Pressed
+	}
+	b.client.Publish(topic, []byte(payload), false)
+	b.surface.event(btnIndex, state)
+}
+
+// tile returns the tile displayed on a key by the bridge, binding a new
+// one if needed.
+func (b *Bridge) tile(btnIndex int) (*tile.Tile, error) {
+	b.mu.Lock()
+	t, ok := b.tiles[btnIndex]
+	if !ok {
+		t = tile.New()
+		b.tiles[btnIndex] = t
+	}
+	b.mu.Unlock()
+
+	if b.runtime != nil {
+		// The page may have changed since the tile was bound.
+		cur := b.runtime.Deck().Current()
+		if k := cur.Key(btnIndex); ok && k != nil && k.Element == t {
+			return t, nil
+		}
+		cur.SetKey(btnIndex, &page.Key{Element: t})
+		return t, nil
+	}
+	if ok {
+		return t, nil
+	}
+	return t, b.host.Bind(btnIndex, t)
+}
+
+func (b *Bridge) setKey(m *Message) {
+	// <prefix>/<serial>/key/<n>/set/<field>
+	levels := strings.Split(strings.TrimPrefix(m.Topic, b.topic("key/")), "/")
+	if len(levels) != 3 {
+		return
+	}
+	n, err := strconv.Atoi(levels[0])
+	if err != nil || n < 0 || n >= b.dev.NumButtons() {
+		b.error(fmt.Errorf("%s: invalid key", m.Topic))
+		return
+	}
+	if err := b.set(n, levels[2], string(m.Payload)); err != nil {
+		b.error(fmt.Errorf("%s: %w", m.Topic, err))
+	}
+}
+
+// set changes a field of a key.
+func (b *Bridge) set(btnIndex int, field, payload string) error {
+	if field == "clear" {
+		b.mu.Lock()
+		delete(b.tiles, btnIndex)
+		b.mu.Unlock()
+		if b.runtime != nil {
+			b.runtime.Deck().Current().SetKey(btnIndex, nil)
+			return nil
+		}
+		b.host.Unbind(btnIndex)
+		return b.dev.ClearBtn(btnIndex)
+	}
+
+	t, err := b.tile(btnIndex)
+	if err != nil {
+		return err
+	}
+	switch field {
+	case "text":
+		t.SetText(payload)
+	case "color", "text_color":
+		c, err := profile.ParseColor(strings.TrimSpace(payload))
+		if err != nil {
+			return err
+		}
+		if field == "color" {
+			t.SetBgColor(c)
+		} else {
+			t.SetTextColor(c)
+		}
+	case "image":
+		payload = strings.TrimSpace(payload)
+		if payload == "" {
+			t.SetImage(nil)
+			return nil
+		}
+		if data, err := base64.StdEncoding.DecodeString(payload); err == nil {
+			if img, _, err := image.Decode(bytes.NewReader(data)); err == nil {
+				t.SetImage(img)
+				return nil
+			}
+		}
+		return t.SetImageFile(payload)
+	default:
+		return fmt.Errorf("unknown field %q", field)
+	}
+	return nil
+}
+
+func (b *Bridge) setBrightness(m *Message) {
+	pc, err := strconv.Atoi(strings.TrimSpace(string(m.Payload)))
+	if err != nil || pc < 0 || pc > 100 {
+		b.error(fmt.Errorf("%s: brightness must be between 0 and 100", m.Topic))
+		return
+	}
+	if err := b.dev.SetBrightness(uint8(pc)); err != nil {
+		b.error(err)
+		return
+	}
+	b.client.Publish(b.topic("brightness"), []byte(strconv.Itoa(pc)), true)
+}
+
+func (b *Bridge) setPage(m *Message) {
+	if b.runtime == nil {
+		b.error(fmt.Errorf("%s: no profile loaded", m.Topic))
+		return
+	}
+	name := strings.TrimSpace(string(m.Payload))
+	var err error
+	if name == "back" {
+		err = b.runtime.Deck().Back()
+	} else {
+		err = b.runtime.Deck().SwitchToName(name)
+	}
+	if err != nil {
+		b.error(fmt.Errorf("%s: %w", m.Topic, err))
+		return
+	}
+	b.publishPage()
+}
+
+func (b *Bridge) error(err error) {
+	if b.onError != nil {
+		b.onError(err)
+	}
+}
+
+// Close publishes that the deck is offline, unsubscribes from its topics
+// and detaches from it. The Client and the deck are left open.
+func (b *Bridge) Close() error {
+	if b.unhook != nil {
+		b.unhook()
+	}
+	var firstErr error
+	for _, filter := range b.filters {
+		if err := b.client.Unsubscribe(filter); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	b.dev.SetBtnEventCb(nil)
+	b.mu.Lock()
+	for i, t := range b.timers {
+		t.Stop()
+		delete(b.timers, i)
+	}
+	b.mu.Unlock()
+
+	if b.runtime != nil {
+		b.runtime.Close()
+	}
+	if b.host != nil {
+		b.host.Close()
+	}
+	if err := b.client.Publish(StatusTopic(b.prefix, b.serial), []byte("offline"), true); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+// tap is the surface given to the Host or profile of a Bridge. It keeps
+// the callback they set, so the Bridge sees events first.
+type tap struct {
+	*sd.StreamDeck
+	mu sync.Mutex
+	cb sd.BtnEvent
+}
+
+func (t *tap) SetBtnEventCb(ev sd.BtnEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cb = ev
+}
+
+func (t *tap) event(btnIndex int, state sd.BtnState) {
+	t.mu.Lock()
+	cb := t.cb
+	t.mu.Unlock()
+
+	if cb != nil {
+		cb(btnIndex, state)
+	}
+}
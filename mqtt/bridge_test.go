@@ -0,0 +1,415 @@
+package mqtt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/internal/testutil"
+	"github.com/KarpelesLab/streamdeck/mock"
+)
+
+const serial = "TEST0001"
+
+var (
+	black = color.RGBA{0, 0, 0, 255}
+	red   = color.RGBA{255, 0, 0, 255}
+	blue  = color.RGBA{0, 0, 255, 255}
+)
+
+// env is a deck bridged to an embedded broker, and a client watching the
+// topics of the deck.
+type env struct {
+	broker *Broker
+	addr   string
+	mock   *mock.Device
+	bridge *Bridge
+	ctl    *Client
+	msgs   chan *Message
+	errs   chan error
+}
+
+func newEnv(t *testing.T, options ...func(*Bridge)) *env {
+	t.Helper()
+	e := &env{
+		broker: NewBroker(),
+		msgs:   make(chan *Message, 256),
+		errs:   make(chan error, 16),
+	}
+	var err error
+	if e.addr, err = e.broker.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { e.broker.Close() })
+
+	var dev *sd.StreamDeck
+	dev, e.mock = mock.Open(t, sd.LookupDevice(0x0063), serial)
+
+	c, err := Dial(e.addr, ClientID("deck"), Will(StatusTopic(DefaultPrefix, serial), []byte("offline"), true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	options = append([]func(*Bridge){OnBridgeError(func(err error) { e.errs <- err })}, options...)
+	if e.bridge, err = NewBridge(c, dev, options...); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { e.bridge.Close() })
+
+	e.ctl = e.watch(t)
+	return e
+}
+
+// watch connects a client to the broker, which forwards the messages of
+// the deck to e.msgs.
+func (e *env) watch(t *testing.T) *Client {
+	t.Helper()
+	c, err := Dial(e.addr, ClientID("controller"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	if err := c.Subscribe(DefaultPrefix+"/"+serial+"/#", func(m *Message) { e.msgs <- m }); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// expect waits for a message, skipping the others.
+func (e *env) expect(t *testing.T, topic, payload string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case m := <-e.msgs:
+			if m.Topic == topic && string(m.Payload) == payload {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("no %q on %s", payload, topic)
+		}
+	}
+}
+
+func (e *env) publish(t *testing.T, suffix, payload string) {
+	t.Helper()
+	if err := e.ctl.Publish(DefaultPrefix+"/"+serial+"/"+suffix, []byte(payload), false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBridgeStatus(t *testing.T) {
+	e := newEnv(t)
+	e.expect(t, "streamdeck/"+serial+"/status", "online")
+
+	e.bridge.Close()
+	e.expect(t, "streamdeck/"+serial+"/status", "offline")
+}
+
+func TestBridgeSetKey(t *testing.T) {
+	e := newEnv(t)
+
+	e.publish(t, "key/1/set/color", "#ff0000")
+	e.mock.WaitColor(t, 1, red)
+
+	e.publish(t, "key/1/set/text", "hello")
+	e.publish(t, "key/1/set/text_color", "yellow")
+	deadline := time.Now().Add(5 * time.Second)
+	for !hasColor(e.mock.Key(1), color.RGBA{255, 255, 0, 255}) {
+		if time.Now().After(deadline) {
+			t.Fatal("no yellow text on key 1")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	var buf bytes.Buffer
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	draw.Draw(img, img.Bounds(), image.NewUniform(blue), image.Point{}, draw.Src)
+	png.Encode(&buf, img)
+	e.publish(t, "key/2/set/image", base64.StdEncoding.EncodeToString(buf.Bytes()))
+	e.mock.WaitColor(t, 2, blue)
+
+	// Images can also be given as files.
+	path := filepath.Join(t.TempDir(), "red.png")
+	buf.Reset()
+	draw.Draw(img, img.Bounds(), image.NewUniform(red), image.Point{}, draw.Src)
+	png.Encode(&buf, img)
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e.publish(t, "key/3/set/image", path)
+	e.mock.WaitColor(t, 3, red)
+
+	e.publish(t, "key/1/set/clear", "")
+	e.mock.WaitColor(t, 1, black)
+
+	e.publish(t, "key/6/set/color", "red")
+	testutil.ExpectError(t, e.errs, "")
+	e.publish(t, "key/1/set/nope", "red")
+	testutil.ExpectError(t, e.errs, "")
+	e.publish(t, "key/1/set/color", "nope")
+	testutil.ExpectError(t, e.errs, "")
+}
+
+// hasColor reports whether a pixel of img has the color c.
+func hasColor(img image.Image, c color.RGBA) bool {
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if color.RGBAModel.Convert(img.At(x, y)) == c {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestBridgeBrightness(t *testing.T) {
+	e := newEnv(t)
+
+	e.publish(t, "brightness/set", "30")
+	e.expect(t, "streamdeck/"+serial+"/brightness", "30")
+	if b := e.mock.Brightness(); b != 30 {
+		t.Errorf("brightness = %d, want 30", b)
+	}
+
+	e.publish(t, "brightness/set", "200")
+	testutil.ExpectError(t, e.errs, "")
+	e.publish(t, "page/set", "main")
+	testutil.ExpectError(t, e.errs, "")
+}
+
+func TestBridgePage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profile.yaml")
+	conf := `devices:
+  - pages:
+      - name: main
+        keys:
+          - key: 0
+            color: "#ff0000"
+      - name: sub
+        parent: main
+        keys:
+          - key: 0
+            color: "#0000ff"
+`
+	if err := ioutil.WriteFile(path, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e := newEnv(t, Profile(path))
+	e.expect(t, "streamdeck/"+serial+"/page", "main")
+	e.mock.WaitColor(t, 0, red)
+
+	e.publish(t, "page/set", "sub")
+	e.expect(t, "streamdeck/"+serial+"/page", "sub")
+	e.mock.WaitColor(t, 0, blue)
+
+	// Keys set over MQTT replace those of the current page.
+	e.publish(t, "key/0/set/color", "#ff0000")
+	e.mock.WaitColor(t, 0, red)
+
+	e.publish(t, "page/set", "back")
+	e.expect(t, "streamdeck/"+serial+"/page", "main")
+
+	e.publish(t, "page/set", "nope")
+	testutil.ExpectError(t, e.errs, "")
+}
+
+func TestBridgeLongPress(t *testing.T) {
+	e := newEnv(t, LongPressAfter(50*time.Millisecond))
+	topic := "streamdeck/" + serial + "/key/2"
+
+	e.mock.Press(2)
+	e.expect(t, topic, Pressed)
+	e.expect(t, topic, LongPress)
+	e.mock.Release(2)
+	e.expect(t, topic, Released)
+
+	// A short press doesn't publish a long press.
+	e.mock.Click(4)
+	e.expect(t, "streamdeck/"+serial+"/key/4", Released)
+	timeout := time.After(200 * time.Millisecond)
+	for {
+		select {
+		case m := <-e.msgs:
+			if string(m.Payload) == LongPress {
+				t.Fatalf("long press published on %s", m.Topic)
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+func TestBridgeReconnect(t *testing.T) {
+	e := newEnv(t)
+	e.expect(t, "streamdeck/"+serial+"/status", "online")
+
+	// Restart the broker on the same address: the bridge reconnects,
+	// subscribes again and announces itself.
+	e.broker.Close()
+	e.broker = NewBroker()
+	if _, err := e.broker.Listen(e.addr); err != nil {
+		t.Fatal(err)
+	}
+	defer e.broker.Close()
+
+	e.ctl = e.watch(t)
+	e.expect(t, "streamdeck/"+serial+"/status", "online")
+
+	e.publish(t, "key/5/set/color", "#0000ff")
+	e.mock.WaitColor(t, 5, blue)
+}
+
+func TestBridgeClose(t *testing.T) {
+	e := newEnv(t)
+	e.expect(t, "streamdeck/"+serial+"/status", "online")
+	e.bridge.Close()
+	e.expect(t, "streamdeck/"+serial+"/status", "offline")
+
+	c := e.bridge.client
+	c.mu.Lock()
+	subs, hooks := len(c.subs), len(c.onConnect)
+	c.mu.Unlock()
+	if subs != 0 || hooks != 0 {
+		t.Errorf("%d subscriptions and %d hooks left after Close", subs, hooks)
+	}
+
+	// Commands sent after Close don't reach the deck.
+	e.publish(t, "key/1/set/color", "#ff0000")
+	time.Sleep(100 * time.Millisecond)
+	if hasColor(e.mock.Key(1), red) {
+		t.Error("key 1 set after Close")
+	}
+}
+
+func TestBridgeDiscovery(t *testing.T) {
+	e := newEnv(t, Discovery("homeassistant"))
+
+	configs := make(chan *Message, 64)
+	if err := e.ctl.Subscribe("homeassistant/device_automation/#", func(m *Message) { configs <- m }); err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]discoveryTrigger)
+	timeout := time.After(5 * time.Second)
+	for len(got) < 6*len(triggerTypes) {
+		select {
+		case m := <-configs:
+			var config discoveryTrigger
+			if err := json.Unmarshal(m.Payload, &config); err != nil {
+				t.Fatalf("%s: %v", m.Topic, err)
+			}
+			if !m.Retain {
+				t.Errorf("%s not retained", m.Topic)
+			}
+			got[m.Topic] = config
+		case <-timeout:
+			t.Fatalf("%d configurations received, want %d", len(got), 6*len(triggerTypes))
+		}
+	}
+
+	config, ok := got["homeassistant/device_automation/TEST0001/key2_long_press/config"]
+	if !ok {
+		t.Fatalf("no configuration for the long press of key 2 in %v", got)
+	}
+	want := discoveryTrigger{
+		AutomationType: "trigger",
+		Topic:          "streamdeck/TEST0001/key/2",
+		Payload:        LongPress,
+		Type:           "button_long_press",
+		Subtype:        "button_3",
+		Device: discoveryDevice{
+			Identifiers:  []string{"streamdeck_TEST0001"},
+			Name:         "Stream Deck Mini TEST0001",
+			Manufacturer: "Elgato",
+			Model:        "Stream Deck Mini",
+			SWVersion:    "1.00.000",
+		},
+	}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("configuration\n%+v\nwant\n%+v", config, want)
+	}
+
+	// The triggers fire on the topics they name.
+	config = got["homeassistant/device_automation/TEST0001/key2_pressed/config"]
+	e.mock.Click(2)
+	e.expect(t, config.Topic, config.Payload)
+}
+
+func TestUnsubscribe(t *testing.T) {
+	e := newEnv(t)
+	msgs := make(chan *Message, 4)
+	if err := e.ctl.Subscribe("test/#", func(m *Message) { msgs <- m }); err != nil {
+		t.Fatal(err)
+	}
+	calls := make(chan bool, 4)
+	remove := e.ctl.OnConnect(func() { calls <- true })
+	remove()
+
+	e.ctl.Publish("test/a", []byte("1"), false)
+	select {
+	case <-msgs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received")
+	}
+	if err := e.ctl.Unsubscribe("test/#"); err != nil {
+		t.Fatal(err)
+	}
+	// The broker doesn't forward messages anymore: the next one received
+	// is that of the new subscription.
+	e.ctl.Publish("test/b", []byte("2"), false)
+	if err := e.ctl.Subscribe("test/c", func(m *Message) { msgs <- m }); err != nil {
+		t.Fatal(err)
+	}
+	e.ctl.Publish("test/c", []byte("3"), false)
+	select {
+	case m := <-msgs:
+		if m.Topic != "test/c" {
+			t.Errorf("received %s after unsubscribing", m.Topic)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received")
+	}
+
+	e.ctl.mu.Lock()
+	hooks := len(e.ctl.onConnect)
+	e.ctl.mu.Unlock()
+	if hooks != 0 || len(calls) != 0 {
+		t.Error("OnConnect hook not removed")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		filter, topic string
+		want          bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/c", false},
+		{"a/+", "a/b", true},
+		{"a/+", "a/b/c", false},
+		{"a/+/c", "a/b/c", true},
+		{"a/#", "a", true},
+		{"a/#", "a/b/c", true},
+		{"#", "a/b", true},
+		{"+/b", "$SYS/b", false},
+		{"#", "$SYS/b", false},
+		{"$SYS/#", "$SYS/b", true},
+		{"a/b/c", "a/b", false},
+	}
+	for _, test := range tests {
+		if got := Match(test.filter, test.topic); got != test.want {
+			t.Errorf("Match(%q, %q) = %v", test.filter, test.topic, got)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package mqtt
+
+import (
+	"bufio"
+	"net"
+	"sync"
+)
+
+// Broker is a minimal in-process MQTT broker, enough for tests and small
+// setups without a broker: it supports QoS 0, retained messages and will
+// messages, and accepts any client.
+type Broker struct {
+	mu       sync.Mutex
+	sessions map[*session]bool
+	retained map[string]*Message
+	l        net.Listener
+}
+
+type session struct {
+	conn    net.Conn
+	wmu     sync.Mutex
+	filters []string
+}
+
+// NewBroker creates a Broker.
+func NewBroker() *Broker {
+	return &Broker{
+		sessions: make(map[*session]bool),
+		retained: make(map[string]*Message),
+	}
+}
+
+// Listen starts serving on a TCP address, e.g. "127.0.0.1:0" for a random
+// port, and returns the address listened on.
+func (b *Broker) Listen(addr string) (string, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	b.mu.Lock()
+	b.l = l
+	b.mu.Unlock()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return l.Addr().String(), nil
+}
+
+// Close stops the broker and disconnects the clients.
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.l != nil {
+		b.l.Close()
+	}
+	for s := range b.sessions {
+		s.conn.Close()
+	}
+	return nil
+}
+
+func (b *Broker) serve(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+
+	p, err := readPacket(br)
+	if err != nil || p.typ != typeConnect {
+		return
+	}
+	will, err := parseConnect(p)
+	if err != nil {
+		conn.Write((&packet{typ: typeConnack, body: []byte{0, 1}}).bytes())
+		return
+	}
+
+	s := &session{conn: conn}
+	b.mu.Lock()
+	b.sessions[s] = true
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.sessions, s)
+		b.mu.Unlock()
+		if will != nil {
+			b.publish(will)
+		}
+	}()
+	s.send(&packet{typ: typeConnack, body: []byte{0, 0}})
+
+	for {
+		p, err := readPacket(br)
+		if err != nil {
+			return
+		}
+
+		switch p.typ {
+		case typePublish:
+			m, qos, id, err := parsePublish(p)
+			if err != nil {
+				return
+			}
+			if qos == 1 {
+				var w writer
+				w.uint16(id)
+				s.send(&packet{typ: typePuback, body: w})
+			}
+			b.publish(m)
+		case typeSubscribe:
+			b.subscribe(s, p)
+		case typeUnsubscribe:
+			r := reader{buf: p.body}
+			id := r.uint16()
+			b.mu.Lock()
+			for len(r.buf) > 0 && r.err == nil {
+				filter := r.string()
+				for i, f := range s.filters {
+					if f == filter {
+						s.filters = append(s.filters[:i], s.filters[i+1:]...)
+						break
+					}
+				}
+			}
+			b.mu.Unlock()
+			var w writer
+			w.uint16(id)
+			s.send(&packet{typ: typeUnsuback, body: w})
+		case typePingreq:
+			s.send(&packet{typ: typePingresp})
+		case typeDisconnect:
+			will = nil
+			return
+		}
+	}
+}
+
+// parseConnect returns the will message of a CONNECT packet, if any.
+func parseConnect(p *packet) (*Message, error) {
+	r := reader{buf: p.body}
+	if r.string() != "MQTT" || r.byte() != 4 {
+		return nil, errMalformed
+	}
+	flags := r.byte()
+	r.uint16() // keep alive
+	r.string() // client identifier
+
+	var will *Message
+	if flags&0x04 != 0 {
+		will = &Message{Topic: r.string(), Payload: r.bytes(), Retain: flags&0x20 != 0}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return will, nil
+}
+
+func (b *Broker) subscribe(s *session, p *packet) {
+	r := reader{buf: p.body}
+	id := r.uint16()
+	var filters []string
+	for len(r.buf) > 0 && r.err == nil {
+		filters = append(filters, r.string())
+		r.byte() // requested QoS
+	}
+
+	var w writer
+	w.uint16(id)
+	for range filters {
+		w.byte(0) // granted QoS
+	}
+
+	b.mu.Lock()
+	for _, f := range filters {
+		if !s.subscribed(f) {
+			s.filters = append(s.filters, f)
+		}
+	}
+	var retained []*Message
+	for _, m := range b.retained {
+		for _, f := range filters {
+			if Match(f, m.Topic) {
+				retained = append(retained, m)
+				break
+			}
+		}
+	}
+	b.mu.Unlock()
+
+	s.send(&packet{typ: typeSuback, body: w})
+	for _, m := range retained {
+		s.send(publishPacket(m.Topic, m.Payload, true))
+	}
+}
+
+// publish delivers a message to the matching subscriptions.
+func (b *Broker) publish(m *Message) {
+	b.mu.Lock()
+	if m.Retain {
+		if len(m.Payload) == 0 {
+			delete(b.retained, m.Topic)
+		} else {
+			b.retained[m.Topic] = m
+		}
+	}
+	var targets []*session
+	for s := range b.sessions {
+		for _, f := range s.filters {
+			if Match(f, m.Topic) {
+				targets = append(targets, s)
+				break
+			}
+		}
+	}
+	b.mu.Unlock()
+
+	p := publishPacket(m.Topic, m.Payload, false)
+	for _, s := range targets {
+		s.send(p)
+	}
+}
+
+func (s *session) subscribed(filter string) bool {
+	for _, f := range s.filters {
+		if f == filter {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *session) send(p *packet) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	s.conn.Write(p.bytes())
+}
@@ -0,0 +1,442 @@
+package mqtt
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/KarpelesLab/streamdeck/internal/reconnect"
+)
+
+// ErrNotConnected is returned when publishing while the connection to the
+// broker is down.
+var ErrNotConnected = errors.New("mqtt: not connected")
+
+// Client is a minimal MQTT 3.1.1 client. Messages are published and
+// subscribed with QoS 0. When the connection is lost, the Client reconnects
+// and subscribes again.
+type Client struct {
+	addr      string
+	clientID  string
+	username  string
+	password  string
+	keepAlive time.Duration
+	tls       *tls.Config
+	will      *Message
+	onError   func(error)
+
+	mu        sync.Mutex
+	conn      net.Conn
+	onConnect []*hook
+	subs      []*subscription
+	nextID    uint16
+	acks      map[uint16]chan struct{}
+	done      chan struct{}
+}
+
+type subscription struct {
+	filter  string
+	handler func(*Message)
+}
+
+// hook is a function registered with OnConnect. It is referred to by
+// pointer, as functions can't be compared.
+type hook struct {
+	f func()
+}
+
+// ClientID sets the client identifier, by default derived from the host
+// name and process ID.
+func ClientID(id string) func(*Client) {
+	return func(c *Client) {
+		c.clientID = id
+	}
+}
+
+// Auth sets the user name and password sent to the broker.
+func Auth(username, password string) func(*Client) {
+	return func(c *Client) {
+		c.username, c.password = username, password
+	}
+}
+
+// KeepAlive sets the interval of keep alive pings, 30 seconds by default.
+func KeepAlive(d time.Duration) func(*Client) {
+	return func(c *Client) {
+		c.keepAlive = d
+	}
+}
+
+// TLS connects to the broker over TLS.
+func TLS(config *tls.Config) func(*Client) {
+	return func(c *Client) {
+		c.tls = config
+	}
+}
+
+// Will sets a message the broker publishes if the client disconnects
+// without saying so, typically to tell it went offline.
+func Will(topic string, payload []byte, retain bool) func(*Client) {
+	return func(c *Client) {
+		c.will = &Message{Topic: topic, Payload: payload, Retain: retain}
+	}
+}
+
+// OnError sets a function called when the connection fails.
+func OnError(f func(error)) func(*Client) {
+	return func(c *Client) {
+		c.onError = f
+	}
+}
+
+// Dial connects to the broker at addr (host:port). The first connection
+// must succeed; later ones are retried until Close.
+func Dial(addr string, options ...func(*Client)) (*Client, error) {
+	host, _ := os.Hostname()
+	c := &Client{
+		addr:      addr,
+		clientID:  fmt.Sprintf("streamdeck-%s-%d", host, os.Getpid()),
+		keepAlive: 30 * time.Second,
+		acks:      make(map[uint16]chan struct{}),
+		done:      make(chan struct{}),
+	}
+	for _, option := range options {
+		option(c)
+	}
+
+	conn, br, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	go c.run(conn, br)
+	return c, nil
+}
+
+// connect opens a connection and completes the MQTT handshake.
+func (c *Client) connect() (net.Conn, *bufio.Reader, error) {
+	var conn net.Conn
+	var err error
+	if c.tls != nil {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", c.addr, c.tls)
+	} else {
+		conn, err = net.DialTimeout("tcp", c.addr, 10*time.Second)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var w writer
+	w.string("MQTT")
+	w.byte(4) // protocol level 3.1.1
+
+	flags := byte(0x02) // clean session
+	if c.will != nil {
+		flags |= 0x04
+		if c.will.Retain {
+			flags |= 0x20
+		}
+	}
+	if c.username != "" {
+		flags |= 0x80
+		if c.password != "" {
+			flags |= 0x40
+		}
+	}
+	w.byte(flags)
+	w.uint16(uint16(c.keepAlive / time.Second))
+	w.string(c.clientID)
+	if c.will != nil {
+		w.string(c.will.Topic)
+		w.bytes(c.will.Payload)
+	}
+	if c.username != "" {
+		w.string(c.username)
+		if c.password != "" {
+			w.string(c.password)
+		}
+	}
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := conn.Write((&packet{typ: typeConnect, body: w}).bytes()); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	br := bufio.NewReader(conn)
+	p, err := readPacket(br)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if p.typ != typeConnack || len(p.body) != 2 {
+		conn.Close()
+		return nil, nil, errMalformed
+	}
+	if code := p.body[1]; code != 0 {
+		conn.Close()
+		return nil, nil, fmt.Errorf("mqtt: connection refused: %s", connackError(code))
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, br, nil
+}
+
+func connackError(code byte) string {
+	switch code {
+	case 1:
+		return "unacceptable protocol version"
+	case 2:
+		return "identifier rejected"
+	case 3:
+		return "server unavailable"
+	case 4:
+		return "bad user name or password"
+	case 5:
+		return "not authorized"
+	}
+	return fmt.Sprintf("code %d", code)
+}
+
+// run serves connections until Close, reconnecting when they are lost.
+func (c *Client) run(conn net.Conn, br *bufio.Reader) {
+	serve := func() error {
+		return c.serve(conn, br)
+	}
+	lost := func() {
+		c.mu.Lock()
+		c.conn = nil
+		c.mu.Unlock()
+		conn.Close()
+	}
+	connect := func() error {
+		var err error
+		if conn, br, err = c.connect(); err != nil {
+			return err
+		}
+
+		c.mu.Lock()
+		c.conn = conn
+		subs := append([]*subscription(nil), c.subs...)
+		onConnect := c.onConnect
+		c.mu.Unlock()
+
+		for _, s := range subs {
+			c.send(subscribePacket(c.packetID(), s.filter))
+		}
+		for _, h := range onConnect {
+			go h.f()
+		}
+		return nil
+	}
+	reconnect.Loop(c.done, serve, lost, connect, c.onError)
+}
+
+// serve reads packets until the connection fails, and pings the broker.
+func (c *Client) serve(conn net.Conn, br *bufio.Reader) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	if c.keepAlive > 0 {
+		go func() {
+			t := time.NewTicker(c.keepAlive)
+			defer t.Stop()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-t.C:
+					c.send(&packet{typ: typePingreq})
+				}
+			}
+		}()
+	}
+
+	for {
+		if c.keepAlive > 0 {
+			conn.SetReadDeadline(time.Now().Add(c.keepAlive * 3 / 2))
+		}
+		p, err := readPacket(br)
+		if err != nil {
+			return err
+		}
+
+		switch p.typ {
+		case typePublish:
+			m, qos, id, err := parsePublish(p)
+			if err != nil {
+				return err
+			}
+			if qos == 1 {
+				var w writer
+				w.uint16(id)
+				c.send(&packet{typ: typePuback, body: w})
+			}
+			c.dispatch(m)
+		case typeSuback, typeUnsuback:
+			r := reader{buf: p.body}
+			id := r.uint16()
+			c.mu.Lock()
+			if ch, ok := c.acks[id]; ok {
+				close(ch)
+				delete(c.acks, id)
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+func (c *Client) dispatch(m *Message) {
+	c.mu.Lock()
+	subs := append([]*subscription(nil), c.subs...)
+	c.mu.Unlock()
+
+	for _, s := range subs {
+		if Match(s.filter, m.Topic) {
+			s.handler(m)
+		}
+	}
+}
+
+func (c *Client) packetID() uint16 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextID++
+	if c.nextID == 0 {
+		c.nextID = 1
+	}
+	return c.nextID
+}
+
+// send writes a packet on the current connection.
+func (c *Client) send(p *packet) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return ErrNotConnected
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := c.conn.Write(p.bytes())
+	return err
+}
+
+// OnConnect registers a function called after each reconnection, e.g. to
+// publish retained state again. It returns a function unregistering it.
+func (c *Client) OnConnect(f func()) func() {
+	h := &hook{f: f}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onConnect = append(c.onConnect, h)
+
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		hooks := make([]*hook, 0, len(c.onConnect))
+		for _, other := range c.onConnect {
+			if other != h {
+				hooks = append(hooks, other)
+			}
+		}
+		c.onConnect = hooks
+	}
+}
+
+// Publish publishes a message.
+func (c *Client) Publish(topic string, payload []byte, retain bool) error {
+	return c.send(publishPacket(topic, payload, retain))
+}
+
+func subscribePacket(id uint16, filter string) *packet {
+	var w writer
+	w.uint16(id)
+	w.string(filter)
+	w.byte(0) // QoS 0
+	return &packet{typ: typeSubscribe, flags: 2, body: w}
+}
+
+// Subscribe calls handler with the messages matching filter, from the
+// goroutine reading the connection. It waits for the broker to acknowledge
+// the subscription.
+func (c *Client) Subscribe(filter string, handler func(*Message)) error {
+	id := c.packetID()
+	ack := make(chan struct{})
+
+	c.mu.Lock()
+	c.subs = append(c.subs, &subscription{filter: filter, handler: handler})
+	c.acks[id] = ack
+	c.mu.Unlock()
+
+	if err := c.send(subscribePacket(id, filter)); err != nil {
+		// Subscribed again on reconnection.
+		return nil
+	}
+	return c.await(ack, "subscription to "+filter)
+}
+
+func unsubscribePacket(id uint16, filter string) *packet {
+	var w writer
+	w.uint16(id)
+	w.string(filter)
+	return &packet{typ: typeUnsubscribe, flags: 2, body: w}
+}
+
+// Unsubscribe removes all handlers of filter, and waits for the broker to
+// acknowledge the unsubscription.
+func (c *Client) Unsubscribe(filter string) error {
+	id := c.packetID()
+	ack := make(chan struct{})
+
+	c.mu.Lock()
+	subs := make([]*subscription, 0, len(c.subs))
+	for _, s := range c.subs {
+		if s.filter != filter {
+			subs = append(subs, s)
+		}
+	}
+	c.subs = subs
+	c.acks[id] = ack
+	c.mu.Unlock()
+
+	if err := c.send(unsubscribePacket(id, filter)); err != nil {
+		// The broker forgot the subscription with the connection.
+		c.mu.Lock()
+		delete(c.acks, id)
+		c.mu.Unlock()
+		return nil
+	}
+	return c.await(ack, "unsubscription from "+filter)
+}
+
+// await waits for the acknowledgement of a subscription or unsubscription.
+func (c *Client) await(ack chan struct{}, what string) error {
+	select {
+	case <-ack:
+		return nil
+	case <-time.After(10 * time.Second):
+		return fmt.Errorf("mqtt: %s not acknowledged", what)
+	case <-c.done:
+		return ErrNotConnected
+	}
+}
+
+// Close disconnects from the broker. The will message isn't published.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	select {
+	case <-c.done:
+		c.mu.Unlock()
+		return nil
+	default:
+	}
+	close(c.done)
+	conn := c.conn
+	c.mu.Unlock()
+
+	if conn != nil {
+		conn.Write((&packet{typ: typeDisconnect}).bytes())
+		conn.Close()
+	}
+	return nil
+}
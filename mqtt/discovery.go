@@ -0,0 +1,82 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// triggerTypes maps the payloads of key topics to Home Assistant trigger
+// types.
+var triggerTypes = []struct {
+	payload string
+	typ     string
+}{
+	{Pressed, "button_short_press"},
+	{Released, "button_short_release"},
+	{LongPress, "button_long_press"},
+}
+
+// discoveryDevice describes the deck to Home Assistant.
+type discoveryDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+	SWVersion    string   `json:"sw_version,omitempty"`
+}
+
+// discoveryTrigger is the configuration of a device trigger.
+type discoveryTrigger struct {
+	AutomationType string          `json:"automation_type"`
+	Topic          string          `json:"topic"`
+	Payload        string          `json:"payload"`
+	Type           string          `json:"type"`
+	Subtype        string          `json:"subtype"`
+	Device         discoveryDevice `json:"device"`
+}
+
+// publishDiscovery publishes a device trigger for each key and trigger
+// type, as retained messages.
+func (b *Bridge) publishDiscovery() error {
+	node := nodeID(b.serial)
+	dev := discoveryDevice{
+		Identifiers:  []string{"streamdeck_" + node},
+		Name:         fmt.Sprintf("%s %s", b.dev.Info.Name, b.serial),
+		Manufacturer: "Elgato",
+		Model:        b.dev.Info.Name,
+		SWVersion:    b.firmware,
+	}
+
+	for i := 0; i < b.dev.NumButtons(); i++ {
+		for _, t := range triggerTypes {
+			config, err := json.Marshal(&discoveryTrigger{
+				AutomationType: "trigger",
+				Topic:          b.topic(fmt.Sprintf("key/%d", i)),
+				Payload:        t.payload,
+				Type:           t.typ,
+				Subtype:        fmt.Sprintf("button_%d", i+1),
+				Device:         dev,
+			})
+			if err != nil {
+				return err
+			}
+			topic := fmt.Sprintf("%s/device_automation/%s/key%d_%s/config", b.discovery, node, i, t.payload)
+			if err := b.client.Publish(topic, config, true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// nodeID turns a serial number into an identifier accepted in discovery
+// topics.
+func nodeID(serial string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, serial)
+}
@@ -0,0 +1,207 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Packet types of MQTT 3.1.1.
+const (
+	typeConnect     = 1
+	typeConnack     = 2
+	typePublish     = 3
+	typePuback      = 4
+	typeSubscribe   = 8
+	typeSuback      = 9
+	typeUnsubscribe = 10
+	typeUnsuback    = 11
+	typePingreq     = 12
+	typePingresp    = 13
+	typeDisconnect  = 14
+)
+
+// maxPacket is the size limit of packets, large enough for key images.
+const maxPacket = 16 << 20
+
+var errMalformed = errors.New("mqtt: malformed packet")
+
+// packet is an MQTT control packet: the type and flags of the fixed header,
+// and the rest of the packet.
+type packet struct {
+	typ   byte
+	flags byte
+	body  []byte
+}
+
+func readPacket(r *bufio.Reader) (*packet, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	p := &packet{typ: b >> 4, flags: b & 0x0f}
+
+	var size, shift int
+	for i := 0; ; i++ {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		size |= int(c&0x7f) << shift
+		shift += 7
+		if c&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return nil, errMalformed
+		}
+	}
+	if size > maxPacket {
+		return nil, fmt.Errorf("mqtt: packet of %d bytes too large", size)
+	}
+
+	p.body = make([]byte, size)
+	if _, err := io.ReadFull(r, p.body); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// bytes encodes the packet.
+func (p *packet) bytes() []byte {
+	buf := []byte{p.typ<<4 | p.flags}
+	n := len(p.body)
+	for {
+		c := byte(n & 0x7f)
+		n >>= 7
+		if n > 0 {
+			c |= 0x80
+		}
+		buf = append(buf, c)
+		if n == 0 {
+			break
+		}
+	}
+	return append(buf, p.body...)
+}
+
+// writer builds packet bodies.
+type writer []byte
+
+func (w *writer) byte(b byte) {
+	*w = append(*w, b)
+}
+
+func (w *writer) uint16(v uint16) {
+	*w = append(*w, byte(v>>8), byte(v))
+}
+
+func (w *writer) string(s string) {
+	w.uint16(uint16(len(s)))
+	*w = append(*w, s...)
+}
+
+func (w *writer) bytes(b []byte) {
+	w.uint16(uint16(len(b)))
+	*w = append(*w, b...)
+}
+
+// reader parses packet bodies. Errors are sticky: after one, everything
+// reads as zero and err is set.
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) byte() byte {
+	if len(r.buf) < 1 {
+		r.err = errMalformed
+		return 0
+	}
+	b := r.buf[0]
+	r.buf = r.buf[1:]
+	return b
+}
+
+func (r *reader) uint16() uint16 {
+	if len(r.buf) < 2 {
+		r.err = errMalformed
+		return 0
+	}
+	v := binary.BigEndian.Uint16(r.buf)
+	r.buf = r.buf[2:]
+	return v
+}
+
+func (r *reader) bytes() []byte {
+	n := int(r.uint16())
+	if len(r.buf) < n {
+		r.err = errMalformed
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *reader) string() string {
+	return string(r.bytes())
+}
+
+// Message is a message received on a subscription.
+type Message struct {
+	Topic   string
+	Payload []byte
+	Retain  bool
+}
+
+// publishPacket builds a PUBLISH packet of QoS 0.
+func publishPacket(topic string, payload []byte, retain bool) *packet {
+	var w writer
+	w.string(topic)
+	w = append(w, payload...)
+	p := &packet{typ: typePublish, body: w}
+	if retain {
+		p.flags = 1
+	}
+	return p
+}
+
+// parsePublish parses a PUBLISH packet, returning its packet identifier if
+// its QoS requires an acknowledgment.
+func parsePublish(p *packet) (m *Message, qos byte, id uint16, err error) {
+	r := reader{buf: p.body}
+	m = &Message{Topic: r.string(), Retain: p.flags&1 != 0}
+	qos = p.flags >> 1 & 3
+	if qos > 0 {
+		id = r.uint16()
+	}
+	if r.err != nil {
+		return nil, 0, 0, r.err
+	}
+	m.Payload = r.buf
+	return m, qos, id, nil
+}
+
+// Match reports whether a topic matches a subscription filter, with its
+// + (one level) and # (all remaining levels) wildcards.
+func Match(filter, topic string) bool {
+	f := strings.Split(filter, "/")
+	t := strings.Split(topic, "/")
+	// Wildcards don't match topics starting with $, e.g. $SYS.
+	if len(t) > 0 && strings.HasPrefix(t[0], "$") && len(f) > 0 && (f[0] == "+" || f[0] == "#") {
+		return false
+	}
+	for i, level := range f {
+		if level == "#" {
+			return true
+		}
+		if i >= len(t) || (level != "+" && level != t[i]) {
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
@@ -0,0 +1,73 @@
+package obs
+
+import (
+	"context"
+
+	"github.com/KarpelesLab/streamdeck/action"
+)
+
+// Request is an action sending a request to OBS, e.g.
+// {Type: "SaveReplayBuffer"}. See the protocol documentation of OBS for the
+// available requests.
+type Request struct {
+	Client *Client
+	Type   string
+	Data   interface{} // parameters of the request, may be nil
+}
+
+// Run sends the request.
+func (r *Request) Run(ctx context.Context, env *action.Env) error {
+	return r.Client.Request(ctx, r.Type, r.Data, nil)
+}
+
+// SwitchScene returns an action switching the program to a scene.
+func SwitchScene(c *Client, scene string) *Request {
+	return &Request{Client: c, Type: "SetCurrentProgramScene", Data: map[string]string{"sceneName": scene}}
+}
+
+// StartStream returns an action starting to stream.
+func StartStream(c *Client) *Request {
+	return &Request{Client: c, Type: "StartStream"}
+}
+
+// StopStream returns an action stopping the stream.
+func StopStream(c *Client) *Request {
+	return &Request{Client: c, Type: "StopStream"}
+}
+
+// ToggleStream returns an action starting or stopping the stream.
+func ToggleStream(c *Client) *Request {
+	return &Request{Client: c, Type: "ToggleStream"}
+}
+
+// StartRecord returns an action starting to record.
+func StartRecord(c *Client) *Request {
+	return &Request{Client: c, Type: "StartRecord"}
+}
+
+// StopRecord returns an action stopping the recording.
+func StopRecord(c *Client) *Request {
+	return &Request{Client: c, Type: "StopRecord"}
+}
+
+// ToggleRecord returns an action starting or stopping the recording.
+func ToggleRecord(c *Client) *Request {
+	return &Request{Client: c, Type: "ToggleRecord"}
+}
+
+// ToggleMute returns an action muting or unmuting an input.
+func ToggleMute(c *Client, input string) *Request {
+	return &Request{Client: c, Type: "ToggleInputMute", Data: map[string]string{"inputName": input}}
+}
+
+// ToggleSource returns an action showing or hiding a source in a scene.
+func ToggleSource(c *Client, scene, source string) action.Action {
+	return action.Func(func(ctx context.Context, env *action.Env) error {
+		v := &SourceVisible{Client: c, Scene: scene, Source: source}
+		state, err := v.Get(ctx)
+		if err != nil {
+			return err
+		}
+		return v.Set(ctx, 1-state)
+	})
+}
@@ -0,0 +1,346 @@
+// Package obs controls OBS Studio through its WebSocket server (protocol
+// version 5): it provides actions switching scenes, toggling sources,
+// streaming, recording and muting, and elements showing their live state,
+// such as the current scene or the elapsed recording time.
+package obs
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/KarpelesLab/streamdeck/internal/websocket"
+)
+
+// DefaultURL is the address of the WebSocket server of OBS by default.
+const DefaultURL = "ws://localhost:4455"
+
+// Opcodes of the protocol.
+const (
+	opHello           = 0
+	opIdentify        = 1
+	opIdentified      = 2
+	opEvent           = 5
+	opRequest         = 6
+	opRequestResponse = 7
+)
+
+// eventSubscriptions subscribes to all events but the high volume ones.
+const eventSubscriptions = 0x7ff
+
+// ErrClosed is returned by requests once the connection is closed.
+var ErrClosed = errors.New("obs: connection closed")
+
+// Event is an event sent by OBS.
+type Event struct {
+	Type string          `json:"eventType"`
+	Data json.RawMessage `json:"eventData"`
+}
+
+// RequestError is returned when OBS fails a request.
+type RequestError struct {
+	Type    string
+	Code    int
+	Comment string
+}
+
+func (e *RequestError) Error() string {
+	if e.Comment != "" {
+		return fmt.Sprintf("obs: %s failed: %s (code %d)", e.Type, e.Comment, e.Code)
+	}
+	return fmt.Sprintf("obs: %s failed with code %d", e.Type, e.Code)
+}
+
+// Client is a connection to OBS.
+type Client struct {
+	conn     *websocket.Conn
+	password string
+	timeout  time.Duration
+
+	mu       sync.Mutex
+	nextID   int
+	pending  map[string]chan *response
+	handlers []*handler
+	done     chan struct{}
+	err      error
+}
+
+type handler struct {
+	f func(*Event)
+}
+
+type message struct {
+	Op int             `json:"op"`
+	D  json.RawMessage `json:"d"`
+}
+
+type response struct {
+	Type   string `json:"requestType"`
+	ID     string `json:"requestId"`
+	Status struct {
+		Result  bool   `json:"result"`
+		Code    int    `json:"code"`
+		Comment string `json:"comment"`
+	} `json:"requestStatus"`
+	Data json.RawMessage `json:"responseData"`
+}
+
+// Password sets the password of the WebSocket server.
+func Password(password string) func(*Client) {
+	return func(c *Client) {
+		c.password = password
+	}
+}
+
+// Timeout sets how long requests wait for their response, 10 seconds by
+// default.
+func Timeout(d time.Duration) func(*Client) {
+	return func(c *Client) {
+		c.timeout = d
+	}
+}
+
+// Dial connects to OBS, DefaultURL if url is empty, and authenticates.
+func Dial(url string, options ...func(*Client)) (*Client, error) {
+	if url == "" {
+		url = DefaultURL
+	}
+	c := &Client{
+		timeout: 10 * time.Second,
+		pending: make(map[string]chan *response),
+		done:    make(chan struct{}),
+	}
+	for _, option := range options {
+		option(c)
+	}
+
+	header := http.Header{"Sec-WebSocket-Protocol": {"obswebsocket.json"}}
+	conn, err := websocket.Dial(url, header)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	if err := c.identify(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go c.read()
+	return c, nil
+}
+
+// identify answers the Hello message of OBS.
+func (c *Client) identify() error {
+	c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+	defer c.conn.SetReadDeadline(time.Time{})
+
+	var m message
+	if err := c.conn.ReadJSON(&m); err != nil {
+		return err
+	}
+	if m.Op != opHello {
+		return fmt.Errorf("obs: expected hello, got opcode %d", m.Op)
+	}
+	var hello struct {
+		RPCVersion     int `json:"rpcVersion"`
+		Authentication *struct {
+			Challenge string `json:"challenge"`
+			Salt      string `json:"salt"`
+		} `json:"authentication"`
+	}
+	if err := json.Unmarshal(m.D, &hello); err != nil {
+		return err
+	}
+
+	identify := map[string]interface{}{
+		"rpcVersion":         1,
+		"eventSubscriptions": eventSubscriptions,
+	}
+	if a := hello.Authentication; a != nil {
+		if c.password == "" {
+			return fmt.Errorf("obs: a password is required")
+		}
+		identify["authentication"] = authResponse(c.password, a.Salt, a.Challenge)
+	}
+	if err := c.conn.WriteJSON(map[string]interface{}{"op": opIdentify, "d": identify}); err != nil {
+		return err
+	}
+
+	if err := c.conn.ReadJSON(&m); err != nil {
+		// OBS closes the connection on authentication failures.
+		if hello.Authentication != nil {
+			return fmt.Errorf("obs: authentication failed")
+		}
+		return err
+	}
+	if m.Op != opIdentified {
+		return fmt.Errorf("obs: expected identified, got opcode %d", m.Op)
+	}
+	return nil
+}
+
+// authResponse computes the authentication string:
+// base64(sha256(base64(sha256(password + salt)) + challenge)).
+func authResponse(password, salt, challenge string) string {
+	secret := sha256.Sum256([]byte(password + salt))
+	auth := sha256.Sum256([]byte(base64.StdEncoding.EncodeToString(secret[:]) + challenge))
+	return base64.StdEncoding.EncodeToString(auth[:])
+}
+
+// read dispatches the messages of OBS until the connection is closed.
+func (c *Client) read() {
+	var err error
+	defer func() {
+		c.mu.Lock()
+		c.err = err
+		for id, ch := range c.pending {
+			close(ch)
+			delete(c.pending, id)
+		}
+		c.mu.Unlock()
+		close(c.done)
+	}()
+
+	for {
+		var m message
+		if err = c.conn.ReadJSON(&m); err != nil {
+			return
+		}
+
+		switch m.Op {
+		case opEvent:
+			var ev Event
+			if json.Unmarshal(m.D, &ev) != nil {
+				continue
+			}
+			c.mu.Lock()
+			handlers := c.handlers
+			c.mu.Unlock()
+			for _, h := range handlers {
+				h.f(&ev)
+			}
+		case opRequestResponse:
+			var res response
+			if json.Unmarshal(m.D, &res) != nil {
+				continue
+			}
+			c.mu.Lock()
+			ch, ok := c.pending[res.ID]
+			delete(c.pending, res.ID)
+			c.mu.Unlock()
+			if ok {
+				ch <- &res
+			}
+		}
+	}
+}
+
+// OnEvent registers a function called with the events of OBS, from the
+// goroutine reading the connection. The returned function unregisters it.
+func (c *Client) OnEvent(f func(*Event)) (cancel func()) {
+	h := &handler{f}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers = append(c.handlers, h)
+
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		// copy, as read may be iterating over the current slice
+		handlers := make([]*handler, 0, len(c.handlers))
+		for _, o := range c.handlers {
+			if o != h {
+				handlers = append(handlers, o)
+			}
+		}
+		c.handlers = handlers
+	}
+}
+
+// Request sends a request, with data as its parameters (may be nil), and
+// decodes the response data into res (may be nil).
+func (c *Client) Request(ctx context.Context, requestType string, data, res interface{}) error {
+	c.mu.Lock()
+	select {
+	case <-c.done:
+		c.mu.Unlock()
+		return ErrClosed
+	default:
+	}
+	c.nextID++
+	id := fmt.Sprintf("%d-%s", c.nextID, randomHex())
+	ch := make(chan *response, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	d := map[string]interface{}{"requestType": requestType, "requestId": id}
+	if data != nil {
+		d["requestData"] = data
+	}
+	if err := c.conn.WriteJSON(map[string]interface{}{"op": opRequest, "d": d}); err != nil {
+		c.forget(id)
+		return err
+	}
+
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+	var r *response
+	select {
+	case r = <-ch:
+	case <-ctx.Done():
+		c.forget(id)
+		return ctx.Err()
+	case <-timer.C:
+		c.forget(id)
+		return fmt.Errorf("obs: %s timed out", requestType)
+	}
+	if r == nil {
+		return ErrClosed
+	}
+	if !r.Status.Result {
+		return &RequestError{Type: requestType, Code: r.Status.Code, Comment: r.Status.Comment}
+	}
+	if res != nil && len(r.Data) > 0 {
+		return json.Unmarshal(r.Data, res)
+	}
+	return nil
+}
+
+func (c *Client) forget(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, id)
+}
+
+func randomHex() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Done returns a channel closed when the connection is lost or closed.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the connection was lost, once Done is closed.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	err := c.conn.Close()
+	<-c.done
+	return err
+}
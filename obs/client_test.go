@@ -0,0 +1,408 @@
+package obs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/KarpelesLab/streamdeck/internal/websocket"
+)
+
+// Example values of the documentation of the protocol.
+const (
+	testPassword  = "supersecretpassword"
+	testSalt      = "lM1GncleQOaCu9lT1yeUZhFYnqhsLLP1G5lAGo3ixaI="
+	testChallenge = "+IxH4CnCiqpX1rM9scsNynZzbOe4KhDeYcTNS3PDaeY="
+	testAuth      = "1Ct943GAT+6YQUUX47Ia/ncufilbe6+oD6lY+5kaCu4="
+)
+
+func TestAuthResponse(t *testing.T) {
+	if got := authResponse(testPassword, testSalt, testChallenge); got != testAuth {
+		t.Errorf("authResponse = %s, want %s", got, testAuth)
+	}
+}
+
+// fakeOBS is a WebSocket server speaking the protocol of OBS. Requests get
+// their requestData back as response, after "delay" milliseconds if set;
+// requests of type "Fail" fail. GetCurrentProgramScene and GetRecordStatus
+// return scene and record.
+type fakeOBS struct {
+	*httptest.Server
+	password string
+
+	mu       sync.Mutex
+	conn     *websocket.Conn
+	identify map[string]interface{}
+	scene    string
+	record   OutputStatus
+}
+
+func newFakeOBS(t *testing.T, password string) *fakeOBS {
+	t.Helper()
+	f := &fakeOBS{password: password, scene: "Main"}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeOBS) url() string {
+	return "ws" + strings.TrimPrefix(f.URL, "http")
+}
+
+func (f *fakeOBS) serve(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	hello := map[string]interface{}{"obsWebSocketVersion": "5.0.0", "rpcVersion": 1}
+	if f.password != "" {
+		hello["authentication"] = map[string]string{"challenge": testChallenge, "salt": testSalt}
+	}
+	conn.WriteJSON(map[string]interface{}{"op": opHello, "d": hello})
+
+	var m struct {
+		Op int             `json:"op"`
+		D  json.RawMessage `json:"d"`
+	}
+	if conn.ReadJSON(&m) != nil || m.Op != opIdentify {
+		return
+	}
+	var identify map[string]interface{}
+	json.Unmarshal(m.D, &identify)
+	if f.password != "" && identify["authentication"] != authResponse(f.password, testSalt, testChallenge) {
+		return
+	}
+	f.mu.Lock()
+	f.identify = identify
+	f.conn = conn
+	f.mu.Unlock()
+	conn.WriteJSON(map[string]interface{}{"op": opIdentified, "d": map[string]int{"negotiatedRpcVersion": 1}})
+
+	for {
+		if conn.ReadJSON(&m) != nil {
+			return
+		}
+		if m.Op != opRequest {
+			continue
+		}
+		var req struct {
+			Type string          `json:"requestType"`
+			ID   string          `json:"requestId"`
+			Data json.RawMessage `json:"requestData"`
+		}
+		json.Unmarshal(m.D, &req)
+		go f.respond(conn, req.Type, req.ID, req.Data)
+	}
+}
+
+func (f *fakeOBS) respond(conn *websocket.Conn, requestType, id string, data json.RawMessage) {
+	var params struct {
+		Delay int `json:"delay"`
+	}
+	json.Unmarshal(data, &params)
+	time.Sleep(time.Duration(params.Delay) * time.Millisecond)
+
+	status := map[string]interface{}{"result": true, "code": 100}
+	d := map[string]interface{}{"requestType": requestType, "requestId": id, "requestStatus": status}
+	switch requestType {
+	case "Fail":
+		status["result"] = false
+		status["code"] = 600
+		status["comment"] = "no such thing"
+	case "GetCurrentProgramScene":
+		f.mu.Lock()
+		d["responseData"] = map[string]string{"currentProgramSceneName": f.scene}
+		f.mu.Unlock()
+	case "GetRecordStatus":
+		f.mu.Lock()
+		d["responseData"] = f.record
+		f.mu.Unlock()
+	default:
+		d["responseData"] = data
+	}
+	// an unknown request ID is ignored by the client
+	conn.WriteJSON(map[string]interface{}{"op": opRequestResponse, "d": map[string]string{"requestId": "unknown"}})
+	conn.WriteJSON(map[string]interface{}{"op": opRequestResponse, "d": d})
+}
+
+// event sends an event to the client.
+func (f *fakeOBS) event(t *testing.T, eventType string, data interface{}) {
+	t.Helper()
+	f.mu.Lock()
+	conn := f.conn
+	f.mu.Unlock()
+	if err := conn.WriteJSON(map[string]interface{}{
+		"op": opEvent,
+		"d":  map[string]interface{}{"eventType": eventType, "eventData": data},
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDial(t *testing.T) {
+	f := newFakeOBS(t, testPassword)
+	c, err := Dial(f.url(), Password(testPassword))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	f.mu.Lock()
+	if f.identify["authentication"] != testAuth || f.identify["rpcVersion"] != 1.0 || f.identify["eventSubscriptions"] != float64(eventSubscriptions) {
+		t.Errorf("identify %v", f.identify)
+	}
+	f.mu.Unlock()
+
+	if _, err := Dial(f.url(), Password("wrong")); err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Errorf("Dial with a wrong password = %v", err)
+	}
+	if _, err := Dial(f.url()); err == nil || !strings.Contains(err.Error(), "password is required") {
+		t.Errorf("Dial without password = %v", err)
+	}
+
+	// No password is needed when authentication is disabled.
+	c2, err := Dial(newFakeOBS(t, "").url())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2.Close()
+}
+
+func TestRequest(t *testing.T) {
+	f := newFakeOBS(t, "")
+	c, err := Dial(f.url(), Timeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// Responses arrive in another order than the requests: each one must
+	// get its own.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var res struct{ N, Delay int }
+			if err := c.Request(context.Background(), "Echo", map[string]int{"n": i, "delay": (10 - i) * 10}, &res); err != nil {
+				t.Error(err)
+				return
+			}
+			if res.N != i {
+				t.Errorf("request %d got the response of %d", i, res.N)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var reqErr *RequestError
+	err = c.Request(context.Background(), "Fail", nil, nil)
+	if !errors.As(err, &reqErr) || reqErr.Code != 600 || reqErr.Comment != "no such thing" {
+		t.Errorf("Fail = %v", err)
+	}
+
+	err = c.Request(context.Background(), "Echo", map[string]int{"delay": 2000}, nil)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("slow request = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.Request(ctx, "Echo", map[string]int{"delay": 2000}, nil); err != context.Canceled {
+		t.Errorf("canceled request = %v", err)
+	}
+	c.mu.Lock()
+	if n := len(c.pending); n != 0 {
+		t.Errorf("%d requests still pending", n)
+	}
+	c.mu.Unlock()
+
+	c.Close()
+	<-c.Done()
+	if err := c.Request(context.Background(), "Echo", nil, nil); err != ErrClosed {
+		t.Errorf("Request after Close = %v", err)
+	}
+}
+
+func TestOnEvent(t *testing.T) {
+	f := newFakeOBS(t, "")
+	c, err := Dial(f.url())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	first := make(chan string, 4)
+	second := make(chan string, 4)
+	cancel := c.OnEvent(func(ev *Event) { first <- ev.Type })
+	c.OnEvent(func(ev *Event) { second <- ev.Type })
+
+	f.event(t, "A", nil)
+	for _, ch := range []chan string{first, second} {
+		select {
+		case typ := <-ch:
+			if typ != "A" {
+				t.Errorf("event %s, want A", typ)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event")
+		}
+	}
+
+	// Handlers are called in order, so the first one would have been
+	// called once the second one is.
+	cancel()
+	cancel()
+	f.event(t, "B", nil)
+	select {
+	case <-second:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+	select {
+	case typ := <-first:
+		t.Errorf("canceled handler called with %s", typ)
+	default:
+	}
+}
+
+func TestSceneButton(t *testing.T) {
+	f := newFakeOBS(t, "")
+	c, err := Dial(f.url())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	b := NewSceneButton(c, "Main")
+	waitCurrent := func(want bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for b.Current() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("Current() = %v, want %v", !want, want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitCurrent(true)
+
+	f.event(t, "CurrentProgramSceneChanged", map[string]string{"sceneName": "Other"})
+	waitCurrent(false)
+
+	// Once closed, the button stops following OBS.
+	b.Close()
+	c.mu.Lock()
+	n := len(c.handlers)
+	c.mu.Unlock()
+	if n != 0 {
+		t.Errorf("%d handlers left after Close", n)
+	}
+
+	o := NewRecordButton(c)
+	m, err := NewToggle(&Mute{Client: c, Input: "Mic"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Close()
+	o.Close()
+	c.mu.Lock()
+	n = len(c.handlers)
+	c.mu.Unlock()
+	if n != 0 {
+		t.Errorf("%d handlers left after Close", n)
+	}
+}
+
+func TestOutputButton(t *testing.T) {
+	f := newFakeOBS(t, "")
+	c, err := Dial(f.url())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	b := NewRecordButton(c)
+	defer b.Close()
+	// record changes the status of the recording, and waits for the button
+	// to show text.
+	record := func(st OutputStatus, text ...string) {
+		t.Helper()
+		f.mu.Lock()
+		f.record = st
+		f.mu.Unlock()
+		f.event(t, "RecordStateChanged", map[string]bool{"outputActive": st.Active})
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			got := b.face().Text()
+			for _, want := range text {
+				if got == want {
+					return
+				}
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("button shows %q, want %q", got, text)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	ticking := func() bool {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return b.stopTick != nil
+	}
+
+	record(OutputStatus{}, "REC")
+	record(OutputStatus{Active: true, Duration: 65000}, "REC\n1:05", "REC\n1:06")
+	if ticking() {
+		t.Error("ticking while not displayed")
+	}
+
+	notified := make(chan bool, 4)
+	b.SetNotify(func() {
+		select {
+		case notified <- true:
+		default:
+		}
+	})
+	if !ticking() {
+		t.Fatal("not ticking while displayed")
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-notified:
+		case <-time.After(5 * time.Second):
+			t.Fatal("elapsed time not updated")
+		}
+	}
+
+	record(OutputStatus{Active: true, Paused: true, Duration: 3723000}, "REC\npaused")
+	if ticking() {
+		t.Error("ticking while paused")
+	}
+	if _, _, elapsed := b.Status(); elapsed != 3723*time.Second {
+		t.Errorf("elapsed %v while paused", elapsed)
+	}
+
+	record(OutputStatus{Active: true, Duration: 3723000}, "REC\n1:02:03", "REC\n1:02:04")
+	if !ticking() {
+		t.Error("not ticking once resumed")
+	}
+	b.SetNotify(nil)
+	if ticking() {
+		t.Error("ticking once unbound")
+	}
+
+	b.SetNotify(func() {})
+	b.Close()
+	if ticking() {
+		t.Error("ticking once closed")
+	}
+}
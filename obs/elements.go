@@ -0,0 +1,287 @@
+package obs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/tile"
+)
+
+// Colors of the elements.
+var (
+	// HighlightColor frames the key of the current scene, and fills the
+	// keys of active outputs.
+	HighlightColor color.Color = color.NRGBA{0xe0, 0x20, 0x20, 0xff}
+	// IdleColor fills the keys of inactive outputs.
+	IdleColor color.Color = color.NRGBA{0x30, 0x30, 0x30, 0xff}
+)
+
+// SceneButton is an Element switching to a scene when pressed, framed
+// while the scene is on program.
+type SceneButton struct {
+	sd.Invalidator
+	client  *Client
+	scene   string
+	tile    *tile.Tile
+	cancel  func()
+	mu      sync.Mutex
+	current bool
+}
+
+var _ sd.Element = (*SceneButton)(nil)
+
+// NewSceneButton creates a SceneButton. By default, it shows the name of
+// the scene; options customize its tile.
+func NewSceneButton(c *Client, scene string, options ...func(*tile.Tile)) *SceneButton {
+	options = append([]func(*tile.Tile){tile.Text(scene)}, options...)
+	b := &SceneButton{
+		client: c,
+		scene:  scene,
+		tile:   tile.New(options...),
+	}
+	b.cancel = c.OnEvent(func(ev *Event) {
+		if ev.Type != "CurrentProgramSceneChanged" {
+			return
+		}
+		var data struct {
+			SceneName string `json:"sceneName"`
+		}
+		json.Unmarshal(ev.Data, &data)
+		b.setCurrent(data.SceneName)
+	})
+	go b.refresh()
+	return b
+}
+
+func (b *SceneButton) refresh() {
+	var res struct {
+		SceneName string `json:"currentProgramSceneName"`
+	}
+	if err := b.client.Request(context.Background(), "GetCurrentProgramScene", nil, &res); err == nil {
+		b.setCurrent(res.SceneName)
+	}
+}
+
+func (b *SceneButton) setCurrent(scene string) {
+	b.mu.Lock()
+	changed := b.current != (scene == b.scene)
+	b.current = scene == b.scene
+	b.mu.Unlock()
+
+	if changed {
+		b.Invalidate()
+	}
+}
+
+// Current reports whether the scene is on program.
+func (b *SceneButton) Current() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.current
+}
+
+// Change switches to the scene when the key is pressed.
+func (b *SceneButton) Change(state sd.BtnState) {
+	if state != sd.BtnPressed {
+		return
+	}
+	go SwitchScene(b.client, b.scene).Run(context.Background(), nil)
+}
+
+// Render draws the tile, framed if the scene is on program.
+func (b *SceneButton) Render(img *image.RGBA) error {
+	if err := b.tile.Render(img); err != nil {
+		return err
+	}
+	if b.Current() {
+		drawFrame(img, HighlightColor)
+	}
+	return nil
+}
+
+// Close stops following the program scene.
+func (b *SceneButton) Close() {
+	b.cancel()
+}
+
+// drawFrame draws a frame of color c around img.
+func drawFrame(img *image.RGBA, c color.Color) {
+	r := img.Bounds()
+	w := r.Dx() / 10
+	if w < 2 {
+		w = 2
+	}
+	frame := image.NewUniform(c)
+	for _, s := range []image.Rectangle{
+		image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+w),
+		image.Rect(r.Min.X, r.Max.Y-w, r.Max.X, r.Max.Y),
+		image.Rect(r.Min.X, r.Min.Y, r.Min.X+w, r.Max.Y),
+		image.Rect(r.Max.X-w, r.Min.Y, r.Max.X, r.Max.Y),
+	} {
+		draw.Draw(img, s, frame, image.Point{}, draw.Src)
+	}
+}
+
+// OutputButton is an Element starting and stopping an output (streaming
+// or recording) when pressed. While the output is active, it is
+// highlighted and shows the elapsed time.
+type OutputButton struct {
+	sd.Invalidator
+	output *Output
+	label  string
+	cancel func()
+
+	mu       sync.Mutex
+	status   OutputStatus
+	at       time.Time // when status was read
+	hosted   bool      // bound to a Host, which draws the button
+	closed   bool
+	stopTick chan struct{} // stops the ticker, nil if not running
+}
+
+var _ sd.Element = (*OutputButton)(nil)
+
+// NewRecordButton creates an OutputButton for the recording.
+func NewRecordButton(c *Client) *OutputButton {
+	return newOutputButton(Recording(c), "REC")
+}
+
+// NewStreamButton creates an OutputButton for the stream.
+func NewStreamButton(c *Client) *OutputButton {
+	return newOutputButton(Streaming(c), "LIVE")
+}
+
+func newOutputButton(o *Output, label string) *OutputButton {
+	b := &OutputButton{
+		output: o,
+		label:  label,
+	}
+	b.cancel = o.Client.OnEvent(func(ev *Event) {
+		if o.Affected(ev) {
+			go b.refresh()
+		}
+	})
+	go b.refresh()
+	return b
+}
+
+// refresh reads the status of the output.
+func (b *OutputButton) refresh() {
+	st, err := b.output.Status(context.Background())
+	if err != nil {
+		return
+	}
+	b.mu.Lock()
+	b.status = *st
+	b.at = time.Now()
+	b.updateTicker()
+	b.mu.Unlock()
+	b.Invalidate()
+}
+
+// SetNotify sets the function called when the button needs to be redrawn.
+// The elapsed time is only updated while it is set.
+func (b *OutputButton) SetNotify(notify func()) {
+	b.Invalidator.SetNotify(notify)
+	b.mu.Lock()
+	b.hosted = notify != nil
+	b.updateTicker()
+	b.mu.Unlock()
+}
+
+// updateTicker starts the ticker while the output is running and the
+// button is displayed by a Host, or stops it otherwise. It must be called
+// with the lock held.
+func (b *OutputButton) updateTicker() {
+	running := b.hosted && !b.closed && b.status.Active && !b.status.Paused
+	if running && b.stopTick == nil {
+		b.stopTick = make(chan struct{})
+		go b.tick(b.stopTick)
+	} else if !running && b.stopTick != nil {
+		close(b.stopTick)
+		b.stopTick = nil
+	}
+}
+
+// tick redraws the elapsed time every second until stop is closed.
+func (b *OutputButton) tick(stop chan struct{}) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-b.output.Client.Done():
+			return
+		case <-t.C:
+			b.Invalidate()
+		}
+	}
+}
+
+// Status returns whether the output is active or paused, and for how long
+// it has been active.
+func (b *OutputButton) Status() (active, paused bool, elapsed time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	elapsed = time.Duration(b.status.Duration) * time.Millisecond
+	if b.status.Active && !b.status.Paused {
+		elapsed += time.Since(b.at)
+	}
+	return b.status.Active, b.status.Paused, elapsed
+}
+
+// Change toggles the output when the key is pressed.
+func (b *OutputButton) Change(state sd.BtnState) {
+	if state != sd.BtnPressed {
+		return
+	}
+	go b.output.Client.Request(context.Background(), "Toggle"+b.output.Name, nil, nil)
+}
+
+// Render draws the label and, while active, the elapsed time.
+func (b *OutputButton) Render(img *image.RGBA) error {
+	return b.face().Render(img)
+}
+
+// face returns the tile showing the current status.
+func (b *OutputButton) face() *tile.Tile {
+	active, paused, elapsed := b.Status()
+
+	t := tile.New(tile.Text(b.label), tile.BgColor(IdleColor))
+	if active {
+		t.SetBgColor(HighlightColor)
+		if paused {
+			t.SetText(b.label + "\npaused")
+		} else {
+			t.SetText(b.label + "\n" + formatElapsed(elapsed))
+		}
+	}
+	return t
+}
+
+// Close stops following the output and updating the elapsed time.
+func (b *OutputButton) Close() {
+	b.cancel()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.updateTicker()
+}
+
+// formatElapsed formats a duration as m:ss, or h:mm:ss after an hour.
+func formatElapsed(d time.Duration) string {
+	s := int(d / time.Second)
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
@@ -0,0 +1,209 @@
+package obs
+
+import (
+	"context"
+	"encoding/json"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/toggle"
+)
+
+// Source is a toggle.Source reflecting a state of OBS, which changes along
+// with some events.
+type Source interface {
+	toggle.Source
+	client() *Client
+	// Affected reports whether an event may change the state.
+	Affected(ev *Event) bool
+}
+
+// Toggle is a toggle button refreshed whenever OBS reports a change of its
+// source.
+type Toggle struct {
+	*toggle.Button
+	cancel func()
+}
+
+// NewToggle creates a toggle button bound to src, refreshed whenever OBS
+// reports a change.
+func NewToggle(src Source, off, on sd.Element, options ...func(*toggle.Button)) (*Toggle, error) {
+	options = append([]func(*toggle.Button){toggle.WithSource(src)}, options...)
+	b, err := toggle.NewToggle(off, on, options...)
+	if err != nil {
+		return nil, err
+	}
+	cancel := src.client().OnEvent(func(ev *Event) {
+		if src.Affected(ev) {
+			go b.Refresh()
+		}
+	})
+	return &Toggle{Button: b, cancel: cancel}, nil
+}
+
+// Close stops following the changes of the source, and closes the button.
+func (t *Toggle) Close() {
+	t.cancel()
+	t.Button.Close()
+}
+
+func boolState(b bool) int {
+	if b {
+		return toggle.On
+	}
+	return toggle.Off
+}
+
+// Mute is On when an input is muted.
+type Mute struct {
+	Client *Client
+	Input  string
+}
+
+// Get returns whether the input is muted.
+func (m *Mute) Get(ctx context.Context) (int, error) {
+	var res struct {
+		InputMuted bool `json:"inputMuted"`
+	}
+	err := m.Client.Request(ctx, "GetInputMute", map[string]string{"inputName": m.Input}, &res)
+	return boolState(res.InputMuted), err
+}
+
+// Set mutes or unmutes the input.
+func (m *Mute) Set(ctx context.Context, state int) error {
+	return m.Client.Request(ctx, "SetInputMute", map[string]interface{}{
+		"inputName":  m.Input,
+		"inputMuted": state == toggle.On,
+	}, nil)
+}
+
+func (m *Mute) client() *Client { return m.Client }
+
+// Affected reports whether ev is about the mute state of the input.
+func (m *Mute) Affected(ev *Event) bool {
+	if ev.Type != "InputMuteStateChanged" {
+		return false
+	}
+	var data struct {
+		InputName string `json:"inputName"`
+	}
+	json.Unmarshal(ev.Data, &data)
+	return data.InputName == m.Input
+}
+
+// SourceVisible is On when a source is visible in a scene.
+type SourceVisible struct {
+	Client *Client
+	Scene  string
+	Source string
+}
+
+// itemID looks up the scene item of the source.
+func (v *SourceVisible) itemID(ctx context.Context) (int, error) {
+	var res struct {
+		SceneItemID int `json:"sceneItemId"`
+	}
+	err := v.Client.Request(ctx, "GetSceneItemId", map[string]string{
+		"sceneName":  v.Scene,
+		"sourceName": v.Source,
+	}, &res)
+	return res.SceneItemID, err
+}
+
+// Get returns whether the source is visible.
+func (v *SourceVisible) Get(ctx context.Context) (int, error) {
+	id, err := v.itemID(ctx)
+	if err != nil {
+		return toggle.Off, err
+	}
+	var res struct {
+		Enabled bool `json:"sceneItemEnabled"`
+	}
+	err = v.Client.Request(ctx, "GetSceneItemEnabled", map[string]interface{}{
+		"sceneName":   v.Scene,
+		"sceneItemId": id,
+	}, &res)
+	return boolState(res.Enabled), err
+}
+
+// Set shows or hides the source.
+func (v *SourceVisible) Set(ctx context.Context, state int) error {
+	id, err := v.itemID(ctx)
+	if err != nil {
+		return err
+	}
+	return v.Client.Request(ctx, "SetSceneItemEnabled", map[string]interface{}{
+		"sceneName":        v.Scene,
+		"sceneItemId":      id,
+		"sceneItemEnabled": state == toggle.On,
+	}, nil)
+}
+
+func (v *SourceVisible) client() *Client { return v.Client }
+
+// Affected reports whether ev is about an item of the scene.
+func (v *SourceVisible) Affected(ev *Event) bool {
+	if ev.Type != "SceneItemEnableStateChanged" {
+		return false
+	}
+	var data struct {
+		SceneName string `json:"sceneName"`
+	}
+	json.Unmarshal(ev.Data, &data)
+	return data.SceneName == v.Scene
+}
+
+// Output is On while an output is active: Streaming or Recording.
+type Output struct {
+	Client *Client
+	Name   string // "Stream" or "Record"
+}
+
+// Streaming returns the state of the stream.
+func Streaming(c *Client) *Output {
+	return &Output{Client: c, Name: "Stream"}
+}
+
+// Recording returns the state of the recording.
+func Recording(c *Client) *Output {
+	return &Output{Client: c, Name: "Record"}
+}
+
+// OutputStatus is the status of an output.
+type OutputStatus struct {
+	Active   bool  `json:"outputActive"`
+	Paused   bool  `json:"outputPaused"`
+	Duration int64 `json:"outputDuration"` // milliseconds
+}
+
+// Status returns the status of the output.
+func (o *Output) Status(ctx context.Context) (*OutputStatus, error) {
+	var res OutputStatus
+	if err := o.Client.Request(ctx, "Get"+o.Name+"Status", nil, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Get returns whether the output is active.
+func (o *Output) Get(ctx context.Context) (int, error) {
+	st, err := o.Status(ctx)
+	if err != nil {
+		return toggle.Off, err
+	}
+	return boolState(st.Active), nil
+}
+
+// Set starts or stops the output.
+func (o *Output) Set(ctx context.Context, state int) error {
+	if state == toggle.On {
+		return o.Client.Request(ctx, "Start"+o.Name, nil, nil)
+	}
+	return o.Client.Request(ctx, "Stop"+o.Name, nil, nil)
+}
+
+func (o *Output) client() *Client { return o.Client }
+
+// Affected reports whether ev is a state change of the output.
+func (o *Output) Affected(ev *Event) bool {
+	return ev.Type == o.Name+"StateChanged"
+}
@@ -0,0 +1,376 @@
+// Package osc connects Stream Decks to lighting and audio consoles, and
+// other software speaking Open Sound Control over UDP.
+//
+// With the default "/streamdeck" prefix, the Bridge sends on key events:
+//
+//	/streamdeck/key/N   1 when key N is pressed, 0 when it is released
+//
+// unless other messages are set with KeyMessages, and handles:
+//
+//	/streamdeck/key/N/text         text of key N; numbers are formatted
+//	/streamdeck/key/N/color        background color, as a string like "red"
+//	                               or "#ff8000", or as red, green and blue
+//	                               arguments: integers from 0 to 255 or
+//	                               floats from 0 to 1
+//	/streamdeck/key/N/text_color   text color
+//	/streamdeck/key/N/image        path of an image file
+//	/streamdeck/key/N/clear        blanks the key
+//	/streamdeck/brightness         brightness, an integer from 0 to 100 or
+//	                               a float from 0 to 1
+//	/streamdeck/page               page name or "back", with a profile
+//
+// Received addresses may be patterns, like /streamdeck/key/*/color or
+// /streamdeck/key/{0,1}/text.
+package osc
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/page"
+	"github.com/KarpelesLab/streamdeck/profile"
+	"github.com/KarpelesLab/streamdeck/tile"
+)
+
+// DefaultPrefix is the address prefix used by default.
+const DefaultPrefix = "/streamdeck"
+
+// fields are the key properties that can be set.
+var fields = []string{"text", "color", "text_color", "image", "clear"}
+
+// Bridge sends the key events of a deck as OSC messages, and displays the
+// messages it receives.
+type Bridge struct {
+	conn    *Conn
+	dev     *sd.StreamDeck
+	prefix  string
+	target  string
+	keys    map[int][2]*Message
+	profile string
+	onError func(error)
+
+	addr    *net.UDPAddr
+	surface *tap
+	host    *sd.Host
+	runtime *profile.Runtime
+
+	mu    sync.Mutex
+	tiles map[int]*tile.Tile
+}
+
+// Prefix sets the prefix of the addresses, DefaultPrefix by default.
+func Prefix(prefix string) func(*Bridge) {
+	return func(b *Bridge) {
+		b.prefix = strings.TrimSuffix(prefix, "/")
+	}
+}
+
+// Target sets the UDP address key events are sent to, e.g.
+// "192.168.1.10:8000". Without a target, no message is sent.
+func Target(addr string) func(*Bridge) {
+	return func(b *Bridge) {
+		b.target = addr
+	}
+}
+
+// KeyMessages sets the messages sent when a key is pressed and released,
+// instead of the default ones. A nil message isn't sent.
+func KeyMessages(btnIndex int, pressed, released *Message) func(*Bridge) {
+	return func(b *Bridge) {
+		b.keys[btnIndex] = [2]*Message{pressed, released}
+	}
+}
+
+// Profile displays the profile file at path on the deck. Keys set over
+// OSC replace those of the current page.
+func Profile(path string) func(*Bridge) {
+	return func(b *Bridge) {
+		b.profile = path
+	}
+}
+
+// OnBridgeError sets a function called with the errors of the messages
+// received and sent.
+func OnBridgeError(f func(error)) func(*Bridge) {
+	return func(b *Bridge) {
+		b.onError = f
+	}
+}
+
+// NewBridge bridges dev to the OSC messages of c.
+func NewBridge(c *Conn, dev *sd.StreamDeck, options ...func(*Bridge)) (*Bridge, error) {
+	b := &Bridge{
+		conn:    c,
+		dev:     dev,
+		prefix:  DefaultPrefix,
+		keys:    make(map[int][2]*Message),
+		surface: &tap{StreamDeck: dev},
+		tiles:   make(map[int]*tile.Tile),
+	}
+	for _, option := range options {
+		option(b)
+	}
+
+	if b.target != "" {
+		addr, err := net.ResolveUDPAddr("udp", b.target)
+		if err != nil {
+			return nil, err
+		}
+		b.addr = addr
+	}
+
+	if b.profile != "" {
+		serial, err := dev.GetSerialNumber()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read serial number: %w", err)
+		}
+		b.runtime, err = profile.Run(b.profile, b.surface, serial)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		b.host = sd.NewHost(b.surface)
+	}
+	dev.SetBtnEventCb(b.event)
+
+	for i := 0; i < dev.NumButtons(); i++ {
+		btnIndex := i
+		for _, field := range fields {
+			field := field
+			c.Handle(b.keyAddress(btnIndex, field), func(m *Message) {
+				if err := b.set(btnIndex, field, m.Args); err != nil {
+					b.error(fmt.Errorf("%s: %w", m.Address, err))
+				}
+			})
+		}
+	}
+	c.Handle(b.prefix+"/brightness", b.setBrightness)
+	c.Handle(b.prefix+"/page", b.setPage)
+	return b, nil
+}
+
+// keyAddress returns the address of a property of a key.
+func (b *Bridge) keyAddress(btnIndex int, field string) string {
+	return b.prefix + "/key/" + strconv.Itoa(btnIndex) + "/" + field
+}
+
+// send sends m to the target, if any.
+func (b *Bridge) send(m *Message) {
+	if b.addr == nil || m == nil {
+		return
+	}
+	if err := b.conn.Send(b.addr, m); err != nil {
+		b.error(err)
+	}
+}
+
+func (b *Bridge) event(btnIndex int, state sd.BtnState) {
+	msgs, ok := b.keys[btnIndex]
+	if !ok {
+		address := b.prefix + "/key/" + strconv.Itoa(btnIndex)
+		msgs = [2]*Message{NewMessage(address, 1), NewMessage(address, 0)}
+	}
+	if state == sd.BtnPressed {
+		b.send(msgs[0])
+	} else {
+		b.send(msgs[1])
+	}
+	b.surface.event(btnIndex, state)
+}
+
+// tile returns the tile displayed on a key by the bridge, binding a new
+// one if needed.
+func (b *Bridge) tile(btnIndex int) (*tile.Tile, error) {
+	b.mu.Lock()
+	t, ok := b.tiles[btnIndex]
+	if !ok {
+		t = tile.New()
+		b.tiles[btnIndex] = t
+	}
+	b.mu.Unlock()
+
+	if b.runtime != nil {
+		// The page may have changed since the tile was bound.
+		cur := b.runtime.Deck().Current()
+		if k := cur.Key(btnIndex); ok && k != nil && k.Element == t {
+			return t, nil
+		}
+		cur.SetKey(btnIndex, &page.Key{Element: t})
+		return t, nil
+	}
+	if ok {
+		return t, nil
+	}
+	return t, b.host.Bind(btnIndex, t)
+}
+
+// set changes a field of a key.
+func (b *Bridge) set(btnIndex int, field string, args []interface{}) error {
+	if field == "clear" {
+		b.mu.Lock()
+		delete(b.tiles, btnIndex)
+		b.mu.Unlock()
+		if b.runtime != nil {
+			b.runtime.Deck().Current().SetKey(btnIndex, nil)
+			return nil
+		}
+		b.host.Unbind(btnIndex)
+		return b.dev.ClearBtn(btnIndex)
+	}
+
+	t, err := b.tile(btnIndex)
+	if err != nil {
+		return err
+	}
+	switch field {
+	case "text":
+		parts := make([]string, len(args))
+		for i, arg := range args {
+			parts[i] = argString(arg)
+		}
+		t.SetText(strings.Join(parts, " "))
+	case "color", "text_color":
+		c, err := parseColor(args)
+		if err != nil {
+			return err
+		}
+		if field == "color" {
+			t.SetBgColor(c)
+		} else {
+			t.SetTextColor(c)
+		}
+	case "image":
+		if len(args) == 0 || argString(args[0]) == "" {
+			t.SetImage(nil)
+			return nil
+		}
+		return t.SetImageFile(argString(args[0]))
+	}
+	return nil
+}
+
+// parseColor returns the color given by the arguments of a message.
+func parseColor(args []interface{}) (color.Color, error) {
+	if len(args) == 1 {
+		if s, ok := args[0].(string); ok {
+			return profile.ParseColor(strings.TrimSpace(s))
+		}
+	}
+	if len(args) != 3 && len(args) != 4 {
+		return nil, errors.New("expected a color name, or red, green and blue")
+	}
+	var c [4]uint8
+	c[3] = 255
+	for i, arg := range args {
+		f, ok := argFloat(arg)
+		if !ok {
+			return nil, fmt.Errorf("invalid color component %v", arg)
+		}
+		if isFloat(arg) {
+			f *= 255
+		}
+		if f < 0 || f > 255 {
+			return nil, fmt.Errorf("color component %v out of range", arg)
+		}
+		c[i] = uint8(f + 0.5)
+	}
+	return color.NRGBA{c[0], c[1], c[2], c[3]}, nil
+}
+
+func (b *Bridge) setBrightness(m *Message) {
+	var pc float64
+	ok := len(m.Args) == 1
+	if ok {
+		pc, ok = argFloat(m.Args[0])
+		if isFloat(m.Args[0]) {
+			pc *= 100
+		}
+	}
+	if !ok || pc < 0 || pc > 100 {
+		b.error(fmt.Errorf("%s: brightness must be between 0 and 100", m.Address))
+		return
+	}
+	if err := b.dev.SetBrightness(uint8(pc + 0.5)); err != nil {
+		b.error(err)
+	}
+}
+
+func (b *Bridge) setPage(m *Message) {
+	if b.runtime == nil {
+		b.error(fmt.Errorf("%s: no profile loaded", m.Address))
+		return
+	}
+	if len(m.Args) != 1 {
+		b.error(fmt.Errorf("%s: expected a page name", m.Address))
+		return
+	}
+	name := strings.TrimSpace(argString(m.Args[0]))
+	var err error
+	if name == "back" {
+		err = b.runtime.Deck().Back()
+	} else {
+		err = b.runtime.Deck().SwitchToName(name)
+	}
+	if err != nil {
+		b.error(fmt.Errorf("%s: %w", m.Address, err))
+		return
+	}
+	b.send(NewMessage(b.prefix+"/page", b.runtime.Deck().Current().Name()))
+}
+
+func (b *Bridge) error(err error) {
+	if b.onError != nil {
+		b.onError(err)
+	}
+}
+
+// Close detaches the bridge from the deck and the Conn, which are left
+// open.
+func (b *Bridge) Close() error {
+	b.dev.SetBtnEventCb(nil)
+	for i := 0; i < b.dev.NumButtons(); i++ {
+		for _, field := range fields {
+			b.conn.Handle(b.keyAddress(i, field), nil)
+		}
+	}
+	b.conn.Handle(b.prefix+"/brightness", nil)
+	b.conn.Handle(b.prefix+"/page", nil)
+
+	if b.runtime != nil {
+		b.runtime.Close()
+	}
+	if b.host != nil {
+		b.host.Close()
+	}
+	return nil
+}
+
+// tap is the surface given to the Host or profile of a Bridge. It keeps
+// the callback they set, so the Bridge sees events first.
+type tap struct {
+	*sd.StreamDeck
+	mu sync.Mutex
+	cb sd.BtnEvent
+}
+
+func (t *tap) SetBtnEventCb(ev sd.BtnEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cb = ev
+}
+
+func (t *tap) event(btnIndex int, state sd.BtnState) {
+	t.mu.Lock()
+	cb := t.cb
+	t.mu.Unlock()
+
+	if cb != nil {
+		cb(btnIndex, state)
+	}
+}
@@ -0,0 +1,334 @@
+package osc
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/internal/testutil"
+	"github.com/KarpelesLab/streamdeck/mock"
+)
+
+var (
+	black = color.RGBA{0, 0, 0, 255}
+	red   = color.RGBA{255, 0, 0, 255}
+	blue  = color.RGBA{0, 0, 255, 255}
+)
+
+func listen(t *testing.T, options ...func(*Conn)) *Conn {
+	t.Helper()
+	c, err := Listen("127.0.0.1:0", options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func addr(c *Conn) *net.UDPAddr {
+	return c.LocalAddr().(*net.UDPAddr)
+}
+
+func expect(t *testing.T, ch chan *Message, want *Message) {
+	t.Helper()
+	select {
+	case m := <-ch:
+		if !reflect.DeepEqual(m, want) {
+			t.Errorf("got %v, want %v", m, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no %v", want)
+	}
+}
+
+func TestConn(t *testing.T) {
+	errs := make(chan error, 4)
+	a := listen(t, OnError(func(err error) { errs <- err }))
+	b := listen(t)
+
+	msgs := make(chan *Message, 4)
+	a.Handle("/x/1", func(m *Message) { msgs <- m })
+	a.Handle("/x/2", func(m *Message) { msgs <- m })
+
+	want := NewMessage("/x/1", int32(3), "abc", float32(0.5))
+	if err := b.Send(addr(a), want); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, msgs, want)
+
+	// A pattern calls every matching method.
+	if err := b.Send(addr(a), NewMessage("/x/[12]")); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, msgs, NewMessage("/x/[12]"))
+	expect(t, msgs, NewMessage("/x/[12]"))
+
+	// Malformed packets are reported, and don't stop reading.
+	if _, err := b.pc.WriteToUDP([]byte("/x/1"), addr(a)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-errs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no error reported")
+	}
+
+	a.Handle("/x/2", nil)
+	b.Send(addr(a), NewMessage("/x/*", int32(1)))
+	b.Send(addr(a), NewMessage("/x/1", int32(2)))
+	expect(t, msgs, NewMessage("/x/*", int32(1)))
+	expect(t, msgs, NewMessage("/x/1", int32(2)))
+
+	if err := b.Send(addr(a), NewMessage("x")); err == nil {
+		t.Error("invalid message sent")
+	}
+}
+
+// env is a deck bridged to OSC, and a console exchanging messages with it.
+type env struct {
+	mock    *mock.Device
+	bridge  *Bridge
+	console *Conn
+	deck    *net.UDPAddr
+	msgs    chan *Message
+	errs    chan error
+}
+
+func newEnv(t *testing.T, options ...func(*Bridge)) *env {
+	t.Helper()
+	e := &env{
+		msgs: make(chan *Message, 16),
+		errs: make(chan error, 16),
+	}
+	var dev *sd.StreamDeck
+	dev, e.mock = mock.Open(t, sd.LookupDevice(0x0063), "TEST0001")
+
+	e.console = listen(t)
+	forward := func(m *Message) { e.msgs <- m }
+	e.console.Handle("/streamdeck/page", forward)
+	e.console.Handle("/streamdeck/go", forward)
+	for i := 0; i < 6; i++ {
+		e.console.Handle("/streamdeck/key/"+strconv.Itoa(i), forward)
+	}
+	c := listen(t)
+	e.deck = addr(c)
+
+	options = append([]func(*Bridge){
+		Target(addr(e.console).String()),
+		OnBridgeError(func(err error) { e.errs <- err }),
+	}, options...)
+	var err error
+	if e.bridge, err = NewBridge(c, dev, options...); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { e.bridge.Close() })
+	return e
+}
+
+func (e *env) send(t *testing.T, address string, args ...interface{}) {
+	t.Helper()
+	if err := e.console.Send(e.deck, NewMessage(address, args...)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBridgeKeys(t *testing.T) {
+	e := newEnv(t, KeyMessages(3, NewMessage("/streamdeck/go"), nil))
+
+	e.mock.Press(1)
+	expect(t, e.msgs, NewMessage("/streamdeck/key/1", int32(1)))
+	e.mock.Release(1)
+	expect(t, e.msgs, NewMessage("/streamdeck/key/1", int32(0)))
+
+	// Key 3 sends a custom message when pressed, nothing when released.
+	e.mock.Click(3)
+	e.mock.Click(2)
+	expect(t, e.msgs, NewMessage("/streamdeck/go"))
+	expect(t, e.msgs, NewMessage("/streamdeck/key/2", int32(1)))
+}
+
+func TestBridgeSet(t *testing.T) {
+	e := newEnv(t)
+
+	e.send(t, "/streamdeck/key/0/color", "#ff0000")
+	e.mock.WaitColor(t, 0, red)
+	e.send(t, "/streamdeck/key/0/color", int32(0), int32(0), int32(255))
+	e.mock.WaitColor(t, 0, blue)
+	e.send(t, "/streamdeck/key/0/color", float32(1), float32(0), float32(0))
+	e.mock.WaitColor(t, 0, red)
+
+	// Patterns set several keys at once.
+	e.send(t, "/streamdeck/key/{1,2}/color", "blue")
+	e.mock.WaitColor(t, 1, blue)
+	e.mock.WaitColor(t, 2, blue)
+	e.send(t, "/streamdeck/key/*/clear")
+	for i := 0; i < 6; i++ {
+		e.mock.WaitColor(t, i, black)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	path := filepath.Join(t.TempDir(), "white.png")
+	if err := writePNG(path, img); err != nil {
+		t.Fatal(err)
+	}
+	e.send(t, "/streamdeck/key/5/image", path)
+	e.mock.WaitColor(t, 5, color.RGBA{255, 255, 255, 255})
+
+	e.send(t, "/streamdeck/key/4/text", "v", float32(1.5))
+	e.send(t, "/streamdeck/key/4/text_color", "yellow")
+	deadline := time.Now().Add(5 * time.Second)
+	for !hasColor(e.mock.Key(4), color.RGBA{255, 255, 0, 255}) {
+		if time.Now().After(deadline) {
+			t.Fatal("no yellow text on key 4")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	e.send(t, "/streamdeck/brightness", float32(0.25))
+	deadline = time.Now().Add(5 * time.Second)
+	for e.mock.Brightness() != 25 {
+		if time.Now().After(deadline) {
+			t.Fatalf("brightness = %d, want 25", e.mock.Brightness())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	e.send(t, "/streamdeck/key/0/color", "nope")
+	testutil.ExpectError(t, e.errs, "/streamdeck/key/0/color")
+	e.send(t, "/streamdeck/key/0/color", int32(300), int32(0), int32(0))
+	testutil.ExpectError(t, e.errs, "out of range")
+	e.send(t, "/streamdeck/key/0/color", int32(1), int32(2))
+	testutil.ExpectError(t, e.errs, "expected a color")
+	e.send(t, "/streamdeck/brightness", int32(101))
+	testutil.ExpectError(t, e.errs, "between 0 and 100")
+	e.send(t, "/streamdeck/key/0/image", "/nonexistent.png")
+	testutil.ExpectError(t, e.errs, "/streamdeck/key/0/image")
+	e.send(t, "/streamdeck/page", "main")
+	testutil.ExpectError(t, e.errs, "no profile")
+}
+
+func TestBridgePage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profile.yaml")
+	conf := `devices:
+  - pages:
+      - name: main
+        keys:
+          - key: 0
+            color: "#ff0000"
+      - name: sub
+        parent: main
+        keys:
+          - key: 0
+            color: "#0000ff"
+`
+	if err := ioutil.WriteFile(path, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e := newEnv(t, Profile(path))
+	e.mock.WaitColor(t, 0, red)
+
+	e.send(t, "/streamdeck/page", "sub")
+	expect(t, e.msgs, NewMessage("/streamdeck/page", "sub"))
+	e.mock.WaitColor(t, 0, blue)
+
+	// Keys set over OSC replace those of the current page.
+	e.send(t, "/streamdeck/key/0/color", "#ff0000")
+	e.mock.WaitColor(t, 0, red)
+
+	e.send(t, "/streamdeck/page", "back")
+	expect(t, e.msgs, NewMessage("/streamdeck/page", "main"))
+
+	e.send(t, "/streamdeck/page", "nope")
+	testutil.ExpectError(t, e.errs, "/streamdeck/page")
+}
+
+func TestBridgeClose(t *testing.T) {
+	e := newEnv(t)
+	e.bridge.Close()
+
+	e.send(t, "/streamdeck/key/0/color", "red")
+	e.mock.Click(0)
+	select {
+	case m := <-e.msgs:
+		t.Errorf("message %v after Close", m)
+	case err := <-e.errs:
+		t.Errorf("error %v after Close", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if c := e.mock.Key(0).(*image.RGBA).RGBAAt(4, 4); c != black {
+		t.Errorf("key 0 is %v after Close", c)
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		args []interface{}
+		want color.Color
+	}{
+		{[]interface{}{"red"}, color.RGBA{255, 0, 0, 255}},
+		{[]interface{}{" #00ff00 "}, color.RGBA{0, 255, 0, 255}},
+		{[]interface{}{int32(1), int32(2), int32(3)}, color.NRGBA{1, 2, 3, 255}},
+		{[]interface{}{int32(1), int32(2), int32(3), int32(4)}, color.NRGBA{1, 2, 3, 4}},
+		{[]interface{}{float32(1), 0.5, float32(0)}, color.NRGBA{255, 128, 0, 255}},
+		{[]interface{}{"10", true, false}, color.NRGBA{10, 1, 0, 255}},
+		{nil, nil},
+		{[]interface{}{"nope"}, nil},
+		{[]interface{}{int32(1), int32(2)}, nil},
+		{[]interface{}{int32(1), int32(-2), int32(3)}, nil},
+		{[]interface{}{float32(1.5), int32(2), int32(3)}, nil},
+		{[]interface{}{[]byte("x"), int32(2), int32(3)}, nil},
+	}
+	for _, test := range tests {
+		c, err := parseColor(test.args)
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("parseColor(%v) = %v", test.args, c)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseColor(%v): %s", test.args, err)
+			continue
+		}
+		if color.RGBAModel.Convert(c) != color.RGBAModel.Convert(test.want) {
+			t.Errorf("parseColor(%v) = %v, want %v", test.args, c, test.want)
+		}
+	}
+}
+
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// hasColor reports whether a pixel of img has the color c.
+func hasColor(img image.Image, c color.RGBA) bool {
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if color.RGBAModel.Convert(img.At(x, y)) == c {
+				return true
+			}
+		}
+	}
+	return false
+}
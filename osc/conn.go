@@ -0,0 +1,131 @@
+package osc
+
+import (
+	"net"
+	"sync"
+)
+
+// maxPacket is the largest UDP datagram.
+const maxPacket = 65535
+
+// Conn sends and receives OSC messages over UDP. Received messages are
+// dispatched to the methods registered with Handle whose address matches
+// the address pattern of the message.
+type Conn struct {
+	pc      *net.UDPConn
+	onError func(error)
+
+	mu      sync.Mutex
+	methods map[string]func(*Message)
+	done    chan struct{}
+}
+
+// OnError sets a function called with the packets that can't be decoded.
+func OnError(f func(error)) func(*Conn) {
+	return func(c *Conn) {
+		c.onError = f
+	}
+}
+
+// Listen listens for OSC messages on the UDP address addr, e.g. ":9000".
+// Messages are sent from the same socket, which is what most consoles
+// expect.
+func Listen(addr string, options ...func(*Conn)) (*Conn, error) {
+	ua, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := net.ListenUDP("udp", ua)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Conn{
+		pc:      pc,
+		methods: make(map[string]func(*Message)),
+		done:    make(chan struct{}),
+	}
+	for _, option := range options {
+		option(c)
+	}
+	go c.read()
+	return c, nil
+}
+
+// LocalAddr returns the address c listens on.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.pc.LocalAddr()
+}
+
+func (c *Conn) read() {
+	defer close(c.done)
+	buf := make([]byte, maxPacket)
+	for {
+		n, _, err := c.pc.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+		msgs, err := parsePacket(buf[:n])
+		if err != nil {
+			c.error(err)
+			continue
+		}
+		for _, m := range msgs {
+			c.dispatch(m)
+		}
+	}
+}
+
+func (c *Conn) dispatch(m *Message) {
+	c.mu.Lock()
+	var handlers []func(*Message)
+	for address, handler := range c.methods {
+		if Match(m.Address, address) {
+			handlers = append(handlers, handler)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, handler := range handlers {
+		handler(m)
+	}
+}
+
+func (c *Conn) error(err error) {
+	if c.onError != nil {
+		c.onError(err)
+	}
+}
+
+// Handle registers handler for the messages sent to address. The address
+// of received messages may be a pattern (see Match), in which case every
+// matching handler is called. A nil handler removes the method.
+func (c *Conn) Handle(address string, handler func(*Message)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if handler == nil {
+		delete(c.methods, address)
+		return
+	}
+	c.methods[address] = handler
+}
+
+// Send sends m to the UDP address addr.
+func (c *Conn) Send(addr *net.UDPAddr, m *Message) error {
+	p, err := m.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = c.pc.WriteToUDP(p, addr)
+	return err
+}
+
+// Close stops listening.
+func (c *Conn) Close() error {
+	err := c.pc.Close()
+	<-c.done
+	return err
+}
@@ -0,0 +1,89 @@
+package osc
+
+import "strings"
+
+// Match reports whether an OSC address pattern matches address. In each
+// part of the pattern, "?" matches any character, "*" any sequence of
+// characters, "[a-z]" and "[!abc]" a character of a set or out of it, and
+// "{foo,bar}" one of the strings listed.
+func Match(pattern, address string) bool {
+	pp := strings.Split(pattern, "/")
+	ap := strings.Split(address, "/")
+	if len(pp) != len(ap) {
+		return false
+	}
+	for i := range pp {
+		if !matchPart(pp[i], ap[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchPart(p, s string) bool {
+	for len(p) > 0 {
+		switch p[0] {
+		case '*':
+			p = strings.TrimLeft(p, "*")
+			if p == "" {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchPart(p, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+		case '[':
+			end := strings.IndexByte(p, ']')
+			if end < 0 || s == "" {
+				return false
+			}
+			if !matchSet(p[1:end], s[0]) {
+				return false
+			}
+			p = p[end:]
+		case '{':
+			end := strings.IndexByte(p, '}')
+			if end < 0 {
+				return false
+			}
+			for _, alt := range strings.Split(p[1:end], ",") {
+				if strings.HasPrefix(s, alt) && matchPart(p[end+1:], s[len(alt):]) {
+					return true
+				}
+			}
+			return false
+		default:
+			if s == "" || s[0] != p[0] {
+				return false
+			}
+		}
+		p, s = p[1:], s[1:]
+	}
+	return s == ""
+}
+
+// matchSet reports whether c matches the content of a [set].
+func matchSet(set string, c byte) bool {
+	negate := strings.HasPrefix(set, "!")
+	if negate {
+		set = set[1:]
+	}
+	in := false
+	for i := 0; i < len(set); i++ {
+		if i+2 < len(set) && set[i+1] == '-' {
+			if set[i] <= c && c <= set[i+2] {
+				in = true
+			}
+			i += 2
+		} else if set[i] == c {
+			in = true
+		}
+	}
+	return in != negate
+}
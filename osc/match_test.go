@@ -0,0 +1,67 @@
+package osc
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, address string
+		want             bool
+	}{
+		{"/a/b", "/a/b", true},
+		{"/a/b", "/a/c", false},
+		{"/a/b", "/a/b/c", false},
+		{"/a/b/c", "/a/b", false},
+		{"/a/b", "/a/bc", false},
+		{"/a/bc", "/a/b", false},
+
+		{"/a/?", "/a/b", true},
+		{"/a/?", "/a/", false},
+		{"/a/?", "/a/bc", false},
+		{"/a/b?d", "/a/bcd", true},
+
+		{"/a/*", "/a/b", true},
+		{"/a/*", "/a/", true},
+		{"/a/*", "/a/b/c", false}, // * doesn't cross parts
+		{"/*/*", "/a/b", true},
+		{"/a/*c", "/a/bbc", true},
+		{"/a/*c", "/a/bcd", false},
+		{"/a/b*d*f", "/a/bcdef", true},
+		{"/a/b**", "/a/b", true},
+		{"/a/*b*", "/a/xyz", false},
+
+		{"/a/[bc]", "/a/b", true},
+		{"/a/[bc]", "/a/c", true},
+		{"/a/[bc]", "/a/d", false},
+		{"/a/[bc]", "/a/", false},
+		{"/a/[a-c]x", "/a/bx", true},
+		{"/a/[a-c]x", "/a/dx", false},
+		{"/a/[0-9][0-9]", "/a/42", true},
+		{"/a/[!bc]", "/a/d", true},
+		{"/a/[!bc]", "/a/b", false},
+		{"/a/[!a-c]", "/a/b", false},
+		{"/a/[!a-c]", "/a/z", true},
+		{"/a/[a-]", "/a/-", true}, // a trailing - is a character
+		{"/a/[b", "/a/b", false},  // unterminated
+
+		{"/a/{b,cd}", "/a/b", true},
+		{"/a/{b,cd}", "/a/cd", true},
+		{"/a/{b,cd}", "/a/c", false},
+		{"/a/{b,cd}", "/a/bcd", false},
+		{"/a/{b,bc}d", "/a/bcd", true}, // the first alternative fails later
+		{"/a/x{,y}", "/a/x", true},
+		{"/a/{b,c}/{d,e}", "/a/c/d", true},
+		{"/a/{b,c", "/a/b", false}, // unterminated
+		{"/a/{b,c}*", "/a/cat", true},
+
+		{"/streamdeck/key/*/color", "/streamdeck/key/12/color", true},
+		{"/streamdeck/key/{0,1}/text", "/streamdeck/key/1/text", true},
+		{"/streamdeck/key/{0,1}/text", "/streamdeck/key/10/text", false},
+		{"/streamdeck/key/1?/text", "/streamdeck/key/12/text", true},
+		{"/streamdeck/key/[!0]/text", "/streamdeck/key/0/text", false},
+	}
+	for _, test := range tests {
+		if got := Match(test.pattern, test.address); got != test.want {
+			t.Errorf("Match(%q, %q) = %v", test.pattern, test.address, got)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package osc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Message is an OSC message. The arguments are int32, float32, string,
+// []byte, bool, int64, float64 or nil values. When sending, int is also
+// accepted and sent as an int32.
+type Message struct {
+	Address string
+	Args    []interface{}
+}
+
+// NewMessage returns a message for address with the given arguments.
+func NewMessage(address string, args ...interface{}) *Message {
+	return &Message{Address: address, Args: args}
+}
+
+// String returns the address and arguments of m, for logs.
+func (m *Message) String() string {
+	var b strings.Builder
+	b.WriteString(m.Address)
+	for _, arg := range m.Args {
+		if s, ok := arg.(string); ok {
+			fmt.Fprintf(&b, " %q", s)
+		} else {
+			fmt.Fprintf(&b, " %v", arg)
+		}
+	}
+	return b.String()
+}
+
+// MarshalBinary encodes m as an OSC packet.
+func (m *Message) MarshalBinary() ([]byte, error) {
+	if !strings.HasPrefix(m.Address, "/") {
+		return nil, fmt.Errorf("osc: invalid address %q", m.Address)
+	}
+	tags := []byte{','}
+	var args bytes.Buffer
+	for _, arg := range m.Args {
+		switch v := arg.(type) {
+		case int:
+			if v < math.MinInt32 || v > math.MaxInt32 {
+				return nil, fmt.Errorf("osc: %d overflows an int32", v)
+			}
+			tags = append(tags, 'i')
+			binary.Write(&args, binary.BigEndian, int32(v))
+		case int32:
+			tags = append(tags, 'i')
+			binary.Write(&args, binary.BigEndian, v)
+		case float32:
+			tags = append(tags, 'f')
+			binary.Write(&args, binary.BigEndian, v)
+		case string:
+			tags = append(tags, 's')
+			writeString(&args, v)
+		case []byte:
+			tags = append(tags, 'b')
+			binary.Write(&args, binary.BigEndian, int32(len(v)))
+			args.Write(v)
+			args.Write(make([]byte, pad(len(v))-len(v)))
+		case bool:
+			if v {
+				tags = append(tags, 'T')
+			} else {
+				tags = append(tags, 'F')
+			}
+		case int64:
+			tags = append(tags, 'h')
+			binary.Write(&args, binary.BigEndian, v)
+		case float64:
+			tags = append(tags, 'd')
+			binary.Write(&args, binary.BigEndian, v)
+		case nil:
+			tags = append(tags, 'N')
+		default:
+			return nil, fmt.Errorf("osc: unsupported argument type %T", arg)
+		}
+	}
+
+	var b bytes.Buffer
+	writeString(&b, m.Address)
+	writeString(&b, string(tags))
+	b.Write(args.Bytes())
+	return b.Bytes(), nil
+}
+
+// pad rounds n up to a multiple of 4.
+func pad(n int) int {
+	return (n + 3) &^ 3
+}
+
+// writeString writes s as a null terminated string padded to 4 bytes.
+func writeString(b *bytes.Buffer, s string) {
+	b.WriteString(s)
+	b.Write(make([]byte, pad(len(s)+1)-len(s)))
+}
+
+var errShort = errors.New("osc: truncated packet")
+
+// readString reads a padded string from the start of p.
+func readString(p []byte) (string, []byte, error) {
+	n := bytes.IndexByte(p, 0)
+	if n < 0 {
+		return "", nil, errShort
+	}
+	end := pad(n + 1)
+	if end > len(p) {
+		return "", nil, errShort
+	}
+	return string(p[:n]), p[end:], nil
+}
+
+// parsePacket decodes an OSC packet, either a message or a bundle. The
+// messages of bundles are returned in order, ignoring their time tags.
+func parsePacket(p []byte) ([]*Message, error) {
+	if bytes.HasPrefix(p, []byte("#bundle\x00")) {
+		return parseBundle(p)
+	}
+	m, err := parseMessage(p)
+	if err != nil {
+		return nil, err
+	}
+	return []*Message{m}, nil
+}
+
+func parseBundle(p []byte) ([]*Message, error) {
+	// "#bundle", then a time tag, then sized elements.
+	if len(p) < 16 {
+		return nil, errShort
+	}
+	p = p[16:]
+	var msgs []*Message
+	for len(p) > 0 {
+		if len(p) < 4 {
+			return nil, errShort
+		}
+		n := int(binary.BigEndian.Uint32(p))
+		p = p[4:]
+		if n < 0 || n > len(p) {
+			return nil, errShort
+		}
+		sub, err := parsePacket(p[:n])
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, sub...)
+		p = p[n:]
+	}
+	return msgs, nil
+}
+
+func parseMessage(p []byte) (*Message, error) {
+	address, p, err := readString(p)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(address, "/") {
+		return nil, fmt.Errorf("osc: invalid address %q", address)
+	}
+	m := &Message{Address: address}
+	if len(p) == 0 {
+		// Very old implementations omit the type tags.
+		return m, nil
+	}
+	tags, p, err := readString(p)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(tags, ",") {
+		return nil, fmt.Errorf("osc: invalid type tags %q", tags)
+	}
+
+	for _, tag := range tags[1:] {
+		var size int
+		switch tag {
+		case 'i', 'f', 'r', 'c', 'm':
+			size = 4
+		case 'h', 'd', 't':
+			size = 8
+		}
+		if len(p) < size {
+			return nil, errShort
+		}
+
+		switch tag {
+		case 'i', 'r', 'c', 'm':
+			// Colors, chars and MIDI messages are passed as int32.
+			m.Args = append(m.Args, int32(binary.BigEndian.Uint32(p)))
+		case 'f':
+			m.Args = append(m.Args, math.Float32frombits(binary.BigEndian.Uint32(p)))
+		case 'h', 't':
+			m.Args = append(m.Args, int64(binary.BigEndian.Uint64(p)))
+		case 'd':
+			m.Args = append(m.Args, math.Float64frombits(binary.BigEndian.Uint64(p)))
+		case 's', 'S':
+			var s string
+			if s, p, err = readString(p); err != nil {
+				return nil, err
+			}
+			m.Args = append(m.Args, s)
+		case 'b':
+			if len(p) < 4 {
+				return nil, errShort
+			}
+			n := int(binary.BigEndian.Uint32(p))
+			p = p[4:]
+			if n < 0 || pad(n) > len(p) {
+				return nil, errShort
+			}
+			m.Args = append(m.Args, append([]byte{}, p[:n]...))
+			p = p[pad(n):]
+		case 'T':
+			m.Args = append(m.Args, true)
+		case 'F':
+			m.Args = append(m.Args, false)
+		case 'N', 'I':
+			m.Args = append(m.Args, nil)
+		case '[', ']':
+			// Arrays are flattened.
+		default:
+			return nil, fmt.Errorf("osc: unsupported type tag %q", tag)
+		}
+		p = p[size:]
+	}
+	return m, nil
+}
+
+// argString returns an argument as a string, formatting numbers.
+func argString(arg interface{}) string {
+	switch v := arg.(type) {
+	case string:
+		return v
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []byte:
+		return string(v)
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(arg)
+}
+
+// argFloat returns a numeric argument as a float64.
+func argFloat(arg interface{}) (float64, bool) {
+	switch v := arg.(type) {
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// isFloat reports whether arg is a floating point argument.
+func isFloat(arg interface{}) bool {
+	switch arg.(type) {
+	case float32, float64:
+		return true
+	}
+	return false
+}
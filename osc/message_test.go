@@ -0,0 +1,206 @@
+package osc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// packet concatenates the parts of a packet.
+func packet(parts ...interface{}) []byte {
+	var b bytes.Buffer
+	for _, part := range parts {
+		switch v := part.(type) {
+		case string:
+			b.WriteString(v)
+		case []byte:
+			b.Write(v)
+		case int:
+			binary.Write(&b, binary.BigEndian, int32(v))
+		}
+	}
+	return b.Bytes()
+}
+
+// bundle returns a bundle of the given elements.
+func bundle(elements ...[]byte) []byte {
+	b := packet("#bundle\x00", "\x00\x00\x00\x00\x00\x00\x00\x01")
+	for _, el := range elements {
+		b = append(b, packet(len(el), el)...)
+	}
+	return b
+}
+
+func TestParseMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		p    []byte
+		want *Message
+	}{
+		{
+			"float",
+			packet("/oscillator/4/frequency\x00", ",f\x00\x00", []byte{0x43, 0xdc, 0x00, 0x00}),
+			NewMessage("/oscillator/4/frequency", float32(440)),
+		},
+		{
+			"example of the specification",
+			packet("/foo\x00\x00\x00\x00", ",iisff\x00\x00", 1000, -1, "hello\x00\x00\x00",
+				[]byte{0x3f, 0x9d, 0xf3, 0xb6}, []byte{0x40, 0xb5, 0xb2, 0x2d}),
+			NewMessage("/foo", int32(1000), int32(-1), "hello", float32(1.234), float32(5.678)),
+		},
+		{
+			"no type tags",
+			packet("/a\x00\x00"),
+			NewMessage("/a"),
+		},
+		{
+			"no arguments",
+			packet("/a\x00\x00", ",\x00\x00\x00"),
+			NewMessage("/a"),
+		},
+		{
+			"blob",
+			packet("/a\x00\x00", ",bi\x00", 5, "abcde\x00\x00\x00", 7),
+			NewMessage("/a", []byte("abcde"), int32(7)),
+		},
+		{
+			"empty blob",
+			packet("/a\x00\x00", ",b\x00\x00", 0),
+			NewMessage("/a", []byte{}),
+		},
+		{
+			"64 bits",
+			packet("/a\x00\x00", ",hdt\x00\x00\x00\x00", 0, 42, []byte{0x40, 0x09, 0x21, 0xfb, 0x54, 0x44, 0x2d, 0x18}, 0, 1),
+			NewMessage("/a", int64(42), 3.141592653589793, int64(1)),
+		},
+		{
+			"without data",
+			packet("/a\x00\x00", ",TFNI\x00\x00\x00"),
+			NewMessage("/a", true, false, nil, nil),
+		},
+		{
+			"as int32",
+			packet("/a\x00\x00", ",rcm\x00\x00\x00\x00", 1, 2, 3),
+			NewMessage("/a", int32(1), int32(2), int32(3)),
+		},
+		{
+			"array",
+			packet("/a\x00\x00", ",[iS]\x00\x00\x00", 1, "b\x00\x00\x00"),
+			NewMessage("/a", int32(1), "b"),
+		},
+	}
+	for _, test := range tests {
+		msgs, err := parsePacket(test.p)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if len(msgs) != 1 || !reflect.DeepEqual(msgs[0], test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, msgs, test.want)
+		}
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		p    []byte
+	}{
+		{"empty", nil},
+		{"unterminated address", packet("/abc")},
+		{"unpadded address", packet("/abc\x00")},
+		{"relative address", packet("abc\x00", ",\x00\x00\x00")},
+		{"no comma", packet("/a\x00\x00", "i\x00\x00\x00", 1)},
+		{"unterminated tags", packet("/a\x00\x00", ",iii")},
+		{"truncated int", packet("/a\x00\x00", ",i\x00\x00", "\x00\x00")},
+		{"truncated float", packet("/a\x00\x00", ",f\x00\x00")},
+		{"truncated int64", packet("/a\x00\x00", ",h\x00\x00", 1)},
+		{"truncated double", packet("/a\x00\x00", ",d\x00\x00", 1, "\x00")},
+		{"truncated second argument", packet("/a\x00\x00", ",ii\x00", 1)},
+		{"unterminated string", packet("/a\x00\x00", ",s\x00\x00", "abcd")},
+		{"unpadded string", packet("/a\x00\x00", ",s\x00\x00", "abcde\x00")},
+		{"no blob size", packet("/a\x00\x00", ",b\x00\x00", "\x00\x00")},
+		{"truncated blob", packet("/a\x00\x00", ",b\x00\x00", 8, "abcd")},
+		{"unpadded blob", packet("/a\x00\x00", ",b\x00\x00", 3, "abc")},
+		{"huge blob", packet("/a\x00\x00", ",b\x00\x00", []byte{0xff, 0xff, 0xff, 0xff}, "abcd")},
+		{"unknown tag", packet("/a\x00\x00", ",x\x00\x00", 1)},
+	}
+	for _, test := range tests {
+		if msgs, err := parsePacket(test.p); err == nil {
+			t.Errorf("%s: got %v", test.name, msgs)
+		}
+	}
+}
+
+func TestParseBundle(t *testing.T) {
+	a := packet("/a\x00\x00", ",i\x00\x00", 1)
+	b := packet("/b\x00\x00", ",s\x00\x00", "x\x00\x00\x00")
+	c := packet("/c\x00\x00")
+
+	msgs, err := parsePacket(bundle(a, bundle(b, bundle()), c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*Message{NewMessage("/a", int32(1)), NewMessage("/b", "x"), NewMessage("/c")}
+	if !reflect.DeepEqual(msgs, want) {
+		t.Errorf("got %v, want %v", msgs, want)
+	}
+
+	if msgs, err := parsePacket(bundle()); err != nil || len(msgs) != 0 {
+		t.Errorf("empty bundle: %v, %v", msgs, err)
+	}
+
+	malformed := map[string][]byte{
+		"no time tag":          packet("#bundle\x00", "\x00\x00\x00\x00"),
+		"truncated size":       append(bundle(a), 0, 0),
+		"size past the end":    append(bundle(), packet(len(a)+4, a)...),
+		"huge size":            append(bundle(), packet([]byte{0xff, 0xff, 0xff, 0xff}, a)...),
+		"malformed element":    bundle(a, packet("/b")),
+		"malformed sub-bundle": bundle(a, bundle(packet("b\x00\x00\x00"))),
+	}
+	for name, p := range malformed {
+		if msgs, err := parsePacket(p); err == nil {
+			t.Errorf("%s: got %v", name, msgs)
+		}
+	}
+}
+
+func TestMarshalBinary(t *testing.T) {
+	m := NewMessage("/foo", 1000, int32(-1), "hello", float32(1.234), float32(5.678))
+	p, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := packet("/foo\x00\x00\x00\x00", ",iisff\x00\x00", 1000, -1, "hello\x00\x00\x00",
+		[]byte{0x3f, 0x9d, 0xf3, 0xb6}, []byte{0x40, 0xb5, 0xb2, 0x2d})
+	if !bytes.Equal(p, want) {
+		t.Errorf("got %q, want %q", p, want)
+	}
+
+	// All the types survive a round trip.
+	m = NewMessage("/a/b", int32(1), float32(2.5), "", "abcd", []byte("xyz"), []byte{}, true, false, int64(-3), 4.25, nil)
+	if p, err = m.MarshalBinary(); err != nil {
+		t.Fatal(err)
+	}
+	if len(p)%4 != 0 {
+		t.Errorf("packet of %d bytes", len(p))
+	}
+	msgs, err := parsePacket(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(msgs, []*Message{m}) {
+		t.Errorf("got %v, want %v", msgs, m)
+	}
+
+	for _, m := range []*Message{
+		NewMessage("a"),
+		NewMessage("/a", 1<<40),
+		NewMessage("/a", struct{}{}),
+	} {
+		if _, err := m.MarshalBinary(); err == nil {
+			t.Errorf("%v encoded", m)
+		}
+	}
+}
@@ -0,0 +1,385 @@
+// Package page implements the streamdeck.Page interface: pages of elements
+// which can be nested and navigated on a deck.
+package page
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"sync"
+
+	sd "github.com/KarpelesLab/streamdeck"
+)
+
+// Key is the content of a key on a Page.
+type Key struct {
+	// Element displayed on the key, may be nil for a blank key.
+	Element sd.Element
+	// OnChange is called when the key is pressed or released.
+	OnChange func(state sd.BtnState)
+	// Target is the page to switch to when the key is pressed.
+	Target sd.Page
+	// Back switches to the parent page when the key is pressed.
+	Back bool
+}
+
+// Page is a set of keys displayed together on a deck.
+type Page struct {
+	mu     sync.Mutex
+	name   string
+	parent sd.Page
+	keys   map[int]*Key
+	deck   *Deck
+	active bool
+}
+
+var _ sd.Page = (*Page)(nil)
+
+// New creates an empty page. parent may be nil for a root page.
+func New(name string, parent sd.Page) *Page {
+	return &Page{
+		name:   name,
+		parent: parent,
+		keys:   make(map[int]*Key),
+	}
+}
+
+// Name returns the name of the page.
+func (p *Page) Name() string {
+	return p.name
+}
+
+// SetKey sets the content of a key and, if the page is displayed, redraws
+// it. A nil key clears it.
+func (p *Page) SetKey(btnIndex int, k *Key) {
+	p.mu.Lock()
+	var prev sd.Element
+	if old := p.keys[btnIndex]; old != nil {
+		prev = old.Element
+	}
+	if k == nil {
+		delete(p.keys, btnIndex)
+	} else {
+		p.keys[btnIndex] = k
+	}
+	deck := p.deck
+	active := p.active
+	p.mu.Unlock()
+
+	if active && deck != nil {
+		// The binding of the key only reaches the element it holds now, so
+		// the previous one must be detached from the Host here.
+		if n, ok := prev.(sd.Notifier); ok && (k == nil || k.Element != prev) {
+			n.SetNotify(nil)
+		}
+		deck.rebind(p, btnIndex)
+	}
+}
+
+// Key returns the content of a key, or nil.
+func (p *Page) Key(btnIndex int) *Key {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.keys[btnIndex]
+}
+
+// Keys returns the indices of the keys which have content.
+func (p *Page) Keys() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	res := make([]int, 0, len(p.keys))
+	for i := range p.keys {
+		res = append(res, i)
+	}
+	return res
+}
+
+// Set forwards a key event to the element of the key and returns the page
+// to display next.
+func (p *Page) Set(btnIndex int, state sd.BtnState) sd.Page {
+	k := p.Key(btnIndex)
+	if k == nil {
+		return p
+	}
+
+	if k.Element != nil {
+		k.Element.Change(state)
+	}
+	if k.OnChange != nil {
+		k.OnChange(state)
+	}
+
+	if state == sd.BtnPressed {
+		if k.Back && p.parent != nil {
+			return p.parent
+		}
+		if k.Target != nil {
+			return k.Target
+		}
+	}
+	return p
+}
+
+// Parent returns the parent page, or nil for a root page.
+func (p *Page) Parent() sd.Page {
+	return p.parent
+}
+
+// Draw redraws all keys of the page, if it is displayed.
+func (p *Page) Draw() {
+	p.mu.Lock()
+	deck := p.deck
+	active := p.active
+	p.mu.Unlock()
+
+	if active && deck != nil {
+		deck.host.Redraw()
+	}
+}
+
+// SetActive is called by the Deck when the page is shown or hidden.
+func (p *Page) SetActive(active bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active = active
+}
+
+// Deck displays pages on a surface and switches between them as keys are
+// pressed.
+type Deck struct {
+	mu      sync.Mutex
+	surface sd.Surface
+	host    *sd.Host
+	current *Page
+	pages   map[string]*Page
+	history []*Page
+}
+
+// NewDeck creates a Deck displaying root on the surface. It takes over the
+// button event callback of the surface.
+func NewDeck(s sd.Surface, root *Page) (*Deck, error) {
+	d := &Deck{
+		surface: s,
+		host:    sd.NewHost(s),
+		pages:   make(map[string]*Page),
+	}
+	d.Add(root)
+
+	if err := d.SwitchTo(root); err != nil {
+		d.host.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// Close detaches the Deck from its surface.
+func (d *Deck) Close() {
+	d.host.Close()
+}
+
+// Host returns the Host used to display the elements.
+func (d *Deck) Host() *sd.Host {
+	return d.host
+}
+
+// Add registers pages, so they can be switched to by name.
+func (d *Deck) Add(pages ...*Page) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, p := range pages {
+		d.pages[p.name] = p
+	}
+}
+
+// Remove unregisters a page. It can't be the page being displayed.
+func (d *Deck) Remove(name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	p, ok := d.pages[name]
+	if !ok {
+		return nil
+	}
+	if p == d.current {
+		return fmt.Errorf("page %q is being displayed", name)
+	}
+	delete(d.pages, name)
+
+	history := d.history[:0]
+	for _, h := range d.history {
+		if h != p {
+			history = append(history, h)
+		}
+	}
+	d.history = history
+	return nil
+}
+
+// Replace substitutes p for old everywhere: in the registry, in the history
+// and on the display if old is the current page.
+func (d *Deck) Replace(old, p *Page) error {
+	d.mu.Lock()
+	if d.pages[old.name] == old {
+		delete(d.pages, old.name)
+	}
+	d.pages[p.name] = p
+	for i, h := range d.history {
+		if h == old {
+			d.history[i] = p
+		}
+	}
+	current := d.current == old
+	d.mu.Unlock()
+
+	if current {
+		return d.show(p)
+	}
+	return nil
+}
+
+// Page returns the page registered with the given name, or nil.
+func (d *Deck) Page(name string) *Page {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.pages[name]
+}
+
+// Current returns the page being displayed.
+func (d *Deck) Current() *Page {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.current
+}
+
+// SwitchToName displays the page registered with the given name.
+func (d *Deck) SwitchToName(name string) error {
+	p := d.Page(name)
+	if p == nil {
+		return fmt.Errorf("unknown page %q", name)
+	}
+	return d.SwitchTo(p)
+}
+
+// Back displays the page which was displayed before the current one.
+func (d *Deck) Back() error {
+	d.mu.Lock()
+	if len(d.history) == 0 {
+		d.mu.Unlock()
+		return fmt.Errorf("no previous page")
+	}
+	p := d.history[len(d.history)-1]
+	d.history = d.history[:len(d.history)-1]
+	d.mu.Unlock()
+
+	return d.show(p)
+}
+
+// SwitchTo displays p.
+func (d *Deck) SwitchTo(p *Page) error {
+	d.mu.Lock()
+	if d.current != nil && d.current != p {
+		d.history = append(d.history, d.current)
+	}
+	d.mu.Unlock()
+
+	return d.show(p)
+}
+
+func (d *Deck) show(p *Page) error {
+	d.mu.Lock()
+	prev := d.current
+	d.current = p
+	if _, ok := d.pages[p.name]; !ok {
+		d.pages[p.name] = p
+	}
+	d.mu.Unlock()
+
+	if prev != nil && prev != p {
+		prev.SetActive(false)
+	}
+
+	p.mu.Lock()
+	p.deck = d
+	p.mu.Unlock()
+	p.SetActive(true)
+
+	var firstErr error
+	for i := 0; i < d.surface.NumButtons(); i++ {
+		if err := d.host.Bind(i, &binding{deck: d, page: p, index: i}); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// rebind redraws a key of p after its content changed.
+func (d *Deck) rebind(p *Page, btnIndex int) {
+	if d.Current() != p {
+		return
+	}
+	d.host.Bind(btnIndex, &binding{deck: d, page: p, index: btnIndex})
+}
+
+// binding is the Element bound to the Host for each key of the current
+// page. It forwards to the element of the key, and switches pages as
+// requested by Page.Set.
+type binding struct {
+	deck  *Deck
+	page  *Page
+	index int
+}
+
+func (b *binding) element() sd.Element {
+	if k := b.page.Key(b.index); k != nil {
+		return k.Element
+	}
+	return nil
+}
+
+func (b *binding) Render(img *image.RGBA) error {
+	if el := b.element(); el != nil {
+		return el.Render(img)
+	}
+	draw.Draw(img, img.Bounds(), image.Black, image.Point{}, draw.Src)
+	return nil
+}
+
+func (b *binding) Change(state sd.BtnState) {
+	next := b.page.Set(b.index, state)
+	if next == sd.Page(b.page) {
+		return
+	}
+
+	// only pages of this package can be displayed by a Deck
+	p, ok := next.(*Page)
+	if !ok {
+		return
+	}
+
+	if b.page.parent == next {
+		// going up, drop the history entry of the parent
+		b.deck.mu.Lock()
+		if n := len(b.deck.history); n > 0 && b.deck.history[n-1] == p {
+			b.deck.history = b.deck.history[:n-1]
+		}
+		b.deck.mu.Unlock()
+		b.deck.show(p)
+		return
+	}
+	b.deck.SwitchTo(p)
+}
+
+func (b *binding) Dirty() bool {
+	if el := b.element(); el != nil {
+		return el.Dirty()
+	}
+	return false
+}
+
+func (b *binding) SetNotify(notify func()) {
+	if n, ok := b.element().(sd.Notifier); ok {
+		n.SetNotify(notify)
+	}
+}
@@ -0,0 +1,182 @@
+package page
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+	"testing"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/mock"
+)
+
+var (
+	black = color.RGBA{0, 0, 0, 255}
+	red   = color.RGBA{255, 0, 0, 255}
+	green = color.RGBA{0, 255, 0, 255}
+	blue  = color.RGBA{0, 0, 255, 255}
+)
+
+// swatch is a key of a solid color, which turns to its pressed color while
+// pressed. It records whether it is bound to a Host.
+type swatch struct {
+	sd.Invalidator
+	mu      sync.Mutex
+	c       color.RGBA
+	pressed color.RGBA
+	down    bool
+	hosted  bool
+}
+
+func newSwatch(c color.RGBA) *swatch {
+	return &swatch{c: c, pressed: c}
+}
+
+func (s *swatch) set(c color.RGBA) {
+	s.mu.Lock()
+	s.c = c
+	s.mu.Unlock()
+	s.Invalidate()
+}
+
+func (s *swatch) isHosted() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hosted
+}
+
+func (s *swatch) Render(img *image.RGBA) error {
+	s.mu.Lock()
+	c := s.c
+	if s.down {
+		c = s.pressed
+	}
+	s.mu.Unlock()
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+	return nil
+}
+
+func (s *swatch) Change(state sd.BtnState) {
+	s.mu.Lock()
+	s.down = state == sd.BtnPressed
+	s.mu.Unlock()
+	s.Invalidate()
+}
+
+func (s *swatch) SetNotify(notify func()) {
+	s.Invalidator.SetNotify(notify)
+	s.mu.Lock()
+	s.hosted = notify != nil
+	s.mu.Unlock()
+}
+
+func newDeck(t *testing.T, root *Page) (*Deck, *mock.Device) {
+	t.Helper()
+	dev, m := mock.Open(t, sd.LookupDevice(0x0063), "TEST0001")
+	d, err := NewDeck(dev, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(d.Close)
+	return d, m
+}
+
+func TestNavigation(t *testing.T) {
+	root := New("root", nil)
+	sub := New("sub", root)
+	folder := newSwatch(blue)
+	root.SetKey(0, &Key{Element: folder, Target: sub})
+	root.SetKey(1, &Key{Element: newSwatch(red)})
+	sub.SetKey(0, &Key{Element: newSwatch(green), Back: true})
+
+	d, m := newDeck(t, root)
+	m.WaitColor(t, 0, blue)
+	m.WaitColor(t, 1, red)
+
+	m.Click(0)
+	m.WaitColor(t, 0, green)
+	m.WaitColor(t, 1, black)
+	if d.Current() != sub {
+		t.Fatalf("current page %s, want sub", d.Current().Name())
+	}
+	// The elements of the page left are detached from the Host.
+	if folder.isHosted() {
+		t.Error("element of a hidden page still hosted")
+	}
+
+	m.Click(0)
+	m.WaitColor(t, 0, blue)
+	if d.Current() != root {
+		t.Fatalf("current page %s, want root", d.Current().Name())
+	}
+	if err := d.Back(); err == nil {
+		t.Error("going back from sub to root left a history entry")
+	}
+
+	if err := d.SwitchToName("sub"); err != nil {
+		t.Fatal(err)
+	}
+	m.WaitColor(t, 0, green)
+	if err := d.Back(); err != nil {
+		t.Fatal(err)
+	}
+	m.WaitColor(t, 0, blue)
+	if err := d.SwitchToName("nope"); err == nil {
+		t.Error("switched to an unknown page")
+	}
+}
+
+func TestPressRedraw(t *testing.T) {
+	root := New("root", nil)
+	el := newSwatch(red)
+	el.pressed = green
+	root.SetKey(2, &Key{Element: el})
+	_, m := newDeck(t, root)
+
+	m.Press(2)
+	m.WaitColor(t, 2, green)
+	m.Release(2)
+	m.WaitColor(t, 2, red)
+}
+
+func TestSetKey(t *testing.T) {
+	root := New("root", nil)
+	old := newSwatch(red)
+	root.SetKey(1, &Key{Element: old})
+	other := New("other", root)
+	_, m := newDeck(t, root)
+	m.WaitColor(t, 1, red)
+	if !old.isHosted() {
+		t.Fatal("element displayed without being hosted")
+	}
+
+	el := newSwatch(blue)
+	root.SetKey(1, &Key{Element: el})
+	m.WaitColor(t, 1, blue)
+	if old.isHosted() {
+		t.Error("replaced element still hosted")
+	}
+	if !el.isHosted() {
+		t.Error("new element not hosted")
+	}
+
+	// The new element is redrawn when it changes, the old one isn't drawn.
+	old.set(green)
+	el.set(red)
+	m.WaitColor(t, 1, red)
+
+	root.SetKey(1, nil)
+	m.WaitColor(t, 1, black)
+	if el.isHosted() {
+		t.Error("cleared element still hosted")
+	}
+
+	// Keys of pages not displayed are only drawn once shown.
+	other.SetKey(1, &Key{Element: newSwatch(green)})
+	root.SetKey(0, &Key{Element: newSwatch(blue), Target: other})
+	m.WaitColor(t, 0, blue)
+	m.WaitColor(t, 1, black)
+	m.Click(0)
+	m.WaitColor(t, 1, green)
+}
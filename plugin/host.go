@@ -0,0 +1,521 @@
+// Package plugin runs plugins written for the Elgato Stream Deck software,
+// by implementing the host side of the Stream Deck SDK WebSocket protocol.
+//
+// The plugin executable is started with the usual registration arguments
+// (-port, -pluginUUID, -registerEvent and -info) and connects back to the
+// Host. Its actions are assigned to keys: key presses are sent to the plugin
+// as keyDown and keyUp events, and the titles, images and states set by the
+// plugin are displayed on the keys. Settings are kept in memory and
+// reported through OnSettings, so they can be persisted.
+package plugin
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/internal/websocket"
+)
+
+// Host runs a plugin and displays its actions on a surface.
+type Host struct {
+	plugin   *Plugin
+	surface  sd.Surface
+	host     *sd.Host
+	model    *sd.StreamdeckDevice
+	deviceID string
+	uuid     string // identifies this run of the plugin
+
+	log              io.Writer
+	registerTimeout  time.Duration
+	onSettings       func(btnIndex int, settings json.RawMessage)
+	onGlobalSettings func(settings json.RawMessage)
+
+	mu             sync.Mutex
+	keys           map[int]*instance
+	globalSettings json.RawMessage
+	conn           *websocket.Conn
+	cmd            *exec.Cmd
+	srv            *http.Server
+	registered     chan struct{} // closed once the plugin is connected
+	exited         chan struct{}
+	exitErr        error
+}
+
+// DeviceID sets the identifier of the device given to the plugin, by
+// default the serial number of the deck.
+func DeviceID(id string) func(*Host) {
+	return func(h *Host) {
+		h.deviceID = id
+	}
+}
+
+// Model sets the model of the device, needed to give the plugin the
+// coordinates of the keys when the surface isn't a StreamDeck.
+func Model(m *sd.StreamdeckDevice) func(*Host) {
+	return func(h *Host) {
+		h.model = m
+	}
+}
+
+// Log sets where the output of the plugin and its log messages are written,
+// by default nowhere.
+func Log(w io.Writer) func(*Host) {
+	return func(h *Host) {
+		h.log = w
+	}
+}
+
+// RegisterTimeout sets how long the plugin has to connect after being
+// started, 10 seconds by default.
+func RegisterTimeout(d time.Duration) func(*Host) {
+	return func(h *Host) {
+		h.registerTimeout = d
+	}
+}
+
+// OnSettings sets a function called when the plugin changes the settings of
+// the action on a key.
+func OnSettings(f func(btnIndex int, settings json.RawMessage)) func(*Host) {
+	return func(h *Host) {
+		h.onSettings = f
+	}
+}
+
+// OnGlobalSettings sets a function called when the plugin changes its
+// global settings.
+func OnGlobalSettings(f func(settings json.RawMessage)) func(*Host) {
+	return func(h *Host) {
+		h.onGlobalSettings = f
+	}
+}
+
+// GlobalSettings sets the initial global settings of the plugin.
+func GlobalSettings(settings json.RawMessage) func(*Host) {
+	return func(h *Host) {
+		h.globalSettings = settings
+	}
+}
+
+// New creates a Host for a plugin, displaying its actions on s. The plugin
+// is started by Start.
+func New(p *Plugin, s sd.Surface, options ...func(*Host)) *Host {
+	h := &Host{
+		plugin:          p,
+		surface:         s,
+		log:             ioutil.Discard,
+		registerTimeout: 10 * time.Second,
+		keys:            make(map[int]*instance),
+		registered:      make(chan struct{}),
+		exited:          make(chan struct{}),
+	}
+	if dev, ok := s.(*sd.StreamDeck); ok {
+		h.model = dev.Info
+		h.deviceID, _ = dev.GetSerialNumber()
+	}
+	for _, option := range options {
+		option(h)
+	}
+	if h.deviceID == "" {
+		h.deviceID = randomID()
+	}
+	h.uuid = randomID()
+	h.host = sd.NewHost(s)
+	return h
+}
+
+// randomID returns a random identifier, as used for contexts.
+func randomID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Assign displays an action of the plugin on a key, with the given
+// settings (a JSON object, or nil).
+func (h *Host) Assign(btnIndex int, actionUUID string, settings json.RawMessage) error {
+	info := h.plugin.Action(actionUUID)
+	if info == nil {
+		return fmt.Errorf("plugin %s has no action %s", h.plugin.Manifest.UUID, actionUUID)
+	}
+	if btnIndex < 0 || btnIndex >= h.surface.NumButtons() {
+		return fmt.Errorf("invalid key index %d", btnIndex)
+	}
+	if len(settings) == 0 {
+		settings = json.RawMessage("{}")
+	}
+
+	inst := newInstance(h, info, btnIndex, settings)
+	h.Remove(btnIndex)
+
+	h.mu.Lock()
+	h.keys[btnIndex] = inst
+	h.mu.Unlock()
+
+	if err := h.host.Bind(btnIndex, inst); err != nil {
+		return err
+	}
+	h.send(inst.event("willAppear", inst.payload()))
+	return nil
+}
+
+// Remove removes the action displayed on a key.
+func (h *Host) Remove(btnIndex int) {
+	h.mu.Lock()
+	inst := h.keys[btnIndex]
+	delete(h.keys, btnIndex)
+	h.mu.Unlock()
+
+	if inst == nil {
+		return
+	}
+	h.host.Unbind(btnIndex)
+	h.surface.FillImage(btnIndex, blank(h.surface.ButtonSize()))
+	h.send(inst.event("willDisappear", inst.payload()))
+}
+
+// Settings returns the settings of the action on a key, or nil.
+func (h *Host) Settings(btnIndex int) json.RawMessage {
+	h.mu.Lock()
+	inst := h.keys[btnIndex]
+	h.mu.Unlock()
+
+	if inst == nil {
+		return nil
+	}
+	return inst.getSettings()
+}
+
+// instanceByContext returns the action instance with the given context.
+func (h *Host) instanceByContext(context string) *instance {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, inst := range h.keys {
+		if inst.context == context {
+			return inst
+		}
+	}
+	return nil
+}
+
+// info is the -info argument given to the plugin.
+func (h *Host) info() ([]byte, error) {
+	type size struct {
+		Columns int `json:"columns"`
+		Rows    int `json:"rows"`
+	}
+	type device struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+		Size size   `json:"size"`
+		Type int    `json:"type"`
+	}
+	info := struct {
+		Application      map[string]string `json:"application"`
+		Plugin           map[string]string `json:"plugin"`
+		DevicePixelRatio int               `json:"devicePixelRatio"`
+		Colors           map[string]string `json:"colors"`
+		Devices          []device          `json:"devices"`
+	}{
+		Application: map[string]string{
+			"font":     "",
+			"language": "en",
+			"platform": "linux",
+			"version":  "6.0.0",
+		},
+		Plugin: map[string]string{
+			"uuid":    h.plugin.Manifest.UUID,
+			"version": h.plugin.Manifest.Version,
+		},
+		DevicePixelRatio: 1,
+		Colors:           map[string]string{},
+		Devices: []device{{
+			ID:   h.deviceID,
+			Name: h.deviceName(),
+			Size: size{Columns: h.columns(), Rows: h.rows()},
+			Type: h.deviceType(),
+		}},
+	}
+	return json.Marshal(&info)
+}
+
+func (h *Host) deviceName() string {
+	if h.model != nil {
+		return h.model.Name
+	}
+	return "Stream Deck"
+}
+
+// deviceType returns the type of the device in the SDK.
+func (h *Host) deviceType() int {
+	if h.model == nil {
+		return 0
+	}
+	switch h.model.ProductID {
+	case 0x0063, 0x0090:
+		return 1 // Stream Deck Mini
+	case 0x006c:
+		return 2 // Stream Deck XL
+	}
+	return 0
+}
+
+func (h *Host) columns() int {
+	if h.model != nil {
+		return h.model.NumButtonColumns
+	}
+	return h.surface.NumButtons()
+}
+
+func (h *Host) rows() int {
+	if h.model != nil {
+		return h.model.NumButtonRows
+	}
+	return 1
+}
+
+// coordinates returns the column and row of a key, counted from the top
+// left corner as in the SDK.
+func (h *Host) coordinates(btnIndex int) (int, int) {
+	if h.model == nil {
+		return btnIndex, 0
+	}
+	r := h.model.KeyRect(btnIndex)
+	step := h.model.ButtonSize + h.model.Spacer
+	return r.Min.X / step, r.Min.Y / step
+}
+
+// Start starts the plugin and waits for it to register.
+func (h *Host) Start() error {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	h.srv = &http.Server{Handler: http.HandlerFunc(h.accept)}
+	go h.srv.Serve(l)
+
+	info, err := h.info()
+	if err != nil {
+		h.srv.Close()
+		return err
+	}
+	path, args, err := h.plugin.command()
+	if err != nil {
+		h.srv.Close()
+		return err
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	args = append(args,
+		"-port", strconv.Itoa(port),
+		"-pluginUUID", h.uuid,
+		"-registerEvent", "registerPlugin",
+		"-info", string(info),
+	)
+
+	cmd := exec.Command(path, args...)
+	cmd.Dir = h.plugin.Dir
+	cmd.Stdout = h.log
+	cmd.Stderr = h.log
+	if err := cmd.Start(); err != nil {
+		h.srv.Close()
+		return fmt.Errorf("failed to start plugin: %w", err)
+	}
+	h.mu.Lock()
+	h.cmd = cmd
+	h.mu.Unlock()
+	go func() {
+		err := cmd.Wait()
+		h.mu.Lock()
+		h.exitErr = err
+		h.mu.Unlock()
+		close(h.exited)
+	}()
+
+	select {
+	case <-h.registered:
+	case <-h.exited:
+		h.srv.Close()
+		return fmt.Errorf("plugin exited before registering: %v", h.Wait())
+	case <-time.After(h.registerTimeout):
+		h.Close()
+		return fmt.Errorf("plugin didn't register within %s", h.registerTimeout)
+	}
+
+	h.send(&event{
+		Event:  "deviceDidConnect",
+		Device: h.deviceID,
+		DeviceInfo: map[string]interface{}{
+			"name": h.deviceName(),
+			"type": h.deviceType(),
+			"size": map[string]int{"columns": h.columns(), "rows": h.rows()},
+		},
+	})
+	h.mu.Lock()
+	var instances []*instance
+	for _, inst := range h.keys {
+		instances = append(instances, inst)
+	}
+	h.mu.Unlock()
+	for _, inst := range instances {
+		h.send(inst.event("willAppear", inst.payload()))
+	}
+	return nil
+}
+
+// accept handles the connection of the plugin.
+func (h *Host) accept(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+
+	var reg message
+	conn.SetReadDeadline(time.Now().Add(h.registerTimeout))
+	if err := conn.ReadJSON(&reg); err != nil || reg.Event != "registerPlugin" || reg.UUID != h.uuid {
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	// The connection is set before serving it, so replies to the first
+	// messages of the plugin aren't lost.
+	h.mu.Lock()
+	if h.conn != nil {
+		// already registered
+		h.mu.Unlock()
+		conn.Close()
+		return
+	}
+	h.conn = conn
+	h.mu.Unlock()
+	close(h.registered)
+	h.serve(conn)
+}
+
+// serve handles the messages of the plugin until it disconnects.
+func (h *Host) serve(conn *websocket.Conn) {
+	defer conn.Close()
+	for {
+		var m message
+		if err := conn.ReadJSON(&m); err != nil {
+			return
+		}
+		h.handle(&m)
+	}
+}
+
+// Wait waits for the plugin to exit.
+func (h *Host) Wait() error {
+	<-h.exited
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.exitErr
+}
+
+// Close stops the plugin and detaches from the surface.
+func (h *Host) Close() error {
+	h.mu.Lock()
+	cmd := h.cmd
+	conn := h.conn
+	h.mu.Unlock()
+
+	h.host.Close()
+	if conn != nil {
+		conn.Close()
+	}
+	if h.srv != nil {
+		h.srv.Close()
+	}
+	if cmd != nil && cmd.Process != nil {
+		cmd.Process.Kill()
+		<-h.exited
+	}
+	return nil
+}
+
+// event is a message sent to the plugin.
+type event struct {
+	Action     string      `json:"action,omitempty"`
+	Event      string      `json:"event"`
+	Context    string      `json:"context,omitempty"`
+	Device     string      `json:"device,omitempty"`
+	DeviceInfo interface{} `json:"deviceInfo,omitempty"`
+	Payload    interface{} `json:"payload,omitempty"`
+}
+
+// message is a message received from the plugin.
+type message struct {
+	Event   string          `json:"event"`
+	UUID    string          `json:"uuid"`
+	Context string          `json:"context"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// send sends an event to the plugin, if it is connected.
+func (h *Host) send(ev *event) {
+	h.mu.Lock()
+	conn := h.conn
+	h.mu.Unlock()
+
+	if conn != nil {
+		conn.WriteJSON(ev)
+	}
+}
+
+func (h *Host) logf(format string, args ...interface{}) {
+	fmt.Fprintf(h.log, format+"\n", args...)
+}
+
+// handle executes a message of the plugin.
+func (h *Host) handle(m *message) {
+	switch m.Event {
+	case "setGlobalSettings":
+		h.mu.Lock()
+		h.globalSettings = m.Payload
+		h.mu.Unlock()
+		if h.onGlobalSettings != nil {
+			h.onGlobalSettings(m.Payload)
+		}
+		return
+	case "getGlobalSettings":
+		h.mu.Lock()
+		settings := h.globalSettings
+		h.mu.Unlock()
+		if len(settings) == 0 {
+			settings = json.RawMessage("{}")
+		}
+		h.send(&event{
+			Event:   "didReceiveGlobalSettings",
+			Payload: map[string]interface{}{"settings": settings},
+		})
+		return
+	case "logMessage":
+		var p struct{ Message string }
+		json.Unmarshal(m.Payload, &p)
+		h.logf("%s", p.Message)
+		return
+	case "openUrl":
+		var p struct{ URL string }
+		json.Unmarshal(m.Payload, &p)
+		h.logf("plugin asked to open %s", p.URL)
+		return
+	}
+
+	inst := h.instanceByContext(m.Context)
+	if inst == nil {
+		return
+	}
+	if err := inst.handle(m); err != nil {
+		h.logf("%s: %s", m.Event, err)
+	}
+}
@@ -0,0 +1,210 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/internal/websocket"
+	"github.com/KarpelesLab/streamdeck/mock"
+)
+
+// The test binary doubles as a plugin: the plugin scripts written by
+// writePlugin run it with STREAMDECK_TEST_PLUGIN set.
+func TestMain(m *testing.M) {
+	if os.Getenv("STREAMDECK_TEST_PLUGIN") != "" {
+		if err := fakePlugin(os.Args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// fakePlugin registers like a plugin of the SDK, asks for its global
+// settings right away and sends them back under "echo", displays a red
+// image on the keys it appears on, and saves {"pressed": true} as settings
+// of a key when it is pressed.
+func fakePlugin(args []string) error {
+	fs := flag.NewFlagSet("plugin", flag.ContinueOnError)
+	port := fs.Int("port", 0, "")
+	uuid := fs.String("pluginUUID", "", "")
+	register := fs.String("registerEvent", "", "")
+	info := fs.String("info", "", "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !json.Valid([]byte(*info)) {
+		return fmt.Errorf("invalid -info %q", *info)
+	}
+
+	conn, err := websocket.Dial(fmt.Sprintf("ws://127.0.0.1:%d", *port), nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.WriteJSON(map[string]string{"event": *register, "uuid": *uuid})
+	conn.WriteJSON(map[string]string{"event": "getGlobalSettings", "context": *uuid})
+
+	var buf bytes.Buffer
+	red := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	draw.Draw(red, red.Bounds(), image.NewUniform(color.RGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+	png.Encode(&buf, red)
+	dataURL := "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	for {
+		var ev struct {
+			Event   string
+			Context string
+			Payload struct {
+				Settings json.RawMessage
+			}
+		}
+		if err := conn.ReadJSON(&ev); err != nil {
+			return nil // closed by the host
+		}
+		switch ev.Event {
+		case "didReceiveGlobalSettings":
+			conn.WriteJSON(map[string]interface{}{
+				"event":   "setGlobalSettings",
+				"context": *uuid,
+				"payload": map[string]json.RawMessage{"echo": ev.Payload.Settings},
+			})
+		case "willAppear":
+			conn.WriteJSON(map[string]interface{}{
+				"event":   "setImage",
+				"context": ev.Context,
+				"payload": map[string]interface{}{"image": dataURL},
+			})
+		case "keyDown":
+			conn.WriteJSON(map[string]interface{}{
+				"event":   "setSettings",
+				"context": ev.Context,
+				"payload": map[string]bool{"pressed": true},
+			})
+		}
+	}
+}
+
+const actionUUID = "com.example.test.action"
+
+// writePlugin writes a plugin whose executable is a shell script.
+func writePlugin(t *testing.T, script string) *Plugin {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "com.example.test.sdPlugin")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	manifest := `{
+		"Name": "Test",
+		"UUID": "com.example.test",
+		"Version": "1.0",
+		"CodePath": "plugin.sh",
+		"Actions": [{"UUID": "` + actionUUID + `", "Name": "Test", "States": [{"Title": "test"}]}]
+	}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "manifest.json"), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "plugin.sh"), []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	p, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestHost(t *testing.T) {
+	bin, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := writePlugin(t, fmt.Sprintf("STREAMDECK_TEST_PLUGIN=1 exec %q \"$@\"", bin))
+	dev, m := mock.Open(t, sd.LookupDevice(0x0063), "TEST0001")
+
+	globals := make(chan string, 1)
+	settings := make(chan string, 1)
+	h := New(p, dev,
+		GlobalSettings(json.RawMessage(`{"n":1}`)),
+		OnGlobalSettings(func(s json.RawMessage) { globals <- string(s) }),
+		OnSettings(func(btnIndex int, s json.RawMessage) { settings <- fmt.Sprintf("%d %s", btnIndex, s) }),
+	)
+	if err := h.Assign(4, actionUUID, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Assign(0, "nope", nil); err == nil {
+		t.Error("Assign accepted an unknown action")
+	}
+	if err := h.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	// The reply to a request sent along with the registration must not be
+	// lost.
+	select {
+	case s := <-globals:
+		if s != `{"echo":{"n":1}}` {
+			t.Errorf("global settings %s", s)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the plugin didn't get its global settings")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for m.Key(4).(*image.RGBA).RGBAAt(40, 40) != (color.RGBA{255, 0, 0, 255}) {
+		if time.Now().After(deadline) {
+			t.Fatal("the image of the plugin isn't displayed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	m.Click(4)
+	select {
+	case s := <-settings:
+		if s != `4 {"pressed":true}` {
+			t.Errorf("settings %s", s)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the plugin didn't receive keyDown")
+	}
+	if s := string(h.Settings(4)); s != `{"pressed":true}` {
+		t.Errorf("Settings(4) = %s", s)
+	}
+
+	h.Close()
+	select {
+	case <-h.exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the plugin wasn't stopped")
+	}
+}
+
+func TestHostStartErrors(t *testing.T) {
+	dev, _ := mock.Open(t, sd.LookupDevice(0x0063), "TEST0001")
+
+	h := New(writePlugin(t, "exit 3"), dev)
+	if err := h.Start(); err == nil || !strings.Contains(err.Error(), "exited before registering") {
+		t.Errorf("Start = %v", err)
+	}
+
+	h = New(writePlugin(t, "exec sleep 60"), dev, RegisterTimeout(100*time.Millisecond))
+	if err := h.Start(); err == nil || !strings.Contains(err.Error(), "didn't register") {
+		t.Errorf("Start = %v", err)
+	}
+}
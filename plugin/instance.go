@@ -0,0 +1,351 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"  // image formats accepted from plugins
+	_ "image/jpeg" // image formats accepted from plugins
+	_ "image/png"  // image formats accepted from plugins
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/action"
+	"github.com/KarpelesLab/streamdeck/profile"
+	"github.com/KarpelesLab/streamdeck/svg"
+	"github.com/KarpelesLab/streamdeck/tile"
+)
+
+// feedbackDuration is how long showOk and showAlert are displayed.
+const feedbackDuration = time.Second
+
+// instance is an action of the plugin displayed on a key. It is the
+// Element bound to the key.
+type instance struct {
+	sd.Invalidator
+	host    *Host
+	info    *ActionInfo
+	key     int
+	context string
+
+	mu       sync.Mutex
+	settings json.RawMessage
+	state    int
+	titles   map[int]string // set by the plugin, per state
+	images   map[int]func(*tile.Tile)
+	defaults map[int]func(*tile.Tile) // images of the manifest, loaded lazily
+
+	feedback      color.Color
+	feedbackUntil time.Time
+}
+
+var _ sd.Element = (*instance)(nil)
+
+func newInstance(h *Host, info *ActionInfo, btnIndex int, settings json.RawMessage) *instance {
+	return &instance{
+		host:     h,
+		info:     info,
+		key:      btnIndex,
+		context:  randomID(),
+		settings: settings,
+		titles:   make(map[int]string),
+		images:   make(map[int]func(*tile.Tile)),
+		defaults: make(map[int]func(*tile.Tile)),
+	}
+}
+
+// event returns an event of the instance.
+func (inst *instance) event(name string, payload interface{}) *event {
+	return &event{
+		Action:  inst.info.UUID,
+		Event:   name,
+		Context: inst.context,
+		Device:  inst.host.deviceID,
+		Payload: payload,
+	}
+}
+
+// payload returns the payload of key and appearance events.
+func (inst *instance) payload() map[string]interface{} {
+	col, row := inst.host.coordinates(inst.key)
+
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	p := map[string]interface{}{
+		"settings":        inst.settings,
+		"coordinates":     map[string]int{"column": col, "row": row},
+		"controller":      "Keypad",
+		"isInMultiAction": false,
+	}
+	if len(inst.info.States) > 1 {
+		p["state"] = inst.state
+	}
+	return p
+}
+
+func (inst *instance) getSettings() json.RawMessage {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	return inst.settings
+}
+
+// Change sends keyDown and keyUp to the plugin. As in the Elgato software,
+// actions with two states switch state when released.
+func (inst *instance) Change(state sd.BtnState) {
+	if state == sd.BtnPressed {
+		inst.host.send(inst.event("keyDown", inst.payload()))
+		return
+	}
+	inst.host.send(inst.event("keyUp", inst.payload()))
+
+	if len(inst.info.States) == 2 {
+		inst.mu.Lock()
+		inst.state = 1 - inst.state
+		inst.mu.Unlock()
+		inst.Invalidate()
+	}
+}
+
+// handle executes a message of the plugin about this instance.
+func (inst *instance) handle(m *message) error {
+	switch m.Event {
+	case "setTitle":
+		var p struct {
+			Title  *string
+			Target int
+			State  *int
+		}
+		if err := json.Unmarshal(m.Payload, &p); err != nil {
+			return err
+		}
+		if p.Target == 2 {
+			return nil // software only
+		}
+		inst.mu.Lock()
+		for _, s := range inst.targetStates(p.State) {
+			if p.Title == nil {
+				delete(inst.titles, s)
+			} else {
+				inst.titles[s] = *p.Title
+			}
+		}
+		inst.mu.Unlock()
+	case "setImage":
+		var p struct {
+			Image  string
+			Target int
+			State  *int
+		}
+		if err := json.Unmarshal(m.Payload, &p); err != nil {
+			return err
+		}
+		if p.Target == 2 {
+			return nil
+		}
+		var img func(*tile.Tile)
+		if p.Image != "" {
+			var err error
+			if img, err = decodeDataURL(p.Image); err != nil {
+				return err
+			}
+		}
+		inst.mu.Lock()
+		for _, s := range inst.targetStates(p.State) {
+			if img == nil {
+				delete(inst.images, s)
+			} else {
+				inst.images[s] = img
+			}
+		}
+		inst.mu.Unlock()
+	case "setState":
+		var p struct{ State int }
+		if err := json.Unmarshal(m.Payload, &p); err != nil {
+			return err
+		}
+		if p.State < 0 || p.State >= len(inst.info.States) {
+			return fmt.Errorf("invalid state %d", p.State)
+		}
+		inst.mu.Lock()
+		inst.state = p.State
+		inst.mu.Unlock()
+	case "showOk", "showAlert":
+		c := action.SuccessColor
+		if m.Event == "showAlert" {
+			c = action.FailureColor
+		}
+		inst.mu.Lock()
+		inst.feedback = c
+		inst.feedbackUntil = time.Now().Add(feedbackDuration)
+		inst.mu.Unlock()
+		time.AfterFunc(feedbackDuration, inst.Invalidate)
+	case "setSettings":
+		inst.mu.Lock()
+		inst.settings = m.Payload
+		inst.mu.Unlock()
+		if inst.host.onSettings != nil {
+			inst.host.onSettings(inst.key, m.Payload)
+		}
+		return nil
+	case "getSettings":
+		inst.host.send(inst.event("didReceiveSettings", inst.payload()))
+		return nil
+	default:
+		return nil
+	}
+	inst.Invalidate()
+	return nil
+}
+
+// targetStates returns the states concerned by a change, all of them if
+// state is nil. The caller holds the lock.
+func (inst *instance) targetStates(state *int) []int {
+	if state != nil {
+		return []int{*state}
+	}
+	n := len(inst.info.States)
+	if n == 0 {
+		n = 1
+	}
+	res := make([]int, n)
+	for i := range res {
+		res[i] = i
+	}
+	return res
+}
+
+// decodeDataURL decodes an image sent by a plugin: a data URL of a PNG,
+// JPEG, GIF or SVG image. It returns the option setting it on a tile.
+func decodeDataURL(s string) (func(*tile.Tile), error) {
+	if !strings.HasPrefix(s, "data:") {
+		return nil, fmt.Errorf("image is not a data URL")
+	}
+	i := strings.IndexByte(s, ',')
+	if i < 0 {
+		return nil, fmt.Errorf("invalid data URL")
+	}
+	meta, data := s[5:i], s[i+1:]
+
+	var raw []byte
+	if strings.HasSuffix(meta, ";base64") {
+		var err error
+		if raw, err = base64.StdEncoding.DecodeString(data); err != nil {
+			return nil, err
+		}
+	} else if unescaped, err := url.PathUnescape(data); err == nil {
+		raw = []byte(unescaped)
+	} else {
+		raw = []byte(data)
+	}
+
+	if strings.HasPrefix(meta, "image/svg") {
+		icon, err := svg.Parse(bytes.NewReader(raw))
+		if err != nil {
+			return nil, err
+		}
+		return func(t *tile.Tile) { t.SetSVG(icon) }, nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	return func(t *tile.Tile) { t.SetImage(img) }, nil
+}
+
+// loadImage loads an image file of the plugin, returning the option
+// setting it on a tile.
+func loadImage(path string) (func(*tile.Tile), error) {
+	if svg.IsSVG(path) {
+		icon, err := svg.ParseFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return func(t *tile.Tile) { t.SetSVG(icon) }, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	return func(t *tile.Tile) { t.SetImage(img) }, nil
+}
+
+// defaultImage returns the image of a state from the manifest. The caller
+// holds the lock.
+func (inst *instance) defaultImage(state int) func(*tile.Tile) {
+	if img, ok := inst.defaults[state]; ok {
+		return img
+	}
+	var img func(*tile.Tile)
+	if state < len(inst.info.States) {
+		if path := inst.host.plugin.image(inst.info.States[state].Image); path != "" {
+			var err error
+			if img, err = loadImage(path); err != nil {
+				inst.host.logf("%s: %s", path, err)
+			}
+		}
+	}
+	inst.defaults[state] = img
+	return img
+}
+
+// Render draws the image and title of the current state, and the feedback
+// requested by showOk or showAlert.
+func (inst *instance) Render(img *image.RGBA) error {
+	inst.mu.Lock()
+	state := inst.state
+	var si *StateInfo
+	if state < len(inst.info.States) {
+		si = inst.info.States[state]
+	}
+
+	t := tile.New()
+	title, ok := inst.titles[state]
+	if !ok && si != nil && (si.ShowTitle == nil || *si.ShowTitle) {
+		title = si.Title
+	}
+	t.SetText(title)
+	if si != nil && si.TitleColor != "" {
+		if c, err := profile.ParseColor(si.TitleColor); err == nil {
+			t.SetTextColor(c)
+		}
+	}
+	if set, ok := inst.images[state]; ok {
+		set(t)
+	} else if set := inst.defaultImage(state); set != nil {
+		set(t)
+	}
+	feedback := inst.feedback
+	showFeedback := feedback != nil && time.Now().Before(inst.feedbackUntil)
+	inst.mu.Unlock()
+
+	if err := t.Render(img); err != nil {
+		return err
+	}
+	if showFeedback {
+		c := color.NRGBAModel.Convert(feedback).(color.NRGBA)
+		c.A = 0x80
+		draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Over)
+	}
+	return nil
+}
+
+// blank returns a black key image.
+func blank(size int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Bounds(), image.Black, image.Point{}, draw.Src)
+	return img
+}
@@ -0,0 +1,115 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Manifest is the part of the manifest.json of a plugin used by the Host.
+type Manifest struct {
+	Name     string
+	UUID     string
+	Version  string
+	CodePath string
+	// CodePathLin is not an official key, but lets a plugin point to a
+	// Linux build.
+	CodePathLin string
+	Actions     []*ActionInfo
+	Nodejs      *struct {
+		Version string
+	}
+}
+
+// ActionInfo describes an action of a plugin.
+type ActionInfo struct {
+	UUID    string
+	Name    string
+	Tooltip string
+	States  []*StateInfo
+}
+
+// StateInfo is the default appearance of a state of an action.
+type StateInfo struct {
+	Image      string // path relative to the plugin, without extension
+	Title      string
+	ShowTitle  *bool
+	TitleColor string
+}
+
+// Plugin is a plugin installed in a directory, typically named like
+// "com.example.name.sdPlugin".
+type Plugin struct {
+	Dir      string
+	Manifest *Manifest
+}
+
+// Load reads the manifest of the plugin in dir.
+func Load(dir string) (*Plugin, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Join(dir, "manifest.json"), err)
+	}
+	if m.UUID == "" {
+		// Older plugins are identified by their directory name.
+		m.UUID = strings.TrimSuffix(filepath.Base(dir), ".sdPlugin")
+	}
+	if len(m.Actions) == 0 {
+		return nil, fmt.Errorf("%s: plugin has no actions", dir)
+	}
+	return &Plugin{Dir: dir, Manifest: &m}, nil
+}
+
+// Action returns the action with the given UUID, or nil.
+func (p *Plugin) Action(uuid string) *ActionInfo {
+	for _, a := range p.Manifest.Actions {
+		if a.UUID == uuid {
+			return a
+		}
+	}
+	return nil
+}
+
+// command returns the executable and arguments to start the plugin.
+func (p *Plugin) command() (string, []string, error) {
+	code := p.Manifest.CodePathLin
+	if code == "" {
+		code = p.Manifest.CodePath
+	}
+	if code == "" {
+		return "", nil, fmt.Errorf("%s: plugin has no CodePath", p.Dir)
+	}
+	path := filepath.Join(p.Dir, code)
+
+	switch strings.ToLower(filepath.Ext(code)) {
+	case ".js", ".mjs", ".cjs":
+		return "node", []string{path}, nil
+	case ".html", ".htm":
+		return "", nil, fmt.Errorf("%s: HTML plugins are not supported", p.Dir)
+	case ".exe":
+		return "", nil, fmt.Errorf("%s: plugin has no Linux executable", p.Dir)
+	}
+	return path, nil, nil
+}
+
+// image resolves an image path of the manifest, which usually has no
+// extension, to a file.
+func (p *Plugin) image(name string) string {
+	if name == "" {
+		return ""
+	}
+	base := filepath.Join(p.Dir, name)
+	for _, suffix := range []string{"", ".png", "@2x.png", ".svg", ".jpg", ".gif"} {
+		if fi, err := os.Stat(base + suffix); err == nil && !fi.IsDir() {
+			return base + suffix
+		}
+	}
+	return ""
+}
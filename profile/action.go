@@ -0,0 +1,173 @@
+package profile
+
+import (
+	"fmt"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/action"
+	"github.com/KarpelesLab/streamdeck/page"
+)
+
+// flashDuration is how long a key flashes after its action ran.
+const flashDuration = 500 * time.Millisecond
+
+// deckRef lets actions switch pages on a deck which is created after them.
+type deckRef struct {
+	mu   sync.Mutex
+	deck *page.Deck
+}
+
+func (r *deckRef) set(d *page.Deck) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deck = d
+}
+
+func (r *deckRef) get() (*page.Deck, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.deck == nil {
+		return nil, fmt.Errorf("deck not ready")
+	}
+	return r.deck, nil
+}
+
+func (r *deckRef) SwitchToName(name string) error {
+	d, err := r.get()
+	if err != nil {
+		return err
+	}
+	return d.SwitchToName(name)
+}
+
+func (r *deckRef) Back() error {
+	d, err := r.get()
+	if err != nil {
+		return err
+	}
+	return d.Back()
+}
+
+// actionEnv returns the environment of the actions run on a surface.
+func actionEnv(s sd.Surface, ref *deckRef) action.Env {
+	env := action.Env{Deck: ref}
+	if d, ok := s.(action.Device); ok {
+		env.Device = d
+	}
+	return env
+}
+
+// button wraps el into an action.Button running the action of the key. Page
+// and back actions are handled by page.Key instead, and yield el as is.
+func (b *builder) button(el sd.Element, k *Key) (sd.Element, error) {
+	a := k.Action
+	if a == nil || a.Type == "page" || a.Type == "back" {
+		return el, nil
+	}
+
+	act, err := b.action(a)
+	if err != nil {
+		return nil, err
+	}
+	var opts []func(*action.Button)
+	if a.Flash {
+		opts = append(opts, action.Flash(flashDuration))
+	}
+	env := b.env
+	env.Key = k.Index()
+	return action.NewButton(el, act, env, opts...), nil
+}
+
+// action converts a validated action definition.
+func (b *builder) action(a *Action) (action.Action, error) {
+	var timeout time.Duration
+	if a.Timeout != "" {
+		timeout, _ = time.ParseDuration(a.Timeout)
+	}
+
+	switch a.Type {
+	case "page":
+		return action.Page(a.Page), nil
+
+	case "back":
+		return action.Back{}, nil
+
+	case "command":
+		c := &action.Command{
+			Path:    a.Command,
+			Args:    a.Args,
+			Timeout: timeout,
+		}
+		// paths with a slash are relative to the profile, others are
+		// looked up in $PATH
+		if strings.ContainsRune(c.Path, '/') {
+			c.Path = b.p.path(c.Path)
+		} else if _, err := exec.LookPath(c.Path); err != nil {
+			return nil, err
+		}
+		if a.Dir != "" {
+			c.Dir = b.p.path(a.Dir)
+		} else {
+			c.Dir = filepath.Dir(b.p.File)
+		}
+		names := make([]string, 0, len(a.Env))
+		for name := range a.Env {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			c.Env = append(c.Env, name+"="+a.Env[name])
+		}
+		return c, nil
+
+	case "http":
+		h := &action.HTTP{
+			Method:  a.Method,
+			URL:     a.URL,
+			Body:    a.Body,
+			Timeout: timeout,
+		}
+		if len(a.Headers) > 0 {
+			h.Header = make(http.Header)
+			for k, v := range a.Headers {
+				h.Header.Set(k, v)
+			}
+		}
+		if err := h.Validate(); err != nil {
+			return nil, err
+		}
+		return h, nil
+
+	case "brightness":
+		return action.Brightness(*a.Brightness), nil
+
+	case "delay":
+		d, err := time.ParseDuration(a.Delay)
+		if err != nil {
+			return nil, err
+		}
+		return action.Delay(d), nil
+
+	case "sequence":
+		seq := make(action.Sequence, len(a.Actions))
+		for i, step := range a.Actions {
+			act, err := b.action(step)
+			if err != nil {
+				return nil, fmt.Errorf("step %d: %w", i+1, err)
+			}
+			seq[i] = act
+		}
+		if timeout > 0 {
+			return action.WithTimeout(seq, timeout), nil
+		}
+		return seq, nil
+	}
+	return nil, fmt.Errorf("unknown action type %q", a.Type)
+}
@@ -0,0 +1,231 @@
+package profile
+
+import (
+	"fmt"
+	"image"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/action"
+	"github.com/KarpelesLab/streamdeck/icon"
+	"github.com/KarpelesLab/streamdeck/label"
+	"github.com/KarpelesLab/streamdeck/ledbutton"
+	"github.com/KarpelesLab/streamdeck/page"
+	"github.com/KarpelesLab/streamdeck/tile"
+	"github.com/golang/freetype/truetype"
+)
+
+// Apply displays the profile on a surface. The device entry is selected by
+// serial number; if the surface can set its brightness, the brightness of
+// the entry is applied.
+func (p *Profile) Apply(s sd.Surface, serial string) (*page.Deck, error) {
+	dev := p.Device(serial)
+	if dev == nil {
+		return nil, fmt.Errorf("%s: no entry for device %s", p.File, serial)
+	}
+
+	if dev.Brightness != nil {
+		if b, ok := s.(interface{ SetBrightness(uint8) error }); ok {
+			if err := b.SetBrightness(uint8(*dev.Brightness)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	ref := &deckRef{}
+	pages, err := p.Build(dev, s.NumButtons(), actionEnv(s, ref))
+	if err != nil {
+		return nil, err
+	}
+
+	deck, err := page.NewDeck(s, pages[dev.start()])
+	if err != nil {
+		return nil, err
+	}
+	for _, pg := range pages {
+		deck.Add(pg)
+	}
+	ref.set(deck)
+	return deck, nil
+}
+
+// Build creates the pages of a device entry, for a device with the given
+// number of keys. Actions are run in env; use Apply to display the pages
+// with their actions bound to the deck and the device.
+func (p *Profile) Build(dev *Device, numKeys int, env action.Env) (map[string]*page.Page, error) {
+	b := &builder{p: p, dev: dev, pages: make(map[string]*page.Page), env: env}
+
+	for _, pg := range dev.Pages {
+		if _, err := b.page(pg.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, pg := range dev.Pages {
+		for _, k := range pg.Keys {
+			if k.Index() >= numKeys {
+				return nil, b.errorf(pg, k, "key index out of range, the device has %d keys", numKeys)
+			}
+			pk, err := b.key(pg, k)
+			if err != nil {
+				return nil, err
+			}
+			b.pages[pg.Name].SetKey(k.Index(), pk)
+		}
+	}
+
+	return b.pages, nil
+}
+
+type builder struct {
+	p     *Profile
+	dev   *Device
+	pages map[string]*page.Page
+	env   action.Env
+	font  *truetype.Font
+	cp    icon.Codepoints
+}
+
+func (b *builder) errorf(pg *Page, k *Key, format string, args ...interface{}) error {
+	return &Error{
+		File:   b.p.File,
+		Device: b.dev.Serial,
+		Page:   pg.Name,
+		Key:    k.Index(),
+		Msg:    fmt.Sprintf(format, args...),
+	}
+}
+
+// page returns the page with the given name, creating it and its parents
+// as needed.
+func (b *builder) page(name string) (*page.Page, error) {
+	if pg, ok := b.pages[name]; ok {
+		return pg, nil
+	}
+
+	def := b.dev.Page(name)
+	if def == nil {
+		return nil, fmt.Errorf("%s: unknown page %q", b.p.File, name)
+	}
+
+	var parent sd.Page
+	if def.Parent != "" {
+		pp, err := b.page(def.Parent)
+		if err != nil {
+			return nil, err
+		}
+		parent = pp
+	}
+
+	pg := page.New(name, parent)
+	b.pages[name] = pg
+	return pg, nil
+}
+
+// key creates the content of a key.
+func (b *builder) key(pg *Page, k *Key) (*page.Key, error) {
+	el, err := b.element(k)
+	if err != nil {
+		return nil, b.errorf(pg, k, "%s", err)
+	}
+	if el, err = b.button(el, k); err != nil {
+		return nil, b.errorf(pg, k, "action: %s", err)
+	}
+
+	res := &page.Key{Element: el}
+	if a := k.Action; a != nil {
+		switch a.Type {
+		case "page":
+			res.Target = b.pages[a.Page]
+		case "back":
+			res.Back = true
+		}
+	}
+	return res, nil
+}
+
+// element creates the element displaying a key.
+func (b *builder) element(k *Key) (sd.Element, error) {
+	bg, _ := ParseColor(k.Color)
+	fg, _ := ParseColor(k.TextColor)
+
+	widget := k.Widget
+	if widget == "" && k.Icon != "" {
+		widget = "icon"
+	}
+
+	switch widget {
+	case "label":
+		opts := []func(*label.Label){label.Text(k.Text)}
+		if bg != nil {
+			opts = append(opts, label.BgColor(bg))
+		}
+		if fg != nil {
+			opts = append(opts, label.TextColor(fg))
+		}
+		return label.NewLabel(nil, k.Index(), opts...)
+
+	case "ledbutton":
+		opts := []func(*ledbutton.LedButton){ledbutton.Text(k.Text)}
+		if c, _ := ParseColor(k.LEDColor); c != nil {
+			opts = append(opts, ledbutton.Color(c))
+		}
+		if fg != nil {
+			opts = append(opts, ledbutton.TextColor(*image.NewUniform(fg)))
+		}
+		return ledbutton.NewLedButton(nil, k.Index(), opts...)
+
+	case "icon":
+		if err := b.loadIcons(); err != nil {
+			return nil, err
+		}
+		r, err := resolveIcon(b.cp, k.Icon)
+		if err != nil {
+			return nil, err
+		}
+		opts := []func(*icon.Icon){icon.Glyph(r), icon.Caption(k.Text)}
+		if bg != nil {
+			opts = append(opts, icon.BgColor(bg))
+		}
+		if fg != nil {
+			opts = append(opts, icon.FgColor(fg))
+		}
+		return icon.NewIcon(nil, k.Index(), b.font, opts...)
+	}
+
+	opts := []func(*tile.Tile){tile.Text(k.Text), tile.TextAlign(textAligns[k.TextAlign])}
+	if bg != nil {
+		opts = append(opts, tile.BgColor(bg))
+	}
+	if fg != nil {
+		opts = append(opts, tile.TextColor(fg))
+	}
+	t := tile.New(opts...)
+	if k.Image != "" {
+		if err := t.SetImageFile(b.p.path(k.Image)); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// loadIcons loads the icon font and codepoints of the profile once.
+func (b *builder) loadIcons() error {
+	if b.font != nil {
+		return nil
+	}
+	if b.p.Icons == nil {
+		return fmt.Errorf("no icon font configured")
+	}
+
+	f, err := icon.LoadFontFile(b.p.path(b.p.Icons.Font))
+	if err != nil {
+		return err
+	}
+	if b.p.Icons.Codepoints != "" {
+		if b.cp, err = icon.LoadCodepointsFile(b.p.path(b.p.Icons.Codepoints)); err != nil {
+			return err
+		}
+	}
+	b.font = f
+	return nil
+}
@@ -0,0 +1,490 @@
+// Package elgato imports profiles exported by the Elgato Stream Deck
+// software (.streamDeckProfile files) into the profile format of this
+// library. No Elgato software is needed.
+//
+// Pages, folders, titles, title alignment and colors, and key images are
+// imported. Folders become child pages, with their "open folder" and "back"
+// keys mapped to page actions, as are the page navigation keys. All other
+// actions are plugin specific and can't be mapped; they are listed in the
+// Result so they can be replaced by hand.
+package elgato
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/KarpelesLab/streamdeck/profile"
+)
+
+// Action UUIDs of the navigation actions built into the Stream Deck
+// software.
+const (
+	uuidOpenChild    = "com.elgato.streamdeck.profile.openchild"
+	uuidBackToParent = "com.elgato.streamdeck.profile.backtoparent"
+	uuidPageNext     = "com.elgato.streamdeck.page.next"
+	uuidPagePrevious = "com.elgato.streamdeck.page.previous"
+	uuidPageIndex    = "com.elgato.streamdeck.page.indicator"
+)
+
+// Options controls an import.
+type Options struct {
+	// Columns is the number of key columns of the device, used to turn
+	// the "col,row" positions of the profile, counted from the top left
+	// corner, into key indices, counted from the top right. If zero, it
+	// is derived from the device model of the profile, defaulting to 5.
+	Columns int
+	// Serial is the serial number of the device entry to create. If empty,
+	// the entry matches any device.
+	Serial string
+}
+
+// Unmapped is an action which couldn't be mapped to this library.
+type Unmapped struct {
+	Page string // page holding the key
+	Key  int    // index of the key
+	Name string // name of the action, as shown in the Stream Deck software
+	UUID string // identifier of the action
+}
+
+// Result is the outcome of an import.
+type Result struct {
+	Profile  *profile.Profile
+	Unmapped []Unmapped
+	// Warnings about content which was skipped, such as missing images.
+	Warnings []string
+}
+
+// ImportFile imports a .streamDeckProfile file. Key images are written to
+// destDir, and the profile is saved there as profile.yaml.
+func ImportFile(file, destDir string, opts Options) (*Result, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return Import(f, fi.Size(), destDir, opts)
+}
+
+// Import imports a .streamDeckProfile archive. Key images are written to
+// destDir, and the profile is saved there as profile.yaml.
+func Import(r io.ReaderAt, size int64, destDir string, opts Options) (*Result, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("not a stream deck profile: %w", err)
+	}
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return nil, err
+	}
+
+	im := &importer{
+		files:   make(map[string]*zip.File),
+		dest:    destDir,
+		opts:    opts,
+		res:     &Result{},
+		names:   make(map[string]bool),
+		visited: make(map[string]string),
+	}
+	for _, f := range zr.File {
+		im.files[path.Clean(f.Name)] = f
+	}
+
+	if err := im.run(); err != nil {
+		return nil, err
+	}
+
+	// validate before saving, so an invalid profile isn't left behind;
+	// File is set for the images to be found
+	p := im.res.Profile
+	file := filepath.Join(destDir, "profile.yaml")
+	p.File = file
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	if err := p.Save(file); err != nil {
+		return nil, err
+	}
+	return im.res, nil
+}
+
+type importer struct {
+	files map[string]*zip.File
+	dest  string
+	opts  Options
+	res   *Result
+	dev   *profile.Device
+	cols  int
+	// names of the pages created so far
+	names map[string]bool
+	// pages created for each manifest directory, to import folders once
+	visited map[string]string
+}
+
+func (im *importer) run() error {
+	rootDir, err := im.rootDir()
+	if err != nil {
+		return err
+	}
+	root, err := im.manifest(rootDir)
+	if err != nil {
+		return err
+	}
+
+	im.cols = im.opts.Columns
+	if im.cols <= 0 {
+		im.cols = columns[root.model()]
+	}
+	if im.cols <= 0 {
+		im.cols = 5
+	}
+
+	im.dev = &profile.Device{Serial: im.opts.Serial}
+	im.res.Profile = &profile.Profile{Devices: []*profile.Device{im.dev}}
+
+	if len(root.Pages.Pages) == 0 {
+		// version 1: the root manifest holds the keys
+		_, err := im.page(rootDir, root, root.Name, "")
+		return err
+	}
+
+	// version 2: the root manifest lists the pages
+	var pageNames []string
+	var pageDirs []string
+	for i, id := range root.Pages.Pages {
+		dir := im.pageDir(rootDir, id)
+		if dir == "" {
+			im.warnf("page %s not found in archive", id)
+			continue
+		}
+		m, err := im.manifest(dir)
+		if err != nil {
+			return err
+		}
+		name := m.Name
+		if name == "" {
+			name = fmt.Sprintf("Page %d", i+1)
+		}
+		name = im.uniqueName(name)
+		pageNames = append(pageNames, name)
+		pageDirs = append(pageDirs, dir)
+		im.visited[dir] = name
+		if strings.EqualFold(id, root.Pages.Current) || strings.EqualFold(id, root.Pages.Default) {
+			if im.dev.StartPage == "" || strings.EqualFold(id, root.Pages.Default) {
+				im.dev.StartPage = name
+			}
+		}
+	}
+	if len(pageDirs) == 0 {
+		return fmt.Errorf("profile has no pages")
+	}
+
+	for i, dir := range pageDirs {
+		m, _ := im.manifest(dir)
+		pg := &profile.Page{Name: pageNames[i]}
+		im.dev.Pages = append(im.dev.Pages, pg)
+		nav := map[string]string{
+			uuidPageNext:     pageNames[(i+1)%len(pageNames)],
+			uuidPagePrevious: pageNames[(i+len(pageNames)-1)%len(pageNames)],
+		}
+		if err := im.keys(dir, m, pg, nav); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// page imports the manifest in dir as a new page and returns its name.
+func (im *importer) page(dir string, m *manifest, name, parent string) (string, error) {
+	if name == "" {
+		name = path.Base(dir)
+	}
+	name = im.uniqueName(name)
+	im.visited[dir] = name
+
+	pg := &profile.Page{Name: name, Parent: parent}
+	im.dev.Pages = append(im.dev.Pages, pg)
+	return name, im.keys(dir, m, pg, nil)
+}
+
+// keys imports the actions of a manifest into pg. nav maps page navigation
+// actions to the page they switch to.
+func (im *importer) keys(dir string, m *manifest, pg *profile.Page, nav map[string]string) error {
+	actions := m.actions()
+	positions := make([]string, 0, len(actions))
+	for pos := range actions {
+		positions = append(positions, pos)
+	}
+	sort.Strings(positions)
+
+	for _, pos := range positions {
+		a := actions[pos]
+		col, row, ok := parsePosition(pos)
+		if !ok || col >= im.cols {
+			im.warnf("page %q: skipping key at invalid position %q", pg.Name, pos)
+			continue
+		}
+		// the profile counts columns from the left, the library from the
+		// right
+		idx := row*im.cols + im.cols - 1 - col
+
+		k := &profile.Key{Key: &idx}
+		st := a.current()
+		if st.ShowTitle == nil || *st.ShowTitle {
+			k.Text = st.Title
+		}
+		if k.Text != "" {
+			k.TextColor = st.TitleColor
+			switch st.TitleAlignment {
+			case "top", "middle", "bottom":
+				k.TextAlign = st.TitleAlignment
+			}
+		}
+		if st.Image != "" {
+			img, err := im.image(dir, pos, a.State, st.Image, pg.Name, idx)
+			if err != nil {
+				im.warnf("page %q: key %d: %s", pg.Name, idx, err)
+			}
+			k.Image = img
+		}
+
+		switch a.UUID {
+		case uuidOpenChild:
+			child, err := im.folder(dir, a.profileUUID(), a.Name, pg.Name)
+			if err != nil {
+				return err
+			}
+			if child == "" {
+				im.warnf("page %q: key %d: folder not found in archive", pg.Name, idx)
+				break
+			}
+			k.Action = &profile.Action{Type: "page", Page: child}
+		case uuidBackToParent:
+			k.Action = &profile.Action{Type: "back"}
+		case uuidPageNext, uuidPagePrevious:
+			if target, ok := nav[a.UUID]; ok {
+				k.Action = &profile.Action{Type: "page", Page: target}
+			}
+		case uuidPageIndex:
+			// display only
+		default:
+			im.res.Unmapped = append(im.res.Unmapped, Unmapped{
+				Page: pg.Name,
+				Key:  idx,
+				Name: a.Name,
+				UUID: a.UUID,
+			})
+		}
+
+		if k.Text == "" && k.Image == "" && k.Action == nil {
+			continue
+		}
+		pg.Keys = append(pg.Keys, k)
+	}
+	return nil
+}
+
+// folder imports the child profile of a folder action and returns the name
+// of its page.
+func (im *importer) folder(dir, id, name, parent string) (string, error) {
+	childDir := im.pageDir(im.rootOf(dir), id)
+	if childDir == "" {
+		return "", nil
+	}
+	if name, ok := im.visited[childDir]; ok {
+		return name, nil
+	}
+	m, err := im.manifest(childDir)
+	if err != nil {
+		return "", err
+	}
+	if m.Name != "" {
+		name = m.Name
+	}
+	if name == "" {
+		name = "Folder"
+	}
+	return im.page(childDir, m, name, parent)
+}
+
+// image extracts the image of a key and returns its path relative to the
+// destination directory.
+func (im *importer) image(dir, pos string, stateIdx int, ref, page string, idx int) (string, error) {
+	var data []byte
+	ext := strings.ToLower(path.Ext(ref))
+
+	if strings.HasPrefix(ref, "data:") {
+		// inline image: data:image/png;base64,...
+		comma := strings.IndexByte(ref, ',')
+		if comma < 0 || !strings.Contains(ref[:comma], ";base64") {
+			return "", fmt.Errorf("unsupported inline image")
+		}
+		var err error
+		if data, err = base64.StdEncoding.DecodeString(ref[comma+1:]); err != nil {
+			return "", fmt.Errorf("invalid inline image: %w", err)
+		}
+		switch {
+		case strings.Contains(ref[:comma], "svg"):
+			ext = ".svg"
+		case strings.Contains(ref[:comma], "jpeg"):
+			ext = ".jpg"
+		case strings.Contains(ref[:comma], "gif"):
+			ext = ".gif"
+		default:
+			ext = ".png"
+		}
+	} else {
+		candidates := []string{
+			path.Join(dir, ref),
+			path.Join(dir, pos, "CustomImages", ref),
+			path.Join(dir, pos, ref),
+			path.Join(dir, pos, "CustomImages", fmt.Sprintf("state%d.png", stateIdx)),
+		}
+		var f *zip.File
+		for _, c := range candidates {
+			if f = im.files[c]; f != nil {
+				break
+			}
+		}
+		if f == nil {
+			return "", fmt.Errorf("image %s not found in archive", ref)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return "", err
+		}
+		data, err = ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if ext == "" {
+		ext = ".png"
+	}
+	name := fmt.Sprintf("%s-%d%s", sanitize(page), idx, ext)
+	if err := ioutil.WriteFile(filepath.Join(im.dest, name), data, 0644); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// rootDir returns the directory of the top level manifest.
+func (im *importer) rootDir() (string, error) {
+	best := ""
+	for name := range im.files {
+		if path.Base(name) != "manifest.json" {
+			continue
+		}
+		dir := path.Dir(name)
+		if strings.Contains(dir, "/Profiles/") || strings.HasPrefix(dir, "Profiles/") {
+			continue
+		}
+		if best == "" || len(dir) < len(best) {
+			best = dir
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("not a stream deck profile: no manifest.json")
+	}
+	return best, nil
+}
+
+// rootOf returns the directory of the profile holding dir, which is either
+// the root profile or one of its sub profiles.
+func (im *importer) rootOf(dir string) string {
+	if i := strings.Index(dir, "/Profiles/"); i >= 0 {
+		return dir[:i]
+	}
+	return dir
+}
+
+// pageDir finds the directory of the sub profile with the given id. The
+// directory name is the id, possibly in another case and with an
+// .sdProfile extension.
+func (im *importer) pageDir(root, id string) string {
+	want := normalizeID(id)
+	if want == "" {
+		return ""
+	}
+	for name := range im.files {
+		if path.Base(name) != "manifest.json" {
+			continue
+		}
+		dir := path.Dir(name)
+		if path.Dir(dir) != path.Join(root, "Profiles") {
+			continue
+		}
+		if normalizeID(path.Base(dir)) == want {
+			return dir
+		}
+	}
+	return ""
+}
+
+func normalizeID(id string) string {
+	id = strings.TrimSuffix(id, ".sdProfile")
+	return strings.ToLower(strings.Replace(id, "-", "", -1))
+}
+
+func (im *importer) manifest(dir string) (*manifest, error) {
+	f := im.files[path.Join(dir, "manifest.json")]
+	if f == nil {
+		return nil, fmt.Errorf("%s: manifest.json not found", dir)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	// some versions write a byte order mark
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	m := &manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("%s/manifest.json: %w", dir, err)
+	}
+	return m, nil
+}
+
+func (im *importer) uniqueName(name string) string {
+	res := name
+	for i := 2; im.names[res]; i++ {
+		res = fmt.Sprintf("%s %d", name, i)
+	}
+	im.names[res] = true
+	return res
+}
+
+func (im *importer) warnf(format string, args ...interface{}) {
+	im.res.Warnings = append(im.res.Warnings, fmt.Sprintf(format, args...))
+}
+
+// sanitize turns a page name into something usable in a file name.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
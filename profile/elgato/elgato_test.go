@@ -0,0 +1,202 @@
+package elgato
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/KarpelesLab/streamdeck/profile"
+)
+
+// archive builds a .streamDeckProfile zip from file names and contents;
+// values other than []byte are encoded as JSON.
+func archive(t *testing.T, files map[string]interface{}) *bytes.Reader {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for name, content := range files {
+		data, ok := content.([]byte)
+		if !ok {
+			var err error
+			if data, err = json.Marshal(content); err != nil {
+				t.Fatal(err)
+			}
+		}
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func pngData(t *testing.T, c color.Color) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for i := 0; i < 16; i++ {
+		img.Set(i%4, i/4, c)
+	}
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+type m map[string]interface{}
+
+func keypad(actions m) m {
+	return m{"Controllers": []m{{"Type": "Keypad", "Actions": actions}}}
+}
+
+func titled(uuid, title string) m {
+	return m{"Name": title, "UUID": uuid, "States": []m{{"Title": title, "TitleColor": "#ff0000", "TitleAlignment": "top"}}}
+}
+
+// miniProfile is a version 2 profile of a Mini with two pages and a folder.
+func miniProfile(t *testing.T) map[string]interface{} {
+	red := pngData(t, color.RGBA{255, 0, 0, 255})
+	folder := titled(uuidOpenChild, "Dir")
+	folder["Settings"] = m{"ProfileUUID": "C0FFEE00-0000-0000-0000-000000000003"}
+	img := titled("com.example.custom", "Img")
+	img["States"] = []m{{"Image": "img.png", "ShowTitle": false}}
+
+	return map[string]interface{}{
+		"P.sdProfile/manifest.json": m{
+			"Name":   "Test",
+			"Device": m{"Model": "20GAI9901"},
+			"Pages": m{
+				"Default": "c0ffee00-0000-0000-0000-000000000001",
+				"Pages":   []string{"c0ffee00-0000-0000-0000-000000000001", "c0ffee00-0000-0000-0000-000000000002"},
+			},
+		},
+		"P.sdProfile/Profiles/C0FFEE00000000000000000000000001.sdProfile/manifest.json": keypad(m{
+			"0,0": img,
+			"1,0": folder,
+			"2,0": titled("com.example.custom", "Cust"),
+			"2,1": titled(uuidPageNext, "Next"),
+			"5,0": titled("com.example.custom", "Off"),
+		}),
+		"P.sdProfile/Profiles/C0FFEE00000000000000000000000001.sdProfile/0,0/CustomImages/img.png": red,
+		"P.sdProfile/Profiles/C0FFEE00000000000000000000000002.sdProfile/manifest.json": m{
+			"Name": "Second",
+			"Controllers": []m{{"Type": "Keypad", "Actions": m{
+				"0,1": titled(uuidPagePrevious, "Prev"),
+			}}},
+		},
+		"P.sdProfile/Profiles/C0FFEE00000000000000000000000003.sdProfile/manifest.json": keypad(m{
+			"2,1": titled(uuidBackToParent, "Back"),
+		}),
+	}
+}
+
+func TestImport(t *testing.T) {
+	dir := t.TempDir()
+	r := archive(t, miniProfile(t))
+	res, err := Import(r, r.Size(), dir, Options{Serial: "MINI0001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the saved profile is valid and holds what was imported
+	p, err := profile.Load(filepath.Join(dir, "profile.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	dev := p.Devices[0]
+	if dev.Serial != "MINI0001" || dev.StartPage != "Page 1" || len(dev.Pages) != 3 {
+		t.Fatalf("device %+v", dev)
+	}
+
+	pages := make(map[string]*profile.Page)
+	for _, pg := range dev.Pages {
+		pages[pg.Name] = pg
+	}
+	keys := func(pg *profile.Page) map[int]*profile.Key {
+		res := make(map[int]*profile.Key)
+		for _, k := range pg.Keys {
+			res[k.Index()] = k
+		}
+		return res
+	}
+
+	// "col,row" from the top left become indices from the top right
+	first := keys(pages["Page 1"])
+	if len(first) != 4 {
+		t.Errorf("first page has keys %v", first)
+	}
+	if k := first[2]; k == nil || k.Text != "" || k.Image == "" {
+		t.Errorf("key 2 (0,0): %+v", k)
+	} else {
+		data, _ := ioutil.ReadFile(filepath.Join(dir, k.Image))
+		if !bytes.Equal(data, pngData(t, color.RGBA{255, 0, 0, 255})) {
+			t.Errorf("image %s not extracted", k.Image)
+		}
+	}
+	if k := first[1]; k == nil || k.Text != "Dir" || k.Action == nil || k.Action.Type != "page" || k.Action.Page != "Dir" {
+		t.Errorf("key 1 (1,0): %+v", k)
+	}
+	if k := first[0]; k == nil || k.Text != "Cust" || k.TextColor != "#ff0000" || k.TextAlign != "top" || k.Action != nil {
+		t.Errorf("key 0 (2,0): %+v", k)
+	}
+	if k := first[3]; k == nil || k.Action == nil || k.Action.Page != "Second" {
+		t.Errorf("key 3 (2,1): %+v", k)
+	}
+
+	if k := keys(pages["Second"])[5]; k == nil || k.Action == nil || k.Action.Page != "Page 1" {
+		t.Errorf("second page, key 5 (0,1): %+v", k)
+	}
+
+	folder := pages["Dir"]
+	if folder == nil || folder.Parent != "Page 1" {
+		t.Fatalf("folder %+v", folder)
+	}
+	if k := keys(folder)[3]; k == nil || k.Action == nil || k.Action.Type != "back" {
+		t.Errorf("folder, key 3 (2,1): %+v", k)
+	}
+
+	// the key at 5,0 is off the Mini
+	if len(res.Unmapped) != 2 {
+		t.Errorf("unmapped %+v", res.Unmapped)
+	}
+	if len(res.Warnings) != 1 {
+		t.Errorf("warnings %q", res.Warnings)
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	// an invalid title color is only caught by Validate; nothing is saved
+	// then
+	files := miniProfile(t)
+	files["P.sdProfile/Profiles/C0FFEE00000000000000000000000002.sdProfile/manifest.json"] = keypad(m{
+		"0,0": m{"UUID": "com.example.custom", "States": []m{{"Title": "x", "TitleColor": "not a color"}}},
+	})
+
+	dir := t.TempDir()
+	r := archive(t, files)
+	if _, err := Import(r, r.Size(), dir, Options{}); err == nil {
+		t.Fatal("invalid profile imported")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "profile.yaml")); !os.IsNotExist(err) {
+		t.Errorf("profile.yaml saved: %v", err)
+	}
+
+	r = archive(t, map[string]interface{}{"readme.txt": []byte("hi")})
+	if _, err := Import(r, r.Size(), t.TempDir(), Options{}); err == nil {
+		t.Error("archive without manifest imported")
+	}
+}
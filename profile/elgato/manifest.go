@@ -0,0 +1,108 @@
+package elgato
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// manifest is the content of a manifest.json file. Version 1 profiles
+// (Stream Deck software before 6.0) have the actions at the top level,
+// version 2 profiles list pages, each stored with its own manifest holding
+// the actions in Controllers.
+type manifest struct {
+	Name        string `json:"Name"`
+	Version     string `json:"Version"`
+	DeviceModel string `json:"DeviceModel"`
+	Device      struct {
+		Model string `json:"Model"`
+	} `json:"Device"`
+	Actions     map[string]*action `json:"Actions"`
+	Controllers []struct {
+		Type    string             `json:"Type"`
+		Actions map[string]*action `json:"Actions"`
+	} `json:"Controllers"`
+	Pages struct {
+		Current string   `json:"Current"`
+		Default string   `json:"Default"`
+		Pages   []string `json:"Pages"`
+	} `json:"Pages"`
+}
+
+// actions returns the key actions of the manifest, whatever its version.
+func (m *manifest) actions() map[string]*action {
+	if m.Actions != nil {
+		return m.Actions
+	}
+	for _, c := range m.Controllers {
+		if c.Type == "" || c.Type == "Keypad" {
+			return c.Actions
+		}
+	}
+	return nil
+}
+
+func (m *manifest) model() string {
+	if m.Device.Model != "" {
+		return m.Device.Model
+	}
+	return m.DeviceModel
+}
+
+type action struct {
+	Name     string          `json:"Name"`
+	UUID     string          `json:"UUID"`
+	State    int             `json:"State"`
+	States   []state         `json:"States"`
+	Settings json.RawMessage `json:"Settings"`
+}
+
+type state struct {
+	Image          string `json:"Image"`
+	Title          string `json:"Title"`
+	TitleAlignment string `json:"TitleAlignment"`
+	TitleColor     string `json:"TitleColor"`
+	ShowTitle      *bool  `json:"ShowTitle"`
+}
+
+// current returns the state displayed initially.
+func (a *action) current() state {
+	if a.State >= 0 && a.State < len(a.States) {
+		return a.States[a.State]
+	}
+	if len(a.States) > 0 {
+		return a.States[0]
+	}
+	return state{}
+}
+
+// profileUUID returns the ProfileUUID setting of folder actions.
+func (a *action) profileUUID() string {
+	var s struct {
+		ProfileUUID string `json:"ProfileUUID"`
+	}
+	json.Unmarshal(a.Settings, &s)
+	return s.ProfileUUID
+}
+
+// parsePosition parses the "col,row" key of an action.
+func parsePosition(s string) (col, row int, ok bool) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	col, err1 := strconv.Atoi(strings.TrimSpace(parts[0]))
+	row, err2 := strconv.Atoi(strings.TrimSpace(parts[1]))
+	return col, row, err1 == nil && err2 == nil
+}
+
+// columns gives the number of key columns of the device models found in
+// profiles.
+var columns = map[string]int{
+	"20GAA9901": 5, // Stream Deck
+	"20GAA9902": 5, // Stream Deck
+	"20GBA9901": 5, // Stream Deck MK.2
+	"20GAI9901": 3, // Stream Deck Mini
+	"20GAT9901": 8, // Stream Deck XL
+	"20GBD9901": 4, // Stream Deck +
+}
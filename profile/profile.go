@@ -0,0 +1,231 @@
+// Package profile loads declarative deck profiles from YAML or JSON files.
+//
+// A profile describes the pages displayed on one or more devices, identified
+// by their serial number, and the content and action of each key:
+//
+//	icons:
+//	  font: fonts/MaterialSymbolsOutlined.ttf
+//	  codepoints: fonts/MaterialSymbolsOutlined.codepoints
+//	devices:
+//	  - serial: AL12345678   # omit to match any device
+//	    brightness: 60
+//	    start_page: main
+//	    pages:
+//	      - name: main
+//	        keys:
+//	          - key: 0
+//	            text: Lights
+//	            icon: lightbulb
+//	            color: "#203040"
+//	            action: {type: page, page: lights}
+//	      - name: lights
+//	        parent: main
+//	        keys:
+//	          - key: 0
+//	            text: Back
+//	            action: {type: back}
+//	          - key: 1
+//	            text: Desk
+//	            action:
+//	              type: sequence
+//	              flash: true
+//	              actions:
+//	                - {type: command, command: ./scripts/lamp.sh, args: [on]}
+//	                - {type: delay, delay: 500ms}
+//	                - type: http
+//	                  method: POST
+//	                  url: http://localhost:8123/api/scene
+//	                  headers: {Content-Type: application/json}
+//	                  body: '{"key": {{.Key}}}'
+//
+// Since JSON is a subset of YAML, the same structure can be written as JSON.
+package profile
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Profile is the root of a profile file.
+type Profile struct {
+	// File is the path the profile was loaded from. It is used to resolve
+	// relative paths and in error messages.
+	File string `yaml:"-" json:"-"`
+
+	Icons   *IconFont `yaml:"icons,omitempty" json:"icons,omitempty"`
+	Devices []*Device `yaml:"devices" json:"devices"`
+
+	// root node of the document, used to locate errors
+	node *yaml.Node
+}
+
+// IconFont configures the icon font used by the icon property of keys.
+type IconFont struct {
+	Font       string `yaml:"font" json:"font"`
+	Codepoints string `yaml:"codepoints,omitempty" json:"codepoints,omitempty"`
+}
+
+// Device describes the pages displayed on a device.
+type Device struct {
+	// Serial number of the device. An empty serial matches any device
+	// which has no entry of its own.
+	Serial     string  `yaml:"serial,omitempty" json:"serial,omitempty"`
+	Brightness *int    `yaml:"brightness,omitempty" json:"brightness,omitempty"`
+	StartPage  string  `yaml:"start_page,omitempty" json:"start_page,omitempty"`
+	Pages      []*Page `yaml:"pages" json:"pages"`
+}
+
+// Page describes the keys of a page.
+type Page struct {
+	Name   string `yaml:"name" json:"name"`
+	Parent string `yaml:"parent,omitempty" json:"parent,omitempty"`
+	Keys   []*Key `yaml:"keys" json:"keys"`
+}
+
+// Key describes the content and the action of a key.
+type Key struct {
+	Key       *int    `yaml:"key" json:"key"`
+	Text      string  `yaml:"text,omitempty" json:"text,omitempty"`
+	TextColor string  `yaml:"text_color,omitempty" json:"text_color,omitempty"`
+	TextAlign string  `yaml:"text_align,omitempty" json:"text_align,omitempty"`
+	Color     string  `yaml:"color,omitempty" json:"color,omitempty"`
+	Image     string  `yaml:"image,omitempty" json:"image,omitempty"`
+	Icon      string  `yaml:"icon,omitempty" json:"icon,omitempty"`
+	Widget    string  `yaml:"widget,omitempty" json:"widget,omitempty"`
+	LEDColor  string  `yaml:"led_color,omitempty" json:"led_color,omitempty"`
+	Action    *Action `yaml:"action,omitempty" json:"action,omitempty"`
+}
+
+// Index returns the index of the key, or -1 if it is missing.
+func (k *Key) Index() int {
+	if k.Key == nil {
+		return -1
+	}
+	return *k.Key
+}
+
+// Action describes what happens when a key is pressed.
+type Action struct {
+	// Type is one of "page" (switch to Page), "back" (return to the
+	// parent page), "command" (run Command with Args), "http" (send a
+	// request to URL), "brightness" (set Brightness), "delay" (wait for
+	// Delay) or "sequence" (run Actions one after the other).
+	Type string `yaml:"type" json:"type"`
+	Page string `yaml:"page,omitempty" json:"page,omitempty"`
+
+	Command string            `yaml:"command,omitempty" json:"command,omitempty"`
+	Args    []string          `yaml:"args,omitempty" json:"args,omitempty"`
+	Env     map[string]string `yaml:"env,omitempty" json:"env,omitempty"`
+	Dir     string            `yaml:"dir,omitempty" json:"dir,omitempty"`
+
+	Method  string            `yaml:"method,omitempty" json:"method,omitempty"`
+	URL     string            `yaml:"url,omitempty" json:"url,omitempty"`
+	Headers map[string]string `yaml:"headers,omitempty" json:"headers,omitempty"`
+	// Body is a text/template, see action.HTTP.
+	Body string `yaml:"body,omitempty" json:"body,omitempty"`
+
+	Brightness *int      `yaml:"brightness,omitempty" json:"brightness,omitempty"`
+	Delay      string    `yaml:"delay,omitempty" json:"delay,omitempty"`
+	Actions    []*Action `yaml:"actions,omitempty" json:"actions,omitempty"`
+
+	// Timeout limits the time the action may take, e.g. "10s".
+	Timeout string `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	// Flash flashes the key green or red when the action succeeds or
+	// fails. Only valid on the action of a key.
+	Flash bool `yaml:"flash,omitempty" json:"flash,omitempty"`
+}
+
+// Load reads and validates a profile file.
+func Load(path string) (*Profile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data, path)
+}
+
+// Parse parses and validates a profile. The file name is used to resolve
+// relative paths and in error messages.
+func Parse(data []byte, file string) (*Profile, error) {
+	p := &Profile{File: file}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(p); err != nil {
+		return nil, &Error{File: file, Key: -1, Msg: err.Error()}
+	}
+
+	// decode a second time as a node tree, to locate validation errors
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err == nil {
+		p.node = &root
+	}
+
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Marshal encodes the profile as YAML.
+func (p *Profile) Marshal() ([]byte, error) {
+	return yaml.Marshal(p)
+}
+
+// Save writes the profile as YAML to the given path, and sets File
+// accordingly.
+func (p *Profile) Save(path string) error {
+	data, err := p.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	p.File = path
+	return nil
+}
+
+// Device returns the device entry matching the given serial number, or the
+// default entry (without serial) if there is none.
+func (p *Profile) Device(serial string) *Device {
+	var def *Device
+	for _, d := range p.Devices {
+		if d.Serial == serial {
+			return d
+		}
+		if d.Serial == "" {
+			def = d
+		}
+	}
+	return def
+}
+
+// Page returns the page of the device with the given name, or nil.
+func (d *Device) Page(name string) *Page {
+	for _, pg := range d.Pages {
+		if pg.Name == name {
+			return pg
+		}
+	}
+	return nil
+}
+
+// start returns the name of the page displayed first.
+func (d *Device) start() string {
+	if d.StartPage != "" {
+		return d.StartPage
+	}
+	return d.Pages[0].Name
+}
+
+// path resolves a path relative to the directory of the profile file.
+func (p *Profile) path(name string) string {
+	if name == "" || filepath.IsAbs(name) || p.File == "" {
+		return name
+	}
+	return filepath.Join(filepath.Dir(p.File), name)
+}
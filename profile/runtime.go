@@ -0,0 +1,412 @@
+package profile
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/page"
+)
+
+// Runtime displays a profile file on a surface and keeps it up to date
+// when the file or the assets it references change. On reload, only the
+// keys whose definition or assets changed are rebuilt and redrawn; the
+// current page and the state of the other widgets are preserved. If the
+// new profile is invalid, the previous one keeps running.
+type Runtime struct {
+	// applyMu serializes the changes of profile, so a reload can't
+	// interleave with another one or with Close.
+	applyMu sync.Mutex
+	mu      sync.Mutex
+	path    string
+	surface sd.Surface
+	serial  string
+	profile *Profile
+	dev     *Device
+	pages   map[string]*page.Page
+	assets  map[string]time.Time
+	deck    *page.Deck
+	ref     *deckRef
+	stop    chan struct{}
+	closed  bool
+}
+
+// Run loads the profile file at path and displays it on the surface. The
+// device entry is selected by serial number.
+func Run(path string, s sd.Surface, serial string) (*Runtime, error) {
+	p, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Runtime{
+		path:    path,
+		surface: s,
+		serial:  serial,
+		pages:   make(map[string]*page.Page),
+		ref:     &deckRef{},
+	}
+	if err := r.apply(p); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Deck returns the deck displaying the profile.
+func (r *Runtime) Deck() *page.Deck {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.deck
+}
+
+// Profile returns the profile being displayed.
+func (r *Runtime) Profile() *Profile {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.profile
+}
+
+// Close stops watching and detaches the profile from the surface.
+func (r *Runtime) Close() {
+	r.applyMu.Lock()
+	defer r.applyMu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
+	if r.stop != nil {
+		close(r.stop)
+		r.stop = nil
+	}
+	if r.deck != nil {
+		r.deck.Close()
+	}
+}
+
+// Reload reads the profile file again and applies the changes.
+func (r *Runtime) Reload() error {
+	p, err := Load(r.path)
+	if err != nil {
+		return err
+	}
+	return r.apply(p)
+}
+
+// Watch polls the profile file and its assets at the given interval, and
+// reloads the profile when any of them changed. Errors are reported to
+// onError, which may be nil. Watching stops when the Runtime is closed.
+func (r *Runtime) Watch(interval time.Duration, onError func(error)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stop != nil {
+		return
+	}
+	r.stop = make(chan struct{})
+	go r.watch(interval, r.stop, onError)
+}
+
+func (r *Runtime) watch(interval time.Duration, stop chan struct{}, onError func(error)) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	// modification times of the last failed attempt, to report each broken
+	// edit only once
+	var failed map[string]time.Time
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+
+		r.mu.Lock()
+		assets := r.assets
+		r.mu.Unlock()
+
+		current := statAll(assets)
+		if sameTimes(current, assets) || (failed != nil && sameTimes(current, failed)) {
+			continue
+		}
+
+		if err := r.Reload(); err != nil {
+			failed = current
+			if onError != nil {
+				onError(err)
+			}
+			continue
+		}
+		failed = nil
+	}
+}
+
+// apply displays p, reusing as much as possible of what is displayed.
+// Everything is validated and built before the display changes, so nothing
+// is changed if p is invalid. Errors drawing the keys are returned once the
+// whole profile is applied.
+func (r *Runtime) apply(p *Profile) error {
+	r.applyMu.Lock()
+	defer r.applyMu.Unlock()
+
+	r.mu.Lock()
+	closed := r.closed
+	r.mu.Unlock()
+	if closed {
+		return fmt.Errorf("%s: profile closed", p.File)
+	}
+
+	dev := p.Device(r.serial)
+	if dev == nil {
+		return fmt.Errorf("%s: no entry for device %s", p.File, r.serial)
+	}
+	numKeys := r.surface.NumButtons()
+	for _, pg := range dev.Pages {
+		for _, k := range pg.Keys {
+			if k.Index() >= numKeys {
+				return &Error{File: p.File, Device: dev.Serial, Page: pg.Name, Key: k.Index(),
+					Msg: fmt.Sprintf("key index out of range, the device has %d keys", numKeys)}
+			}
+		}
+	}
+
+	r.mu.Lock()
+	oldDev, oldPages, oldAssets, oldProfile := r.dev, r.pages, r.assets, r.profile
+	r.mu.Unlock()
+
+	assets := p.assets(dev)
+
+	// a page is kept if it existed with the same parent, and its parent is
+	// kept as well: page.Page can't change parents
+	kept := make(map[string]bool)
+	var isKept func(name string, depth int) bool
+	isKept = func(name string, depth int) bool {
+		if v, ok := kept[name]; ok {
+			return v
+		}
+		res := false
+		if oldDev != nil && depth <= len(dev.Pages) {
+			def, old := dev.Page(name), oldDev.Page(name)
+			res = def != nil && old != nil && oldPages[name] != nil && def.Parent == old.Parent &&
+				(def.Parent == "" || isKept(def.Parent, depth+1))
+		}
+		kept[name] = res
+		return res
+	}
+
+	b := &builder{p: p, dev: dev, pages: make(map[string]*page.Page), env: actionEnv(r.surface, r.ref)}
+	for _, pg := range dev.Pages {
+		if isKept(pg.Name, 0) {
+			b.pages[pg.Name] = oldPages[pg.Name]
+		}
+	}
+	for _, pg := range dev.Pages {
+		if _, err := b.page(pg.Name); err != nil {
+			return err
+		}
+	}
+
+	iconsChanged := oldProfile == nil || !reflect.DeepEqual(oldProfile.Icons, p.Icons) ||
+		(p.Icons != nil && (assetChanged(p.path(p.Icons.Font), oldAssets, assets) ||
+			assetChanged(p.path(p.Icons.Codepoints), oldAssets, assets)))
+
+	// build all changed keys first, so a failure leaves everything as is
+	type update struct {
+		pg  *page.Page
+		idx int
+		key *page.Key
+	}
+	var updates []update
+
+	for _, def := range dev.Pages {
+		pg := b.pages[def.Name]
+		old := make(map[int]*Key)
+		if kept[def.Name] {
+			for _, k := range oldDev.Page(def.Name).Keys {
+				old[k.Index()] = k
+			}
+		}
+
+		for _, k := range def.Keys {
+			if o := old[k.Index()]; o != nil && reflect.DeepEqual(o, k) &&
+				!(k.Icon != "" && iconsChanged) &&
+				!(k.Image != "" && assetChanged(p.path(k.Image), oldAssets, assets)) &&
+				!(k.Action != nil && k.Action.Type == "page" && !kept[k.Action.Page]) {
+				delete(old, k.Index())
+				continue
+			}
+			delete(old, k.Index())
+
+			pk, err := b.key(def, k)
+			if err != nil {
+				return err
+			}
+			updates = append(updates, update{pg, k.Index(), pk})
+		}
+
+		// keys which were removed
+		for idx := range old {
+			updates = append(updates, update{pg, idx, nil})
+		}
+	}
+
+	r.mu.Lock()
+	deck := r.deck
+	r.mu.Unlock()
+
+	if deck == nil {
+		// nothing is displayed yet: the profile is only kept if the deck
+		// can be created
+		for _, u := range updates {
+			u.pg.SetKey(u.idx, u.key)
+		}
+		if err := r.setBrightness(dev); err != nil {
+			return err
+		}
+		deck, err := page.NewDeck(r.surface, b.pages[dev.start()])
+		if err != nil {
+			return err
+		}
+		deck.Add(pagesOf(b.pages)...)
+		r.ref.set(deck)
+
+		r.mu.Lock()
+		r.deck = deck
+		r.profile, r.dev, r.pages, r.assets = p, dev, b.pages, assets
+		r.mu.Unlock()
+		return nil
+	}
+
+	// commit: from here on, the whole profile is applied even if drawing
+	// fails, and the first error is returned
+	var firstErr error
+	for _, u := range updates {
+		if prev := u.pg.Key(u.idx); prev != nil {
+			closeElement(prev.Element)
+		}
+		u.pg.SetKey(u.idx, u.key)
+	}
+
+	r.mu.Lock()
+	r.profile, r.dev, r.pages, r.assets = p, dev, b.pages, assets
+	r.mu.Unlock()
+
+	deck.Add(pagesOf(b.pages)...)
+
+	// swap the pages which were recreated, the current page is kept if it
+	// still exists, otherwise the start page is displayed instead
+	start := b.pages[dev.start()]
+	for name, old := range oldPages {
+		pg := b.pages[name]
+		if pg == old {
+			continue
+		}
+		if pg == nil {
+			pg = start
+		}
+		if err := deck.Replace(old, pg); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if b.pages[name] == nil {
+			deck.Remove(name)
+		}
+		for _, idx := range old.Keys() {
+			closeElement(old.Key(idx).Element)
+		}
+	}
+
+	if !reflect.DeepEqual(oldDev.Brightness, dev.Brightness) {
+		if err := r.setBrightness(dev); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (r *Runtime) setBrightness(dev *Device) error {
+	if dev.Brightness == nil {
+		return nil
+	}
+	if b, ok := r.surface.(interface{ SetBrightness(uint8) error }); ok {
+		return b.SetBrightness(uint8(*dev.Brightness))
+	}
+	return nil
+}
+
+func pagesOf(m map[string]*page.Page) []*page.Page {
+	res := make([]*page.Page, 0, len(m))
+	for _, pg := range m {
+		res = append(res, pg)
+	}
+	return res
+}
+
+// closeElement releases the resources of elements which have some, such
+// as the animation of a LedButton.
+func closeElement(el interface{}) {
+	if c, ok := el.(interface{ Close() }); ok {
+		c.Close()
+	}
+}
+
+// assets returns the files used by the profile for a device, with their
+// modification time.
+func (p *Profile) assets(dev *Device) map[string]time.Time {
+	var paths []string
+	if p.File != "" {
+		paths = append(paths, p.File)
+	}
+	if p.Icons != nil {
+		paths = append(paths, p.path(p.Icons.Font))
+		if p.Icons.Codepoints != "" {
+			paths = append(paths, p.path(p.Icons.Codepoints))
+		}
+	}
+	for _, pg := range dev.Pages {
+		for _, k := range pg.Keys {
+			if k.Image != "" {
+				paths = append(paths, p.path(k.Image))
+			}
+		}
+	}
+
+	res := make(map[string]time.Time, len(paths))
+	for _, path := range paths {
+		res[path] = time.Time{}
+	}
+	return statAll(res)
+}
+
+// statAll returns the current modification times of the given files. Files
+// which can't be accessed get the zero time.
+func statAll(files map[string]time.Time) map[string]time.Time {
+	res := make(map[string]time.Time, len(files))
+	for path := range files {
+		var t time.Time
+		if fi, err := os.Stat(path); err == nil {
+			t = fi.ModTime()
+		}
+		res[path] = t
+	}
+	return res
+}
+
+func sameTimes(a, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || !w.Equal(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func assetChanged(path string, old, cur map[string]time.Time) bool {
+	o, ok := old[path]
+	return !ok || !o.Equal(cur[path])
+}
@@ -0,0 +1,217 @@
+package profile
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/mock"
+)
+
+var (
+	red   = color.RGBA{255, 0, 0, 255}
+	green = color.RGBA{0, 255, 0, 255}
+	blue  = color.RGBA{0, 0, 255, 255}
+)
+
+// testProfile returns a profile with two pages.
+func testProfile(brightness int, key0, key1 string) string {
+	return fmt.Sprintf(`devices:
+  - brightness: %d
+    pages:
+      - name: main
+        keys:
+          - key: 0
+            color: "%s"
+          - key: 1
+            color: "%s"
+          - key: 2
+            action: {type: page, page: sub}
+      - name: sub
+        parent: main
+        keys:
+          - key: 0
+            color: "#00ff00"
+`, brightness, key0, key1)
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// failingSurface is a deck on which drawing some keys fails.
+type failingSurface struct {
+	*sd.StreamDeck
+	mu   sync.Mutex
+	fail map[int]bool
+}
+
+func (s *failingSurface) FillImage(btnIndex int, img image.Image) error {
+	s.mu.Lock()
+	fail := s.fail[btnIndex]
+	s.mu.Unlock()
+	if fail {
+		return errors.New("draw failed")
+	}
+	return s.StreamDeck.FillImage(btnIndex, img)
+}
+
+func newSurface(t *testing.T) (*failingSurface, *mock.Device) {
+	t.Helper()
+	dev, m := mock.Open(t, sd.LookupDevice(0x0063), "TEST0001")
+	return &failingSurface{StreamDeck: dev, fail: make(map[int]bool)}, m
+}
+
+func keyColor(m *mock.Device, btnIndex int) color.RGBA {
+	return m.Key(btnIndex).(*image.RGBA).RGBAAt(4, 4)
+}
+
+func TestRuntimeReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profile.yaml")
+	writeFile(t, path, testProfile(50, "#ff0000", "#0000ff"))
+	s, m := newSurface(t)
+
+	r, err := Run(path, s, "TEST0001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if c := keyColor(m, 0); c != red {
+		t.Errorf("key 0 is %v, want red", c)
+	}
+	if m.Brightness() != 50 {
+		t.Errorf("brightness = %d, want 50", m.Brightness())
+	}
+
+	var mu sync.Mutex
+	draws := make(map[int]int)
+	m.OnDraw(func(btnIndex int) {
+		mu.Lock()
+		draws[btnIndex]++
+		mu.Unlock()
+	})
+
+	writeFile(t, path, testProfile(70, "#00ff00", "#0000ff"))
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if c := keyColor(m, 0); c != green {
+		t.Errorf("key 0 is %v, want green", c)
+	}
+	if m.Brightness() != 70 {
+		t.Errorf("brightness = %d, want 70", m.Brightness())
+	}
+	mu.Lock()
+	if draws[1] != 0 {
+		t.Errorf("unchanged key 1 was drawn %d times", draws[1])
+	}
+	mu.Unlock()
+
+	// An invalid profile changes nothing.
+	prev := r.Profile()
+	writeFile(t, path, testProfile(20, "#ff0000", "#ff0000")+"          - key: 6\n")
+	if err := r.Reload(); err == nil {
+		t.Fatal("invalid profile accepted")
+	}
+	if r.Profile() != prev || keyColor(m, 0) != green || keyColor(m, 1) != blue || m.Brightness() != 70 {
+		t.Error("invalid profile was partly applied")
+	}
+
+	if err := r.Deck().SwitchToName("sub"); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, path, testProfile(70, "#0000ff", "#0000ff"))
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if name := r.Deck().Current().Name(); name != "sub" {
+		t.Errorf("current page %q after reload, want sub", name)
+	}
+}
+
+func TestRuntimeDrawError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profile.yaml")
+	writeFile(t, path, testProfile(50, "#ff0000", "#ff0000"))
+	s, m := newSurface(t)
+
+	r, err := Run(path, s, "TEST0001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// Renaming the current page replaces it, redrawing all keys.
+	s.mu.Lock()
+	s.fail[0] = true
+	s.mu.Unlock()
+	writeFile(t, path, strings.Replace(testProfile(80, "#00ff00", "#0000ff"), "main", "home", -1))
+	if err := r.Reload(); err == nil {
+		t.Error("draw error not reported")
+	}
+
+	// The rest of the profile is applied nonetheless.
+	if c := keyColor(m, 1); c != blue {
+		t.Errorf("key 1 is %v, want blue", c)
+	}
+	if m.Brightness() != 80 {
+		t.Errorf("brightness = %d, want 80", m.Brightness())
+	}
+	if b := r.Profile().Device("TEST0001").Brightness; b == nil || *b != 80 {
+		t.Error("the new profile isn't the current one")
+	}
+	if name := r.Deck().Current().Name(); name != "home" {
+		t.Errorf("current page %q, want home", name)
+	}
+}
+
+func TestRuntimeConcurrentReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profile.yaml")
+	writeFile(t, path, testProfile(50, "#ff0000", "#ff0000"))
+	s, m := newSurface(t)
+
+	r, err := Run(path, s, "TEST0001")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				p, err := Parse([]byte(testProfile(10+i, "#0000ff", fmt.Sprintf("#0000%02x", i*16+j))), path)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if err := r.apply(p); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Whatever order they ran in, the last profile applied is displayed.
+	want := r.Profile().Devices[0].Pages[0].Keys[1].Color
+	c, _ := ParseColor(want)
+	if got := keyColor(m, 1); got != color.RGBAModel.Convert(c) {
+		t.Errorf("key 1 is %v, want %s", got, want)
+	}
+
+	r.Close()
+	if err := r.Reload(); err == nil {
+		t.Error("Reload after Close succeeded")
+	}
+}
@@ -0,0 +1,544 @@
+package profile
+
+import (
+	"fmt"
+	"image/color"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/KarpelesLab/streamdeck/action"
+	"github.com/KarpelesLab/streamdeck/icon"
+	"github.com/KarpelesLab/streamdeck/svg"
+	"github.com/KarpelesLab/streamdeck/tile"
+	"golang.org/x/image/colornames"
+	"gopkg.in/yaml.v3"
+)
+
+// Error is a profile error, located as precisely as possible.
+type Error struct {
+	File   string
+	Line   int    // 0 if unknown
+	Device string // serial of the device, if any
+	Page   string // name of the page, if any
+	Key    int    // index of the key, -1 if none
+	Field  string // name of the property, if any
+	Msg    string
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	b.WriteString(e.File)
+	if e.Line > 0 {
+		fmt.Fprintf(&b, ":%d", e.Line)
+	}
+	b.WriteString(": ")
+	if e.Device != "" {
+		fmt.Fprintf(&b, "device %s: ", e.Device)
+	}
+	if e.Page != "" {
+		fmt.Fprintf(&b, "page %q: ", e.Page)
+	}
+	if e.Key >= 0 {
+		fmt.Fprintf(&b, "key %d: ", e.Key)
+	}
+	if e.Field != "" {
+		fmt.Fprintf(&b, "%s: ", e.Field)
+	}
+	b.WriteString(e.Msg)
+	return b.String()
+}
+
+// ErrorList is the list of all errors found while validating a profile.
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	msgs := make([]string, len(l))
+	for i, e := range l {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Widget types accepted by the widget property of a key.
+var widgets = map[string]bool{
+	"":          true,
+	"tile":      true,
+	"label":     true,
+	"ledbutton": true,
+	"icon":      true,
+}
+
+// Values accepted by the text_align property of a key.
+var textAligns = map[string]tile.Align{
+	"":       tile.AlignAuto,
+	"top":    tile.AlignTop,
+	"middle": tile.AlignMiddle,
+	"bottom": tile.AlignBottom,
+}
+
+// Validate checks the profile for errors. It returns an ErrorList, or nil.
+func (p *Profile) Validate() error {
+	v := &validator{p: p}
+	v.validate()
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+type validator struct {
+	p          *Profile
+	errs       ErrorList
+	codepoints icon.Codepoints
+}
+
+// loc identifies where an error is found.
+type loc struct {
+	path   []interface{} // path to the node: map keys and sequence indices
+	device string
+	page   string
+	key    int
+}
+
+func (v *validator) errorf(l loc, field, format string, args ...interface{}) {
+	path := l.path
+	if field != "" {
+		path = append(path[:len(path):len(path)], field)
+	}
+	v.errs = append(v.errs, &Error{
+		File:   v.p.File,
+		Line:   lineOf(v.p.node, path),
+		Device: l.device,
+		Page:   l.page,
+		Key:    l.key,
+		Field:  field,
+		Msg:    fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) validate() {
+	root := loc{key: -1}
+
+	if v.p.Icons != nil {
+		v.validateIcons(loc{path: []interface{}{"icons"}, key: -1})
+	}
+
+	if len(v.p.Devices) == 0 {
+		v.errorf(root, "devices", "at least one device is required")
+	}
+
+	serials := make(map[string]bool)
+	for i, d := range v.p.Devices {
+		l := loc{path: []interface{}{"devices", i}, device: d.Serial, key: -1}
+		if d.Serial == "" {
+			l.device = "(default)"
+		}
+		if serials[d.Serial] {
+			v.errorf(l, "serial", "duplicate device")
+		}
+		serials[d.Serial] = true
+		v.validateDevice(l, d)
+	}
+}
+
+func (v *validator) validateIcons(l loc) {
+	ic := v.p.Icons
+	if ic.Font == "" {
+		v.errorf(l, "font", "missing icon font")
+	} else if _, err := os.Stat(v.p.path(ic.Font)); err != nil {
+		v.errorf(l, "font", "%s", err)
+	}
+	if ic.Codepoints != "" {
+		cp, err := icon.LoadCodepointsFile(v.p.path(ic.Codepoints))
+		if err != nil {
+			v.errorf(l, "codepoints", "%s", err)
+			return
+		}
+		v.codepoints = cp
+	}
+}
+
+func (v *validator) validateDevice(l loc, d *Device) {
+	if d.Brightness != nil && (*d.Brightness < 0 || *d.Brightness > 100) {
+		v.errorf(l, "brightness", "must be between 0 and 100")
+	}
+
+	if len(d.Pages) == 0 {
+		v.errorf(l, "pages", "at least one page is required")
+		return
+	}
+
+	names := make(map[string]*Page)
+	for i, pg := range d.Pages {
+		pl := l
+		pl.path = append(l.path[:len(l.path):len(l.path)], "pages", i)
+		pl.page = pg.Name
+		if pg.Name == "" {
+			v.errorf(pl, "name", "missing page name")
+			continue
+		}
+		if names[pg.Name] != nil {
+			v.errorf(pl, "name", "duplicate page")
+		}
+		names[pg.Name] = pg
+	}
+
+	if d.StartPage != "" && names[d.StartPage] == nil {
+		v.errorf(l, "start_page", "unknown page %q", d.StartPage)
+	}
+
+	for i, pg := range d.Pages {
+		pl := l
+		pl.path = append(l.path[:len(l.path):len(l.path)], "pages", i)
+		pl.page = pg.Name
+		v.validatePage(pl, pg, names)
+	}
+}
+
+func (v *validator) validatePage(l loc, pg *Page, names map[string]*Page) {
+	if pg.Parent != "" {
+		if names[pg.Parent] == nil {
+			v.errorf(l, "parent", "unknown page %q", pg.Parent)
+		} else {
+			// walk up the parents to detect cycles
+			seen := map[string]bool{pg.Name: true}
+			for p := names[pg.Parent]; p != nil; p = names[p.Parent] {
+				if seen[p.Name] {
+					v.errorf(l, "parent", "cycle in page parents")
+					break
+				}
+				seen[p.Name] = true
+			}
+		}
+	}
+
+	keys := make(map[int]bool)
+	for i, k := range pg.Keys {
+		kl := l
+		kl.path = append(l.path[:len(l.path):len(l.path)], "keys", i)
+		kl.key = k.Index()
+		if k.Key == nil {
+			v.errorf(kl, "key", "missing key index")
+		} else if *k.Key < 0 {
+			v.errorf(kl, "key", "invalid key index")
+		} else if keys[*k.Key] {
+			v.errorf(kl, "key", "duplicate key")
+		}
+		if k.Key != nil {
+			keys[*k.Key] = true
+		}
+		v.validateKey(kl, k, names)
+	}
+}
+
+func (v *validator) validateKey(l loc, k *Key, names map[string]*Page) {
+	for _, c := range []struct{ field, value string }{
+		{"color", k.Color},
+		{"text_color", k.TextColor},
+		{"led_color", k.LEDColor},
+	} {
+		if _, err := ParseColor(c.value); err != nil {
+			v.errorf(l, c.field, "%s", err)
+		}
+	}
+
+	if !widgets[k.Widget] {
+		v.errorf(l, "widget", "unknown widget %q", k.Widget)
+	}
+	switch k.Widget {
+	case "label", "ledbutton":
+		if len(k.Text) > 5 {
+			v.errorf(l, "text", "a %s can display at most 5 characters", k.Widget)
+		}
+		if k.Image != "" || k.Icon != "" {
+			v.errorf(l, "widget", "a %s can't display images or icons", k.Widget)
+		}
+	case "tile":
+		if k.Icon != "" {
+			v.errorf(l, "icon", "a tile can't display icon font glyphs")
+		}
+	case "icon":
+		if k.Icon == "" {
+			v.errorf(l, "icon", "missing icon for icon widget")
+		}
+	}
+	if _, ok := textAligns[k.TextAlign]; !ok {
+		v.errorf(l, "text_align", "must be one of top, middle or bottom")
+	} else if k.TextAlign != "" && ((k.Widget != "" && k.Widget != "tile") || k.Icon != "") {
+		v.errorf(l, "text_align", "only valid for the tile widget")
+	}
+	if k.LEDColor != "" && k.Widget != "ledbutton" {
+		v.errorf(l, "led_color", "only valid for the ledbutton widget")
+	}
+
+	if k.Image != "" && k.Icon != "" {
+		v.errorf(l, "image", "image and icon are mutually exclusive")
+	}
+	if k.Image != "" {
+		v.validateImage(l, k.Image)
+	}
+	if k.Icon != "" {
+		if v.p.Icons == nil {
+			v.errorf(l, "icon", "no icon font configured")
+		} else if _, err := v.resolveIcon(k.Icon); err != nil {
+			v.errorf(l, "icon", "%s", err)
+		}
+	}
+
+	if k.Action != nil {
+		al := l
+		al.path = append(l.path[:len(l.path):len(l.path)], "action")
+		v.validateAction(al, k.Action, names)
+	}
+}
+
+func (v *validator) validateImage(l loc, name string) {
+	path := v.p.path(name)
+	if _, err := os.Stat(path); err != nil {
+		v.errorf(l, "image", "%s", err)
+		return
+	}
+	if svg.IsSVG(path) {
+		if _, err := svg.ParseFile(path); err != nil {
+			v.errorf(l, "image", "%s: %s", name, err)
+		}
+		return
+	}
+	switch strings.ToLower(strings.TrimPrefix(filepath.Ext(path), ".")) {
+	case "png", "jpg", "jpeg", "gif":
+	default:
+		v.errorf(l, "image", "unsupported image format %q", filepath.Ext(path))
+	}
+}
+
+func (v *validator) validateAction(l loc, a *Action, names map[string]*Page) {
+	v.validateStep(l, a, names, true)
+}
+
+// validateStep validates an action, which is either the action of a key
+// (top) or a step of a sequence.
+func (v *validator) validateStep(l loc, a *Action, names map[string]*Page, top bool) {
+	if fields, ok := actionFields[a.Type]; ok {
+		for _, f := range a.fields() {
+			if !fields[f] {
+				v.errorf(l, f, "not valid for %s actions", a.Type)
+			}
+		}
+	}
+
+	switch a.Type {
+	case "page":
+		if a.Page == "" {
+			v.errorf(l, "page", "missing page")
+		} else if names[a.Page] == nil {
+			v.errorf(l, "page", "unknown page %q", a.Page)
+		}
+	case "back":
+	case "command":
+		if a.Command == "" {
+			v.errorf(l, "command", "missing command")
+		}
+		for name := range a.Env {
+			if name == "" || strings.ContainsAny(name, "=\x00") {
+				v.errorf(l, "env", "invalid variable name %q", name)
+			}
+		}
+	case "http":
+		if a.URL == "" {
+			v.errorf(l, "url", "missing url")
+		} else if u, err := url.Parse(a.URL); err != nil {
+			v.errorf(l, "url", "%s", err)
+		} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.errorf(l, "url", "must be an absolute http or https URL")
+		}
+		if a.Method != "" && !validMethod(a.Method) {
+			v.errorf(l, "method", "invalid method %q", a.Method)
+		}
+		if a.Body != "" {
+			h := &action.HTTP{URL: a.URL, Body: a.Body}
+			if err := h.Validate(); err != nil {
+				v.errorf(l, "body", "%s", err)
+			}
+		}
+	case "brightness":
+		if a.Brightness == nil {
+			v.errorf(l, "brightness", "missing brightness")
+		} else if *a.Brightness < 0 || *a.Brightness > 100 {
+			v.errorf(l, "brightness", "must be between 0 and 100")
+		}
+	case "delay":
+		if a.Delay == "" {
+			v.errorf(l, "delay", "missing delay")
+		} else if d, err := time.ParseDuration(a.Delay); err != nil || d < 0 {
+			v.errorf(l, "delay", "invalid duration %q", a.Delay)
+		}
+	case "sequence":
+		if len(a.Actions) == 0 {
+			v.errorf(l, "actions", "at least one action is required")
+		}
+		for i, step := range a.Actions {
+			sl := l
+			sl.path = append(l.path[:len(l.path):len(l.path)], "actions", i)
+			v.validateStep(sl, step, names, false)
+		}
+	case "":
+		v.errorf(l, "type", "missing action type")
+	default:
+		v.errorf(l, "type", "unknown action type %q", a.Type)
+	}
+
+	if a.Timeout != "" {
+		if d, err := time.ParseDuration(a.Timeout); err != nil || d <= 0 {
+			v.errorf(l, "timeout", "invalid duration %q", a.Timeout)
+		}
+	}
+	if a.Flash {
+		if !top {
+			v.errorf(l, "flash", "only valid on the action of a key")
+		} else if a.Type == "page" || a.Type == "back" || a.Type == "delay" {
+			v.errorf(l, "flash", "not valid for %s actions", a.Type)
+		}
+	}
+}
+
+// Properties accepted by each action type, besides type.
+var actionFields = map[string]map[string]bool{
+	"page":       {"page": true},
+	"back":       {},
+	"command":    {"command": true, "args": true, "env": true, "dir": true, "timeout": true},
+	"http":       {"method": true, "url": true, "headers": true, "body": true, "timeout": true},
+	"brightness": {"brightness": true},
+	"delay":      {"delay": true},
+	"sequence":   {"actions": true, "timeout": true},
+}
+
+// fields returns the names of the properties which are set.
+func (a *Action) fields() []string {
+	var res []string
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"page", a.Page != ""},
+		{"command", a.Command != ""},
+		{"args", len(a.Args) > 0},
+		{"env", len(a.Env) > 0},
+		{"dir", a.Dir != ""},
+		{"method", a.Method != ""},
+		{"url", a.URL != ""},
+		{"headers", len(a.Headers) > 0},
+		{"body", a.Body != ""},
+		{"brightness", a.Brightness != nil},
+		{"delay", a.Delay != ""},
+		{"actions", len(a.Actions) > 0},
+		{"timeout", a.Timeout != ""},
+	} {
+		if f.set {
+			res = append(res, f.name)
+		}
+	}
+	return res
+}
+
+// validMethod reports whether m is a valid HTTP method token.
+func validMethod(m string) bool {
+	for _, r := range m {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune("()<>@,;:\\\"/[]?={}", r) {
+			return false
+		}
+	}
+	return m != ""
+}
+
+// resolveIcon returns the codepoint of an icon given either by name, or
+// as a hexadecimal codepoint such as "U+E88A" or "0xe88a".
+func (v *validator) resolveIcon(name string) (rune, error) {
+	return resolveIcon(v.codepoints, name)
+}
+
+func resolveIcon(cp icon.Codepoints, name string) (rune, error) {
+	for _, prefix := range []string{"U+", "u+", "0x"} {
+		if strings.HasPrefix(name, prefix) {
+			r, err := strconv.ParseUint(name[len(prefix):], 16, 32)
+			if err != nil {
+				return 0, fmt.Errorf("invalid codepoint %q", name)
+			}
+			return rune(r), nil
+		}
+	}
+	if cp == nil {
+		return 0, fmt.Errorf("icon %q given by name, but no codepoints file configured", name)
+	}
+	return cp.Lookup(name)
+}
+
+// ParseColor parses a color given as #rgb, #rrggbb, #rrggbbaa or by its
+// SVG/CSS name. The empty string yields nil.
+func ParseColor(s string) (color.Color, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(s, "#") {
+		hex := s[1:]
+		if len(hex) == 3 {
+			hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+		}
+		if len(hex) == 6 {
+			hex += "ff"
+		}
+		if len(hex) == 8 {
+			if v, err := strconv.ParseUint(hex, 16, 32); err == nil {
+				return color.NRGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}, nil
+			}
+		}
+		return nil, fmt.Errorf("invalid color %q", s)
+	}
+	if c, ok := colornames.Map[strings.ToLower(s)]; ok {
+		return c, nil
+	}
+	return nil, fmt.Errorf("unknown color %q", s)
+}
+
+// lineOf returns the line of the node found by following path from root,
+// or of the deepest node found along the way.
+func lineOf(root *yaml.Node, path []interface{}) int {
+	if root == nil {
+		return 0
+	}
+	n := root
+	if n.Kind == yaml.DocumentNode && len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+
+	for _, elem := range path {
+		var next *yaml.Node
+		switch e := elem.(type) {
+		case string:
+			if n.Kind == yaml.MappingNode {
+				for i := 0; i+1 < len(n.Content); i += 2 {
+					if n.Content[i].Value == e {
+						next = n.Content[i+1]
+						if next.Kind != yaml.MappingNode && next.Kind != yaml.SequenceNode {
+							// point at the key for scalar values
+							next = n.Content[i]
+						}
+						break
+					}
+				}
+			}
+		case int:
+			if n.Kind == yaml.SequenceNode && e < len(n.Content) {
+				next = n.Content[e]
+			}
+		}
+		if next == nil {
+			break
+		}
+		n = next
+	}
+	return n.Line
+}
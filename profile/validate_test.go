@@ -0,0 +1,192 @@
+package profile
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		data string
+		want []string
+	}{{
+		name: "no device",
+		file: "p.yaml",
+		data: "devices: []\n",
+		want: []string{"p.yaml:1: devices: at least one device is required"},
+	}, {
+		name: "device and keys",
+		file: "p.yaml",
+		data: `devices:
+  - serial: ABC
+    brightness: 120
+    start_page: nope
+    pages:
+      - name: main
+        keys:
+          - key: 0
+            color: nope
+          - key: 0
+            widget: label
+            text: toolong
+          - key: 1
+            action:
+              type: page
+              page: missing
+      - name: main
+        parent: main
+`,
+		want: []string{
+			`p.yaml:3: device ABC: brightness: must be between 0 and 100`,
+			`p.yaml:17: device ABC: page "main": name: duplicate page`,
+			`p.yaml:4: device ABC: start_page: unknown page "nope"`,
+			`p.yaml:9: device ABC: page "main": key 0: color: unknown color "nope"`,
+			`p.yaml:10: device ABC: page "main": key 0: key: duplicate key`,
+			`p.yaml:12: device ABC: page "main": key 0: text: a label can display at most 5 characters`,
+			`p.yaml:16: device ABC: page "main": key 1: page: unknown page "missing"`,
+			`p.yaml:18: device ABC: page "main": parent: cycle in page parents`,
+		},
+	}, {
+		// Missing properties are located at their parent.
+		name: "missing",
+		file: "p.yaml",
+		data: `devices:
+  - pages:
+      - keys: []
+      - name: main
+        keys:
+          - color: red
+`,
+		want: []string{
+			`p.yaml:3: device (default): name: missing page name`,
+			`p.yaml:6: device (default): page "main": key: missing key index`,
+		},
+	}, {
+		name: "sequence",
+		file: "p.yaml",
+		data: `devices:
+  - pages:
+      - name: main
+        keys:
+          - key: 2
+            action:
+              type: sequence
+              flash: true
+              actions:
+                - type: delay
+                  delay: -1s
+                  flash: true
+                - command: ls
+`,
+		want: []string{
+			`p.yaml:11: device (default): page "main": key 2: delay: invalid duration "-1s"`,
+			`p.yaml:12: device (default): page "main": key 2: flash: only valid on the action of a key`,
+			`p.yaml:13: device (default): page "main": key 2: type: missing action type`,
+		},
+	}, {
+		name: "json",
+		file: "p.json",
+		data: `{
+  "devices": [
+    {
+      "pages": [
+        {
+          "name": "main",
+          "keys": [
+            {
+              "key": -1,
+              "action": {
+                "type": "http",
+                "url": "ftp://example.com",
+                "brightness": 5,
+                "timeout": "soon"
+              }
+            }
+          ]
+        }
+      ]
+    }
+  ]
+}
+`,
+		want: []string{
+			`p.json:9: device (default): page "main": key: invalid key index`,
+			`p.json:13: device (default): page "main": brightness: not valid for http actions`,
+			`p.json:12: device (default): page "main": url: must be an absolute http or https URL`,
+			`p.json:14: device (default): page "main": timeout: invalid duration "soon"`,
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse([]byte(test.data), test.file)
+			errs, ok := err.(ErrorList)
+			if !ok {
+				t.Fatalf("got %v, want a list of errors", err)
+			}
+			got := make([]string, len(errs))
+			for i, e := range errs {
+				got[i] = e.Error()
+			}
+			if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+				t.Errorf("errors:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestParseError(t *testing.T) {
+	for _, test := range []struct {
+		file, data, want string
+	}{
+		{"p.yaml", "devices:\n  - pagez: []\n", "line 2: field pagez not found"},
+		{"p.yaml", "devices: [\n", "did not find expected node content"},
+		{"p.json", `{"devices": [{"pages": 3}]}`, "cannot unmarshal !!int `3`"},
+	} {
+		_, err := Parse([]byte(test.data), test.file)
+		e, ok := err.(*Error)
+		if !ok {
+			t.Errorf("%s: got %v, want an *Error", test.data, err)
+			continue
+		}
+		if e.File != test.file || !strings.HasPrefix(e.Error(), test.file+": ") || !strings.Contains(e.Msg, test.want) {
+			t.Errorf("%s: got %q, want %q", test.data, e, test.want)
+		}
+	}
+}
+
+func TestLineOf(t *testing.T) {
+	p, err := Parse([]byte(`devices:
+  - serial: A
+    pages:
+      - name: main
+        keys:
+          - key: 0
+            color: red
+`), "p.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		path []interface{}
+		want int
+	}{
+		{nil, 1},
+		{[]interface{}{"devices"}, 2},
+		{[]interface{}{"devices", 0, "serial"}, 2},
+		{[]interface{}{"devices", 0, "pages", 0, "keys", 0, "color"}, 7},
+		// deepest node found
+		{[]interface{}{"devices", 0, "pages", 3}, 4},
+		{[]interface{}{"devices", 0, "pages", 0, "keys", 0, "nope"}, 6},
+		{[]interface{}{"icons", "font"}, 1},
+	} {
+		if got := lineOf(p.node, test.path); got != test.want {
+			t.Errorf("lineOf(%v) = %d, want %d", test.path, got, test.want)
+		}
+	}
+	if got := lineOf(nil, []interface{}{"devices"}); got != 0 {
+		t.Errorf("lineOf without a document = %d", got)
+	}
+}
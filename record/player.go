@@ -0,0 +1,232 @@
+package record
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+)
+
+// ErrClosed is returned once the Player is closed.
+var ErrClosed = errors.New("record: player closed")
+
+// Player is a transport replaying a recording. Reports sent by the library
+// must match the recorded ones, in order; recorded input reports are
+// delivered as soon as the library sent all reports recorded before them,
+// regardless of the recorded times, so a replay is deterministic as long
+// as the library is driven the same way.
+type Player struct {
+	entries []*Entry
+
+	mu       sync.Mutex
+	out      int // next entry to match against what the library sends
+	in       int // next input report to deliver
+	mismatch error
+	progress chan struct{} // closed and replaced whenever out or in moves
+	done     chan struct{}
+	closed   chan struct{}
+}
+
+var _ sd.Transport = (*Player)(nil)
+
+// NewPlayer creates a Player of the given entries.
+func NewPlayer(entries []*Entry) *Player {
+	p := &Player{
+		entries:  entries,
+		progress: make(chan struct{}),
+		done:     make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+	p.mu.Lock()
+	p.advance()
+	p.mu.Unlock()
+	return p
+}
+
+// Open creates a Player of the recording at path.
+func Open(path string) (*Player, error) {
+	_, entries, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewPlayer(entries), nil
+}
+
+// advance moves the cursors past the entries they don't handle, and
+// signals the progress. The caller holds p.mu.
+func (p *Player) advance() {
+	for p.out < len(p.entries) && p.entries[p.out].Op == OpRead {
+		p.out++
+	}
+	for p.in < len(p.entries) && p.entries[p.in].Op != OpRead {
+		p.in++
+	}
+	close(p.progress)
+	p.progress = make(chan struct{})
+	if p.out == len(p.entries) && p.in == len(p.entries) {
+		select {
+		case <-p.done:
+		default:
+			close(p.done)
+		}
+	}
+}
+
+// expect matches an operation of the library against the next recorded
+// one, and returns the recorded entry.
+func (p *Player) expect(op string, report int, data []byte) (*Entry, error) {
+	select {
+	case <-p.closed:
+		return nil, ErrClosed
+	default:
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := p.out
+	var err error
+	if n >= len(p.entries) {
+		err = fmt.Errorf("record: unexpected %s after the end of the recording", describe(op, report))
+	} else if e := p.entries[n]; e.Op != op || e.Report != report {
+		err = fmt.Errorf("record: entry %d: expected %s, got %s", n, describe(e.Op, e.Report), describe(op, report))
+	} else if op != OpGetFeature && !bytes.Equal(e.Data, data) {
+		err = fmt.Errorf("record: entry %d: %s differs: %s", n, describe(op, report), diff(e.Data, data))
+	}
+	if err != nil {
+		if p.mismatch == nil {
+			p.mismatch = err
+		}
+		return nil, err
+	}
+
+	p.out++
+	p.advance()
+	return p.entries[n], nil
+}
+
+// describe names an operation for error messages.
+func describe(op string, report int) string {
+	if op == OpSetFeature || op == OpGetFeature {
+		return fmt.Sprintf("%s %d", op, report)
+	}
+	return op
+}
+
+// diff describes where two reports differ.
+func diff(want, got []byte) string {
+	for i := 0; i < len(want) && i < len(got); i++ {
+		if want[i] != got[i] {
+			return fmt.Sprintf("byte %d is %#02x, expected %#02x", i, got[i], want[i])
+		}
+	}
+	return fmt.Sprintf("%d bytes, expected %d", len(got), len(want))
+}
+
+// recorded returns the error recorded for an entry.
+func recorded(e *Entry) error {
+	if e.Err != "" {
+		return errors.New(e.Err)
+	}
+	return nil
+}
+
+// Write checks an output report against the recording.
+func (p *Player) Write(data []byte, timeout time.Duration) (int, error) {
+	e, err := p.expect(OpWrite, 0, data)
+	if err != nil {
+		return 0, err
+	}
+	if err := recorded(e); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// SetFeatureReport checks a feature report against the recording.
+func (p *Player) SetFeatureReport(report int, data []byte) error {
+	e, err := p.expect(OpSetFeature, report, data)
+	if err != nil {
+		return err
+	}
+	return recorded(e)
+}
+
+// GetFeatureReport returns the recorded feature report.
+func (p *Player) GetFeatureReport(report int) ([]byte, error) {
+	e, err := p.expect(OpGetFeature, report, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := recorded(e); err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), e.Data...), nil
+}
+
+// ReadInputPacket returns the next recorded input report, once the library
+// sent all reports recorded before it.
+func (p *Player) ReadInputPacket(timeout time.Duration) ([]byte, error) {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	for {
+		p.mu.Lock()
+		if p.in < len(p.entries) && p.out > p.in {
+			e := p.entries[p.in]
+			p.in++
+			p.advance()
+			p.mu.Unlock()
+			return append([]byte(nil), e.Data...), nil
+		}
+		progress := p.progress
+		p.mu.Unlock()
+
+		select {
+		case <-progress:
+		case <-p.closed:
+			return nil, ErrClosed
+		case <-t.C:
+			return nil, fmt.Errorf("record: timeout")
+		}
+	}
+}
+
+// Done returns a channel closed once the whole recording was replayed.
+func (p *Player) Done() <-chan struct{} {
+	return p.done
+}
+
+// Verify returns the first mismatch between the recording and what the
+// library sent, or an error if the recording wasn't replayed entirely.
+func (p *Player) Verify() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.mismatch != nil {
+		return p.mismatch
+	}
+	if p.out < len(p.entries) {
+		e := p.entries[p.out]
+		return fmt.Errorf("record: entry %d: expected %s, which was never sent", p.out, describe(e.Op, e.Report))
+	}
+	if p.in < len(p.entries) {
+		return fmt.Errorf("record: entry %d: input report never read", p.in)
+	}
+	return nil
+}
+
+// Close stops the replay. Pending and later reads fail.
+func (p *Player) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.closed:
+	default:
+		close(p.closed)
+	}
+	return nil
+}
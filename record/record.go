@@ -0,0 +1,216 @@
+// Package record captures the traffic between the library and a Stream
+// Deck, and plays it back. A Recorder wraps a transport and logs every
+// output report, feature report and input report with its time to a file;
+// a Player replays such a file as a transport: it delivers the recorded
+// input reports and checks that the library sends the recorded reports
+// again, which turns a session with real hardware into a regression test.
+//
+// Recordings are JSON lines: a header, then one Entry per line.
+package record
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+)
+
+// Version is the version of the recording format.
+const Version = 1
+
+// Operations of the entries.
+const (
+	OpWrite      = "write"       // output report sent
+	OpRead       = "read"        // input report received
+	OpSetFeature = "set_feature" // feature report sent
+	OpGetFeature = "get_feature" // feature report received
+)
+
+// Header is the first line of a recording.
+type Header struct {
+	Version int       `json:"version"`
+	Start   time.Time `json:"start"`
+	// Product is the USB product ID of the device, if known.
+	Product uint16 `json:"product,omitempty"`
+}
+
+// Entry is an operation on the transport.
+type Entry struct {
+	Time   time.Duration `json:"t"` // since the start of the recording
+	Op     string        `json:"op"`
+	Report int           `json:"report,omitempty"` // feature report number
+	Data   []byte        `json:"data,omitempty"`
+	Err    string        `json:"err,omitempty"` // error returned by the device
+}
+
+// Recorder is a transport logging the traffic of another one.
+type Recorder struct {
+	t       sd.Transport
+	closer  io.Closer
+	product uint16
+
+	mu    sync.Mutex
+	w     *bufio.Writer
+	enc   *json.Encoder
+	start time.Time
+	err   error
+}
+
+var _ sd.Transport = (*Recorder)(nil)
+
+// Model records the model of the device in the header.
+func Model(m *sd.StreamdeckDevice) func(*Recorder) {
+	return func(r *Recorder) {
+		r.product = m.ProductID
+	}
+}
+
+// NewRecorder records the traffic of t to w.
+func NewRecorder(t sd.Transport, w io.Writer, options ...func(*Recorder)) (*Recorder, error) {
+	r := &Recorder{
+		t:     t,
+		w:     bufio.NewWriter(w),
+		start: time.Now(),
+	}
+	for _, option := range options {
+		option(r)
+	}
+	r.enc = json.NewEncoder(r.w)
+	if err := r.enc.Encode(&Header{Version: Version, Start: r.start, Product: r.product}); err != nil {
+		return nil, err
+	}
+	return r, r.w.Flush()
+}
+
+// Create records the traffic of t to a new file at path, closed along
+// with the Recorder.
+func Create(t sd.Transport, path string, options ...func(*Recorder)) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewRecorder(t, f, options...)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r.closer = f
+	return r, nil
+}
+
+// log writes an entry. Entries are flushed right away, so a recording is
+// complete up to a crash.
+func (r *Recorder) log(op string, report int, data []byte, err error) {
+	e := &Entry{
+		Time:   time.Since(r.start),
+		Op:     op,
+		Report: report,
+		Data:   data,
+	}
+	if err != nil {
+		e.Err = err.Error()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	if r.err = r.enc.Encode(e); r.err == nil {
+		r.err = r.w.Flush()
+	}
+}
+
+// Err returns the error which stopped the recording, if any.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Write sends and records an output report.
+func (r *Recorder) Write(data []byte, timeout time.Duration) (int, error) {
+	n, err := r.t.Write(data, timeout)
+	r.log(OpWrite, 0, data, err)
+	return n, err
+}
+
+// ReadInputPacket receives and records an input report. Failed reads,
+// such as timeouts, aren't recorded.
+func (r *Recorder) ReadInputPacket(timeout time.Duration) ([]byte, error) {
+	data, err := r.t.ReadInputPacket(timeout)
+	if err == nil {
+		r.log(OpRead, 0, data, nil)
+	}
+	return data, err
+}
+
+// SetFeatureReport sends and records a feature report.
+func (r *Recorder) SetFeatureReport(report int, data []byte) error {
+	err := r.t.SetFeatureReport(report, data)
+	r.log(OpSetFeature, report, data, err)
+	return err
+}
+
+// GetFeatureReport receives and records a feature report.
+func (r *Recorder) GetFeatureReport(report int) ([]byte, error) {
+	data, err := r.t.GetFeatureReport(report)
+	r.log(OpGetFeature, report, data, err)
+	return data, err
+}
+
+// Close closes the transport, and the file opened by Create.
+func (r *Recorder) Close() error {
+	err := r.t.Close()
+	if r.closer != nil {
+		if cerr := r.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Read reads a recording.
+func Read(rd io.Reader) (*Header, []*Entry, error) {
+	dec := json.NewDecoder(rd)
+	var h Header
+	if err := dec.Decode(&h); err != nil {
+		return nil, nil, fmt.Errorf("record: invalid header: %w", err)
+	}
+	if h.Version != Version {
+		return nil, nil, fmt.Errorf("record: unsupported version %d", h.Version)
+	}
+
+	var entries []*Entry
+	for {
+		e := &Entry{}
+		err := dec.Decode(e)
+		if err == io.EOF {
+			return &h, entries, nil
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("record: entry %d: %w", len(entries), err)
+		}
+		switch e.Op {
+		case OpWrite, OpRead, OpSetFeature, OpGetFeature:
+		default:
+			return nil, nil, fmt.Errorf("record: entry %d: unknown operation %q", len(entries), e.Op)
+		}
+		entries = append(entries, e)
+	}
+}
+
+// Load reads the recording at path.
+func Load(path string) (*Header, []*Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
@@ -0,0 +1,187 @@
+package record
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/mock"
+)
+
+var update = flag.Bool("update", false, "record testdata/mini.jsonl again from a mock deck")
+
+const fixture = "testdata/mini.jsonl"
+
+// session drives a deck like the recorded session: it is opened, dimmed,
+// key 0 turns red and key 3 is pressed. press is called to press key 3 when
+// recording, and is nil when replaying.
+func session(t *testing.T, tr sd.Transport, model *sd.StreamdeckDevice, press func()) []string {
+	t.Helper()
+	dev, err := sd.Open(tr, model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan string, 4)
+	dev.SetBtnEventCb(func(btnIndex int, state sd.BtnState) {
+		events <- fmt.Sprintf("%d %s", btnIndex, state)
+	})
+
+	serial, err := dev.GetSerialNumber()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.SetBrightness(50); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.FillColor(0, 255, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if press != nil {
+		press()
+	}
+
+	res := []string{serial}
+	for len(res) < 3 {
+		select {
+		case ev := <-events:
+			res = append(res, ev)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("events %v", res)
+		}
+	}
+	dev.Close()
+	return res
+}
+
+func TestRecordFixture(t *testing.T) {
+	if !*update {
+		t.Skip("run with -update to record " + fixture)
+	}
+	m := mock.New(sd.LookupDevice(0x0063), "MINI0001")
+	r, err := Create(m, fixture, Model(m.Model()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	session(t, r, m.Model(), func() { m.Click(3) })
+	if err := r.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	h, entries, err := Load(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	model := sd.LookupDevice(h.Product)
+	if model == nil {
+		t.Fatalf("unknown product %#04x", h.Product)
+	}
+
+	p := NewPlayer(entries)
+	got := session(t, p, model, nil)
+	want := []string{"MINI0001", "3 BtnPressed", "3 BtnReleased"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	select {
+	case <-p.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the recording wasn't replayed entirely")
+	}
+	if err := p.Verify(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReplayMismatch(t *testing.T) {
+	p, err := Open(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev, err := sd.Open(p, sd.LookupDevice(0x0063))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dev.Close()
+	dev.GetSerialNumber()
+	dev.SetBrightness(50)
+
+	// Key 0 turns blue instead of red.
+	err = dev.FillColor(0, 0, 0, 255)
+	if err == nil || !strings.Contains(err.Error(), "differs") {
+		t.Fatalf("FillColor = %v", err)
+	}
+	if verr := p.Verify(); verr == nil || !strings.Contains(verr.Error(), "write differs") {
+		t.Errorf("Verify = %v", verr)
+	}
+
+	// Reports missing from the replay are reported as well.
+	p = NewPlayer(p.entries)
+	d := sd.Attach(p, sd.LookupDevice(0x0063))
+	if err := p.Verify(); err == nil || !strings.Contains(err.Error(), "never sent") {
+		t.Errorf("Verify = %v", err)
+	}
+	d.Close()
+	if _, err := p.ReadInputPacket(time.Second); err != ErrClosed {
+		t.Errorf("ReadInputPacket after Close = %v", err)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	m := mock.New(sd.LookupDevice(0x0063), "MINI0002")
+	var buf bytes.Buffer
+	r, err := NewRecorder(m, &buf, Model(m.Model()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	session(t, r, m.Model(), func() { m.Click(3) })
+
+	h, entries, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Product != 0x0063 || h.Version != Version {
+		t.Errorf("header %+v", h)
+	}
+	count := make(map[string]int)
+	for _, e := range entries {
+		count[e.Op]++
+	}
+	// 7 images of 20 reports each, a press and a release, the reset, the
+	// brightness twice and the serial number.
+	want := map[string]int{OpWrite: 140, OpRead: 2, OpSetFeature: 3, OpGetFeature: 1}
+	if fmt.Sprint(count) != fmt.Sprint(want) {
+		t.Errorf("operations %v, want %v", count, want)
+	}
+
+	// Failed operations are recorded with their error.
+	m.Close()
+	r.SetFeatureReport(5, []byte{5})
+	var e Entry
+	if err := json.Unmarshal(buf.Bytes(), &e); err != nil || e.Op != OpSetFeature || e.Report != 5 || e.Err == "" {
+		t.Errorf("entry %s", buf.Bytes())
+	}
+}
+
+func TestRead(t *testing.T) {
+	for _, test := range []struct{ name, data, err string }{
+		{"no header", "", "invalid header"},
+		{"version", `{"version": 2}`, "unsupported version"},
+		{"operation", `{"version": 1}` + "\n" + `{"op": "nope"}`, "unknown operation"},
+		{"truncated", `{"version": 1}` + "\n" + `{"op": "write", "da`, "entry 0"},
+	} {
+		if _, _, err := Read(strings.NewReader(test.data)); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: %v", test.name, err)
+		}
+	}
+	if _, _, err := Load(filepath.Join(t.TempDir(), "nope.jsonl")); err == nil {
+		t.Error("missing file loaded")
+	}
+}
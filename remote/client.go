@@ -0,0 +1,397 @@
+package remote
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/internal/reconnect"
+)
+
+var (
+	// ErrNotConnected is returned while the connection to the server is
+	// down.
+	ErrNotConnected = errors.New("remote: not connected")
+	// ErrClosed is returned once the Client is closed.
+	ErrClosed = errors.New("remote: client closed")
+)
+
+// requestTimeout bounds how long a request waits for its result, on top
+// of the timeout of the device.
+const requestTimeout = 5 * time.Second
+
+// Client is a transport to a deck exposed by a Server. When the connection
+// is lost, the Client reconnects; meanwhile, reports fail with
+// ErrNotConnected, and keys held are released.
+type Client struct {
+	addr    string
+	secret  []byte
+	tls     *tls.Config
+	onError func(error)
+	model   *sd.StreamdeckDevice
+
+	mu        sync.Mutex
+	conn      *link
+	onConnect []func()
+	nextID    uint32
+	pending   map[uint32]chan []byte
+	last      []byte // last input report
+	input     chan []byte
+	done      chan struct{}
+}
+
+var _ sd.Transport = (*Client)(nil)
+
+// TLS connects to the server over TLS.
+func TLS(config *tls.Config) func(*Client) {
+	return func(c *Client) {
+		c.tls = config
+	}
+}
+
+// OnError sets a function called when the connection fails.
+func OnError(f func(error)) func(*Client) {
+	return func(c *Client) {
+		c.onError = f
+	}
+}
+
+// Dial connects to the server at addr (host:port, the port defaulting to
+// DefaultPort). The first connection must succeed; later ones are retried
+// until Close.
+func Dial(addr, secret string, options ...func(*Client)) (*Client, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, strconv.Itoa(DefaultPort))
+	}
+	c := &Client{
+		addr:    addr,
+		secret:  []byte(secret),
+		pending: make(map[uint32]chan []byte),
+		input:   make(chan []byte, 64),
+		done:    make(chan struct{}),
+	}
+	for _, option := range options {
+		option(c)
+	}
+
+	conn, product, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+	if c.model = sd.LookupDevice(product); c.model == nil {
+		conn.Close()
+		return nil, fmt.Errorf("remote: unknown product %#04x", product)
+	}
+	c.conn = conn
+	go c.run(conn)
+	return c, nil
+}
+
+// Model returns the model of the remote deck.
+func (c *Client) Model() *sd.StreamdeckDevice {
+	return c.model
+}
+
+// connect opens a connection and completes the handshake.
+func (c *Client) connect() (*link, uint16, error) {
+	var conn net.Conn
+	var err error
+	if c.tls != nil {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", c.addr, c.tls)
+	} else {
+		conn, err = net.DialTimeout("tcp", c.addr, 10*time.Second)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	l, product, err := c.handshake(conn, bufio.NewReader(conn))
+	if err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+	conn.SetDeadline(time.Time{})
+	return l, product, nil
+}
+
+func (c *Client) handshake(conn net.Conn, br *bufio.Reader) (*link, uint16, error) {
+	hello := make([]byte, len(magic)+1+nonceSize)
+	if _, err := io.ReadFull(br, hello); err != nil {
+		return nil, 0, err
+	}
+	if string(hello[:len(magic)]) != magic {
+		return nil, 0, errors.New("remote: not a Stream Deck server")
+	}
+	if v := hello[len(magic)]; v != version {
+		return nil, 0, fmt.Errorf("remote: unsupported version %d", v)
+	}
+	serverNonce := hello[len(magic)+1:]
+
+	clientNonce := make([]byte, nonceSize)
+	if _, err := rand.Read(clientNonce); err != nil {
+		return nil, 0, err
+	}
+	if _, err := conn.Write(append(clientNonce, mac(c.secret, "client", serverNonce, clientNonce)...)); err != nil {
+		return nil, 0, err
+	}
+
+	status, err := br.ReadByte()
+	if err != nil {
+		return nil, 0, err
+	}
+	if status != statusOK {
+		return nil, 0, ErrAuth
+	}
+	reply := make([]byte, macSize+2)
+	if _, err := io.ReadFull(br, reply); err != nil {
+		return nil, 0, err
+	}
+	product := reply[macSize:]
+	if !hmac.Equal(reply[:macSize], mac(c.secret, "server", serverNonce, clientNonce, product...)) {
+		return nil, 0, ErrAuth
+	}
+	return newLink(conn, br, c.secret, "client", serverNonce, clientNonce), binary.BigEndian.Uint16(product), nil
+}
+
+// run serves connections until Close, reconnecting when they are lost.
+func (c *Client) run(conn *link) {
+	serve := func() error {
+		return c.serve(conn)
+	}
+	lost := func() {
+		c.disconnected(conn)
+	}
+	connect := func() error {
+		next, product, err := c.connect()
+		if err != nil {
+			return err
+		}
+		if product != c.model.ProductID {
+			next.Close()
+			return fmt.Errorf("remote: the server now has a %#04x deck", product)
+		}
+		conn = next
+
+		c.mu.Lock()
+		c.conn = conn
+		onConnect := c.onConnect
+		c.mu.Unlock()
+
+		for _, f := range onConnect {
+			go f()
+		}
+		return nil
+	}
+	reconnect.Loop(c.done, serve, lost, connect, c.onError)
+}
+
+// disconnected fails the pending requests, and releases the keys held.
+func (c *Client) disconnected(conn *link) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn = nil
+	conn.Close()
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+
+	header := 1 // 01
+	if c.model.Protocol == sd.ProtocolV2 {
+		header = 4 // 01 00 <number of keys> 00
+	}
+	if len(c.last) < header {
+		return
+	}
+	for _, b := range c.last[header:] {
+		if b != 0 {
+			release := make([]byte, len(c.last))
+			copy(release, c.last[:header])
+			c.last = release
+			select {
+			case c.input <- release:
+			default:
+			}
+			break
+		}
+	}
+}
+
+// serve reads frames until the connection fails, and pings the server.
+func (c *Client) serve(conn *link) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		t := time.NewTicker(pingInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				conn.send(&frame{typ: framePing})
+			}
+		}
+	}()
+
+	for {
+		conn.conn.SetReadDeadline(time.Now().Add(3 * pingInterval))
+		f, err := conn.read()
+		if err != nil {
+			return err
+		}
+
+		switch f.typ {
+		case frameInput:
+			c.mu.Lock()
+			if len(f.payload) > 0 {
+				c.last = f.payload
+			}
+			c.mu.Unlock()
+			select {
+			case c.input <- f.payload:
+			default:
+				// nobody reads, drop the report
+			}
+		case frameResult:
+			c.mu.Lock()
+			if ch, ok := c.pending[f.id]; ok {
+				ch <- f.payload
+				delete(c.pending, f.id)
+			}
+			c.mu.Unlock()
+		case framePong:
+		default:
+			return fmt.Errorf("remote: unknown frame type %d", f.typ)
+		}
+	}
+}
+
+// request sends a request and waits for its result.
+func (c *Client) request(typ byte, payload []byte, timeout time.Duration) ([]byte, error) {
+	select {
+	case <-c.done:
+		return nil, ErrClosed
+	default:
+	}
+
+	c.mu.Lock()
+	conn := c.conn
+	if conn == nil {
+		c.mu.Unlock()
+		return nil, ErrNotConnected
+	}
+	c.nextID++
+	id := c.nextID
+	ch := make(chan []byte, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	if err := conn.send(&frame{typ: typ, id: id, payload: payload}); err != nil {
+		conn.Close()
+		return nil, ErrNotConnected
+	}
+
+	t := time.NewTimer(timeout + requestTimeout)
+	defer t.Stop()
+	select {
+	case res, ok := <-ch:
+		if !ok {
+			return nil, ErrNotConnected
+		}
+		return parseResult(res)
+	case <-t.C:
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		// the connection is stuck
+		conn.Close()
+		return nil, ErrNotConnected
+	case <-c.done:
+		return nil, ErrClosed
+	}
+}
+
+// Write sends an output report to the deck.
+func (c *Client) Write(data []byte, timeout time.Duration) (int, error) {
+	payload := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(payload, uint32(timeout/time.Millisecond))
+	copy(payload[4:], data)
+	if _, err := c.request(frameWrite, payload, timeout); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// ReadInputPacket returns the next input report of the deck.
+func (c *Client) ReadInputPacket(timeout time.Duration) ([]byte, error) {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case data := <-c.input:
+		return data, nil
+	case <-c.done:
+		return nil, ErrClosed
+	case <-t.C:
+		return nil, errors.New("remote: timeout")
+	}
+}
+
+// SetFeatureReport sends a feature report to the deck.
+func (c *Client) SetFeatureReport(report int, data []byte) error {
+	payload := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(payload, uint32(report))
+	copy(payload[4:], data)
+	_, err := c.request(frameSetFeature, payload, 0)
+	return err
+}
+
+// GetFeatureReport reads a feature report of the deck.
+func (c *Client) GetFeatureReport(report int) ([]byte, error) {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(report))
+	return c.request(frameGetFeature, payload, 0)
+}
+
+// OnConnect registers a function called after each reconnection. The deck
+// may have been reset meanwhile, e.g. if the server restarted, so this is
+// the place to draw the keys again.
+func (c *Client) OnConnect(f func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onConnect = append(c.onConnect, f)
+}
+
+// Connected tells whether the connection to the server is up.
+func (c *Client) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn != nil
+}
+
+// Close disconnects from the server; the remote deck stays as it is.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.done:
+		return nil
+	default:
+	}
+	close(c.done)
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package remote
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// The protocol starts with a handshake proving to each side that the
+// other knows the secret, without sending it:
+//
+//	server: magic, version, server nonce (32 bytes)
+//	client: client nonce (32 bytes), HMAC("client", nonces)
+//	server: status; if ok, HMAC("server", nonces, product ID) and the USB
+//	        product ID
+//
+// Then both sides exchange frames: a type, a request ID, the length of the
+// payload, the payload and its MAC. The client sends requests, which the
+// server answers with a result frame of the same ID; the server also sends
+// the input reports of the device.
+//
+// The MAC of a frame is HMAC(key, sequence number, frame), with a key per
+// direction derived from the secret and the nonces, and the sequence
+// number counting the frames sent in that direction since the handshake.
+// Frames can't be forged, and replaying, reordering or dropping them
+// breaks the connection.
+
+const (
+	magic   = "SDRM"
+	version = 2
+
+	nonceSize = 32
+	macSize   = sha256.Size
+
+	statusOK         = 0
+	statusAuthFailed = 1
+
+	maxPayload = 1 << 20
+)
+
+// Frame types.
+const (
+	frameWrite      = 1 // timeout in milliseconds (4 bytes), data
+	frameSetFeature = 2 // report (4 bytes), data
+	frameGetFeature = 3 // report (4 bytes)
+	frameResult     = 4 // status byte, then the data or the error message
+	frameInput      = 5 // input report
+	framePing       = 6
+	framePong       = 7
+)
+
+// ErrAuth is returned when the other side doesn't know the secret.
+var ErrAuth = errors.New("remote: authentication failed")
+
+// mac computes the proof of a side of the handshake, or the key of the
+// frames sent by a side.
+func mac(secret []byte, side string, serverNonce, clientNonce []byte, extra ...byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(side))
+	h.Write(serverNonce)
+	h.Write(clientNonce)
+	h.Write(extra)
+	return h.Sum(nil)
+}
+
+type frame struct {
+	typ     byte
+	id      uint32
+	payload []byte
+}
+
+// link is an authenticated connection, once the handshake is done. Frames
+// are sent from any goroutine, and received by a single one.
+type link struct {
+	conn    net.Conn
+	br      *bufio.Reader
+	recvKey []byte
+	recvSeq uint64
+
+	mu      sync.Mutex // serializes sending
+	sendKey []byte
+	sendSeq uint64
+}
+
+// newLink returns the link of a connection, given the nonces of its
+// handshake. side is "client" or "server".
+func newLink(conn net.Conn, br *bufio.Reader, secret []byte, side string, serverNonce, clientNonce []byte) *link {
+	clientKey := mac(secret, "client frames", serverNonce, clientNonce)
+	serverKey := mac(secret, "server frames", serverNonce, clientNonce)
+	if side == "client" {
+		return &link{conn: conn, br: br, sendKey: clientKey, recvKey: serverKey}
+	}
+	return &link{conn: conn, br: br, sendKey: serverKey, recvKey: clientKey}
+}
+
+// frameMAC computes the MAC of the frame with the given sequence number,
+// buf holding its header and payload.
+func frameMAC(key []byte, seq uint64, buf []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], seq)
+	h.Write(n[:])
+	h.Write(buf)
+	return h.Sum(nil)
+}
+
+// send writes a frame in a single call, so frames are never interleaved.
+func (l *link) send(f *frame) error {
+	buf := make([]byte, 9+len(f.payload), 9+len(f.payload)+macSize)
+	buf[0] = f.typ
+	binary.BigEndian.PutUint32(buf[1:], f.id)
+	binary.BigEndian.PutUint32(buf[5:], uint32(len(f.payload)))
+	copy(buf[9:], f.payload)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	buf = append(buf, frameMAC(l.sendKey, l.sendSeq, buf)...)
+	l.sendSeq++
+	l.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := l.conn.Write(buf)
+	return err
+}
+
+// read reads the next frame, failing with ErrAuth if its MAC is wrong.
+func (l *link) read() (*frame, error) {
+	buf := make([]byte, 9)
+	if _, err := io.ReadFull(l.br, buf); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(buf[5:])
+	if n > maxPayload {
+		return nil, fmt.Errorf("remote: frame of %d bytes is too large", n)
+	}
+	buf = append(buf, make([]byte, int(n)+macSize)...)
+	if _, err := io.ReadFull(l.br, buf[9:]); err != nil {
+		return nil, err
+	}
+	body, sum := buf[:9+n], buf[9+n:]
+	if !hmac.Equal(sum, frameMAC(l.recvKey, l.recvSeq, body)) {
+		return nil, fmt.Errorf("%w: invalid MAC on frame %d", ErrAuth, l.recvSeq)
+	}
+	l.recvSeq++
+	return &frame{typ: buf[0], id: binary.BigEndian.Uint32(buf[1:]), payload: body[9:]}, nil
+}
+
+// Close closes the connection.
+func (l *link) Close() error {
+	return l.conn.Close()
+}
+
+// result returns the payload of a result frame.
+func result(data []byte, err error) []byte {
+	if err != nil {
+		return append([]byte{1}, err.Error()...)
+	}
+	return append([]byte{0}, data...)
+}
+
+// parseResult decodes the payload of a result frame.
+func parseResult(payload []byte) ([]byte, error) {
+	if len(payload) == 0 {
+		return nil, errors.New("remote: empty result")
+	}
+	if payload[0] != 0 {
+		return nil, &DeviceError{Message: string(payload[1:])}
+	}
+	return payload[1:], nil
+}
+
+// DeviceError is an error returned by the device on the server side.
+type DeviceError struct {
+	Message string
+}
+
+func (e *DeviceError) Error() string {
+	return "remote: " + e.Message
+}
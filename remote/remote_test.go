@@ -0,0 +1,265 @@
+package remote
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"image/color"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/mock"
+)
+
+// serve exposes m on addr, e.g. 127.0.0.1:0 for any port.
+func serve(t *testing.T, m *mock.Device, secret, addr string, options ...func(*Server)) (*Server, string) {
+	t.Helper()
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(m, m.Model(), secret, options...)
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return s, l.Addr().String()
+}
+
+// open opens the deck of a client, and sends its key presses and releases
+// to events.
+func open(t *testing.T, c *Client, events chan<- int) *sd.StreamDeck {
+	t.Helper()
+	dev, err := sd.Open(c, c.Model())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dev.Close() })
+	dev.SetBtnEventCb(func(btnIndex int, state sd.BtnState) {
+		if state == sd.BtnReleased {
+			btnIndex = -btnIndex - 1
+		}
+		events <- btnIndex
+	})
+	return dev
+}
+
+// expect waits for an event sent by open: the index of a key pressed, or
+// -1 - the index of a key released.
+func expect(t *testing.T, events <-chan int, want int) {
+	t.Helper()
+	select {
+	case i := <-events:
+		if i != want {
+			t.Errorf("event %d, want %d", i, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for event %d", want)
+	}
+}
+
+func TestRemote(t *testing.T) {
+	m := mock.New(sd.LookupDevice(0x0063), "TEST0001")
+	_, addr := serve(t, m, "s3cret", "127.0.0.1:0")
+
+	if _, err := Dial(addr, "wrong"); err != ErrAuth {
+		t.Errorf("Dial with a wrong secret: %v", err)
+	}
+
+	c, err := Dial(addr, "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan int, 4)
+	dev := open(t, c, events)
+
+	if s, err := dev.GetSerialNumber(); err != nil || s != "TEST0001" {
+		t.Errorf("GetSerialNumber = %q, %v", s, err)
+	}
+	if err := dev.FillColor(2, 255, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := color.RGBAModel.Convert(m.Key(2).At(10, 10)); got != (color.RGBA{255, 0, 0, 255}) {
+		t.Errorf("key 2 is %v", got)
+	}
+
+	m.Click(4)
+	expect(t, events, 4)
+	expect(t, events, -5)
+}
+
+func TestReconnect(t *testing.T) {
+	m := mock.New(sd.LookupDevice(0x0063), "TEST0001")
+	s, addr := serve(t, m, "s3cret", "127.0.0.1:0")
+	c, err := Dial(addr, "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	connected := make(chan bool, 1)
+	c.OnConnect(func() { connected <- true })
+	events := make(chan int, 4)
+	dev := open(t, c, events)
+
+	m.Press(4)
+	expect(t, events, 4)
+
+	// The server goes away with the key held.
+	s.Close()
+	expect(t, events, -5)
+	for deadline := time.Now().Add(5 * time.Second); c.Connected(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("still connected")
+		}
+	}
+	if _, err := dev.GetSerialNumber(); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("GetSerialNumber while disconnected: %v", err)
+	}
+
+	serve(t, m, "s3cret", addr)
+	select {
+	case <-connected:
+	case <-time.After(10 * time.Second):
+		t.Fatal("not reconnected")
+	}
+	if !c.Connected() {
+		t.Error("not connected after OnConnect")
+	}
+	if s, err := dev.GetSerialNumber(); err != nil || s != "TEST0001" {
+		t.Errorf("GetSerialNumber after reconnecting = %q, %v", s, err)
+	}
+}
+
+// selfSigned returns a certificate for 127.0.0.1, and a pool trusting it.
+func selfSigned(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "streamdeck test"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestTLS(t *testing.T) {
+	cert, pool := selfSigned(t)
+	m := mock.New(sd.LookupDevice(0x0063), "TEST0001")
+	_, addr := serve(t, m, "s3cret", "127.0.0.1:0", ServerTLS(&tls.Config{Certificates: []tls.Certificate{cert}}))
+
+	if c, err := Dial(addr, "s3cret", TLS(&tls.Config{})); err == nil {
+		c.Close()
+		t.Error("Dial trusted an unknown certificate")
+	}
+
+	c, err := Dial(addr, "s3cret", TLS(&tls.Config{RootCAs: pool}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan int, 4)
+	dev := open(t, c, events)
+	if s, err := dev.GetSerialNumber(); err != nil || s != "TEST0001" {
+		t.Errorf("GetSerialNumber = %q, %v", s, err)
+	}
+	if err := dev.FillColor(2, 0, 0, 255); err != nil {
+		t.Fatal(err)
+	}
+	if got := color.RGBAModel.Convert(m.Key(2).At(10, 10)); got != (color.RGBA{0, 0, 255, 255}) {
+		t.Errorf("key 2 is %v", got)
+	}
+	m.Click(1)
+	expect(t, events, 1)
+	expect(t, events, -2)
+}
+
+// wire is a connection recording what is written to it.
+type wire struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (w *wire) Write(p []byte) (int, error)        { return w.buf.Write(p) }
+func (w *wire) SetWriteDeadline(t time.Time) error { return nil }
+
+func TestLinkAuth(t *testing.T) {
+	secret := []byte("s3cret")
+	serverNonce, clientNonce := bytes.Repeat([]byte{1}, nonceSize), bytes.Repeat([]byte{2}, nonceSize)
+
+	// frames returns the frames sent by a new client link, as on the wire.
+	frames := func(payloads ...string) [][]byte {
+		w := &wire{}
+		l := newLink(w, nil, secret, "client", serverNonce, clientNonce)
+		var res [][]byte
+		for i, p := range payloads {
+			start := w.buf.Len()
+			if err := l.send(&frame{typ: frameWrite, id: uint32(i), payload: []byte(p)}); err != nil {
+				t.Fatal(err)
+			}
+			res = append(res, append([]byte(nil), w.buf.Bytes()[start:]...))
+		}
+		return res
+	}
+	// receive reads frames with a new link of the given side, and returns
+	// the payloads read until the first error.
+	receive := func(side string, nonce []byte, data ...[]byte) ([]string, error) {
+		l := newLink(nil, bufio.NewReader(bytes.NewReader(bytes.Join(data, nil))), secret, side, serverNonce, nonce)
+		var res []string
+		for range data {
+			f, err := l.read()
+			if err != nil {
+				return res, err
+			}
+			res = append(res, string(f.payload))
+		}
+		return res, nil
+	}
+
+	f := frames("a", "b")
+	if got, err := receive("server", clientNonce, f[0], f[1]); err != nil || len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Fatalf("received %q, %v", got, err)
+	}
+
+	tampered := append([]byte(nil), f[1]...)
+	tampered[9] = 'c'
+	for name, c := range map[string]struct {
+		side  string
+		nonce []byte
+		data  [][]byte
+		want  int
+	}{
+		"tampered":      {"server", clientNonce, [][]byte{f[0], tampered}, 1},
+		"replayed":      {"server", clientNonce, [][]byte{f[0], f[0]}, 1},
+		"reordered":     {"server", clientNonce, [][]byte{f[1], f[0]}, 0},
+		"dropped":       {"server", clientNonce, [][]byte{f[1]}, 0},
+		"reflected":     {"client", clientNonce, [][]byte{f[0]}, 0},
+		"other session": {"server", serverNonce, [][]byte{f[0]}, 0},
+	} {
+		got, err := receive(c.side, c.nonce, c.data...)
+		if !errors.Is(err, ErrAuth) || len(got) != c.want {
+			t.Errorf("%s: received %q, %v", name, got, err)
+		}
+	}
+}
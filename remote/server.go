@@ -0,0 +1,312 @@
+// Package remote makes a Stream Deck attached to another machine usable as
+// if it was connected locally. A Server runs next to the deck, e.g. on a
+// Raspberry Pi, and exposes its transport over TCP; on the controlling
+// machine, a Client is a transport forwarding the reports to the Server,
+// which can be given to sd.Open like a USB device.
+//
+// Both sides share a secret, which they prove they know on each connection
+// without sending it. Every frame is then authenticated with keys derived
+// from the handshake, so nobody on the network can inject or replay
+// reports; the traffic itself is only encrypted with TLS.
+package remote
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+)
+
+// DefaultPort is the TCP port used by the command line tool.
+const DefaultPort = 9191
+
+// ErrServerClosed is returned by Serve once the Server is closed.
+var ErrServerClosed = errors.New("remote: server closed")
+
+// pingInterval is how often the client pings the server; a connection
+// silent for three intervals is considered lost.
+const pingInterval = 5 * time.Second
+
+// Server exposes a deck to one client at a time: when a new client
+// connects, the previous one is disconnected.
+type Server struct {
+	t       sd.Transport
+	model   *sd.StreamdeckDevice
+	secret  []byte
+	tls     *tls.Config
+	onError func(error)
+
+	mu        sync.Mutex
+	current   *link
+	listeners map[net.Listener]struct{}
+	reading   bool
+	done      chan struct{}
+}
+
+// ServerTLS serves over TLS.
+func ServerTLS(config *tls.Config) func(*Server) {
+	return func(s *Server) {
+		s.tls = config
+	}
+}
+
+// OnServerError sets a function called when a connection fails, e.g. with
+// ErrAuth.
+func OnServerError(f func(error)) func(*Server) {
+	return func(s *Server) {
+		s.onError = f
+	}
+}
+
+// NewServer creates a Server exposing t, a deck of the given model, to the
+// clients knowing secret. The transport is left open when the Server is
+// closed.
+func NewServer(t sd.Transport, model *sd.StreamdeckDevice, secret string, options ...func(*Server)) *Server {
+	s := &Server{
+		t:         t,
+		model:     model,
+		secret:    []byte(secret),
+		listeners: make(map[net.Listener]struct{}),
+		done:      make(chan struct{}),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+// ListenAndServe listens on the TCP address addr and serves clients.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts clients on l until Close.
+func (s *Server) Serve(l net.Listener) error {
+	if len(s.secret) == 0 {
+		l.Close()
+		return errors.New("remote: a secret is required")
+	}
+	if s.tls != nil {
+		l = tls.NewListener(l, s.tls)
+	}
+
+	s.mu.Lock()
+	select {
+	case <-s.done:
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	default:
+	}
+	s.listeners[l] = struct{}{}
+	if !s.reading {
+		s.reading = true
+		go s.read()
+	}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				return ErrServerClosed
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+// read forwards the input reports of the deck to the current client.
+func (s *Server) read() {
+	for {
+		start := time.Now()
+		data, err := s.t.ReadInputPacket(time.Second)
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+		if err != nil {
+			if time.Since(start) < 10*time.Millisecond {
+				// the device fails right away, don't spin
+				time.Sleep(100 * time.Millisecond)
+			}
+			continue
+		}
+
+		s.mu.Lock()
+		cur := s.current
+		s.mu.Unlock()
+		if cur != nil {
+			if err := cur.send(&frame{typ: frameInput, payload: data}); err != nil {
+				cur.Close()
+			}
+		}
+	}
+}
+
+func (s *Server) error(err error) {
+	if s.onError != nil {
+		s.onError(err)
+	}
+}
+
+// handle serves a client until it disconnects.
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	sess, err := s.handshake(conn, br)
+	if err != nil {
+		s.error(fmt.Errorf("%s: %w", conn.RemoteAddr(), err))
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	s.mu.Lock()
+	select {
+	case <-s.done:
+		s.mu.Unlock()
+		return
+	default:
+	}
+	if s.current != nil {
+		s.current.Close()
+	}
+	s.current = sess
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		if s.current == sess {
+			s.current = nil
+		}
+		s.mu.Unlock()
+	}()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(3 * pingInterval))
+		f, err := sess.read()
+		if err != nil {
+			select {
+			case <-s.done:
+			default:
+				if err != io.EOF {
+					s.error(fmt.Errorf("%s: %w", conn.RemoteAddr(), err))
+				}
+			}
+			return
+		}
+
+		res := &frame{typ: frameResult, id: f.id}
+		switch f.typ {
+		case frameWrite:
+			if len(f.payload) < 4 {
+				return
+			}
+			timeout := time.Duration(binary.BigEndian.Uint32(f.payload)) * time.Millisecond
+			_, err := s.t.Write(f.payload[4:], timeout)
+			res.payload = result(nil, err)
+		case frameSetFeature:
+			if len(f.payload) < 4 {
+				return
+			}
+			err := s.t.SetFeatureReport(int(binary.BigEndian.Uint32(f.payload)), f.payload[4:])
+			res.payload = result(nil, err)
+		case frameGetFeature:
+			if len(f.payload) < 4 {
+				return
+			}
+			res.payload = result(s.t.GetFeatureReport(int(binary.BigEndian.Uint32(f.payload))))
+		case framePing:
+			res.typ = framePong
+		default:
+			s.error(fmt.Errorf("%s: unknown frame type %d", conn.RemoteAddr(), f.typ))
+			return
+		}
+		if err := sess.send(res); err != nil {
+			return
+		}
+	}
+}
+
+// handshake authenticates the client, and proves the server knows the
+// secret too.
+func (s *Server) handshake(conn net.Conn, br *bufio.Reader) (*link, error) {
+	hello := make([]byte, len(magic)+1+nonceSize)
+	copy(hello, magic)
+	hello[len(magic)] = version
+	serverNonce := hello[len(magic)+1:]
+	if _, err := rand.Read(serverNonce); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(hello); err != nil {
+		return nil, err
+	}
+
+	reply := make([]byte, nonceSize+macSize)
+	if _, err := io.ReadFull(br, reply); err != nil {
+		return nil, err
+	}
+	clientNonce := reply[:nonceSize]
+	if !hmac.Equal(reply[nonceSize:], mac(s.secret, "client", serverNonce, clientNonce)) {
+		conn.Write([]byte{statusAuthFailed})
+		return nil, ErrAuth
+	}
+
+	var product [2]byte
+	binary.BigEndian.PutUint16(product[:], s.model.ProductID)
+	ok := append([]byte{statusOK}, mac(s.secret, "server", serverNonce, clientNonce, product[:]...)...)
+	if _, err := conn.Write(append(ok, product[:]...)); err != nil {
+		return nil, err
+	}
+	return newLink(conn, br, s.secret, "server", serverNonce, clientNonce), nil
+}
+
+// Close stops serving and disconnects the client.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.done:
+		return nil
+	default:
+	}
+	close(s.done)
+	for l := range s.listeners {
+		l.Close()
+	}
+	if s.current != nil {
+		s.current.Close()
+		s.current = nil
+	}
+	return nil
+}
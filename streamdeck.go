@@ -16,13 +16,13 @@ import (
 	"github.com/golang/freetype"
 	"github.com/golang/freetype/truetype"
 
-	"github.com/KarpelesLab/hid"
+	"github.com/KarpelesLab/streamdeck/svg"
 
 	"image/color"
 	"image/draw"
-	_ "image/gif"  // support gif
-	_ "image/jpeg" // support jpeg
-	_ "image/png"  // support png
+	_ "image/gif" // support gif
+	"image/jpeg"
+	_ "image/png" // support png
 )
 
 // VendorID is the USB VendorID assigned to Elgato (0x0fd9)
@@ -49,10 +49,13 @@ type ReadErrorCb func(err error)
 // StreamDeck is the object representing the Elgato Stream Deck.
 type StreamDeck struct {
 	sync.Mutex
-	device     hid.Handle
+	device     Transport
 	btnEventCb BtnEvent
 	btnState   []BtnState
 	Info       *StreamdeckDevice
+	closed     chan struct{}
+	// shadow framebuffer: the image displayed on each key, nil if black
+	shadow []*image.RGBA
 }
 
 // TextButton holds the lines to be written to a button and the desired
@@ -93,64 +96,49 @@ func NewStreamDeck(serial ...string) (*StreamDeck, error) {
 		return nil, fmt.Errorf("only <= 1 serial numbers must be provided")
 	}
 
-	var devices []hid.Device
-	hid.UsbWalk(func(device hid.Device) {
-		info := device.Info()
-		if info.Vendor != VendorID {
-			return
-		}
-
-		found := false
-		for _, sd := range streamdeckDevices {
-			if sd.ProductID == info.Product {
-				// found device
-				devices = append(devices, device)
-				found = true
-				break
-			}
-		}
-		if !found {
-			log.Printf("WARNING: unsupported Elgato device %04x:%04x:%04x:%02x", info.Vendor, info.Product, info.Revision, info.Interface)
-		}
-	})
-
+	devices := ListUSB()
 	if len(devices) == 0 {
 		return nil, fmt.Errorf("no stream deck device found")
 	}
 
-	id := 0
-	/*
-		if len(serial) == 1 {
-			found := false
-			for i, d := range devices {
-				info := d.Info()
-				if info.Serial == serial[0] {
-					id = i
-					found = true
-				}
-			}
-			if !found {
-				return nil, fmt.Errorf("no stream deck device found with serial number %s", serial[0])
-			}
-		}*/
+	if len(serial) == 0 {
+		return devices[0].Open()
+	}
+
+	// Only the matching deck is opened, as opening resets it.
+	for _, d := range devices {
+		if s, _, err := d.Identify(); err == nil && s == serial[0] {
+			return d.Open()
+		}
+	}
+	return nil, fmt.Errorf("no stream deck device found with serial number %s", serial[0])
+}
 
-	handle, err := devices[id].Open()
+// Open initializes a StreamDeck of the given model connected through t:
+// the device is reset, set to full brightness and all keys are cleared.
+func Open(t Transport, model *StreamdeckDevice) (*StreamDeck, error) {
+	sd := Attach(t, model)
+
+	err := sd.Reset()
 	if err != nil {
+		sd.Close()
 		return nil, err
 	}
+	sd.SetBrightness(100)
+	sd.ClearAllBtns()
 
-	info := devices[id].Info()
-	var sdinfo *StreamdeckDevice
-	for _, sdinfo = range streamdeckDevices {
-		if sdinfo.ProductID == info.Product {
-			break
-		}
-	}
+	return sd, nil
+}
 
+// Attach returns a StreamDeck of the given model connected through t,
+// leaving the device as is: unlike Open, what is displayed is kept.
+func Attach(t Transport, model *StreamdeckDevice) *StreamDeck {
 	sd := &StreamDeck{
-		device:   handle,
-		btnState: make([]BtnState, sdinfo.NumButtons),
-		Info:     sdinfo,
+		device:   t,
+		btnState: make([]BtnState, model.NumButtons),
+		Info:     model,
+		closed:   make(chan struct{}),
+		shadow:   make([]*image.RGBA, model.NumButtons),
 	}
 
 	// initialize buttons to state BtnReleased
@@ -158,64 +146,116 @@ func NewStreamDeck(serial ...string) (*StreamDeck, error) {
 		sd.btnState[i] = BtnReleased
 	}
 
-	err = sd.Reset()
-	if err != nil {
-		return nil, err
-	}
-	sd.SetBrightness(100)
-	sd.ClearAllBtns()
-
 	go sd.read()
 
-	return sd, nil
+	return sd
 }
 
 // SetBtnEventCb sets the BtnEvent callback which get's executed whenever
 // a Button event (pressed/released) occures.
+//
+// Callbacks are called one at a time, in the order of the events, from a
+// goroutine of the StreamDeck: a callback taking long delays the following
+// events, so lengthy work should be started in a goroutine of its own.
 func (sd *StreamDeck) SetBtnEventCb(ev BtnEvent) {
 	sd.Lock()
 	defer sd.Unlock()
 	sd.btnEventCb = ev
 }
 
+// ButtonSize returns the size in pixels of the (square) keys.
+func (sd *StreamDeck) ButtonSize() int {
+	return sd.Info.ButtonSize
+}
+
+// NumButtons returns the number of keys of the device.
+func (sd *StreamDeck) NumButtons() int {
+	return sd.Info.NumButtons
+}
+
 // Read will listen in a for loop for incoming messages from the Stream Deck.
 // It is typically executed in a dedicated go routine.
 func (sd *StreamDeck) read() {
+	events := make(chan btnEvent, 64)
+	defer close(events)
+	go sd.dispatch(events)
+
 	for {
 		data, err := sd.device.ReadInputPacket(time.Second)
+		select {
+		case <-sd.closed:
+			return
+		default:
+		}
 		if err != nil {
 			continue
 		}
 
-		if data[0] != 1 {
+		if len(data) == 0 || data[0] != 1 {
 			continue
 		}
 
-		data = data[1:] // strip off the first byte; usage unknown, but it is always '\x01'
+		if sd.Info.Protocol == ProtocolV2 {
+			if len(data) < 4 {
+				continue
+			}
+			data = data[4:] // 01 00 <number of keys> 00
+		} else {
+			data = data[1:] // strip off the first byte; usage unknown, but it is always '\x01'
+		}
 
+		var changed []btnEvent
 		sd.Lock()
-		// we have to iterate over all 15 buttons and check if the state
-		// has changed. If it has changed, execute the callback.
-		for i, b := range data {
-			if i >= len(sd.btnState) {
+		// we have to iterate over all buttons and check if the state
+		// has changed.
+		for j, b := range data {
+			if j >= len(sd.btnState) {
 				break
 			}
+			i := sd.Info.DeviceKey(j)
 			if sd.btnState[i] != itob(int(b)) {
 				sd.btnState[i] = itob(int(b))
-				if sd.btnEventCb != nil {
-					btnState := sd.btnState[i]
-					go sd.btnEventCb(i, btnState)
-				}
+				changed = append(changed, btnEvent{i, sd.btnState[i]})
 			}
 		}
 		sd.Unlock()
+
+		for _, ev := range changed {
+			events <- ev
+		}
+	}
+}
+
+type btnEvent struct {
+	btnIndex int
+	state    BtnState
+}
+
+// dispatch executes the callback for each event, in order, without
+// blocking the reading of the device.
+func (sd *StreamDeck) dispatch(events chan btnEvent) {
+	for ev := range events {
+		sd.Lock()
+		cb := sd.btnEventCb
+		sd.Unlock()
+
+		if cb != nil {
+			cb(ev.btnIndex, ev.state)
+		}
 	}
 }
 
 // Close the connection to the Elgato Stream Deck
 func (sd *StreamDeck) Close() error {
 	sd.Lock()
-	sd.Unlock()
+	defer sd.Unlock()
+
+	select {
+	case <-sd.closed:
+		return nil
+	default:
+	}
+	close(sd.closed)
 	return sd.device.Close()
 }
 
@@ -223,7 +263,7 @@ func (sd *StreamDeck) Close() error {
 func (sd *StreamDeck) ClearBtn(btnIndex int) error {
 	//log.Printf("about to clear button %d", btnIndex)
 
-	if err := checkValidKeyIndex(btnIndex); err != nil {
+	if err := sd.checkValidKeyIndex(btnIndex); err != nil {
 		return err
 	}
 	return sd.FillColor(btnIndex, 0, 0, 0)
@@ -250,7 +290,7 @@ func (sd *StreamDeck) FillColor(btnIndex, r, g, b int) error {
 	}
 
 	img := image.NewRGBA(image.Rect(0, 0, sd.Info.ButtonSize, sd.Info.ButtonSize))
-	color := color.RGBA{uint8(r), uint8(g), uint8(b), 0}
+	color := color.RGBA{uint8(r), uint8(g), uint8(b), 0xff}
 	draw.Draw(img, img.Bounds(), image.NewUniform(color), image.Point{0, 0}, draw.Src)
 
 	return sd.FillImage(btnIndex, img)
@@ -327,37 +367,102 @@ func makeBitmap(img image.Image, rotate int) []byte {
 	return out.Bytes()
 }
 
+// makeJPEG encodes the image of a key for ProtocolV2 devices, which
+// display it upside down.
+func makeJPEG(img *image.RGBA) ([]byte, error) {
+	b := img.Bounds()
+	flipped := image.NewRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			flipped.SetRGBA(b.Max.X-1-x+b.Min.X, b.Max.Y-1-y+b.Min.Y, img.RGBAAt(x, y))
+		}
+	}
+
+	out := &bytes.Buffer{}
+	if err := jpeg.Encode(out, flipped, &jpeg.Options{Quality: 95}); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
 // FillImage fills the given key with an image. For best performance, provide
 // the image in the size of ?x? pixels. Otherwise it will be automatically
 // resized.
 func (sd *StreamDeck) FillImage(btnIndex int, img image.Image) error {
-	if err := checkValidKeyIndex(btnIndex); err != nil {
+	if err := sd.checkValidKeyIndex(btnIndex); err != nil {
 		return err
 	}
 
 	// if necessary, rescale the picture
 	rect := img.Bounds()
-	if rect.Dx() != sd.Info.ButtonSize {
+	if rect.Dx() != sd.Info.ButtonSize || rect.Dy() != sd.Info.ButtonSize {
 		img = resize(img, sd.Info.ButtonSize, sd.Info.ButtonSize)
+		rect = img.Bounds()
 	}
 
-	imgBuf := makeBitmap(img, 270)
+	// copy the picture for the shadow framebuffer, starting at the origin
+	// as makeBitmap expects
+	key := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(key, key.Bounds(), img, rect.Min, draw.Src)
+
+	var imgBuf []byte
+	if sd.Info.Protocol == ProtocolV2 {
+		var err error
+		if imgBuf, err = makeJPEG(key); err != nil {
+			return err
+		}
+	} else {
+		imgBuf = makeBitmap(key, 270)
+	}
 
 	sd.Lock()
 	defer sd.Unlock()
 
-	return sd.writeBitmap(uint8(btnIndex), imgBuf)
+	if err := sd.writeBitmap(uint8(sd.Info.DeviceKey(btnIndex)), imgBuf); err != nil {
+		return err
+	}
+	sd.shadow[btnIndex] = key
+	return nil
 }
 
-// FillImageFromFile fills the given key with an image from a file.
-func (sd *StreamDeck) FillImageFromFile(keyIndex int, path string) error {
-	reader, err := os.Open(path)
-	if err != nil {
-		return err
+// KeyImage returns the image last displayed on a key, from the shadow
+// framebuffer kept by the StreamDeck.
+func (sd *StreamDeck) KeyImage(btnIndex int) (image.Image, error) {
+	if err := sd.checkValidKeyIndex(btnIndex); err != nil {
+		return nil, err
 	}
-	defer reader.Close()
 
-	img, _, err := image.Decode(reader)
+	img := image.NewRGBA(image.Rect(0, 0, sd.Info.ButtonSize, sd.Info.ButtonSize))
+	sd.Lock()
+	defer sd.Unlock()
+	if src := sd.shadow[btnIndex]; src != nil {
+		draw.Draw(img, img.Bounds(), src, image.Point{}, draw.Src)
+	} else {
+		draw.Draw(img, img.Bounds(), image.Black, image.Point{}, draw.Src)
+	}
+	return img, nil
+}
+
+// Screenshot returns an image of the whole panel from the shadow
+// framebuffer, with the keys laid out as by FillPanel.
+func (sd *StreamDeck) Screenshot() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, sd.Info.PanelWidth(), sd.Info.PanelHeight()))
+	draw.Draw(img, img.Bounds(), image.Black, image.Point{}, draw.Src)
+
+	sd.Lock()
+	defer sd.Unlock()
+	for i, key := range sd.shadow {
+		if key != nil {
+			draw.Draw(img, sd.Info.KeyRect(i), key, image.Point{}, draw.Src)
+		}
+	}
+	return img
+}
+
+// FillImageFromFile fills the given key with an image from a file. SVG
+// files are rasterized at the native key size.
+func (sd *StreamDeck) FillImageFromFile(keyIndex int, path string) error {
+	img, err := decodeImageFile(path, sd.Info.ButtonSize, sd.Info.ButtonSize)
 	if err != nil {
 		return err
 	}
@@ -383,49 +488,55 @@ func (sd *StreamDeck) FillPanel(img image.Image) error {
 		img = cropCenter(img, sd.Info.PanelWidth(), sd.Info.PanelHeight())
 	}
 
-	counter := 0
-
-	for row := 0; row < sd.Info.NumButtonRows; row++ {
-		for col := 0; col < sd.Info.NumButtonColumns; col++ {
-			rect := image.Rectangle{
-				Min: image.Point{
-					sd.Info.PanelWidth() - sd.Info.ButtonSize - col*sd.Info.ButtonSize - col*sd.Info.Spacer,
-					row*sd.Info.ButtonSize + row*sd.Info.Spacer,
-				},
-				Max: image.Point{
-					sd.Info.PanelWidth() - 1 - col*sd.Info.ButtonSize - col*sd.Info.Spacer,
-					sd.Info.ButtonSize - 1 + row*sd.Info.ButtonSize + row*sd.Info.Spacer,
-				},
-			}
-			sd.FillImage(counter, img.(*image.RGBA).SubImage(rect))
-			counter++
+	for i := 0; i < sd.Info.NumButtons; i++ {
+		rect := sd.Info.KeyRect(i)
+		key := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+		draw.Draw(key, key.Bounds(), img, img.Bounds().Min.Add(rect.Min), draw.Src)
+		if err := sd.FillImage(i, key); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-// FillPanelFromFile fills the entire panel with an image from a file.
+// FillPanelFromFile fills the entire panel with an image from a file. SVG
+// files are rasterized at the native panel size.
 func (sd *StreamDeck) FillPanelFromFile(path string) error {
-	reader, err := os.Open(path)
+	img, err := decodeImageFile(path, sd.Info.PanelWidth(), sd.Info.PanelHeight())
 	if err != nil {
 		return err
 	}
-	defer reader.Close()
 
-	img, _, err := image.Decode(reader)
+	return sd.FillPanel(img)
+}
+
+// decodeImageFile loads an image from a file. Bitmap formats are decoded
+// as is, SVG documents are rasterized at the given size.
+func decodeImageFile(path string, width, height int) (image.Image, error) {
+	if svg.IsSVG(path) {
+		icon, err := svg.ParseFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return icon.Rasterize(width, height), nil
+	}
+
+	reader, err := os.Open(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer reader.Close()
 
-	return sd.FillPanel(img)
+	img, _, err := image.Decode(reader)
+	return img, err
 }
 
 // WriteText can write several lines of Text to a button. It is up to the
 // user to ensure that the lines fit properly on the button.
 func (sd *StreamDeck) WriteText(btnIndex int, textBtn TextButton) error {
 
-	if err := checkValidKeyIndex(btnIndex); err != nil {
+	if err := sd.checkValidKeyIndex(btnIndex); err != nil {
 		return err
 	}
 
@@ -455,14 +566,38 @@ func (sd *StreamDeck) WriteText(btnIndex int, textBtn TextButton) error {
 }
 
 func (sd *StreamDeck) Reset() error {
-	payload := make([]byte, 17)
-	payload[0] = 0x0b
-	payload[1] = 0x63
+	var payload []byte
+	if sd.Info.Protocol == ProtocolV2 {
+		payload = make([]byte, 32)
+		payload[0] = 0x03
+		payload[1] = 0x02
+	} else {
+		payload = make([]byte, 17)
+		payload[0] = 0x0b
+		payload[1] = 0x63
+	}
+
+	if err := sd.device.SetFeatureReport(0, payload); err != nil {
+		return err
+	}
 
-	return sd.device.SetFeatureReport(0, payload)
+	sd.Lock()
+	defer sd.Unlock()
+	for i := range sd.shadow {
+		sd.shadow[i] = nil
+	}
+	return nil
 }
 
 func (sd *StreamDeck) SetBrightness(pc uint8) error {
+	if sd.Info.Protocol == ProtocolV2 {
+		payload := make([]byte, 32)
+		payload[0] = 0x03
+		payload[1] = 0x08
+		payload[2] = pc
+		return sd.device.SetFeatureReport(0, payload)
+	}
+
 	payload := make([]byte, 17)
 	payload[0] = 0x05
 	payload[1] = 0x55
@@ -475,33 +610,62 @@ func (sd *StreamDeck) SetBrightness(pc uint8) error {
 }
 
 func (sd *StreamDeck) GetFirmwareVersion() (string, error) {
-	// check firmware version
-	fwVer, err := sd.device.GetFeatureReport(4)
-	if err != nil {
-		return "", err
+	return readFirmware(sd.device, sd.Info)
+}
+
+func (sd *StreamDeck) GetSerialNumber() (string, error) {
+	return readSerial(sd.device, sd.Info)
+}
+
+// Identify reads the serial number and firmware version of a device of the
+// given model, without initializing it as Open does.
+func Identify(t Transport, model *StreamdeckDevice) (serial, firmware string, err error) {
+	if serial, err = readSerial(t, model); err != nil {
+		return "", "", err
 	}
+	if firmware, err = readFirmware(t, model); err != nil {
+		return "", "", err
+	}
+	return serial, firmware, nil
+}
 
-	fwVer = fwVer[5:] // first 5 bytes are: 04 00 00 00 00
-	if pos := bytes.IndexByte(fwVer, 0); pos >= 0 {
-		fwVer = fwVer[:pos]
+func readSerial(t Transport, model *StreamdeckDevice) (string, error) {
+	if model.Protocol == ProtocolV2 {
+		return readString(t, 6, 2) // 06 <length>
 	}
-	return string(fwVer), nil
+	return readString(t, 3, 5) // 03 00 00 00 00
 }
 
-func (sd *StreamDeck) GetSerialNumber() (string, error) {
-	serialNo, err := sd.device.GetFeatureReport(3)
+func readFirmware(t Transport, model *StreamdeckDevice) (string, error) {
+	if model.Protocol == ProtocolV2 {
+		return readString(t, 5, 6) // 05 <length> <checksum>
+	}
+	return readString(t, 4, 5) // 04 00 00 00 00
+}
+
+// readString reads a feature report holding a string, such as the serial
+// number or the firmware version, starting at the given offset.
+func readString(t Transport, report, offset int) (string, error) {
+	data, err := t.GetFeatureReport(report)
 	if err != nil {
 		return "", err
 	}
+	if len(data) < offset {
+		return "", fmt.Errorf("short feature report %d", report)
+	}
 
-	serialNo = serialNo[5:] // first 5 bytes are: 03 00 00 00 00
-	if pos := bytes.IndexByte(serialNo, 0); pos >= 0 {
-		serialNo = serialNo[:pos]
+	data = data[offset:]
+	if pos := bytes.IndexByte(data, 0); pos >= 0 {
+		data = data[:pos]
 	}
-	return string(serialNo), nil
+	return string(data), nil
 }
 
 func (sd *StreamDeck) writeBitmap(key uint8, buf []byte) error {
+	if sd.Info.Protocol == ProtocolV2 {
+		return sd.writeImageV2(key, buf)
+	}
+
 	// write buf through interrupt, limit to 1024 bytes each time
 	out := make([]byte, 1024)
 	out[0] = 0x02
@@ -527,7 +691,7 @@ func (sd *StreamDeck) writeBitmap(key uint8, buf []byte) error {
 		_, err := sd.device.Write(out, time.Second)
 		//err := sd.device.SetReport(0x0202, out)
 		if err != nil {
-			panic(fmt.Sprintf("failed to setreport: %s", err))
+			return fmt.Errorf("failed to write key image: %w", err)
 		}
 		//log.Printf("wrote %d bytes, remaining %d", len(out), len(buf))
 
@@ -537,6 +701,33 @@ func (sd *StreamDeck) writeBitmap(key uint8, buf []byte) error {
 	}
 }
 
+// writeImageV2 sends an image to a ProtocolV2 device, in 1024 byte pages
+// with an 8 byte header: 02 07 key last-page-flag, then the length of the
+// chunk and the page number, both little endian.
+func (sd *StreamDeck) writeImageV2(key uint8, buf []byte) error {
+	out := make([]byte, 1024)
+	out[0] = 0x02
+	out[1] = 0x07
+	out[2] = key
+
+	for page := 0; ; page++ {
+		n := copy(out[8:], buf)
+		buf = buf[n:]
+		if len(buf) == 0 {
+			out[3] = 1
+		}
+		binary.LittleEndian.PutUint16(out[4:], uint16(n))
+		binary.LittleEndian.PutUint16(out[6:], uint16(page))
+
+		if _, err := sd.device.Write(out, time.Second); err != nil {
+			return fmt.Errorf("failed to write key image: %w", err)
+		}
+		if len(buf) == 0 {
+			return nil
+		}
+	}
+}
+
 // resize returns a resized copy of the supplied image with the given width and height.
 func resize(img image.Image, width, height int) image.Image {
 	g := gift.New(
@@ -560,8 +751,8 @@ func cropCenter(img image.Image, width, height int) image.Image {
 }
 
 // checkValidKeyIndex checks that the keyIndex is valid
-func checkValidKeyIndex(keyIndex int) error {
-	if keyIndex < 0 || keyIndex > 15 {
+func (sd *StreamDeck) checkValidKeyIndex(keyIndex int) error {
+	if keyIndex < 0 || keyIndex >= sd.Info.NumButtons {
 		return fmt.Errorf("invalid key index")
 	}
 	return nil
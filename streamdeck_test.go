@@ -0,0 +1,241 @@
+package streamdeck_test
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/mock"
+)
+
+var models = []uint16{0x0060, 0x0063, 0x0090, 0x006c}
+
+func at(img image.Image, x, y int) color.RGBA {
+	return color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+}
+
+// same compares colors read back from a key, allowing for the JPEG
+// compression of ProtocolV2 devices.
+func same(model *sd.StreamdeckDevice, a, b color.RGBA) bool {
+	if model.Protocol != sd.ProtocolV2 {
+		return a == b
+	}
+	near := func(x, y uint8) bool {
+		diff := int(x) - int(y)
+		return diff > -8 && diff < 8
+	}
+	return near(a.R, b.R) && near(a.G, b.G) && near(a.B, b.B) && a.A == b.A
+}
+
+func TestOpen(t *testing.T) {
+	m := mock.New(sd.LookupDevice(0x0063), "TEST0001")
+	m.SetFirmware("3.00.000")
+
+	serial, firmware, err := sd.Identify(m, m.Model())
+	if err != nil || serial != "TEST0001" || firmware != "3.00.000" {
+		t.Errorf("Identify = %q, %q, %v", serial, firmware, err)
+	}
+	if m.Resets() != 0 {
+		t.Error("Identify reset the deck")
+	}
+
+	dev, err := sd.Open(m, m.Model())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dev.Close()
+	if m.Resets() != 1 || m.Brightness() != 100 {
+		t.Errorf("after Open: %d resets, brightness %d", m.Resets(), m.Brightness())
+	}
+	if s, err := dev.GetSerialNumber(); err != nil || s != "TEST0001" {
+		t.Errorf("GetSerialNumber = %q, %v", s, err)
+	}
+	if err := dev.SetBrightness(42); err != nil || m.Brightness() != 42 {
+		t.Errorf("SetBrightness: %v, brightness %d", err, m.Brightness())
+	}
+}
+
+func TestFillImage(t *testing.T) {
+	for _, id := range models {
+		model := sd.LookupDevice(id)
+		t.Run(model.Name, func(t *testing.T) {
+			dev, m := mock.Open(t, sd.LookupDevice(id), "TEST0001")
+			size := dev.ButtonSize()
+
+			// Distinct quadrants catch rotated or mirrored images.
+			img := image.NewRGBA(image.Rect(0, 0, size, size))
+			quadrants := []color.RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}, {255, 255, 255, 255}}
+			for i, c := range quadrants {
+				x, y := i%2*size/2, i/2*size/2
+				draw.Draw(img, image.Rect(x, y, x+size/2, y+size/2), &image.Uniform{c}, image.Point{}, draw.Src)
+			}
+
+			last := dev.NumButtons() - 1
+			if err := dev.FillImage(last, img); err != nil {
+				t.Fatal(err)
+			}
+			got := m.Key(last)
+			for i, c := range quadrants {
+				x, y := i%2*size/2+size/4, i/2*size/2+size/4
+				if g := at(got, x, y); !same(model, g, c) {
+					t.Errorf("quadrant %d is %v, want %v", i, g, c)
+				}
+			}
+			if g := at(m.Key(0), size/2, size/2); !same(model, g, color.RGBA{0, 0, 0, 255}) {
+				t.Errorf("key 0 is %v, want black", g)
+			}
+
+			if err := dev.FillColor(0, 0, 255, 0); err != nil {
+				t.Fatal(err)
+			}
+			if g := at(m.Key(0), 1, 1); !same(model, g, color.RGBA{0, 255, 0, 255}) {
+				t.Errorf("key 0 is %v, want green", g)
+			}
+			if err := dev.FillImage(dev.NumButtons(), img); err == nil {
+				t.Error("FillImage accepted an invalid key")
+			}
+		})
+	}
+}
+
+func TestFillPanel(t *testing.T) {
+	dev, m := mock.Open(t, sd.LookupDevice(0x0060), "TEST0001")
+	w, h := dev.Info.PanelWidth(), dev.Info.PanelHeight()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x * 255 / w), uint8(y * 255 / h), 0, 255})
+		}
+	}
+	if err := dev.FillPanel(img); err != nil {
+		t.Fatal(err)
+	}
+
+	panel := m.Panel()
+	for i := 0; i < dev.NumButtons(); i++ {
+		c := dev.Info.KeyRect(i).Min.Add(image.Pt(10, 10))
+		want := img.RGBAAt(c.X, c.Y)
+		got := panel.RGBAAt(c.X, c.Y)
+		if got != want {
+			t.Errorf("key %d at %v is %v, want %v", i, c, got, want)
+		}
+	}
+
+	shot := dev.Screenshot()
+	if shot.Bounds() != panel.Bounds() {
+		t.Errorf("screenshot bounds %v, want %v", shot.Bounds(), panel.Bounds())
+	}
+}
+
+func TestEvents(t *testing.T) {
+	dev, m := mock.Open(t, sd.LookupDevice(0x0063), "TEST0001")
+
+	type event struct {
+		key   int
+		state sd.BtnState
+	}
+	events := make(chan event, 16)
+	dev.SetBtnEventCb(func(btnIndex int, state sd.BtnState) {
+		events <- event{btnIndex, state}
+	})
+
+	m.Press(1)
+	m.Press(4)
+	m.Release(1)
+	m.Release(4)
+	want := []event{{1, sd.BtnPressed}, {4, sd.BtnPressed}, {1, sd.BtnReleased}, {4, sd.BtnReleased}}
+	for _, w := range want {
+		select {
+		case ev := <-events:
+			if ev != w {
+				t.Errorf("event %+v, want %+v", ev, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %+v", w)
+		}
+	}
+}
+
+func TestClose(t *testing.T) {
+	m := mock.New(sd.LookupDevice(0x0063), "TEST0001")
+	dev := sd.Attach(m, m.Model())
+	if m.Resets() != 0 {
+		t.Error("Attach reset the deck")
+	}
+	if err := dev.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+	if err := m.Press(0); err != mock.ErrClosed {
+		t.Errorf("Press after Close: %v", err)
+	}
+}
+
+func TestXL(t *testing.T) {
+	m := mock.New(sd.LookupDevice(0x006c), "XL000001")
+	m.SetFirmware("1.01.000")
+	serial, firmware, err := sd.Identify(m, m.Model())
+	if err != nil || serial != "XL000001" || firmware != "1.01.000" {
+		t.Errorf("Identify = %q, %q, %v", serial, firmware, err)
+	}
+
+	dev, err := sd.Open(m, m.Model())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dev.Close()
+	if m.Resets() != 1 || m.Brightness() != 100 {
+		t.Errorf("after Open: %d resets, brightness %d", m.Resets(), m.Brightness())
+	}
+	if err := dev.SetBrightness(30); err != nil || m.Brightness() != 30 {
+		t.Errorf("SetBrightness: %v, brightness %d", err, m.Brightness())
+	}
+	if s, err := dev.GetSerialNumber(); err != nil || s != "XL000001" {
+		t.Errorf("GetSerialNumber = %q, %v", s, err)
+	}
+
+	// Keys are numbered from the top right corner, as on other models.
+	if r := dev.Info.KeyRect(0); r.Min.X != dev.Info.PanelWidth()-96 || r.Min.Y != 0 {
+		t.Errorf("key 0 at %v", r)
+	}
+	events := make(chan int, 4)
+	dev.SetBtnEventCb(func(btnIndex int, state sd.BtnState) {
+		if state == sd.BtnPressed {
+			events <- btnIndex
+		}
+	})
+	for _, i := range []int{0, 9, 31} {
+		m.Click(i)
+		select {
+		case got := <-events:
+			if got != i {
+				t.Errorf("pressed %d, got %d", i, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %d", i)
+		}
+	}
+
+	w, h := dev.Info.PanelWidth(), dev.Info.PanelHeight()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x * 255 / w), uint8(y * 255 / h), 0, 255})
+		}
+	}
+	if err := dev.FillPanel(img); err != nil {
+		t.Fatal(err)
+	}
+	panel := m.Panel()
+	for i := 0; i < dev.NumButtons(); i++ {
+		c := dev.Info.KeyRect(i).Min.Add(image.Pt(48, 48))
+		if got, want := panel.RGBAAt(c.X, c.Y), img.RGBAAt(c.X, c.Y); !same(dev.Info, got, want) {
+			t.Errorf("key %d at %v is %v, want %v", i, c, got, want)
+		}
+	}
+}
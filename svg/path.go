@@ -0,0 +1,384 @@
+package svg
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+type point struct {
+	x, y float64
+}
+
+// segment kinds of a path
+const (
+	opMove = iota
+	opLine
+	opQuad
+	opCube
+	opClose
+)
+
+// op is a single path segment in absolute coordinates. Only the points
+// needed by kind are used: opQuad uses 2, opCube uses 3, the others 1.
+type op struct {
+	kind int
+	pts  [3]point
+}
+
+// path is a list of segments, built in user space and transformed into
+// device space before rasterization.
+type path []op
+
+func (p *path) moveTo(a point)       { *p = append(*p, op{kind: opMove, pts: [3]point{a}}) }
+func (p *path) lineTo(a point)       { *p = append(*p, op{kind: opLine, pts: [3]point{a}}) }
+func (p *path) quadTo(a, b point)    { *p = append(*p, op{kind: opQuad, pts: [3]point{a, b}}) }
+func (p *path) cubeTo(a, b, c point) { *p = append(*p, op{kind: opCube, pts: [3]point{a, b, c}}) }
+func (p *path) close()               { *p = append(*p, op{kind: opClose}) }
+
+func (p path) transform(m matrix) path {
+	res := make(path, len(p))
+	for i, o := range p {
+		res[i].kind = o.kind
+		for j := range o.pts {
+			res[i].pts[j] = m.apply(o.pts[j])
+		}
+	}
+	return res
+}
+
+// pathScanner tokenizes SVG path data.
+type pathScanner struct {
+	s   string
+	pos int
+}
+
+func (sc *pathScanner) skipSeparators() {
+	for sc.pos < len(sc.s) {
+		switch sc.s[sc.pos] {
+		case ' ', '\t', '\r', '\n', ',':
+			sc.pos++
+		default:
+			return
+		}
+	}
+}
+
+// command returns the next command letter, if any.
+func (sc *pathScanner) command() (byte, bool) {
+	sc.skipSeparators()
+	if sc.pos >= len(sc.s) {
+		return 0, false
+	}
+	c := sc.s[sc.pos]
+	if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+		if c == 'e' || c == 'E' {
+			return 0, false
+		}
+		sc.pos++
+		return c, true
+	}
+	return 0, false
+}
+
+// hasNumber reports whether a number follows.
+func (sc *pathScanner) hasNumber() bool {
+	sc.skipSeparators()
+	if sc.pos >= len(sc.s) {
+		return false
+	}
+	c := sc.s[sc.pos]
+	return c == '-' || c == '+' || c == '.' || (c >= '0' && c <= '9')
+}
+
+func (sc *pathScanner) number() (float64, error) {
+	sc.skipSeparators()
+	start := sc.pos
+	i := sc.pos
+	if i < len(sc.s) && (sc.s[i] == '-' || sc.s[i] == '+') {
+		i++
+	}
+	dot := false
+	digits := false
+	for i < len(sc.s) {
+		c := sc.s[i]
+		if c >= '0' && c <= '9' {
+			digits = true
+		} else if c == '.' && !dot {
+			dot = true
+		} else {
+			break
+		}
+		i++
+	}
+	if digits && i < len(sc.s) && (sc.s[i] == 'e' || sc.s[i] == 'E') {
+		j := i + 1
+		if j < len(sc.s) && (sc.s[j] == '-' || sc.s[j] == '+') {
+			j++
+		}
+		if j < len(sc.s) && sc.s[j] >= '0' && sc.s[j] <= '9' {
+			for j < len(sc.s) && sc.s[j] >= '0' && sc.s[j] <= '9' {
+				j++
+			}
+			i = j
+		}
+	}
+	if !digits {
+		return 0, fmt.Errorf("expected number at offset %d", start)
+	}
+	sc.pos = i
+	return strconv.ParseFloat(sc.s[start:i], 64)
+}
+
+// flag reads an arc flag, which may be written without separator.
+func (sc *pathScanner) flag() (bool, error) {
+	sc.skipSeparators()
+	if sc.pos < len(sc.s) {
+		switch sc.s[sc.pos] {
+		case '0':
+			sc.pos++
+			return false, nil
+		case '1':
+			sc.pos++
+			return true, nil
+		}
+	}
+	return false, fmt.Errorf("expected flag at offset %d", sc.pos)
+}
+
+func (sc *pathScanner) numbers(v []float64) error {
+	for i := range v {
+		n, err := sc.number()
+		if err != nil {
+			return err
+		}
+		v[i] = n
+	}
+	return nil
+}
+
+// parsePath parses the d attribute of a path element.
+func parsePath(d string) (path, error) {
+	var p path
+	sc := &pathScanner{s: d}
+	var cur, start, ctrl point
+	var prev byte
+	var v [7]float64
+
+	cmd, ok := sc.command()
+	if !ok {
+		if sc.pos < len(sc.s) {
+			return nil, fmt.Errorf("path must start with a command")
+		}
+		return nil, nil
+	}
+
+	for {
+		rel := cmd >= 'a'
+		var base point
+		if rel {
+			base = cur
+		}
+
+		switch cmd {
+		case 'M', 'm':
+			if err := sc.numbers(v[:2]); err != nil {
+				return nil, err
+			}
+			cur = point{base.x + v[0], base.y + v[1]}
+			start = cur
+			p.moveTo(cur)
+			// subsequent pairs are implicit lineto commands
+			if rel {
+				cmd = 'l'
+			} else {
+				cmd = 'L'
+			}
+		case 'L', 'l':
+			if err := sc.numbers(v[:2]); err != nil {
+				return nil, err
+			}
+			cur = point{base.x + v[0], base.y + v[1]}
+			p.lineTo(cur)
+		case 'H', 'h':
+			if err := sc.numbers(v[:1]); err != nil {
+				return nil, err
+			}
+			if rel {
+				cur.x += v[0]
+			} else {
+				cur.x = v[0]
+			}
+			p.lineTo(cur)
+		case 'V', 'v':
+			if err := sc.numbers(v[:1]); err != nil {
+				return nil, err
+			}
+			if rel {
+				cur.y += v[0]
+			} else {
+				cur.y = v[0]
+			}
+			p.lineTo(cur)
+		case 'C', 'c':
+			if err := sc.numbers(v[:6]); err != nil {
+				return nil, err
+			}
+			c1 := point{base.x + v[0], base.y + v[1]}
+			ctrl = point{base.x + v[2], base.y + v[3]}
+			cur = point{base.x + v[4], base.y + v[5]}
+			p.cubeTo(c1, ctrl, cur)
+		case 'S', 's':
+			if err := sc.numbers(v[:4]); err != nil {
+				return nil, err
+			}
+			c1 := cur
+			if prev == 'C' || prev == 'S' {
+				c1 = point{2*cur.x - ctrl.x, 2*cur.y - ctrl.y}
+			}
+			ctrl = point{base.x + v[0], base.y + v[1]}
+			cur = point{base.x + v[2], base.y + v[3]}
+			p.cubeTo(c1, ctrl, cur)
+		case 'Q', 'q':
+			if err := sc.numbers(v[:4]); err != nil {
+				return nil, err
+			}
+			ctrl = point{base.x + v[0], base.y + v[1]}
+			cur = point{base.x + v[2], base.y + v[3]}
+			p.quadTo(ctrl, cur)
+		case 'T', 't':
+			if err := sc.numbers(v[:2]); err != nil {
+				return nil, err
+			}
+			if prev == 'Q' || prev == 'T' {
+				ctrl = point{2*cur.x - ctrl.x, 2*cur.y - ctrl.y}
+			} else {
+				ctrl = cur
+			}
+			cur = point{base.x + v[0], base.y + v[1]}
+			p.quadTo(ctrl, cur)
+		case 'A', 'a':
+			if err := sc.numbers(v[:3]); err != nil {
+				return nil, err
+			}
+			large, err := sc.flag()
+			if err != nil {
+				return nil, err
+			}
+			sweep, err := sc.flag()
+			if err != nil {
+				return nil, err
+			}
+			if err := sc.numbers(v[3:5]); err != nil {
+				return nil, err
+			}
+			end := point{base.x + v[3], base.y + v[4]}
+			p.arcTo(cur, v[0], v[1], v[2], large, sweep, end)
+			cur = end
+		case 'Z', 'z':
+			p.close()
+			cur = start
+		default:
+			return nil, fmt.Errorf("unknown path command %q", cmd)
+		}
+
+		prev = cmd
+		if prev >= 'a' {
+			prev -= 'a' - 'A'
+		}
+		if cmd != 'Z' && cmd != 'z' && sc.hasNumber() {
+			// repeated command with implicit letter
+			continue
+		}
+
+		if cmd, ok = sc.command(); !ok {
+			if sc.pos < len(sc.s) {
+				return nil, fmt.Errorf("unexpected character %q in path at offset %d", sc.s[sc.pos], sc.pos)
+			}
+			return p, nil
+		}
+	}
+}
+
+// arcTo appends an elliptical arc from cur to end, approximated with cubic
+// Bézier curves, following the endpoint to center conversion described in
+// the SVG implementation notes.
+func (p *path) arcTo(cur point, rx, ry, angle float64, large, sweep bool, end point) {
+	if cur == end {
+		return
+	}
+	rx, ry = math.Abs(rx), math.Abs(ry)
+	if rx == 0 || ry == 0 {
+		p.lineTo(end)
+		return
+	}
+
+	phi := angle * math.Pi / 180
+	sinPhi, cosPhi := math.Sin(phi), math.Cos(phi)
+
+	dx, dy := (cur.x-end.x)/2, (cur.y-end.y)/2
+	x1 := cosPhi*dx + sinPhi*dy
+	y1 := -sinPhi*dx + cosPhi*dy
+
+	// scale up radii if they are too small
+	if l := x1*x1/(rx*rx) + y1*y1/(ry*ry); l > 1 {
+		l = math.Sqrt(l)
+		rx, ry = rx*l, ry*l
+	}
+
+	num := rx*rx*ry*ry - rx*rx*y1*y1 - ry*ry*x1*x1
+	den := rx*rx*y1*y1 + ry*ry*x1*x1
+	coef := 0.0
+	if num > 0 && den > 0 {
+		coef = math.Sqrt(num / den)
+	}
+	if large == sweep {
+		coef = -coef
+	}
+	cx1 := coef * rx * y1 / ry
+	cy1 := -coef * ry * x1 / rx
+
+	cx := cosPhi*cx1 - sinPhi*cy1 + (cur.x+end.x)/2
+	cy := sinPhi*cx1 + cosPhi*cy1 + (cur.y+end.y)/2
+
+	theta1 := math.Atan2((y1-cy1)/ry, (x1-cx1)/rx)
+	dtheta := math.Atan2((-y1-cy1)/ry, (-x1-cx1)/rx) - theta1
+	if sweep && dtheta < 0 {
+		dtheta += 2 * math.Pi
+	} else if !sweep && dtheta > 0 {
+		dtheta -= 2 * math.Pi
+	}
+
+	n := int(math.Ceil(math.Abs(dtheta) / (math.Pi / 2)))
+	delta := dtheta / float64(n)
+	k := 4.0 / 3 * math.Tan(delta/4)
+
+	at := func(t float64) (point, point) {
+		sin, cos := math.Sin(t), math.Cos(t)
+		pt := point{
+			cx + rx*cos*cosPhi - ry*sin*sinPhi,
+			cy + rx*cos*sinPhi + ry*sin*cosPhi,
+		}
+		deriv := point{
+			-rx*sin*cosPhi - ry*cos*sinPhi,
+			-rx*sin*sinPhi + ry*cos*cosPhi,
+		}
+		return pt, deriv
+	}
+
+	t := theta1
+	from, d1 := at(t)
+	for i := 0; i < n; i++ {
+		t += delta
+		to, d2 := at(t)
+		if i == n-1 {
+			to = end
+		}
+		p.cubeTo(
+			point{from.x + k*d1.x, from.y + k*d1.y},
+			point{to.x - k*d2.x, to.y - k*d2.y},
+			to,
+		)
+		from, d1 = to, d2
+	}
+}
@@ -0,0 +1,222 @@
+package svg
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"golang.org/x/image/vector"
+)
+
+// rasterizer wraps vector.Rasterizer to fill and stroke paths.
+type rasterizer struct {
+	z    *vector.Rasterizer
+	w, h int
+}
+
+func newRasterizer(w, h int) *rasterizer {
+	return &rasterizer{z: vector.NewRasterizer(w, h), w: w, h: h}
+}
+
+func (r *rasterizer) draw(img *image.RGBA, c color.RGBA) {
+	r.z.DrawOp = draw.Over
+	r.z.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{})
+}
+
+func pt32(p point) (float32, float32) {
+	return float32(p.x), float32(p.y)
+}
+
+// fill prepares the rasterizer with the interior of p.
+func (r *rasterizer) fill(p path) {
+	r.z.Reset(r.w, r.h)
+	open := false
+	for _, o := range p {
+		switch o.kind {
+		case opMove:
+			if open {
+				r.z.ClosePath()
+			}
+			r.z.MoveTo(pt32(o.pts[0]))
+			open = true
+		case opLine:
+			r.z.LineTo(pt32(o.pts[0]))
+		case opQuad:
+			x1, y1 := pt32(o.pts[0])
+			x2, y2 := pt32(o.pts[1])
+			r.z.QuadTo(x1, y1, x2, y2)
+		case opCube:
+			x1, y1 := pt32(o.pts[0])
+			x2, y2 := pt32(o.pts[1])
+			x3, y3 := pt32(o.pts[2])
+			r.z.CubeTo(x1, y1, x2, y2, x3, y3)
+		case opClose:
+			if open {
+				r.z.ClosePath()
+				open = false
+			}
+		}
+	}
+	if open {
+		r.z.ClosePath()
+	}
+}
+
+// polyline is a flattened subpath.
+type polyline struct {
+	pts    []point
+	closed bool
+}
+
+// flatten approximates the curves of p with line segments.
+func flatten(p path) []polyline {
+	var res []polyline
+	var cur *polyline
+	var last, start point
+
+	for _, o := range p {
+		if o.kind != opMove && cur == nil {
+			// drawing without a moveto starts at the last point
+			res = append(res, polyline{pts: []point{last}})
+			cur = &res[len(res)-1]
+		}
+		switch o.kind {
+		case opMove:
+			res = append(res, polyline{pts: []point{o.pts[0]}})
+			cur = &res[len(res)-1]
+			last, start = o.pts[0], o.pts[0]
+		case opLine:
+			cur.pts = append(cur.pts, o.pts[0])
+			last = o.pts[0]
+		case opQuad:
+			n := segments(last, o.pts[0], o.pts[1])
+			for i := 1; i <= n; i++ {
+				t := float64(i) / float64(n)
+				mt := 1 - t
+				cur.pts = append(cur.pts, point{
+					mt*mt*last.x + 2*mt*t*o.pts[0].x + t*t*o.pts[1].x,
+					mt*mt*last.y + 2*mt*t*o.pts[0].y + t*t*o.pts[1].y,
+				})
+			}
+			last = o.pts[1]
+		case opCube:
+			n := segments(last, o.pts[0], o.pts[1], o.pts[2])
+			for i := 1; i <= n; i++ {
+				t := float64(i) / float64(n)
+				mt := 1 - t
+				a, b, c, d := mt*mt*mt, 3*mt*mt*t, 3*mt*t*t, t*t*t
+				cur.pts = append(cur.pts, point{
+					a*last.x + b*o.pts[0].x + c*o.pts[1].x + d*o.pts[2].x,
+					a*last.y + b*o.pts[0].y + c*o.pts[1].y + d*o.pts[2].y,
+				})
+			}
+			last = o.pts[2]
+		case opClose:
+			cur.closed = true
+			cur = nil
+			last = start
+		}
+	}
+	return res
+}
+
+// segments returns the number of line segments used to flatten a curve,
+// based on the length of its control polygon in pixels.
+func segments(pts ...point) int {
+	l := 0.0
+	for i := 1; i < len(pts); i++ {
+		l += math.Hypot(pts[i].x-pts[i-1].x, pts[i].y-pts[i-1].y)
+	}
+	n := int(l / 2)
+	if n < 4 {
+		n = 4
+	} else if n > 100 {
+		n = 100
+	}
+	return n
+}
+
+// stroke prepares the rasterizer with the outline of p stroked with the
+// given width. The outline is built from one quad per segment and circles
+// at the vertices (round joins). All polygons share the same orientation,
+// so overlapping parts don't cancel each other out.
+func (r *rasterizer) stroke(p path, width float64, lineCap string) {
+	r.z.Reset(r.w, r.h)
+	hw := width / 2
+
+	for _, pl := range flatten(p) {
+		pts := pl.pts
+		if pl.closed && len(pts) > 1 && pts[0] != pts[len(pts)-1] {
+			pts = append(pts, pts[0])
+		}
+
+		for i := 1; i < len(pts); i++ {
+			a, b := pts[i-1], pts[i]
+			dx, dy := b.x-a.x, b.y-a.y
+			l := math.Hypot(dx, dy)
+			if l == 0 {
+				continue
+			}
+			nx, ny := -dy/l*hw, dx/l*hw
+			if lineCap == "square" && !pl.closed {
+				ex, ey := dx/l*hw, dy/l*hw
+				if i == 1 {
+					a = point{a.x - ex, a.y - ey}
+				}
+				if i == len(pts)-1 {
+					b = point{b.x + ex, b.y + ey}
+				}
+			}
+			r.polygon([]point{
+				{a.x + nx, a.y + ny},
+				{b.x + nx, b.y + ny},
+				{b.x - nx, b.y - ny},
+				{a.x - nx, a.y - ny},
+			})
+		}
+
+		for i, pt := range pts {
+			end := i == 0 || i == len(pts)-1
+			if end && !pl.closed && lineCap != "round" {
+				continue
+			}
+			r.circle(pt, hw)
+		}
+	}
+}
+
+// polygon adds a closed polygon with a clockwise orientation.
+func (r *rasterizer) polygon(pts []point) {
+	area := 0.0
+	for i := range pts {
+		j := (i + 1) % len(pts)
+		area += pts[i].x*pts[j].y - pts[j].x*pts[i].y
+	}
+	if area < 0 {
+		for i, j := 0, len(pts)-1; i < j; i, j = i+1, j-1 {
+			pts[i], pts[j] = pts[j], pts[i]
+		}
+	}
+
+	r.z.MoveTo(pt32(pts[0]))
+	for _, pt := range pts[1:] {
+		r.z.LineTo(pt32(pt))
+	}
+	r.z.ClosePath()
+}
+
+func (r *rasterizer) circle(c point, radius float64) {
+	n := int(radius * 2)
+	if n < 8 {
+		n = 8
+	} else if n > 64 {
+		n = 64
+	}
+	pts := make([]point, n)
+	for i := range pts {
+		a := 2 * math.Pi * float64(i) / float64(n)
+		pts[i] = point{c.x + radius*math.Cos(a), c.y + radius*math.Sin(a)}
+	}
+	r.polygon(pts)
+}
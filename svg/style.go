@@ -0,0 +1,283 @@
+package svg
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/colornames"
+)
+
+// paint is the value of a fill or stroke property.
+type paint struct {
+	none    bool
+	current bool // currentColor
+	c       color.RGBA
+}
+
+// style holds the inherited presentation attributes of an element.
+type style struct {
+	fill          paint
+	stroke        paint
+	fillOpacity   float64
+	strokeOpacity float64
+	opacity       float64
+	strokeWidth   float64
+	lineCap       string
+	color         color.RGBA
+	ctm           matrix
+}
+
+var defaultStyle = style{
+	fill:          paint{c: color.RGBA{0, 0, 0, 0xff}},
+	stroke:        paint{none: true},
+	fillOpacity:   1,
+	strokeOpacity: 1,
+	opacity:       1,
+	strokeWidth:   1,
+	lineCap:       "butt",
+	color:         color.RGBA{0, 0, 0, 0xff},
+	ctm:           identity,
+}
+
+// apply updates the style with the presentation attributes of an element.
+// opacity is not inherited in SVG, but since groups are not composited
+// separately, it is multiplied into the children's opacity instead.
+func (st *style) apply(attrs map[string]string) error {
+	var err error
+
+	// color has to be resolved first, as fill and stroke may refer to it
+	if v, ok := attrs["color"]; ok && v != "inherit" {
+		var p paint
+		if p, err = parsePaint(v); err != nil {
+			return err
+		}
+		if !p.none && !p.current {
+			st.color = p.c
+		}
+	}
+
+	for k, v := range attrs {
+		if v == "inherit" {
+			continue
+		}
+		switch k {
+		case "fill":
+			st.fill, err = parsePaint(v)
+		case "stroke":
+			st.stroke, err = parsePaint(v)
+		case "fill-opacity":
+			st.fillOpacity, err = parseOpacity(v)
+		case "stroke-opacity":
+			st.strokeOpacity, err = parseOpacity(v)
+		case "opacity":
+			var o float64
+			o, err = parseOpacity(v)
+			st.opacity *= o
+		case "stroke-width":
+			st.strokeWidth, err = parseLength(v)
+		case "stroke-linecap":
+			st.lineCap = v
+		case "transform":
+			var m matrix
+			m, err = parseTransform(v)
+			st.ctm = st.ctm.mul(m)
+		}
+		if err != nil {
+			return fmt.Errorf("attribute %s: %w", k, err)
+		}
+	}
+	return nil
+}
+
+// resolve returns the color to use for p, or false if nothing is painted.
+func (st *style) resolve(p paint, opacity float64) (color.RGBA, bool) {
+	if p.none {
+		return color.RGBA{}, false
+	}
+	c := p.c
+	if p.current {
+		c = st.color
+	}
+	a := float64(c.A) / 0xff * opacity * st.opacity
+	if a <= 0 {
+		return color.RGBA{}, false
+	}
+	// return a premultiplied color
+	return color.RGBA{
+		R: uint8(float64(c.R)*a + 0.5),
+		G: uint8(float64(c.G)*a + 0.5),
+		B: uint8(float64(c.B)*a + 0.5),
+		A: uint8(0xff*a + 0.5),
+	}, true
+}
+
+// parseStyle parses the content of a style attribute into attrs.
+func parseStyle(s string, attrs map[string]string) {
+	for _, decl := range strings.Split(s, ";") {
+		kv := strings.SplitN(decl, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		attrs[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+}
+
+func parsePaint(s string) (paint, error) {
+	s = strings.TrimSpace(s)
+	switch s {
+	case "none", "transparent":
+		return paint{none: true}, nil
+	case "currentColor":
+		return paint{current: true}, nil
+	}
+	c, err := parseColor(s)
+	return paint{c: c}, err
+}
+
+func parseColor(s string) (color.RGBA, error) {
+	if strings.HasPrefix(s, "#") {
+		hex := s[1:]
+		if len(hex) == 3 || len(hex) == 4 {
+			var b strings.Builder
+			for _, c := range hex {
+				b.WriteRune(c)
+				b.WriteRune(c)
+			}
+			hex = b.String()
+		}
+		if len(hex) == 6 {
+			hex += "ff"
+		}
+		if len(hex) != 8 {
+			return color.RGBA{}, fmt.Errorf("invalid color %q", s)
+		}
+		v, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return color.RGBA{}, fmt.Errorf("invalid color %q", s)
+		}
+		return color.RGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}, nil
+	}
+
+	if strings.HasPrefix(s, "rgb(") || strings.HasPrefix(s, "rgba(") {
+		open := strings.IndexByte(s, '(')
+		if !strings.HasSuffix(s, ")") {
+			return color.RGBA{}, fmt.Errorf("invalid color %q", s)
+		}
+		parts := strings.FieldsFunc(s[open+1:len(s)-1], func(r rune) bool {
+			return r == ',' || r == ' ' || r == '/'
+		})
+		if len(parts) != 3 && len(parts) != 4 {
+			return color.RGBA{}, fmt.Errorf("invalid color %q", s)
+		}
+		var v [4]float64
+		v[3] = 1
+		for i, part := range parts {
+			scale := 1.0
+			if i == 3 {
+				scale = 1.0 / 255
+			}
+			if strings.HasSuffix(part, "%") {
+				part = part[:len(part)-1]
+				scale = 255.0 / 100
+				if i == 3 {
+					scale = 1.0 / 100
+				}
+			}
+			f, err := strconv.ParseFloat(part, 64)
+			if err != nil {
+				return color.RGBA{}, fmt.Errorf("invalid color %q", s)
+			}
+			v[i] = f * scale
+		}
+		return color.RGBA{clamp8(v[0]), clamp8(v[1]), clamp8(v[2]), clamp8(v[3] * 255)}, nil
+	}
+
+	if c, ok := colornames.Map[strings.ToLower(s)]; ok {
+		return c, nil
+	}
+	return color.RGBA{}, fmt.Errorf("invalid color %q", s)
+}
+
+func clamp8(v float64) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v + 0.5)
+}
+
+func parseOpacity(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	scale := 1.0
+	if strings.HasSuffix(s, "%") {
+		s = s[:len(s)-1]
+		scale = 0.01
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	v *= scale
+	if v < 0 {
+		v = 0
+	} else if v > 1 {
+		v = 1
+	}
+	return v, nil
+}
+
+// parseLength parses a length in user units. Absolute units are converted
+// assuming 96 dpi, percentages are not supported.
+func parseLength(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	units := []struct {
+		suffix string
+		factor float64
+	}{
+		{"px", 1},
+		{"pt", 96.0 / 72},
+		{"pc", 16},
+		{"mm", 96 / 25.4},
+		{"cm", 96 / 2.54},
+		{"in", 96},
+	}
+	factor := 1.0
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(s[:len(s)-len(u.suffix)])
+			factor = u.factor
+			break
+		}
+	}
+	if strings.HasSuffix(s, "%") {
+		return 0, fmt.Errorf("percentage lengths are not supported")
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid length %q", s)
+	}
+	return v * factor, nil
+}
+
+// parseNumbers parses a list of numbers separated by whitespace and/or
+// commas, as used by viewBox, points and transform.
+func parseNumbers(s string) ([]float64, error) {
+	sc := &pathScanner{s: s}
+	var res []float64
+	for sc.hasNumber() {
+		v, err := sc.number()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, v)
+	}
+	sc.skipSeparators()
+	if sc.pos < len(sc.s) {
+		return nil, fmt.Errorf("invalid number list %q", s)
+	}
+	return res, nil
+}
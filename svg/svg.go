@@ -0,0 +1,333 @@
+// Package svg rasterizes SVG icons so they can be displayed on the keys of a
+// StreamDeck.
+//
+// It supports the subset of SVG commonly found in icon sets: paths, basic
+// shapes (rect, circle, ellipse, line, polyline, polygon), groups, transforms,
+// fills, strokes, opacity and viewBox scaling. Gradients, clipping, masks,
+// text and <use> references are ignored. Fills always use the nonzero rule.
+package svg
+
+import (
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// Icon is a parsed SVG document, ready to be rasterized at any size.
+type Icon struct {
+	// ViewBox of the document, in user units.
+	MinX, MinY, Width, Height float64
+
+	shapes []shape
+}
+
+type shape struct {
+	path        path
+	fill        color.RGBA
+	hasFill     bool
+	stroke      color.RGBA
+	hasStroke   bool
+	strokeWidth float64 // in user units of the root element
+	lineCap     string
+}
+
+// elements whose content is never rendered directly
+var skipped = map[string]bool{
+	"defs":           true,
+	"clipPath":       true,
+	"mask":           true,
+	"symbol":         true,
+	"pattern":        true,
+	"marker":         true,
+	"linearGradient": true,
+	"radialGradient": true,
+	"style":          true,
+	"title":          true,
+	"desc":           true,
+	"metadata":       true,
+	"text":           true,
+}
+
+// Parse reads an SVG document.
+func Parse(r io.Reader) (*Icon, error) {
+	dec := xml.NewDecoder(r)
+	dec.Strict = false
+
+	icon := &Icon{}
+	var stack []style
+	skipDepth := 0
+	root := true
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if skipDepth > 0 || skipped[t.Name.Local] {
+				skipDepth++
+				continue
+			}
+
+			attrs := make(map[string]string, len(t.Attr))
+			for _, a := range t.Attr {
+				attrs[a.Name.Local] = a.Value
+			}
+			if s, ok := attrs["style"]; ok {
+				parseStyle(s, attrs)
+			}
+			if attrs["display"] == "none" {
+				skipDepth++
+				continue
+			}
+
+			if root {
+				if t.Name.Local != "svg" {
+					return nil, fmt.Errorf("not an svg document")
+				}
+				root = false
+				if err := icon.parseRoot(attrs); err != nil {
+					return nil, err
+				}
+				stack = append(stack, defaultStyle)
+			}
+
+			st := stack[len(stack)-1]
+			if err := st.apply(attrs); err != nil {
+				return nil, fmt.Errorf("<%s>: %w", t.Name.Local, err)
+			}
+			stack = append(stack, st)
+
+			p, err := shapePath(t.Name.Local, attrs)
+			if err != nil {
+				return nil, fmt.Errorf("<%s>: %w", t.Name.Local, err)
+			}
+			if p != nil {
+				icon.addShape(p, &st)
+			}
+
+		case xml.EndElement:
+			if skipDepth > 0 {
+				skipDepth--
+				continue
+			}
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+
+	if root {
+		return nil, fmt.Errorf("not an svg document")
+	}
+	return icon, nil
+}
+
+// ParseFile reads an SVG document from disk.
+func ParseFile(path string) (*Icon, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(f)
+}
+
+func (icon *Icon) parseRoot(attrs map[string]string) error {
+	if vb, ok := attrs["viewBox"]; ok {
+		v, err := parseNumbers(vb)
+		if err != nil || len(v) != 4 || v[2] <= 0 || v[3] <= 0 {
+			return fmt.Errorf("invalid viewBox %q", vb)
+		}
+		icon.MinX, icon.MinY, icon.Width, icon.Height = v[0], v[1], v[2], v[3]
+		return nil
+	}
+
+	// without a viewBox, the user space is given by width and height
+	icon.Width, icon.Height = 300, 150
+	if w, err := parseLength(attrs["width"]); err == nil && w > 0 {
+		icon.Width = w
+	}
+	if h, err := parseLength(attrs["height"]); err == nil && h > 0 {
+		icon.Height = h
+	}
+	return nil
+}
+
+func (icon *Icon) addShape(p path, st *style) {
+	s := shape{
+		path:        p.transform(st.ctm),
+		strokeWidth: st.strokeWidth * st.ctm.scale(),
+		lineCap:     st.lineCap,
+	}
+	s.fill, s.hasFill = st.resolve(st.fill, st.fillOpacity)
+	s.stroke, s.hasStroke = st.resolve(st.stroke, st.strokeOpacity)
+	if s.strokeWidth <= 0 {
+		s.hasStroke = false
+	}
+	if s.hasFill || s.hasStroke {
+		icon.shapes = append(icon.shapes, s)
+	}
+}
+
+// shapePath returns the outline of a shape element, or nil for elements
+// that are not shapes.
+func shapePath(name string, attrs map[string]string) (path, error) {
+	num := func(key string) float64 {
+		v, _ := parseLength(attrs[key])
+		return v
+	}
+
+	var p path
+	switch name {
+	case "path":
+		return parsePath(attrs["d"])
+
+	case "rect":
+		x, y, w, h := num("x"), num("y"), num("width"), num("height")
+		if w <= 0 || h <= 0 {
+			return nil, nil
+		}
+		_, hasRx := attrs["rx"]
+		_, hasRy := attrs["ry"]
+		rx, ry := num("rx"), num("ry")
+		if hasRx && !hasRy {
+			ry = rx
+		} else if hasRy && !hasRx {
+			rx = ry
+		}
+		rx, ry = math.Min(math.Max(rx, 0), w/2), math.Min(math.Max(ry, 0), h/2)
+		if rx == 0 || ry == 0 {
+			p.moveTo(point{x, y})
+			p.lineTo(point{x + w, y})
+			p.lineTo(point{x + w, y + h})
+			p.lineTo(point{x, y + h})
+			p.close()
+			return p, nil
+		}
+		p.moveTo(point{x + rx, y})
+		p.lineTo(point{x + w - rx, y})
+		p.arcTo(point{x + w - rx, y}, rx, ry, 0, false, true, point{x + w, y + ry})
+		p.lineTo(point{x + w, y + h - ry})
+		p.arcTo(point{x + w, y + h - ry}, rx, ry, 0, false, true, point{x + w - rx, y + h})
+		p.lineTo(point{x + rx, y + h})
+		p.arcTo(point{x + rx, y + h}, rx, ry, 0, false, true, point{x, y + h - ry})
+		p.lineTo(point{x, y + ry})
+		p.arcTo(point{x, y + ry}, rx, ry, 0, false, true, point{x + rx, y})
+		p.close()
+		return p, nil
+
+	case "circle":
+		r := num("r")
+		if r <= 0 {
+			return nil, nil
+		}
+		p.ellipse(num("cx"), num("cy"), r, r)
+		return p, nil
+
+	case "ellipse":
+		rx, ry := num("rx"), num("ry")
+		if rx <= 0 || ry <= 0 {
+			return nil, nil
+		}
+		p.ellipse(num("cx"), num("cy"), rx, ry)
+		return p, nil
+
+	case "line":
+		p.moveTo(point{num("x1"), num("y1")})
+		p.lineTo(point{num("x2"), num("y2")})
+		return p, nil
+
+	case "polyline", "polygon":
+		v, err := parseNumbers(attrs["points"])
+		if err != nil {
+			return nil, err
+		}
+		if len(v) < 4 {
+			return nil, nil
+		}
+		p.moveTo(point{v[0], v[1]})
+		for i := 2; i+1 < len(v); i += 2 {
+			p.lineTo(point{v[i], v[i+1]})
+		}
+		if name == "polygon" {
+			p.close()
+		}
+		return p, nil
+	}
+
+	return nil, nil
+}
+
+func (p *path) ellipse(cx, cy, rx, ry float64) {
+	start := point{cx + rx, cy}
+	p.moveTo(start)
+	p.arcTo(start, rx, ry, 0, false, true, point{cx - rx, cy})
+	p.arcTo(point{cx - rx, cy}, rx, ry, 0, false, true, start)
+	p.close()
+}
+
+// Rasterize renders the icon into a new image of the given size. The viewBox
+// is scaled uniformly to fit and centered, the background is transparent.
+func (icon *Icon) Rasterize(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	icon.Draw(img)
+	return img
+}
+
+// Draw renders the icon on top of img, scaled to fit its bounds.
+func (icon *Icon) Draw(img *image.RGBA) {
+	b := img.Bounds()
+	s := math.Min(float64(b.Dx())/icon.Width, float64(b.Dy())/icon.Height)
+	m := matrix{
+		s, 0, 0, s,
+		(float64(b.Dx())-icon.Width*s)/2 - icon.MinX*s,
+		(float64(b.Dy())-icon.Height*s)/2 - icon.MinY*s,
+	}
+
+	r := newRasterizer(b.Dx(), b.Dy())
+	for _, sh := range icon.shapes {
+		p := sh.path.transform(m)
+		if sh.hasFill {
+			r.fill(p)
+			r.draw(img, sh.fill)
+		}
+		if sh.hasStroke {
+			r.stroke(p, sh.strokeWidth*s, sh.lineCap)
+			r.draw(img, sh.stroke)
+		}
+	}
+}
+
+// Recolor replaces the color of every pixel of img with c, keeping its
+// alpha. This is meant for monochrome icons, which can then be rendered in
+// any theme color.
+func Recolor(img *image.RGBA, c color.Color) {
+	r, g, b, _ := c.RGBA()
+	b0 := img.Bounds()
+	for y := b0.Min.Y; y < b0.Max.Y; y++ {
+		for x := b0.Min.X; x < b0.Max.X; x++ {
+			i := img.PixOffset(x, y)
+			a := uint32(img.Pix[i+3])
+			img.Pix[i+0] = uint8(r * a / 0xffff)
+			img.Pix[i+1] = uint8(g * a / 0xffff)
+			img.Pix[i+2] = uint8(b * a / 0xffff)
+		}
+	}
+}
+
+// IsSVG reports whether the file name has an svg extension.
+func IsSVG(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), ".svg")
+}
@@ -0,0 +1,173 @@
+package svg
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+var (
+	transparent = color.RGBA{}
+	red         = color.RGBA{255, 0, 0, 255}
+	green       = color.RGBA{0, 128, 0, 255}
+	blue        = color.RGBA{0, 0, 255, 255}
+)
+
+// render parses and rasterizes an SVG document.
+func render(t *testing.T, doc string, width, height int) *image.RGBA {
+	t.Helper()
+	icon, err := Parse(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return icon.Rasterize(width, height)
+}
+
+// pixel is a point of a rendered image and its expected color.
+type pixel struct {
+	x, y int
+	c    color.RGBA
+}
+
+func check(t *testing.T, img *image.RGBA, pixels ...pixel) {
+	t.Helper()
+	for _, p := range pixels {
+		if got := img.RGBAAt(p.x, p.y); got != p.c {
+			t.Errorf("pixel (%d,%d) is %v, want %v", p.x, p.y, got, p.c)
+		}
+	}
+}
+
+func TestShapes(t *testing.T) {
+	img := render(t, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10">
+		<rect width="5" height="8" fill="red"/>
+		<circle cx="7.5" cy="4" r="2" style="fill:#00f"/>
+		<line x1="0" y1="9" x2="10" y2="9" stroke="green"/>
+		<polygon points="9,0 10,0 10,1" fill="red" display="none"/>
+	</svg>`, 100, 100)
+	check(t, img,
+		pixel{20, 40, red},
+		pixel{75, 40, blue},
+		pixel{75, 10, transparent}, // out of the circle
+		pixel{50, 90, green},
+		pixel{50, 83, transparent}, // out of the stroke
+		pixel{98, 1, transparent},
+	)
+}
+
+func TestOpacity(t *testing.T) {
+	img := render(t, `<svg viewBox="0 0 10 10">
+		<g opacity="0.5"><rect width="10" height="10" fill="blue" fill-opacity="0.5"/></g>
+	</svg>`, 10, 10)
+	if a := img.RGBAAt(5, 5).A; a < 62 || a > 66 {
+		t.Errorf("alpha %d, want 64", a)
+	}
+}
+
+func TestArcs(t *testing.T) {
+	for _, c := range []struct {
+		name, d string
+		pixels  []pixel
+	}{{
+		// From the left to the right, clockwise: the upper half disk.
+		"half", "M 10 50 A 40 40 0 0 1 90 50 Z",
+		[]pixel{{50, 15, red}, {20, 45, red}, {50, 5, transparent}, {50, 60, transparent}},
+	}, {
+		// From the top to the right, counterclockwise the long way round,
+		// then to the center: a disk missing its top right quarter.
+		"large", "M 50 10 A 40 40 0 1 0 90 50 L 50 50 Z",
+		[]pixel{{20, 50, red}, {50, 80, red}, {25, 25, red}, {75, 75, red}, {70, 30, transparent}},
+	}, {
+		// Relative elliptical arc rotated by 90 degrees, counterclockwise:
+		// the lower half of an ellipse 40 pixels wide and 80 high.
+		"relative", "m 30 50 a 40 20 90 0 0 40 0 z",
+		[]pixel{{50, 85, red}, {50, 40, transparent}, {33, 85, transparent}},
+	}} {
+		t.Run(c.name, func(t *testing.T) {
+			img := render(t, `<svg viewBox="0 0 100 100"><path fill="red" d="`+c.d+`"/></svg>`, 100, 100)
+			check(t, img, c.pixels...)
+		})
+	}
+}
+
+func TestViewBox(t *testing.T) {
+	// The viewBox is twice as wide as high: it is scaled by 2 to fit 40
+	// pixels wide, and centered vertically.
+	img := render(t, `<svg viewBox="100 100 20 10">
+		<rect x="100" y="100" width="10" height="10" fill="red"/>
+	</svg>`, 40, 40)
+	check(t, img,
+		pixel{10, 20, red},
+		pixel{30, 20, transparent},
+		pixel{10, 8, transparent},
+		pixel{10, 32, transparent},
+	)
+
+	// Without a viewBox, the size gives the user space.
+	img = render(t, `<svg width="20px" height="20"><rect width="10" height="10" fill="red"/></svg>`, 40, 40)
+	check(t, img, pixel{18, 18, red}, pixel{22, 22, transparent})
+}
+
+func TestTransforms(t *testing.T) {
+	// The top left quarter moved by each transform.
+	for _, c := range []struct {
+		transform string
+		in, out   image.Point
+	}{
+		{"translate(50)", image.Pt(75, 25), image.Pt(25, 25)},
+		{"translate(50 50)", image.Pt(75, 75), image.Pt(25, 25)},
+		{"rotate(90 50 50)", image.Pt(75, 25), image.Pt(25, 25)},
+		{"translate(100 0) scale(-1 1)", image.Pt(75, 25), image.Pt(25, 25)},
+		{"scale(0.5)", image.Pt(10, 10), image.Pt(40, 40)},
+		{"matrix(1 0 0 1 0 50)", image.Pt(25, 75), image.Pt(25, 25)},
+		{"skewX(45)", image.Pt(60, 45), image.Pt(5, 45)},
+	} {
+		t.Run(c.transform, func(t *testing.T) {
+			img := render(t, `<svg viewBox="0 0 100 100"><g transform="`+c.transform+`">
+				<rect width="50" height="50" fill="red"/>
+			</g></svg>`, 100, 100)
+			check(t, img, pixel{c.in.X, c.in.Y, red}, pixel{c.out.X, c.out.Y, transparent})
+		})
+	}
+
+	// Stroke widths are scaled too.
+	img := render(t, `<svg viewBox="0 0 100 100">
+		<line transform="scale(2)" x1="0" y1="25" x2="50" y2="25" stroke="red" stroke-width="10"/>
+	</svg>`, 100, 100)
+	check(t, img, pixel{50, 41, red}, pixel{50, 59, red}, pixel{50, 39, transparent})
+}
+
+func TestRecolor(t *testing.T) {
+	img := render(t, `<svg viewBox="0 0 10 10"><circle cx="5" cy="5" r="4"/></svg>`, 40, 40)
+	Recolor(img, red)
+
+	check(t, img, pixel{20, 20, red}, pixel{1, 1, transparent})
+	edges := 0
+	for x := 0; x < 40; x++ {
+		c := img.RGBAAt(x, 20)
+		if c.R != c.A || c.G != 0 || c.B != 0 {
+			t.Fatalf("pixel (%d,20) is %v, want red with its alpha", x, c)
+		}
+		if c.A > 0 && c.A < 255 {
+			edges++
+		}
+	}
+	if edges == 0 {
+		t.Error("no antialiased pixel on the edges of the circle")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, c := range []struct{ doc, err string }{
+		{`<html/>`, "not an svg document"},
+		{`<svg viewBox="0 0 0 10"/>`, "invalid viewBox"},
+		{`<svg><path d="M 0 0 L x"/></svg>`, "<path>"},
+		{`<svg><g transform="spin(3)"/></svg>`, "invalid transform"},
+	} {
+		_, err := Parse(strings.NewReader(c.doc))
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: got error %v, want %q", c.doc, err, c.err)
+		}
+	}
+}
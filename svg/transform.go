@@ -0,0 +1,86 @@
+package svg
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// matrix is an affine transform [a b c d e f], mapping (x, y) to
+// (a*x + c*y + e, b*x + d*y + f) as in the SVG specification.
+type matrix [6]float64
+
+var identity = matrix{1, 0, 0, 1, 0, 0}
+
+// mul returns the transform applying n first, then m.
+func (m matrix) mul(n matrix) matrix {
+	return matrix{
+		m[0]*n[0] + m[2]*n[1],
+		m[1]*n[0] + m[3]*n[1],
+		m[0]*n[2] + m[2]*n[3],
+		m[1]*n[2] + m[3]*n[3],
+		m[0]*n[4] + m[2]*n[5] + m[4],
+		m[1]*n[4] + m[3]*n[5] + m[5],
+	}
+}
+
+func (m matrix) apply(p point) point {
+	return point{m[0]*p.x + m[2]*p.y + m[4], m[1]*p.x + m[3]*p.y + m[5]}
+}
+
+// scale returns the average scaling factor of m, used for stroke widths.
+func (m matrix) scale() float64 {
+	return math.Sqrt(math.Abs(m[0]*m[3] - m[1]*m[2]))
+}
+
+// parseTransform parses the value of a transform attribute, such as
+// "translate(10 20) rotate(45)".
+func parseTransform(s string) (matrix, error) {
+	m := identity
+	s = strings.TrimSpace(s)
+
+	for s != "" {
+		open := strings.IndexByte(s, '(')
+		end := strings.IndexByte(s, ')')
+		if open < 0 || end < open {
+			return m, fmt.Errorf("invalid transform %q", s)
+		}
+		name := strings.TrimSpace(s[:open])
+		args, err := parseNumbers(s[open+1 : end])
+		if err != nil {
+			return m, err
+		}
+		s = strings.TrimLeft(s[end+1:], " \t\r\n,")
+
+		var t matrix
+		switch {
+		case name == "matrix" && len(args) == 6:
+			copy(t[:], args)
+		case name == "translate" && len(args) == 1:
+			t = matrix{1, 0, 0, 1, args[0], 0}
+		case name == "translate" && len(args) == 2:
+			t = matrix{1, 0, 0, 1, args[0], args[1]}
+		case name == "scale" && len(args) == 1:
+			t = matrix{args[0], 0, 0, args[0], 0, 0}
+		case name == "scale" && len(args) == 2:
+			t = matrix{args[0], 0, 0, args[1], 0, 0}
+		case name == "rotate" && (len(args) == 1 || len(args) == 3):
+			a := args[0] * math.Pi / 180
+			sin, cos := math.Sin(a), math.Cos(a)
+			t = matrix{cos, sin, -sin, cos, 0, 0}
+			if len(args) == 3 {
+				cx, cy := args[1], args[2]
+				t = matrix{1, 0, 0, 1, cx, cy}.mul(t).mul(matrix{1, 0, 0, 1, -cx, -cy})
+			}
+		case name == "skewX" && len(args) == 1:
+			t = matrix{1, 0, math.Tan(args[0] * math.Pi / 180), 1, 0, 0}
+		case name == "skewY" && len(args) == 1:
+			t = matrix{1, math.Tan(args[0] * math.Pi / 180), 0, 1, 0, 0}
+		default:
+			return m, fmt.Errorf("invalid transform %s with %d arguments", name, len(args))
+		}
+		m = m.mul(t)
+	}
+
+	return m, nil
+}
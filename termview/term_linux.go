@@ -0,0 +1,63 @@
+package termview
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+func ioctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// makeRaw puts a terminal in raw mode, so keys are read as they are typed
+// and not echoed. It returns a function restoring the previous mode.
+func makeRaw(f *os.File) (func(), error) {
+	var old syscall.Termios
+	if err := ioctl(f, syscall.TCGETS, unsafe.Pointer(&old)); err != nil {
+		return nil, err
+	}
+	raw := old
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctl(f, syscall.TCSETS, unsafe.Pointer(&raw)); err != nil {
+		return nil, err
+	}
+	return func() { ioctl(f, syscall.TCSETS, unsafe.Pointer(&old)) }, nil
+}
+
+// size returns the size of a terminal in characters.
+func size(f *os.File) (cols, rows int, err error) {
+	var ws struct{ rows, cols, x, y uint16 }
+	if err := ioctl(f, syscall.TIOCGWINSZ, unsafe.Pointer(&ws)); err != nil {
+		return 0, 0, err
+	}
+	return int(ws.cols), int(ws.rows), nil
+}
+
+// readable waits up to timeout for f to have data to read.
+func readable(f *os.File, timeout time.Duration) (bool, error) {
+	var set syscall.FdSet
+	fd := int(f.Fd())
+	bits := int(8 * unsafe.Sizeof(set.Bits[0]))
+	if fd < 0 || fd >= len(set.Bits)*bits {
+		return false, errors.New("termview: file descriptor out of range")
+	}
+	set.Bits[fd/bits] |= 1 << uint(fd%bits)
+	tv := syscall.NsecToTimeval(timeout.Nanoseconds())
+	n, err := syscall.Select(fd+1, &set, nil, nil, &tv)
+	if err == syscall.EINTR {
+		return false, nil
+	}
+	return n > 0, err
+}
@@ -0,0 +1,26 @@
+//go:build !linux
+// +build !linux
+
+package termview
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+var errUnsupported = errors.New("termview: terminals are only supported on Linux")
+
+func makeRaw(f *os.File) (func(), error) {
+	return nil, errUnsupported
+}
+
+func size(f *os.File) (cols, rows int, err error) {
+	return 0, 0, errUnsupported
+}
+
+// readable can't wait for input: the file is read right away, so the
+// goroutine reading it only stops with Run once something is typed.
+func readable(f *os.File, timeout time.Duration) (bool, error) {
+	return false, errUnsupported
+}
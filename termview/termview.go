@@ -0,0 +1,286 @@
+// Package termview shows what a Stream Deck displays in a terminal, e.g.
+// over SSH on a headless board. The shadow framebuffer of the deck is drawn
+// with 24-bit colors, two pixels per character using half blocks, and
+// redrawn as it changes. With an emulated device, keyboard keys press the
+// keys of the deck.
+package termview
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	xdraw "golang.org/x/image/draw"
+)
+
+// Presser is implemented by emulated devices, such as mock.Device and
+// emulator.Emulator.
+type Presser interface {
+	Press(btnIndex int) error
+	Release(btnIndex int) error
+}
+
+// keyboard is the layout of the keyboard keys mapped to the keys of the
+// deck, which are mapped by position: 1 is the top left key.
+var keyboard = []string{"1234567890", "qwertyuiop", "asdfghjkl;", "zxcvbnm,./"}
+
+// Viewer draws a deck in a terminal.
+type Viewer struct {
+	dev      *sd.StreamDeck
+	presser  Presser
+	interval time.Duration
+	keys     map[byte]int
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// Keyboard presses the keys of p when the matching keyboard keys are
+// typed. Terminals don't report key releases, so keys are released right
+// away.
+func Keyboard(p Presser) func(*Viewer) {
+	return func(v *Viewer) {
+		v.presser = p
+	}
+}
+
+// Interval sets how often the framebuffer is checked for changes, 100ms by
+// default.
+func Interval(d time.Duration) func(*Viewer) {
+	return func(v *Viewer) {
+		v.interval = d
+	}
+}
+
+// New creates a Viewer of dev.
+func New(dev *sd.StreamDeck, options ...func(*Viewer)) *Viewer {
+	v := &Viewer{
+		dev:      dev,
+		interval: 100 * time.Millisecond,
+		keys:     make(map[byte]int),
+		stop:     make(chan struct{}),
+	}
+	for _, option := range options {
+		option(v)
+	}
+
+	m := dev.Info
+	for i := 0; i < m.NumButtons; i++ {
+		p := m.KeyRect(i).Min
+		col, row := p.X/(m.ButtonSize+m.Spacer), p.Y/(m.ButtonSize+m.Spacer)
+		if row < len(keyboard) && col < len(keyboard[row]) {
+			v.keys[keyboard[row][col]] = i
+		}
+	}
+	return v
+}
+
+// Key returns the key of the deck pressed by a keyboard key.
+func (v *Viewer) Key(c byte) (int, bool) {
+	i, ok := v.keys[c]
+	return i, ok
+}
+
+// Render draws the panel once, scaled down to fit in cols x rows
+// characters.
+func (v *Viewer) Render(w io.Writer, cols, rows int) error {
+	_, err := w.Write(render(v.dev.Screenshot(), cols, rows))
+	return err
+}
+
+// render draws img with half blocks: the foreground color of each
+// character is the upper pixel, and the background color the lower one.
+func render(src *image.RGBA, cols, rows int) []byte {
+	sb := src.Bounds()
+	w, h := sb.Dx(), sb.Dy()
+	if w > cols {
+		w, h = cols, h*cols/w
+	}
+	if h > rows*2 {
+		w, h = w*rows*2/h, rows*2
+	}
+	h += h % 2
+	if w < 1 || h < 2 {
+		return nil
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	if w == sb.Dx() && h == sb.Dy() {
+		draw.Draw(img, img.Bounds(), src, sb.Min, draw.Src)
+	} else {
+		xdraw.CatmullRom.Scale(img, img.Bounds(), src, sb, draw.Src, nil)
+	}
+
+	var b bytes.Buffer
+	for y := 0; y < h; y += 2 {
+		var fg, bg [3]uint8
+		first := true
+		for x := 0; x < w; x++ {
+			top := img.Pix[img.PixOffset(x, y):]
+			bottom := img.Pix[img.PixOffset(x, y+1):]
+			if t := [3]uint8{top[0], top[1], top[2]}; first || t != fg {
+				fg = t
+				fmt.Fprintf(&b, "\x1b[38;2;%d;%d;%dm", t[0], t[1], t[2])
+			}
+			if u := [3]uint8{bottom[0], bottom[1], bottom[2]}; first || u != bg {
+				bg = u
+				fmt.Fprintf(&b, "\x1b[48;2;%d;%d;%dm", u[0], u[1], u[2])
+			}
+			first = false
+			b.WriteString("▀")
+		}
+		b.WriteString("\x1b[0m\x1b[K\r\n")
+	}
+	return b.Bytes()
+}
+
+// Run draws the deck on out until Close is called, or Ctrl-C or Escape is
+// typed on in. If in is a terminal, it is put in raw mode meanwhile. On
+// Linux, in is no longer read once Run returns; elsewhere, the next input
+// is consumed.
+func (v *Viewer) Run(in *os.File, out io.Writer) error {
+	if restore, err := makeRaw(in); err == nil {
+		defer restore()
+	}
+	fmt.Fprint(out, "\x1b[?25l\x1b[2J")
+	defer fmt.Fprint(out, "\x1b[0m\x1b[?25h\r\n")
+
+	// in is only read once it has data, so that the goroutine stops with
+	// Run instead of consuming the next input. Run waits for it.
+	typed := make(chan byte)
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	defer func() {
+		close(done)
+		<-exited
+	}()
+	go func() {
+		defer close(exited)
+		buf := make([]byte, 64)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if ok, err := readable(in, 50*time.Millisecond); err == nil && !ok {
+				continue
+			}
+			n, err := in.Read(buf)
+			if err != nil {
+				return
+			}
+			if buf[0] == 0x1b && n > 1 {
+				// escape sequence, such as an arrow key
+				continue
+			}
+			for _, c := range buf[:n] {
+				select {
+				case typed <- c:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+
+	t := time.NewTicker(v.interval)
+	defer t.Stop()
+	var last []byte
+	var status string
+	redraw := false
+	lastCols, lastRows := 0, 0
+	for {
+		cols, rows, err := size(in)
+		if err != nil {
+			if f, ok := out.(*os.File); ok {
+				cols, rows, err = size(f)
+			}
+		}
+		if err != nil || cols <= 0 || rows <= 0 {
+			cols, rows = 80, 24
+		}
+
+		frame := v.dev.Screenshot()
+		if redraw || !bytes.Equal(frame.Pix, last) || cols != lastCols || rows != lastRows {
+			if cols != lastCols || rows != lastRows {
+				fmt.Fprint(out, "\x1b[2J")
+			}
+			last, lastCols, lastRows, redraw = frame.Pix, cols, rows, false
+			var b bytes.Buffer
+			b.WriteString("\x1b[H")
+			b.Write(render(frame, cols, rows-1))
+			b.WriteString(v.footer(status, cols))
+			if _, err := out.Write(b.Bytes()); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-v.stop:
+			return nil
+		case c := <-typed:
+			switch c {
+			case 0x03, 0x1b: // Ctrl-C, Escape
+				return nil
+			}
+			if i, ok := v.Key(c); ok && v.presser != nil {
+				status = fmt.Sprintf("pressed key %d", i)
+				if err := v.presser.Press(i); err == nil {
+					v.presser.Release(i)
+				}
+			} else {
+				status = fmt.Sprintf("no key for %q", c)
+			}
+			redraw = true
+		case <-t.C:
+		}
+	}
+}
+
+// footer returns the line shown under the deck.
+func (v *Viewer) footer(status string, cols int) string {
+	s := v.dev.Info.Name + " · Ctrl-C quits"
+	if v.presser != nil {
+		s = v.dev.Info.Name + " · keys " + v.layout() + " press · Ctrl-C quits"
+	}
+	if status != "" {
+		s += " · " + status
+	}
+	if r := []rune(s); len(r) > cols {
+		s = string(r[:cols])
+	}
+	return "\x1b[0m\x1b[K" + s
+}
+
+// layout describes the keyboard keys mapped, e.g. "1-3 q-e".
+func (v *Viewer) layout() string {
+	var parts []string
+	for _, row := range keyboard {
+		first, last := byte(0), byte(0)
+		for i := 0; i < len(row); i++ {
+			if _, ok := v.keys[row[i]]; ok {
+				if first == 0 {
+					first = row[i]
+				}
+				last = row[i]
+			}
+		}
+		if first != 0 {
+			parts = append(parts, string(first)+"-"+string(last))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Close stops Run.
+func (v *Viewer) Close() {
+	v.once.Do(func() { close(v.stop) })
+}
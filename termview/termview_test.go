@@ -0,0 +1,171 @@
+package termview
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/mock"
+)
+
+func TestRender(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	green := color.RGBA{0, 255, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+	white := color.RGBA{255, 255, 255, 255}
+	black := color.RGBA{0, 0, 0, 255}
+
+	img := image.NewRGBA(image.Rect(0, 0, 2, 4))
+	img.Set(0, 0, red)
+	img.Set(1, 0, red)
+	img.Set(0, 1, blue)
+	img.Set(1, 1, green)
+	img.Set(0, 2, white)
+	img.Set(1, 2, white)
+	img.Set(0, 3, black)
+	img.Set(1, 3, black)
+
+	// Unchanged colors aren't repeated within a line.
+	want := "\x1b[38;2;255;0;0m\x1b[48;2;0;0;255m▀\x1b[48;2;0;255;0m▀\x1b[0m\x1b[K\r\n" +
+		"\x1b[38;2;255;255;255m\x1b[48;2;0;0;0m▀▀\x1b[0m\x1b[K\r\n"
+	if got := string(render(img, 80, 24)); got != want {
+		t.Errorf("render = %q, want %q", got, want)
+	}
+}
+
+func TestRenderScale(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	for _, tt := range []struct {
+		cols, rows    int
+		width, height int
+	}{
+		{80, 24, 8, 4},
+		{4, 24, 4, 2},
+		{80, 2, 4, 2},
+	} {
+		out := string(render(img, tt.cols, tt.rows))
+		lines := strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n")
+		if len(lines) != tt.height {
+			t.Errorf("%dx%d: %d lines, want %d", tt.cols, tt.rows, len(lines), tt.height)
+		}
+		for _, l := range lines {
+			if n := strings.Count(l, "▀"); n != tt.width {
+				t.Errorf("%dx%d: %d characters, want %d", tt.cols, tt.rows, n, tt.width)
+			}
+		}
+	}
+	if out := render(img, 0, 24); out != nil {
+		t.Errorf("render in no columns = %q", out)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	for _, tt := range []struct {
+		id   uint16
+		keys map[byte]int
+	}{
+		// Keys are numbered from the top right, and 1 is the top left key.
+		{0x0060, map[byte]int{'1': 4, '5': 0, 'q': 9, 't': 5, 'a': 14, 'g': 10}},
+		{0x006c, map[byte]int{'1': 7, '8': 0, 'q': 15, 'i': 8, 'a': 23, 'z': 31, ',': 24}},
+	} {
+		model := sd.LookupDevice(tt.id)
+		t.Run(model.Name, func(t *testing.T) {
+			dev, _ := mock.Open(t, model, "TEST0001")
+			v := New(dev)
+			for c, want := range tt.keys {
+				if i, ok := v.Key(c); !ok || i != want {
+					t.Errorf("Key(%q) = %d, %v, want %d", c, i, ok, want)
+				}
+			}
+			if len(v.keys) != model.NumButtons {
+				t.Errorf("%d keys mapped, want %d", len(v.keys), model.NumButtons)
+			}
+			cols := model.NumButtonColumns
+			if i, ok := v.Key(keyboard[0][cols]); ok {
+				t.Errorf("Key(%q) = %d past the last column", keyboard[0][cols], i)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	dev, m := mock.Open(t, sd.LookupDevice(0x0060), "TEST0001")
+	events := make(chan int, 4)
+	dev.SetBtnEventCb(func(i int, s sd.BtnState) {
+		if s == sd.BtnPressed {
+			events <- i
+		}
+	})
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	v := New(dev, Keyboard(m), Interval(10*time.Millisecond))
+	var out bytes.Buffer
+	done := make(chan error)
+	go func() { done <- v.Run(r, &out) }()
+
+	w.Write([]byte("q"))
+	select {
+	case i := <-events:
+		if i != 9 {
+			t.Errorf("q pressed key %d, want 9", i)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no key pressed")
+	}
+
+	v.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return")
+	}
+	if !strings.Contains(out.String(), "pressed key 9") {
+		t.Errorf("no status in %q", out.String())
+	}
+
+	// Input typed after Run returned is left unread.
+	w.Write([]byte("x"))
+	buf := make([]byte, 8)
+	if n, err := r.Read(buf); err != nil || string(buf[:n]) != "x" {
+		t.Errorf("read %q, %v after Run, want %q", buf[:n], err, "x")
+	}
+}
+
+func TestRunEscape(t *testing.T) {
+	dev, _ := mock.Open(t, sd.LookupDevice(0x0063), "TEST0001")
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	done := make(chan error)
+	go func() { done <- New(dev).Run(r, &bytes.Buffer{}) }()
+	w.Write([]byte{0x1b})
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Escape didn't stop Run")
+	}
+}
@@ -0,0 +1,52 @@
+package tile
+
+import (
+	"image"
+	"image/color"
+
+	sd "github.com/KarpelesLab/streamdeck"
+)
+
+// Text is a functional option setting the text of the Tile.
+func Text(text string) func(*Tile) {
+	return func(t *Tile) {
+		t.text = text
+	}
+}
+
+// TextColor is a functional option setting the color of the text.
+func TextColor(c color.Color) func(*Tile) {
+	return func(t *Tile) {
+		t.textColor = c
+	}
+}
+
+// TextAlign is a functional option setting the vertical alignment of the
+// text.
+func TextAlign(align Align) func(*Tile) {
+	return func(t *Tile) {
+		t.align = align
+	}
+}
+
+// BgColor is a functional option setting the background color.
+func BgColor(c color.Color) func(*Tile) {
+	return func(t *Tile) {
+		t.bgColor = c
+	}
+}
+
+// Image is a functional option setting the image of the Tile.
+func Image(img image.Image) func(*Tile) {
+	return func(t *Tile) {
+		t.img = img
+	}
+}
+
+// Callback is a functional option which sets the function called when the
+// key is pressed or released.
+func Callback(cb func(sd.BtnState)) func(*Tile) {
+	return func(t *Tile) {
+		t.cb = cb
+	}
+}
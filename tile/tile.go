@@ -0,0 +1,288 @@
+// Package tile provides a general purpose Element showing a background
+// color, an image and some text, all of which can be changed at any time.
+package tile
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"strings"
+	"sync"
+
+	sd "github.com/KarpelesLab/streamdeck"
+	"github.com/KarpelesLab/streamdeck/label"
+	"github.com/KarpelesLab/streamdeck/svg"
+	"github.com/golang/freetype/truetype"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// Align is the vertical alignment of the text of a Tile.
+type Align int
+
+const (
+	// AlignAuto centers text alone, and puts it under the image if there
+	// is one.
+	AlignAuto Align = iota
+	// AlignTop puts the text at the top, above the image if any.
+	AlignTop
+	// AlignMiddle centers the text, over the image if any.
+	AlignMiddle
+	// AlignBottom puts the text at the bottom, below the image if any.
+	AlignBottom
+)
+
+// Tile is an Element made of a background color, an optional image and
+// optional text. All methods are safe for concurrent use.
+type Tile struct {
+	sd.Invalidator
+	mu        sync.Mutex
+	bgColor   color.Color
+	textColor color.Color
+	text      string
+	align     Align
+	img       image.Image
+	icon      *svg.Icon
+	cb        func(sd.BtnState)
+}
+
+var _ sd.Element = (*Tile)(nil)
+
+// New creates a Tile. Without options, it is black.
+func New(options ...func(*Tile)) *Tile {
+	t := &Tile{
+		bgColor:   color.Black,
+		textColor: color.White,
+	}
+
+	for _, option := range options {
+		option(t)
+	}
+
+	return t
+}
+
+// Change calls the callback of the Tile, if any.
+func (t *Tile) Change(state sd.BtnState) {
+	t.mu.Lock()
+	cb := t.cb
+	t.mu.Unlock()
+
+	if cb != nil {
+		cb(state)
+	}
+}
+
+// SetText sets the text of the Tile. It may contain several lines.
+func (t *Tile) SetText(text string) {
+	t.mu.Lock()
+	t.text = text
+	t.mu.Unlock()
+	t.Invalidate()
+}
+
+// Text returns the text of the Tile.
+func (t *Tile) Text() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.text
+}
+
+// SetAlign sets the vertical alignment of the text.
+func (t *Tile) SetAlign(align Align) {
+	t.mu.Lock()
+	t.align = align
+	t.mu.Unlock()
+	t.Invalidate()
+}
+
+// SetTextColor sets the color of the text.
+func (t *Tile) SetTextColor(c color.Color) {
+	t.mu.Lock()
+	t.textColor = c
+	t.mu.Unlock()
+	t.Invalidate()
+}
+
+// SetBgColor sets the background color.
+func (t *Tile) SetBgColor(c color.Color) {
+	t.mu.Lock()
+	t.bgColor = c
+	t.mu.Unlock()
+	t.Invalidate()
+}
+
+// SetImage sets the image of the Tile, nil removes it. The image is scaled to
+// fit the key.
+func (t *Tile) SetImage(img image.Image) {
+	t.mu.Lock()
+	t.img = img
+	t.icon = nil
+	t.mu.Unlock()
+	t.Invalidate()
+}
+
+// SetSVG sets an SVG icon as image of the Tile. It is rasterized at the
+// size of the key.
+func (t *Tile) SetSVG(icon *svg.Icon) {
+	t.mu.Lock()
+	t.img = nil
+	t.icon = icon
+	t.mu.Unlock()
+	t.Invalidate()
+}
+
+// SetImageFile loads the image of the Tile from a file, which can be a GIF,
+// JPEG, PNG or SVG.
+func (t *Tile) SetImageFile(path string) error {
+	if svg.IsSVG(path) {
+		icon, err := svg.ParseFile(path)
+		if err != nil {
+			return err
+		}
+		t.SetSVG(icon)
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return err
+	}
+	t.SetImage(img)
+	return nil
+}
+
+// Render draws the Tile into img.
+func (t *Tile) Render(img *image.RGBA) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := img.Bounds()
+	draw.Draw(img, b, image.NewUniform(t.bgColor), image.Point{}, draw.Src)
+
+	if t.img == nil && t.icon == nil {
+		align := t.align
+		if align == AlignAuto {
+			align = AlignMiddle
+		}
+		drawText(img, b, t.text, t.textColor, align, float64(b.Dy())/2.5)
+		return nil
+	}
+
+	// the text is either overlaid on the image, or a caption above or
+	// below it
+	area := b
+	if t.align == AlignMiddle {
+		t.drawImage(img, square(area))
+		drawText(img, b, t.text, t.textColor, AlignMiddle, float64(b.Dy())/5)
+		return nil
+	}
+
+	align := t.align
+	if align == AlignAuto {
+		align = AlignBottom
+	}
+	h := drawText(img, b, t.text, t.textColor, align, float64(b.Dy())/5)
+	if align == AlignTop {
+		area.Min.Y += h
+	} else {
+		area.Max.Y -= h
+	}
+	t.drawImage(img, square(area))
+	return nil
+}
+
+// drawImage draws the image or the icon scaled to fit area.
+func (t *Tile) drawImage(img *image.RGBA, area image.Rectangle) {
+	if t.icon != nil {
+		icon := image.NewRGBA(image.Rect(0, 0, area.Dx(), area.Dy()))
+		t.icon.Draw(icon)
+		draw.Draw(img, area, icon, image.Point{}, draw.Over)
+		return
+	}
+	xdraw.CatmullRom.Scale(img, fit(area, t.img.Bounds()), t.img, t.img.Bounds(), draw.Over, nil)
+}
+
+// square returns the largest square centered in r.
+func square(r image.Rectangle) image.Rectangle {
+	if r.Dx() > r.Dy() {
+		d := (r.Dx() - r.Dy()) / 2
+		return image.Rect(r.Min.X+d, r.Min.Y, r.Min.X+d+r.Dy(), r.Max.Y)
+	}
+	d := (r.Dy() - r.Dx()) / 2
+	return image.Rect(r.Min.X, r.Min.Y+d, r.Max.X, r.Min.Y+d+r.Dx())
+}
+
+// fit returns the largest rectangle with the aspect ratio of src centered
+// in area.
+func fit(area, src image.Rectangle) image.Rectangle {
+	if src.Dx() == 0 || src.Dy() == 0 {
+		return image.Rectangle{}
+	}
+	w, h := area.Dx(), src.Dy()*area.Dx()/src.Dx()
+	if h > area.Dy() {
+		w, h = src.Dx()*area.Dy()/src.Dy(), area.Dy()
+	}
+	x, y := area.Min.X+(area.Dx()-w)/2, area.Min.Y+(area.Dy()-h)/2
+	return image.Rect(x, y, x+w, y+h)
+}
+
+// drawText renders text in area with the given vertical alignment, using
+// the largest font size up to maxSize which lets all lines fit. It returns
+// the height taken by the text, margins included.
+func drawText(img *image.RGBA, area image.Rectangle, text string, c color.Color, align Align, maxSize float64) int {
+	if text == "" {
+		return 0
+	}
+
+	lines := strings.Split(text, "\n")
+	margin := area.Dx() / 16
+
+	var face font.Face
+	var lineHeight fixed.Int26_6
+	for size := maxSize; size >= 6; size-- {
+		face = truetype.NewFace(label.MPlus1mMediumFont, &truetype.Options{Size: size, DPI: 72})
+		m := face.Metrics()
+		lineHeight = m.Ascent + m.Descent
+
+		fits := (lineHeight * fixed.Int26_6(len(lines))).Ceil() <= area.Dy()-2*margin
+		for _, line := range lines {
+			if font.MeasureString(face, line).Ceil() > area.Dx()-2*margin {
+				fits = false
+			}
+		}
+		if fits {
+			break
+		}
+	}
+
+	height := lineHeight * fixed.Int26_6(len(lines))
+	var top fixed.Int26_6
+	switch align {
+	case AlignTop:
+		top = fixed.I(area.Min.Y + margin)
+	case AlignBottom:
+		top = fixed.I(area.Max.Y-margin) - height
+	default:
+		top = fixed.I(area.Min.Y) + (fixed.I(area.Dy())-height)/2
+	}
+
+	d := &font.Drawer{Dst: img, Src: image.NewUniform(c), Face: face}
+	for i, line := range lines {
+		d.Dot = fixed.Point26_6{
+			X: fixed.I(area.Min.X) + (fixed.I(area.Dx())-font.MeasureString(face, line))/2,
+			Y: top + lineHeight*fixed.Int26_6(i) + face.Metrics().Ascent,
+		}
+		d.DrawString(line)
+	}
+
+	return height.Ceil() + margin
+}
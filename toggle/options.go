@@ -0,0 +1,48 @@
+package toggle
+
+import "time"
+
+// Initial sets the state displayed until the source is read.
+func Initial(state int) func(*Button) {
+	return func(b *Button) {
+		b.current = state
+	}
+}
+
+// WithSource binds the Button to an external state.
+func WithSource(src Source) func(*Button) {
+	return func(b *Button) {
+		b.source = src
+	}
+}
+
+// Poll reads the source at the given interval, so the Button follows
+// changes made elsewhere.
+func Poll(interval time.Duration) func(*Button) {
+	return func(b *Button) {
+		b.interval = interval
+	}
+}
+
+// Timeout limits the time reading or changing the source may take. The
+// default is 10 seconds.
+func Timeout(d time.Duration) func(*Button) {
+	return func(b *Button) {
+		b.timeout = d
+	}
+}
+
+// OnChange sets a function called when the displayed state changes.
+func OnChange(f func(state int)) func(*Button) {
+	return func(b *Button) {
+		b.onChange = f
+	}
+}
+
+// OnError sets a function called when the source can't be read or
+// changed.
+func OnError(f func(error)) func(*Button) {
+	return func(b *Button) {
+		b.onError = f
+	}
+}
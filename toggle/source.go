@@ -0,0 +1,153 @@
+package toggle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/KarpelesLab/streamdeck/action"
+)
+
+// Source holds the external state a Button reflects.
+type Source interface {
+	// Get returns the current state.
+	Get(ctx context.Context) (int, error)
+	// Set changes the state.
+	Set(ctx context.Context, state int) error
+}
+
+// Funcs adapts a pair of functions to the Source interface.
+type Funcs struct {
+	GetFunc func(ctx context.Context) (int, error)
+	SetFunc func(ctx context.Context, state int) error
+}
+
+// Get calls GetFunc.
+func (f Funcs) Get(ctx context.Context) (int, error) {
+	return f.GetFunc(ctx)
+}
+
+// Set calls SetFunc.
+func (f Funcs) Set(ctx context.Context, state int) error {
+	return f.SetFunc(ctx, state)
+}
+
+// setState runs the action switching to state.
+func setState(ctx context.Context, actions []action.Action, state int) error {
+	if state < 0 || state >= len(actions) || actions[state] == nil {
+		return fmt.Errorf("no action to switch to state %d", state)
+	}
+	return actions[state].Run(ctx, &action.Env{Key: -1})
+}
+
+// Command reads the state by running a command.
+type Command struct {
+	Query *action.Command
+	// Output selects the state from the standard output of the query,
+	// parsed by ParseState. Otherwise, the state is On if the query exits
+	// with status 0 and Off if it exits with another status, as with
+	// "systemctl is-active".
+	Output bool
+	// Actions[i] is run to switch to state i.
+	Actions []action.Action
+}
+
+// Get runs the query.
+func (c *Command) Get(ctx context.Context) (int, error) {
+	out, err := c.Query.Output(ctx, &action.Env{Key: -1})
+	if c.Output {
+		if err != nil {
+			return 0, err
+		}
+		return ParseState(out)
+	}
+
+	var exit *exec.ExitError
+	switch {
+	case err == nil:
+		return On, nil
+	case errors.As(err, &exit):
+		return Off, nil
+	}
+	return 0, err
+}
+
+// Set runs the action of the state.
+func (c *Command) Set(ctx context.Context, state int) error {
+	return setState(ctx, c.Actions, state)
+}
+
+// HTTP reads the state from an HTTP endpoint.
+type HTTP struct {
+	URL    string
+	Header http.Header
+	Client *http.Client // http.DefaultClient if nil
+	// Parse extracts the state from the response body. ParseState is used
+	// if nil.
+	Parse func(body []byte) (int, error)
+	// Actions[i] is run to switch to state i.
+	Actions []action.Action
+}
+
+// Get fetches the URL and parses the response.
+func (h *HTTP) Get(ctx context.Context) (int, error) {
+	req, err := http.NewRequest(http.MethodGet, h.URL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range h.Header {
+		req.Header[k] = v
+	}
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return 0, fmt.Errorf("GET %s: %s", h.URL, resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	parse := h.Parse
+	if parse == nil {
+		parse = ParseState
+	}
+	return parse(body)
+}
+
+// Set runs the action of the state.
+func (h *HTTP) Set(ctx context.Context, state int) error {
+	return setState(ctx, h.Actions, state)
+}
+
+// ParseState parses a state index, or one of on/off, true/false, yes/no
+// (case insensitive), surrounded by optional white space or quotes.
+func ParseState(data []byte) (int, error) {
+	s := strings.Trim(strings.TrimSpace(string(data)), `"`)
+	switch strings.ToLower(s) {
+	case "on", "true", "yes":
+		return On, nil
+	case "off", "false", "no":
+		return Off, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid state %q", s)
+	}
+	return n, nil
+}
@@ -0,0 +1,291 @@
+// Package toggle implements buttons cycling through several states on
+// each press, each state displayed by its own element, and toggle buttons
+// showing an on or off appearance.
+//
+// A Button can be bound to a Source holding the real state, such as a
+// command or an HTTP endpoint. Presses then change the external state, and
+// the key only keeps its new appearance if that succeeded: on failure, or
+// if the state read back differs, the key shows the actual state again.
+package toggle
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"sync"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+)
+
+// Off and On are the states of a toggle button.
+const (
+	Off = 0
+	On  = 1
+)
+
+// Button is an Element cycling through states on key presses.
+type Button struct {
+	sd.Invalidator
+	mu       sync.Mutex
+	states   []sd.Element
+	current  int
+	source   Source
+	timeout  time.Duration
+	interval time.Duration
+	busy     bool
+	stop     chan struct{}
+	onChange func(state int)
+	onError  func(error)
+}
+
+var _ sd.Element = (*Button)(nil)
+
+// New creates a Button displaying one element per state. Elements may be
+// nil for black keys.
+func New(states []sd.Element, options ...func(*Button)) (*Button, error) {
+	if len(states) == 0 {
+		return nil, fmt.Errorf("a button needs at least one state")
+	}
+
+	b := &Button{
+		states:  states,
+		timeout: 10 * time.Second,
+	}
+	for _, option := range options {
+		option(b)
+	}
+	if b.current < 0 || b.current >= len(states) {
+		return nil, fmt.Errorf("invalid initial state %d", b.current)
+	}
+
+	if b.source != nil {
+		go b.Refresh()
+		if b.interval > 0 {
+			b.stop = make(chan struct{})
+			go b.poll(b.interval, b.stop)
+		}
+	}
+	return b, nil
+}
+
+// NewToggle creates a Button with an off and an on state.
+func NewToggle(off, on sd.Element, options ...func(*Button)) (*Button, error) {
+	return New([]sd.Element{off, on}, options...)
+}
+
+// Close stops polling the source.
+func (b *Button) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.stop != nil {
+		close(b.stop)
+		b.stop = nil
+	}
+	for _, el := range b.states {
+		if c, ok := el.(interface{ Close() }); ok {
+			c.Close()
+		}
+	}
+}
+
+// State returns the current state.
+func (b *Button) State() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.current
+}
+
+// IsOn reports whether a toggle button is on.
+func (b *Button) IsOn() bool {
+	return b.State() != Off
+}
+
+// SetState displays the given state, without changing the external state.
+// It is meant to be called when the external state is known to have
+// changed.
+func (b *Button) SetState(state int) error {
+	if state < 0 || state >= len(b.states) {
+		return fmt.Errorf("invalid state %d", state)
+	}
+	b.show(state)
+	return nil
+}
+
+// show displays state and reports the change.
+func (b *Button) show(state int) {
+	b.mu.Lock()
+	changed := b.current != state
+	b.current = state
+	onChange := b.onChange
+	b.mu.Unlock()
+
+	if changed {
+		b.Invalidate()
+		if onChange != nil {
+			onChange(state)
+		}
+	}
+}
+
+// Refresh reads the state from the source and displays it. It does
+// nothing while a press or another refresh is in progress, and presses are
+// ignored until it is done, so that an older state can't be displayed over
+// a newer one.
+func (b *Button) Refresh() error {
+	if b.source == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	if b.busy {
+		b.mu.Unlock()
+		return nil
+	}
+	b.busy = true
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		b.busy = false
+		b.mu.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+	defer cancel()
+
+	state, err := b.source.Get(ctx)
+	if err == nil && (state < 0 || state >= len(b.states)) {
+		err = fmt.Errorf("source returned invalid state %d", state)
+	}
+	if err != nil {
+		b.report(err)
+		return err
+	}
+
+	b.show(state)
+	return nil
+}
+
+func (b *Button) poll(interval time.Duration, stop chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			b.Refresh()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Change forwards key events to the element of the current state, and
+// moves to the next state when the key is pressed.
+func (b *Button) Change(state sd.BtnState) {
+	b.mu.Lock()
+	el := b.states[b.current]
+	b.mu.Unlock()
+	if el != nil {
+		el.Change(state)
+	}
+
+	if state != sd.BtnPressed {
+		return
+	}
+
+	b.mu.Lock()
+	if b.busy {
+		b.mu.Unlock()
+		return
+	}
+	prev := b.current
+	next := (prev + 1) % len(b.states)
+	if b.source != nil {
+		b.busy = true
+	}
+	b.mu.Unlock()
+
+	// show the new state right away, the source confirms it afterwards
+	b.show(next)
+	if b.source != nil {
+		go b.set(prev, next)
+	}
+}
+
+// set changes the external state, and reverts the display if that failed.
+func (b *Button) set(prev, next int) {
+	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+	defer cancel()
+
+	actual := next
+	err := b.source.Set(ctx, next)
+	if err != nil {
+		actual = prev
+	} else if state, gerr := b.source.Get(ctx); gerr != nil {
+		err = gerr
+		actual = prev
+	} else if state != next {
+		if state >= 0 && state < len(b.states) {
+			actual = state
+		} else {
+			actual = prev
+		}
+		err = fmt.Errorf("state is %d after switching to %d", state, next)
+	}
+
+	b.show(actual)
+	b.mu.Lock()
+	b.busy = false
+	b.mu.Unlock()
+
+	if err != nil {
+		b.report(err)
+	}
+}
+
+func (b *Button) report(err error) {
+	if b.onError != nil {
+		b.onError(err)
+	}
+}
+
+// Render draws the element of the current state.
+func (b *Button) Render(img *image.RGBA) error {
+	b.mu.Lock()
+	el := b.states[b.current]
+	b.mu.Unlock()
+
+	if el == nil {
+		draw.Draw(img, img.Bounds(), image.Black, image.Point{}, draw.Src)
+		return nil
+	}
+	return el.Render(img)
+}
+
+// Dirty reports whether the Button or the element of the current state
+// needs to be redrawn.
+func (b *Button) Dirty() bool {
+	dirty := b.Invalidator.Dirty()
+
+	b.mu.Lock()
+	el := b.states[b.current]
+	b.mu.Unlock()
+	if el != nil && el.Dirty() {
+		dirty = true
+	}
+	return dirty
+}
+
+// SetNotify sets the function called when the Button or the element of
+// any state needs to be redrawn.
+func (b *Button) SetNotify(notify func()) {
+	b.Invalidator.SetNotify(notify)
+	for _, el := range b.states {
+		if n, ok := el.(sd.Notifier); ok {
+			n.SetNotify(notify)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package toggle
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	sd "github.com/KarpelesLab/streamdeck"
+)
+
+// fakeSource is a Source whose calls can be held until the test releases
+// them.
+type fakeSource struct {
+	mu     sync.Mutex
+	state  int
+	seen   int // state returned by Get, if different from the one set
+	setErr error
+	gets   int
+	sets   []int
+	gate   chan struct{} // if not nil, calls wait for it to be closed
+	called chan string
+}
+
+func newSource(state int) *fakeSource {
+	return &fakeSource{state: state, seen: -1, called: make(chan string, 16)}
+}
+
+func (s *fakeSource) wait(name string) {
+	s.mu.Lock()
+	gate := s.gate
+	s.mu.Unlock()
+	s.called <- name
+	if gate != nil {
+		<-gate
+	}
+}
+
+func (s *fakeSource) Get(ctx context.Context) (int, error) {
+	s.wait("get")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gets++
+	if s.seen >= 0 {
+		return s.seen, nil
+	}
+	return s.state, nil
+}
+
+func (s *fakeSource) Set(ctx context.Context, state int) error {
+	s.wait("set")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sets = append(s.sets, state)
+	if s.setErr != nil {
+		return s.setErr
+	}
+	s.state = state
+	return nil
+}
+
+func (s *fakeSource) hold() {
+	s.mu.Lock()
+	s.gate = make(chan struct{})
+	s.mu.Unlock()
+}
+
+func (s *fakeSource) release() {
+	s.mu.Lock()
+	close(s.gate)
+	s.gate = nil
+	s.mu.Unlock()
+}
+
+func (s *fakeSource) expect(t *testing.T, name string) {
+	t.Helper()
+	select {
+	case got := <-s.called:
+		if got != name {
+			t.Fatalf("source called with %s, want %s", got, name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for %s", name)
+	}
+}
+
+// waitState waits for the button to be done with the source and display
+// state.
+func waitState(t *testing.T, b *Button, state int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b.mu.Lock()
+		busy, current := b.busy, b.current
+		b.mu.Unlock()
+		if !busy && current == state {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("state %d (busy: %v), want %d", current, busy, state)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func newButton(t *testing.T, src *fakeSource, options ...func(*Button)) *Button {
+	t.Helper()
+	b, err := NewToggle(nil, nil, append(options, WithSource(src))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(b.Close)
+	src.expect(t, "get")
+	waitState(t, b, src.state)
+	return b
+}
+
+func TestPress(t *testing.T) {
+	src := newSource(Off)
+	b := newButton(t, src)
+
+	b.Change(sd.BtnPressed)
+	src.expect(t, "set")
+	src.expect(t, "get")
+	waitState(t, b, On)
+	if len(src.sets) != 1 || src.sets[0] != On {
+		t.Errorf("source set to %v", src.sets)
+	}
+}
+
+func TestRevert(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		set  func(src *fakeSource)
+		err  string
+	}{
+		{"set fails", func(src *fakeSource) { src.setErr = errors.New("unreachable") }, "unreachable"},
+		{"state unchanged", func(src *fakeSource) { src.seen = Off }, "state is 0 after switching to 1"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			src := newSource(Off)
+			c.set(src)
+			errs := make(chan error, 1)
+			changes := make(chan int, 4)
+			b := newButton(t, src,
+				OnError(func(err error) { errs <- err }),
+				OnChange(func(state int) { changes <- state }))
+
+			src.hold()
+			b.Change(sd.BtnPressed)
+			// The new state is displayed right away...
+			if !b.IsOn() {
+				t.Error("press not displayed while in progress")
+			}
+			src.expect(t, "set")
+			src.release()
+
+			// ...and reverted once the source didn't follow.
+			select {
+			case err := <-errs:
+				if !strings.Contains(err.Error(), c.err) {
+					t.Errorf("error %q, want %q", err, c.err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("no error reported")
+			}
+			waitState(t, b, Off)
+			for _, want := range []int{On, Off} {
+				if got := <-changes; got != want {
+					t.Errorf("changed to %d, want %d", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRefreshDuringPress(t *testing.T) {
+	src := newSource(Off)
+	b := newButton(t, src)
+
+	// While the source is being switched on, a refresh can't display the
+	// state from before.
+	src.hold()
+	b.Change(sd.BtnPressed)
+	src.expect(t, "set")
+	if err := b.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if !b.IsOn() {
+		t.Error("refresh displayed the state from before the press")
+	}
+	src.release()
+	src.expect(t, "get")
+	waitState(t, b, On)
+	if src.gets != 2 {
+		t.Errorf("source read %d times, want 2", src.gets)
+	}
+}
+
+func TestPressDuringRefresh(t *testing.T) {
+	src := newSource(Off)
+	b := newButton(t, src)
+
+	// A press while the state is being read is ignored: the state read
+	// might be displayed after it.
+	src.hold()
+	done := make(chan error)
+	go func() { done <- b.Refresh() }()
+	src.expect(t, "get")
+	b.Change(sd.BtnPressed)
+	if b.IsOn() {
+		t.Error("press displayed during a refresh")
+	}
+	src.release()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	waitState(t, b, Off)
+	if len(src.sets) != 0 {
+		t.Errorf("source set to %v", src.sets)
+	}
+
+	b.Change(sd.BtnPressed)
+	src.expect(t, "set")
+	src.expect(t, "get")
+	waitState(t, b, On)
+}
@@ -0,0 +1,87 @@
+package streamdeck
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/KarpelesLab/hid"
+)
+
+// Transport is the connection to a Stream Deck. USB devices opened through
+// the hid package are transports; other implementations can emulate,
+// record or forward a device.
+type Transport interface {
+	// Write sends an output report, such as a page of a key image.
+	Write(data []byte, timeout time.Duration) (int, error)
+	// ReadInputPacket waits for an input report, the state of the keys.
+	ReadInputPacket(timeout time.Duration) ([]byte, error)
+	SetFeatureReport(report int, data []byte) error
+	GetFeatureReport(report int) ([]byte, error)
+	Close() error
+}
+
+var _ Transport = hid.Handle(nil)
+
+// USBDevice is a Stream Deck found on the USB bus.
+type USBDevice struct {
+	Model   *StreamdeckDevice
+	Bus     int // bus number, as in /dev/bus/usb/BBB/DDD
+	Address int // device number on the bus
+	dev     hid.Device
+}
+
+// ListUSB returns the Stream Decks connected to the USB bus.
+func ListUSB() []*USBDevice {
+	var res []*USBDevice
+	hid.UsbWalk(func(device hid.Device) {
+		info := device.Info()
+		if info.Vendor != VendorID {
+			return
+		}
+
+		model := LookupDevice(info.Product)
+		if model == nil {
+			log.Printf("WARNING: unsupported Elgato device %04x:%04x:%04x:%02x", info.Vendor, info.Product, info.Revision, info.Interface)
+			return
+		}
+		res = append(res, &USBDevice{
+			Model:   model,
+			Bus:     info.Bus,
+			Address: info.Device,
+			dev:     device,
+		})
+	})
+	return res
+}
+
+// Open opens and initializes the device, see Open.
+func (u *USBDevice) Open() (*StreamDeck, error) {
+	handle, err := u.dev.Open()
+	if err != nil {
+		return nil, err
+	}
+	return Open(handle, u.Model)
+}
+
+// OpenTransport opens the device and returns its transport, for use with
+// Attach or to wrap it.
+func (u *USBDevice) OpenTransport() (Transport, error) {
+	return u.dev.Open()
+}
+
+// Path returns the path of the device node.
+func (u *USBDevice) Path() string {
+	return fmt.Sprintf("%s/%03d/%03d", hid.DevBusUsb, u.Bus, u.Address)
+}
+
+// Identify reads the serial number and firmware version of the device,
+// without initializing it.
+func (u *USBDevice) Identify() (serial, firmware string, err error) {
+	handle, err := u.dev.Open()
+	if err != nil {
+		return "", "", err
+	}
+	defer handle.Close()
+	return Identify(handle, u.Model)
+}
@@ -0,0 +1,373 @@
+package udev
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+
+	sd "github.com/KarpelesLab/streamdeck"
+)
+
+// Doctor inspects the Stream Decks connected to the system and the udev
+// rules applying to them. The zero value checks the real system.
+type Doctor struct {
+	SysDir    string   // /sys/bus/usb/devices if empty
+	DevDir    string   // /dev/bus/usb if empty
+	RulesDirs []string // the standard udev rule directories if empty
+	Group     string   // group expected in the rules, DefaultGroup if empty
+
+	// Checks of the current process, replaced in tests.
+	access  func(path string) bool
+	inGroup func(group string) bool
+	member  func(username, group string) bool
+}
+
+// Device is the status of a connected Elgato device.
+type Device struct {
+	Path      string               // device node
+	ProductID uint16               // USB product ID
+	Model     *sd.StreamdeckDevice // nil if the model isn't supported
+	Mode      os.FileMode          // permissions of the node
+	Owner     string               // user owning the node
+	Group     string               // group owning the node
+	Access    bool                 // the current process can open the node
+	Rule      string               // file with a rule for the product, if any
+}
+
+// Problem is something preventing the use of a device.
+type Problem struct {
+	Msg string
+	Fix string // shell commands or steps fixing the problem
+}
+
+// Report is the outcome of a check.
+type Report struct {
+	Devices  []*Device
+	Problems []*Problem
+}
+
+// OK reports whether no problem was found.
+func (r *Report) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Write prints the report.
+func (r *Report) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if len(r.Devices) == 0 {
+		fmt.Fprintln(bw, "No Elgato device connected.")
+	}
+	for _, d := range r.Devices {
+		name := "unsupported model"
+		if d.Model != nil {
+			name = d.Model.Name
+		}
+		access := "no access"
+		if d.Access {
+			access = "accessible"
+		}
+		fmt.Fprintf(bw, "%s: %s (%04x:%04x), %s %s:%s, %s\n",
+			d.Path, name, sd.VendorID, d.ProductID, d.Mode.Perm(), d.Owner, d.Group, access)
+		if d.Rule != "" {
+			fmt.Fprintf(bw, "  udev rule: %s\n", d.Rule)
+		} else {
+			fmt.Fprintf(bw, "  udev rule: missing\n")
+		}
+	}
+
+	if r.OK() {
+		fmt.Fprintln(bw, "\nNo problem found.")
+	}
+	for _, p := range r.Problems {
+		fmt.Fprintf(bw, "\nProblem: %s\n", p.Msg)
+		if p.Fix != "" {
+			fmt.Fprintf(bw, "Fix:\n")
+			for _, line := range strings.Split(p.Fix, "\n") {
+				fmt.Fprintf(bw, "  %s\n", line)
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+var defaultRulesDirs = []string{
+	"/etc/udev/rules.d",
+	"/run/udev/rules.d",
+	"/usr/lib/udev/rules.d",
+	"/lib/udev/rules.d",
+}
+
+// Check inspects the system.
+func (doc *Doctor) Check() (*Report, error) {
+	sysDir := doc.SysDir
+	if sysDir == "" {
+		sysDir = "/sys/bus/usb/devices"
+	}
+	devDir := doc.DevDir
+	if devDir == "" {
+		devDir = "/dev/bus/usb"
+	}
+	rulesDirs := doc.RulesDirs
+	if len(rulesDirs) == 0 {
+		rulesDirs = defaultRulesDirs
+	}
+	group := doc.Group
+	if group == "" {
+		group = DefaultGroup
+	}
+	access, inGroup, member := doc.access, doc.inGroup, doc.member
+	if access == nil {
+		access = func(path string) bool {
+			return syscall.Access(path, 6) == nil // R_OK | W_OK
+		}
+	}
+	if inGroup == nil {
+		inGroup = processInGroup
+	}
+	if member == nil {
+		member = listedMember
+	}
+
+	rules, err := findRules(rulesDirs)
+	if err != nil {
+		return nil, err
+	}
+
+	devices, err := findDevices(sysDir, devDir, access)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Report{Devices: devices}
+	fixRules := false
+	for _, d := range devices {
+		d.Rule = rules[d.ProductID]
+		if d.Model == nil {
+			r.Problems = append(r.Problems, &Problem{
+				Msg: fmt.Sprintf("%s: product %04x is not supported by this library", d.Path, d.ProductID),
+			})
+			continue
+		}
+		if d.Access {
+			continue
+		}
+
+		switch {
+		case d.Rule == "":
+			r.Problems = append(r.Problems, &Problem{
+				Msg: fmt.Sprintf("%s: no udev rule for %s (%04x:%04x), the device node is %s %s:%s",
+					d.Path, d.Model.Name, sd.VendorID, d.ProductID, d.Mode.Perm(), d.Owner, d.Group),
+			})
+			fixRules = true
+		case d.Group == group && !inGroup(group):
+			// handled below, once for all devices
+		default:
+			r.Problems = append(r.Problems, &Problem{
+				Msg: fmt.Sprintf("%s: the rule in %s was not applied, the device node is %s %s:%s",
+					d.Path, d.Rule, d.Mode.Perm(), d.Owner, d.Group),
+				Fix: "sudo udevadm control --reload-rules\nsudo udevadm trigger\n# or unplug and plug the device again",
+			})
+		}
+	}
+
+	if fixRules {
+		r.Problems = append(r.Problems, &Problem{
+			Msg: "udev rules are missing",
+			Fix: fmt.Sprintf("streamdeck udev-rules -group %s | sudo tee %s\nsudo udevadm control --reload-rules\nsudo udevadm trigger", group, RulesFile),
+		})
+	}
+
+	if len(devices) > 0 && !inGroup(group) {
+		name := "$USER"
+		if u, err := user.Current(); err == nil {
+			name = u.Username
+		}
+		if member(name, group) {
+			r.Problems = append(r.Problems, &Problem{
+				Msg: fmt.Sprintf("user %s was added to group %s, but this session predates it", name, group),
+				Fix: "log out and back in (or run: newgrp " + group + ")",
+			})
+		} else if os.Geteuid() != 0 {
+			r.Problems = append(r.Problems, &Problem{
+				Msg: fmt.Sprintf("user %s is not a member of group %s", name, group),
+				Fix: fmt.Sprintf("sudo usermod -aG %s %s\n# then log out and back in", group, name),
+			})
+		}
+	}
+	return r, nil
+}
+
+// findDevices lists the Elgato devices in sysfs.
+func findDevices(sysDir, devDir string, access func(path string) bool) ([]*Device, error) {
+	entries, err := ioutil.ReadDir(sysDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var res []*Device
+	for _, e := range entries {
+		dir := filepath.Join(sysDir, e.Name())
+		vendor, err := readHex(filepath.Join(dir, "idVendor"))
+		if err != nil || vendor != sd.VendorID {
+			continue
+		}
+		product, err := readHex(filepath.Join(dir, "idProduct"))
+		if err != nil {
+			continue
+		}
+		bus, err1 := readInt(filepath.Join(dir, "busnum"))
+		dev, err2 := readInt(filepath.Join(dir, "devnum"))
+		if err1 != nil || err2 != nil {
+			continue
+		}
+
+		d := &Device{
+			Path:      filepath.Join(devDir, fmt.Sprintf("%03d", bus), fmt.Sprintf("%03d", dev)),
+			ProductID: uint16(product),
+			Model:     sd.LookupDevice(uint16(product)),
+		}
+		if fi, err := os.Stat(d.Path); err == nil {
+			d.Mode = fi.Mode()
+			if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+				d.Owner = userName(st.Uid)
+				d.Group = groupName(st.Gid)
+			}
+			d.Access = access(d.Path)
+		}
+		res = append(res, d)
+	}
+	return res, nil
+}
+
+var reProduct = regexp.MustCompile(`(?i)ATTRS?\{idProduct\}\s*==\s*"([0-9a-f]{4})"`)
+var reVendor = regexp.MustCompile(`(?i)ATTRS?\{idVendor\}\s*==\s*"0fd9"`)
+
+// findRules returns the files holding a rule for each Elgato product. Like
+// udev, a file in an earlier directory masks files of the same name in
+// later ones.
+func findRules(dirs []string) (map[uint16]string, error) {
+	res := make(map[uint16]string)
+	seen := make(map[string]bool)
+
+	for _, dir := range dirs {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if !strings.HasSuffix(e.Name(), ".rules") || seen[e.Name()] {
+				continue
+			}
+			seen[e.Name()] = true
+
+			file := filepath.Join(dir, e.Name())
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				continue
+			}
+			for _, line := range strings.Split(string(data), "\n") {
+				line = strings.TrimSpace(line)
+				if strings.HasPrefix(line, "#") || !reVendor.MatchString(line) {
+					continue
+				}
+				m := reProduct.FindStringSubmatch(line)
+				if m == nil {
+					continue
+				}
+				id, _ := strconv.ParseUint(m[1], 16, 16)
+				if _, ok := res[uint16(id)]; !ok {
+					res[uint16(id)] = file
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+// processInGroup reports whether the current process has the group.
+func processInGroup(name string) bool {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return false
+	}
+	gid, err := strconv.Atoi(g.Gid)
+	if err != nil {
+		return false
+	}
+	if os.Getegid() == gid {
+		return true
+	}
+	groups, _ := os.Getgroups()
+	for _, id := range groups {
+		if id == gid {
+			return true
+		}
+	}
+	return false
+}
+
+// listedMember reports whether the group database lists the user as a
+// member, which may not be effective in the current session yet.
+func listedMember(username, group string) bool {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return false
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return false
+	}
+	ids, err := u.GroupIds()
+	if err != nil {
+		return false
+	}
+	for _, id := range ids {
+		if id == g.Gid {
+			return true
+		}
+	}
+	return false
+}
+
+func userName(uid uint32) string {
+	id := strconv.Itoa(int(uid))
+	if u, err := user.LookupId(id); err == nil {
+		return u.Username
+	}
+	return id
+}
+
+func groupName(gid uint32) string {
+	id := strconv.Itoa(int(gid))
+	if g, err := user.LookupGroupId(id); err == nil {
+		return g.Name
+	}
+	return id
+}
+
+func readHex(file string) (uint64, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 16, 16)
+}
+
+func readInt(file string) (int, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
@@ -0,0 +1,210 @@
+package udev
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sd "github.com/KarpelesLab/streamdeck"
+)
+
+// node is a USB device of a fake system.
+type node struct {
+	name            string // in sysfs
+	vendor, product string
+	bus, dev        int
+	mode            os.FileMode // of the device node, none if 0
+}
+
+// fakeSystem creates the sysfs and devfs directories of the given devices.
+func fakeSystem(t *testing.T, nodes ...node) (sysDir, devDir string) {
+	t.Helper()
+	root := t.TempDir()
+	sysDir, devDir = filepath.Join(root, "sys"), filepath.Join(root, "dev")
+	write := func(file, content string, mode os.FileMode) {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), mode); err != nil {
+			t.Fatal(err)
+		}
+		// not affected by the umask
+		if err := os.Chmod(file, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, n := range nodes {
+		dir := filepath.Join(sysDir, n.name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if n.vendor == "" {
+			continue
+		}
+		write(filepath.Join(dir, "idVendor"), n.vendor+"\n", 0644)
+		write(filepath.Join(dir, "idProduct"), n.product+"\n", 0644)
+		write(filepath.Join(dir, "busnum"), fmt.Sprintf("%d\n", n.bus), 0644)
+		write(filepath.Join(dir, "devnum"), fmt.Sprintf("%d\n", n.dev), 0644)
+		if n.mode != 0 {
+			write(filepath.Join(devDir, fmt.Sprintf("%03d/%03d", n.bus, n.dev)), "", n.mode)
+		}
+	}
+	return sysDir, devDir
+}
+
+// rulesDir writes the generated rules for group into a directory.
+func rulesDir(t *testing.T, group string) string {
+	t.Helper()
+	dir := t.TempDir()
+	f, err := os.Create(filepath.Join(dir, "50-streamdeck.rules"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := WriteRules(f, RuleOptions{Group: group}); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// newDoctor checks a fake system as a user who can open the device nodes
+// writable by others only, whatever the user running the test.
+func newDoctor(sysDir, devDir string, rulesDirs []string, group string, inGroup, member bool) *Doctor {
+	return &Doctor{
+		SysDir:    sysDir,
+		DevDir:    devDir,
+		RulesDirs: rulesDirs,
+		Group:     group,
+		access: func(path string) bool {
+			fi, err := os.Stat(path)
+			return err == nil && fi.Mode().Perm()&0006 == 0006
+		},
+		inGroup: func(string) bool { return inGroup },
+		member:  func(string, string) bool { return member },
+	}
+}
+
+func check(t *testing.T, doc *Doctor) (*Report, string) {
+	t.Helper()
+	r, err := doc.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := r.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	return r, b.String()
+}
+
+// expectProblems checks the messages of the problems found, in order.
+func expectProblems(t *testing.T, r *Report, msgs ...string) {
+	t.Helper()
+	if len(r.Problems) != len(msgs) {
+		for _, p := range r.Problems {
+			t.Log(p.Msg)
+		}
+		t.Fatalf("%d problems, want %d", len(r.Problems), len(msgs))
+	}
+	for i, p := range r.Problems {
+		if !strings.Contains(p.Msg, msgs[i]) {
+			t.Errorf("problem %d is %q, want %q", i, p.Msg, msgs[i])
+		}
+	}
+}
+
+var (
+	owner = userName(uint32(os.Getuid()))
+	group = groupName(uint32(os.Getgid()))
+)
+
+func TestDoctorNoDevice(t *testing.T) {
+	r, out := check(t, newDoctor(filepath.Join(t.TempDir(), "none"), "", nil, "", false, false))
+	if !r.OK() || len(r.Devices) != 0 {
+		t.Errorf("report %+v", r)
+	}
+	if out != "No Elgato device connected.\n\nNo problem found.\n" {
+		t.Errorf("report:\n%s", out)
+	}
+}
+
+func TestDoctorMissingRules(t *testing.T) {
+	sysDir, devDir := fakeSystem(t,
+		node{"1-1", "0fd9", "0063", 1, 5, 0600},
+		node{"1-2", "0fd9", "0fff", 1, 6, 0600},
+		node{"1-3", "0fd9", "006c", 1, 7, 0666},
+		node{"2-1", "046d", "c52b", 2, 2, 0666},
+		node{"usb2", "", "", 0, 0, 0},
+	)
+	r, out := check(t, newDoctor(sysDir, devDir, []string{t.TempDir()}, "", false, true))
+
+	if len(r.Devices) != 3 {
+		t.Fatalf("%d devices, want the 3 Elgato ones", len(r.Devices))
+	}
+	mini := r.Devices[0]
+	if mini.Path != filepath.Join(devDir, "001", "005") || mini.Model != sd.LookupDevice(0x0063) ||
+		mini.Mode.Perm() != 0600 || mini.Owner != owner || mini.Group != group || mini.Access || mini.Rule != "" {
+		t.Errorf("mini %+v", mini)
+	}
+	if !r.Devices[2].Access {
+		t.Error("XL with mode 0666 not accessible")
+	}
+
+	expectProblems(t, r,
+		"001/005: no udev rule for Stream Deck Mini (0fd9:0063), the device node is -rw------- "+owner+":"+group,
+		"001/006: product 0fff is not supported",
+		"udev rules are missing",
+		"user "+owner+" was added to group plugdev, but this session predates it",
+	)
+	if fix := r.Problems[2].Fix; !strings.Contains(fix, "streamdeck udev-rules -group plugdev | sudo tee "+RulesFile) {
+		t.Errorf("fix of the missing rules:\n%s", fix)
+	}
+
+	for _, line := range []string{
+		mini.Path + ": Stream Deck Mini (0fd9:0063), -rw------- " + owner + ":" + group + ", no access\n  udev rule: missing\n",
+		": unsupported model (0fd9:0fff)",
+		": Stream Deck XL (0fd9:006c), -rw-rw-rw- " + owner + ":" + group + ", accessible\n",
+		"\nProblem: udev rules are missing\nFix:\n  streamdeck udev-rules",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("report doesn't contain %q:\n%s", line, out)
+		}
+	}
+}
+
+func TestDoctorRuleNotApplied(t *testing.T) {
+	sysDir, devDir := fakeSystem(t, node{"1-1", "0fd9", "0063", 1, 5, 0600})
+	rules := rulesDir(t, "plugdev")
+	r, out := check(t, newDoctor(sysDir, devDir, []string{rules}, "plugdev", true, true))
+
+	// The node isn't owned by the group of the rule.
+	expectProblems(t, r, "the rule in "+filepath.Join(rules, "50-streamdeck.rules")+" was not applied, the device node is -rw-------")
+	if !strings.Contains(r.Problems[0].Fix, "udevadm trigger") {
+		t.Errorf("fix: %s", r.Problems[0].Fix)
+	}
+	if !strings.Contains(out, "  udev rule: "+filepath.Join(rules, "50-streamdeck.rules")+"\n") {
+		t.Errorf("report doesn't name the rule:\n%s", out)
+	}
+}
+
+func TestDoctorGroup(t *testing.T) {
+	// The rule was applied, giving the node to the group of the test.
+	sysDir, devDir := fakeSystem(t, node{"1-1", "0fd9", "0063", 1, 5, 0660})
+	rules := []string{rulesDir(t, group)}
+
+	r, _ := check(t, newDoctor(sysDir, devDir, rules, group, false, true))
+	expectProblems(t, r, "user "+owner+" was added to group "+group+", but this session predates it")
+	if fix := r.Problems[0].Fix; !strings.Contains(fix, "newgrp "+group) {
+		t.Errorf("fix: %s", fix)
+	}
+
+	// Once the session has the group, the node is accessible.
+	doc := newDoctor(sysDir, devDir, rules, group, true, true)
+	doc.access = func(string) bool { return true }
+	r, out := check(t, doc)
+	if !r.OK() || !strings.HasSuffix(out, "\nNo problem found.\n") {
+		t.Errorf("report:\n%s", out)
+	}
+}
@@ -0,0 +1,57 @@
+// Package udev generates udev rules giving users access to Stream Decks on
+// Linux, and diagnoses why a deck can't be opened.
+package udev
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	sd "github.com/KarpelesLab/streamdeck"
+)
+
+// DefaultGroup is the group given access to the devices by default.
+const DefaultGroup = "plugdev"
+
+// RulesFile is the conventional location of the generated rules.
+const RulesFile = "/etc/udev/rules.d/50-streamdeck.rules"
+
+// RuleOptions controls the generated rules.
+type RuleOptions struct {
+	// Group owning the device nodes, DefaultGroup if empty.
+	Group string
+	// Mode of the device nodes, "0660" if empty.
+	Mode string
+	// Uaccess also grants access to the user logged in at the seat, for
+	// desktop systems.
+	Uaccess bool
+}
+
+// Rule returns the rule for one model.
+func Rule(model *sd.StreamdeckDevice, opts RuleOptions) string {
+	group, mode := opts.Group, opts.Mode
+	if group == "" {
+		group = DefaultGroup
+	}
+	if mode == "" {
+		mode = "0660"
+	}
+
+	rule := fmt.Sprintf(`SUBSYSTEM=="usb", ATTRS{idVendor}=="%04x", ATTRS{idProduct}=="%04x", MODE="%s", GROUP="%s"`,
+		sd.VendorID, model.ProductID, mode, group)
+	if opts.Uaccess {
+		rule += `, TAG+="uaccess"`
+	}
+	return rule
+}
+
+// WriteRules writes the rules for all supported models.
+func WriteRules(w io.Writer, opts RuleOptions) error {
+	var b strings.Builder
+	b.WriteString("# Elgato Stream Deck, generated by github.com/KarpelesLab/streamdeck\n")
+	for _, m := range sd.Devices() {
+		fmt.Fprintf(&b, "\n# %s\n%s\n", m.Name, Rule(m, opts))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
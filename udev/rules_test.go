@@ -0,0 +1,87 @@
+package udev
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sd "github.com/KarpelesLab/streamdeck"
+)
+
+func TestRule(t *testing.T) {
+	mini := sd.LookupDevice(0x0063)
+	if got, want := Rule(mini, RuleOptions{}),
+		`SUBSYSTEM=="usb", ATTRS{idVendor}=="0fd9", ATTRS{idProduct}=="0063", MODE="0660", GROUP="plugdev"`; got != want {
+		t.Errorf("default rule\n%s\nwant\n%s", got, want)
+	}
+	if got, want := Rule(mini, RuleOptions{Group: "video", Mode: "0666", Uaccess: true}),
+		`SUBSYSTEM=="usb", ATTRS{idVendor}=="0fd9", ATTRS{idProduct}=="0063", MODE="0666", GROUP="video", TAG+="uaccess"`; got != want {
+		t.Errorf("rule with options\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWriteRules(t *testing.T) {
+	var b strings.Builder
+	if err := WriteRules(&b, RuleOptions{Group: "streamdeck"}); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, m := range sd.Devices() {
+		rule := Rule(m, RuleOptions{Group: "streamdeck"})
+		if !strings.Contains(out, "# "+m.Name+"\n"+rule+"\n") {
+			t.Errorf("no rule for %s (%04x):\n%s", m.Name, m.ProductID, out)
+		}
+	}
+
+	// The doctor recognizes the rules of every model.
+	dir := t.TempDir()
+	file := filepath.Join(dir, "50-streamdeck.rules")
+	if err := os.WriteFile(file, []byte(out), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := findRules([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != len(sd.Devices()) {
+		t.Errorf("rules found for %d products, want %d", len(rules), len(sd.Devices()))
+	}
+	for _, m := range sd.Devices() {
+		if rules[m.ProductID] != file {
+			t.Errorf("rule of %04x in %q", m.ProductID, rules[m.ProductID])
+		}
+	}
+}
+
+func TestFindRules(t *testing.T) {
+	etc, lib := t.TempDir(), t.TempDir()
+	write := func(dir, name, content string) string {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	rule := func(product uint16) string {
+		return Rule(&sd.StreamdeckDevice{ProductID: product}, RuleOptions{})
+	}
+
+	// The package's rules are masked by an empty file of the same name.
+	write(etc, "50-streamdeck.rules", "")
+	write(lib, "50-streamdeck.rules", rule(0x0060))
+	mini := write(lib, "70-other.rules", "# "+rule(0x006c)+"\n"+rule(0x0063)+"\n")
+	write(lib, "80-vendor.rules", `ATTRS{idVendor}=="046d", ATTRS{idProduct}=="0060"`)
+	write(lib, "90-disabled.rules.bak", rule(0x0090))
+	xl := write(lib, "99-xl.rules", `SUBSYSTEM=="hidraw", ATTRS{idVendor}=="0FD9", ATTRS{idProduct}=="006C", MODE="0666"`)
+
+	rules, err := findRules([]string{etc, filepath.Join(etc, "missing"), lib})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[uint16]string{0x0063: mini, 0x006c: xl}
+	if fmt.Sprint(rules) != fmt.Sprint(want) {
+		t.Errorf("rules %v, want %v", rules, want)
+	}
+}